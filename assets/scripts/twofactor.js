@@ -8,6 +8,7 @@
   const submitButton = d.getElementById('login-submit')
   const twoFactorPasscodeInput = d.getElementById('two-factor-passcode')
   const twoFactorTokenInput = d.getElementById('two-factor-token')
+  const twoFactorMethodInput = d.getElementById('two-factor-method')
   const twoFactorTrustDeviceCheckbox = d.getElementById(
     'two-factor-trust-device'
   )
@@ -40,10 +41,77 @@
     submitButton.removeAttribute('disabled')
   }
 
+  // The binary values of WebAuthn are exchanged in base64url with the server
+  const decodeBase64URL = function(str) {
+    const b64 = str.replace(/-/g, '+').replace(/_/g, '/')
+    const bin = w.atob(b64)
+    const bytes = new Uint8Array(bin.length)
+    for (let i = 0; i < bin.length; i++) {
+      bytes[i] = bin.charCodeAt(i)
+    }
+    return bytes.buffer
+  }
+
+  const encodeBase64URL = function(buffer) {
+    const bytes = new Uint8Array(buffer)
+    let bin = ''
+    for (let i = 0; i < bytes.length; i++) {
+      bin += String.fromCharCode(bytes[i])
+    }
+    return w
+      .btoa(bin)
+      .replace(/\+/g, '-')
+      .replace(/\//g, '_')
+      .replace(/=+$/, '')
+  }
+
+  // getWebAuthnAssertion asks the browser to sign the challenge with one of
+  // the security keys, and returns a promise for the JSON-encoded assertion.
+  const getWebAuthnAssertion = function() {
+    if (!w.navigator.credentials || !w.PublicKeyCredential) {
+      return Promise.reject(
+        new Error('Your browser does not support the security keys')
+      )
+    }
+    const options = JSON.parse(twoFactorPasscodeInput.dataset.webauthnOptions)
+    options.challenge = decodeBase64URL(options.challenge)
+    options.allowCredentials = (options.allowCredentials || []).map(function(
+      cred
+    ) {
+      return { type: cred.type, id: decodeBase64URL(cred.id) }
+    })
+    return w.navigator.credentials
+      .get({ publicKey: options })
+      .then(function(cred) {
+        return JSON.stringify({
+          id: encodeBase64URL(cred.rawId),
+          client_data_json: encodeBase64URL(cred.response.clientDataJSON),
+          authenticator_data: encodeBase64URL(
+            cred.response.authenticatorData
+          ),
+          signature: encodeBase64URL(cred.response.signature)
+        })
+      })
+  }
+
   const onSubmitTwoFactorCode = function(event) {
     event.preventDefault()
     submitButton.setAttribute('disabled', true)
 
+    const method = twoFactorMethodInput ? twoFactorMethodInput.value : ''
+    if (method === 'webauthn') {
+      getWebAuthnAssertion()
+        .then(function(assertion) {
+          twoFactorPasscodeInput.value = assertion
+          sendTwoFactorCode(method)
+        })
+        .catch(showError)
+      return
+    }
+    sendTwoFactorCode(method)
+  }
+
+  const sendTwoFactorCode = function(method) {
     const longRunSession =
       longRunSessionCheckbox && longRunSessionCheckbox.checked ? '1' : '0'
     const passcode = twoFactorPasscodeInput.value
@@ -62,6 +130,8 @@
       encodeURIComponent(longRunSession) +
       '&two-factor-token=' +
       encodeURIComponent(token) +
+      '&two-factor-method=' +
+      encodeURIComponent(method) +
       '&two-factor-generate-trusted-device-token=' +
       encodeURIComponent(trustDevice) +
       '&redirect=' +
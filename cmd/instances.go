@@ -1041,6 +1041,78 @@ var setAuthModeCmd = &cobra.Command{
 	},
 }
 
+var rebuildSearchIndexCmd = &cobra.Command{
+	Use:     "rebuild-search-index <domain>",
+	Short:   "Rebuild the full-text search index of the files",
+	Example: "$ cozy-stack instances rebuild-search-index cozy.tools:8080",
+	Long: `
+The cozy-stack instances rebuild-search-index command pushes a job that indexes
+again all the files of the instance for the full-text search, and removes the
+entries for the files that no longer exist. It also adds the trigger that keeps
+the index up-to-date if it is missing (for instances created before the search
+was available).
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		domain := args[0]
+		c := newAdminClient()
+		res, err := c.Req(&request.Options{
+			Method: "POST",
+			Path:   "/instances/" + url.PathEscape(domain) + "/search/rebuild",
+		})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		var j struct {
+			ID string `json:"_id"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&j); err != nil {
+			return err
+		}
+		fmt.Printf("The search index of %s is being rebuilt by the job %s\n", domain, j.ID)
+		return nil
+	},
+}
+
+var rotateFilesKeyCmd = &cobra.Command{
+	Use:     "rotate-files-key <domain>",
+	Short:   "Rotate the key used for the encryption at rest of the files",
+	Example: "$ cozy-stack instances rotate-files-key cozy.tools:8080",
+	Long: `
+cozy-stack instances rotate-files-key can be used to generate a new key for the
+encryption at rest of the binaries (files, versions and thumbnails) of an
+instance. A job encrypts again the binaries with the new key, and removes the
+old key when it is done. It can also be used to encrypt the binaries of an
+instance created before the encryption was enabled in the configuration.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		domain := args[0]
+		c := newAdminClient()
+		res, err := c.Req(&request.Options{
+			Method: "POST",
+			Path:   "/instances/" + url.PathEscape(domain) + "/files-key/rotate",
+		})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		var j struct {
+			ID string `json:"_id"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&j); err != nil {
+			return err
+		}
+		fmt.Printf("The key of %s is being rotated by the job %s\n", domain, j.ID)
+		return nil
+	},
+}
+
 func init() {
 	instanceCmdGroup.AddCommand(showInstanceCmd)
 	instanceCmdGroup.AddCommand(showDBPrefixInstanceCmd)
@@ -1065,6 +1137,8 @@ func init() {
 	instanceCmdGroup.AddCommand(instanceAppVersionCmd)
 	instanceCmdGroup.AddCommand(updateInstancePassphraseCmd)
 	instanceCmdGroup.AddCommand(setAuthModeCmd)
+	instanceCmdGroup.AddCommand(rebuildSearchIndexCmd)
+	instanceCmdGroup.AddCommand(rotateFilesKeyCmd)
 	addInstanceCmd.Flags().StringSliceVar(&flagDomainAliases, "domain-aliases", nil, "Specify one or more aliases domain for the instance (separated by ',')")
 	addInstanceCmd.Flags().StringVar(&flagLocale, "locale", consts.DefaultLocale, "Locale of the new cozy instance")
 	addInstanceCmd.Flags().StringVar(&flagUUID, "uuid", "", "The UUID of the instance")
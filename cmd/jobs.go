@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,6 +21,8 @@ var flagJobPrintLogs bool
 var flagJobPrintLogsVerbose bool
 var flagJobWorkers []string
 var flagJobsPurgeDuration string
+var flagDeadLettersWorker string
+var flagDeadLettersError string
 
 var jobsCmdGroup = &cobra.Command{
 	Use:   "jobs <command>",
@@ -113,6 +116,185 @@ var jobsPurgeCmd = &cobra.Command{
 	},
 }
 
+var deadLettersCmdGroup = &cobra.Command{
+	Use:   "dead-letters <command>",
+	Short: "Inspect and replay the jobs that have failed",
+	Long: `
+When a job has failed after all its executions, it is kept as a dead letter,
+with its message and its last error. The dead letters can be listed, inspected,
+modified, and replayed (a new job is pushed, and the dead letter is deleted).
+`,
+}
+
+// printDeadLettersResponse sends the request and prints the JSON response.
+func printDeadLettersResponse(opts *request.Options) error {
+	return printDeadLettersResponseFor(flagDomain, opts)
+}
+
+// printDeadLettersResponseFor sends the request to the given instance and
+// prints the JSON response.
+func printDeadLettersResponseFor(domain string, opts *request.Options) error {
+	c := newClient(domain, "io.cozy.jobs")
+	res, err := c.Req(opts)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 204 {
+		return nil
+	}
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+func deadLettersFilters() url.Values {
+	q := url.Values{}
+	if flagDeadLettersWorker != "" {
+		q.Add("Worker", flagDeadLettersWorker)
+	}
+	if flagDeadLettersError != "" {
+		q.Add("Error", flagDeadLettersError)
+	}
+	return q
+}
+
+var deadLettersListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List the dead letters",
+	Example: `$ cozy-stack jobs dead-letters ls --domain example.mycozy.cloud --worker sendmail`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if flagDomain == "" {
+			return errMissingDomain
+		}
+		return printDeadLettersResponse(&request.Options{
+			Method:  "GET",
+			Path:    "/jobs/dead-letters",
+			Queries: deadLettersFilters(),
+		})
+	},
+}
+
+var deadLettersShowCmd = &cobra.Command{
+	Use:     "show <id>",
+	Short:   "Show a dead letter",
+	Example: `$ cozy-stack jobs dead-letters show --domain example.mycozy.cloud 4a0c7b1e5b3a4f8e`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		if flagDomain == "" {
+			return errMissingDomain
+		}
+		return printDeadLettersResponse(&request.Options{
+			Method: "GET",
+			Path:   "/jobs/dead-letters/" + url.PathEscape(args[0]),
+		})
+	},
+}
+
+var deadLettersEditCmd = &cobra.Command{
+	Use:     "edit <id>",
+	Short:   "Change the message of a dead letter before replaying it",
+	Example: `$ cozy-stack jobs dead-letters edit --domain example.mycozy.cloud 4a0c7b1e5b3a4f8e --json '{"mode": "noreply"}'`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		if flagDomain == "" {
+			return errMissingDomain
+		}
+		if flagJobJSONArg == "" {
+			return errors.New("The JSON argument is missing")
+		}
+		body, err := json.Marshal(map[string]interface{}{
+			"data": map[string]interface{}{
+				"attributes": map[string]interface{}{
+					"message": json.RawMessage(flagJobJSONArg),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return printDeadLettersResponse(&request.Options{
+			Method: "PATCH",
+			Path:   "/jobs/dead-letters/" + url.PathEscape(args[0]),
+			Headers: request.Headers{
+				"Content-Type": "application/vnd.api+json",
+			},
+			Body: bytes.NewReader(body),
+		})
+	},
+}
+
+var deadLettersReplayCmd = &cobra.Command{
+	Use:   "replay [id]",
+	Short: "Replay a dead letter, or all the dead letters matching the filters",
+	Long: `
+cozy-stack jobs dead-letters replay pushes a new job for the given dead letter.
+Without an identifier, all the dead letters for the worker given by --worker,
+and whose error contains the string given by --error, are replayed. With
+--all-domains, they are replayed on all the instances.
+`,
+	Example: `$ cozy-stack jobs dead-letters replay --domain example.mycozy.cloud --worker sendmail --error timeout`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return cmd.Usage()
+		}
+		if flagAllDomains {
+			if len(args) == 1 {
+				return errors.New("A dead letter identifier can't be used with --all-domains")
+			}
+			return foreachDomains(func(in *client.Instance) error {
+				fmt.Printf("%s: ", in.Attrs.Domain)
+				return printDeadLettersResponseFor(in.Attrs.Domain, &request.Options{
+					Method:  "POST",
+					Path:    "/jobs/dead-letters/replay",
+					Queries: deadLettersFilters(),
+				})
+			})
+		}
+		if flagDomain == "" {
+			return errMissingDomain
+		}
+		if len(args) == 1 {
+			return printDeadLettersResponse(&request.Options{
+				Method: "POST",
+				Path:   "/jobs/dead-letters/" + url.PathEscape(args[0]) + "/replay",
+			})
+		}
+		return printDeadLettersResponse(&request.Options{
+			Method:  "POST",
+			Path:    "/jobs/dead-letters/replay",
+			Queries: deadLettersFilters(),
+		})
+	},
+}
+
+var deadLettersDiscardCmd = &cobra.Command{
+	Use:     "discard <id>",
+	Aliases: []string{"rm"},
+	Short:   "Delete a dead letter without replaying it",
+	Example: `$ cozy-stack jobs dead-letters discard --domain example.mycozy.cloud 4a0c7b1e5b3a4f8e`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		if flagDomain == "" {
+			return errMissingDomain
+		}
+		return printDeadLettersResponse(&request.Options{
+			Method: "DELETE",
+			Path:   "/jobs/dead-letters/" + url.PathEscape(args[0]),
+		})
+	},
+}
+
 func init() {
 	jobsCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 
@@ -123,7 +305,21 @@ func init() {
 	jobsPurgeCmd.Flags().StringSliceVar(&flagJobWorkers, "workers", nil, "worker types to iterate over (all workers by default)")
 	jobsPurgeCmd.Flags().StringVar(&flagJobsPurgeDuration, "duration", "", "duration to look for (ie. 3D, 2M)")
 
+	deadLettersListCmd.Flags().StringVar(&flagDeadLettersWorker, "worker", "", "filter on the worker type")
+	deadLettersListCmd.Flags().StringVar(&flagDeadLettersError, "error", "", "filter on a string contained in the error")
+	deadLettersReplayCmd.Flags().StringVar(&flagDeadLettersWorker, "worker", "", "filter on the worker type")
+	deadLettersReplayCmd.Flags().StringVar(&flagDeadLettersError, "error", "", "filter on a string contained in the error")
+	deadLettersReplayCmd.Flags().BoolVar(&flagAllDomains, "all-domains", false, "replay the dead letters on all the instances")
+	deadLettersEditCmd.Flags().StringVar(&flagJobJSONArg, "json", "", "specify the new message as raw JSON")
+
+	deadLettersCmdGroup.AddCommand(deadLettersListCmd)
+	deadLettersCmdGroup.AddCommand(deadLettersShowCmd)
+	deadLettersCmdGroup.AddCommand(deadLettersEditCmd)
+	deadLettersCmdGroup.AddCommand(deadLettersReplayCmd)
+	deadLettersCmdGroup.AddCommand(deadLettersDiscardCmd)
+
 	jobsCmdGroup.AddCommand(jobsRunCmd)
 	jobsCmdGroup.AddCommand(jobsPurgeCmd)
+	jobsCmdGroup.AddCommand(deadLettersCmdGroup)
 	RootCmd.AddCommand(jobsCmdGroup)
 }
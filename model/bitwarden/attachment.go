@@ -0,0 +1,203 @@
+package bitwarden
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/utils"
+)
+
+// AttachmentsDirName is the path of the hidden directory in the VFS where the
+// encrypted content of the attachments is stored.
+const AttachmentsDirName = "/.cozy_bitwarden/attachments"
+
+// ErrAttachmentNotFound is used when the attachment is not found in the
+// cipher.
+var ErrAttachmentNotFound = errors.New("Attachment not found")
+
+var attachmentMACConfig = crypto.MACConfig{
+	Name:   "bitwarden-attachment",
+	MaxAge: 5 * time.Minute,
+	MaxLen: 256,
+}
+
+// Attachment is a file attached to a cipher. Its name, its key and its content
+// are encrypted by the client, and the content is stored in the VFS, where it
+// counts in the disk usage of the instance.
+type Attachment struct {
+	ID       string `json:"id"`
+	FileName string `json:"filename"`
+	Key      string `json:"key,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+func attachmentPath(id string) string {
+	return path.Join(AttachmentsDirName, id)
+}
+
+// attachmentsDir returns the directory where the content of the attachments
+// of the cipher is stored. For a cipher in an organization, it is the
+// directory of the organization, that is shared with its members.
+func attachmentsDir(inst *instance.Instance, c *Cipher) (*vfs.DirDoc, error) {
+	if c.OrganizationID == "" {
+		return vfs.MkdirAll(inst.VFS(), AttachmentsDirName)
+	}
+	org, err := FindOrganization(inst, c.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	return org.attachmentsDir(inst)
+}
+
+// findAttachmentFile returns the VFS file with the content of the attachment.
+// The attachments added before the cipher was put in an organization can
+// still be in the default directory.
+func findAttachmentFile(inst *instance.Instance, c *Cipher, id string) (*vfs.FileDoc, error) {
+	fs := inst.VFS()
+	if c.OrganizationID != "" {
+		dir, err := attachmentsDir(inst, c)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := fs.FileByPath(path.Join(dir.Fullpath, id))
+		if !os.IsNotExist(err) {
+			return doc, err
+		}
+	}
+	return fs.FileByPath(attachmentPath(id))
+}
+
+// FindAttachment returns the attachment of the cipher with the given
+// identifier.
+func (c *Cipher) FindAttachment(id string) (*Attachment, error) {
+	for i := range c.Attachments {
+		if c.Attachments[i].ID == id {
+			return &c.Attachments[i], nil
+		}
+	}
+	return nil, ErrAttachmentNotFound
+}
+
+// AddAttachment stores the encrypted content of a new attachment in the VFS,
+// and adds the attachment to the cipher. The size can be -1 if it is not
+// known in advance. An error is returned if the disk quota is exceeded.
+func AddAttachment(inst *instance.Instance, c *Cipher, fileName, key string, size int64, content io.Reader) (*Attachment, error) {
+	fs := inst.VFS()
+	dir, err := attachmentsDir(inst, c)
+	if err != nil {
+		return nil, err
+	}
+
+	att := Attachment{
+		ID:       utils.RandomString(20),
+		FileName: fileName,
+		Key:      key,
+	}
+	doc, err := vfs.NewFileDoc(att.ID, dir.ID(), size, nil,
+		"application/octet-stream", "files", time.Now(), false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.CreateFile(doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(file, content)
+	if errc := file.Close(); errc != nil && err == nil {
+		err = errc
+	}
+	if err != nil {
+		return nil, err
+	}
+	att.Size = n
+
+	c.Attachments = append(c.Attachments, att)
+	if c.Metadata != nil {
+		c.Metadata.ChangeUpdatedAt()
+	}
+	if err := couchdb.UpdateDoc(inst, c); err != nil {
+		_ = destroyAttachmentFile(inst, c, att.ID)
+		return nil, err
+	}
+	return &att, nil
+}
+
+// OpenAttachment returns the VFS file with the encrypted content of the
+// attachment.
+func OpenAttachment(inst *instance.Instance, c *Cipher, att *Attachment) (*vfs.FileDoc, error) {
+	return findAttachmentFile(inst, c, att.ID)
+}
+
+// DeleteAttachment removes the attachment from the cipher, and destroys its
+// content.
+func DeleteAttachment(inst *instance.Instance, c *Cipher, id string) error {
+	if _, err := c.FindAttachment(id); err != nil {
+		return err
+	}
+	if err := destroyAttachmentFile(inst, c, id); err != nil {
+		return err
+	}
+
+	attachments := c.Attachments[:0]
+	for _, att := range c.Attachments {
+		if att.ID != id {
+			attachments = append(attachments, att)
+		}
+	}
+	c.Attachments = attachments
+	if c.Metadata != nil {
+		c.Metadata.ChangeUpdatedAt()
+	}
+	return couchdb.UpdateDoc(inst, c)
+}
+
+// DeleteAttachments destroys the content of all the attachments of a cipher.
+// It should be called when the cipher is deleted.
+func DeleteAttachments(inst *instance.Instance, c *Cipher) error {
+	for _, att := range c.Attachments {
+		if err := destroyAttachmentFile(inst, c, att.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyAttachmentFile(inst *instance.Instance, c *Cipher, id string) error {
+	fs := inst.VFS()
+	doc, err := findAttachmentFile(inst, c, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return fs.DestroyFile(doc)
+}
+
+// AttachmentToken returns a token that can be used to download the content of
+// an attachment. The clients download the attachments from the URL that is
+// given in the sync response, without their bearer token, so this token is
+// put in the URL instead.
+func AttachmentToken(inst *instance.Instance, cipherID, attachmentID string) (string, error) {
+	additionalData := []byte(inst.Domain + cipherID + attachmentID)
+	token, err := crypto.EncodeAuthMessage(attachmentMACConfig, inst.SessionSecret(), nil, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// CheckAttachmentToken returns true if the token has been generated for
+// downloading the given attachment.
+func CheckAttachmentToken(inst *instance.Instance, cipherID, attachmentID, token string) bool {
+	additionalData := []byte(inst.Domain + cipherID + attachmentID)
+	_, err := crypto.DecodeAuthMessage(attachmentMACConfig, inst.SessionSecret(), []byte(token), additionalData)
+	return err == nil
+}
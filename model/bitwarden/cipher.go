@@ -2,6 +2,7 @@ package bitwarden
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -61,6 +62,8 @@ type Cipher struct {
 	CouchRev       string                 `json:"_rev,omitempty"`
 	Type           CipherType             `json:"type"`
 	SharedWithCozy bool                   `json:"shared_with_cozy"`
+	OrganizationID string                 `json:"organization_id,omitempty"`
+	CollectionID   string                 `json:"collection_id,omitempty"`
 	Favorite       bool                   `json:"favorite,omitempty"`
 	Name           string                 `json:"name"`
 	Notes          string                 `json:"notes,omitempty"`
@@ -68,6 +71,8 @@ type Cipher struct {
 	Login          *LoginData             `json:"login,omitempty"`
 	Data           *MapData               `json:"data,omitempty"`
 	Fields         []Field                `json:"fields"`
+	Attachments    []Attachment           `json:"attachments,omitempty"`
+	DeletedDate    *time.Time             `json:"deleted_date,omitempty"`
 	Metadata       *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 }
 
@@ -96,6 +101,14 @@ func (c *Cipher) Clone() couchdb.Doc {
 	}
 	cloned.Fields = make([]Field, len(c.Fields))
 	copy(cloned.Fields, c.Fields)
+	if c.Attachments != nil {
+		cloned.Attachments = make([]Attachment, len(c.Attachments))
+		copy(cloned.Attachments, c.Attachments)
+	}
+	if c.DeletedDate != nil {
+		deleted := *c.DeletedDate
+		cloned.DeletedDate = &deleted
+	}
 	if c.Metadata != nil {
 		cloned.Metadata = c.Metadata.Clone()
 	}
@@ -123,8 +136,9 @@ func FindCiphersInFolder(inst *instance.Instance, folderID string) ([]*Cipher, e
 }
 
 // DeleteUnrecoverableCiphers will delete all the ciphers that are not shared
-// with the cozy organization. It should be called when the master password is
-// lost, as there are no ways to recover those encrypted ciphers.
+// with the cozy organization or another organization. It should be called
+// when the master password is lost, as there are no ways to recover those
+// encrypted ciphers.
 func DeleteUnrecoverableCiphers(inst *instance.Instance) error {
 	var ciphers []couchdb.Doc
 	err := couchdb.ForeachDocs(inst, consts.BitwardenCiphers, func(_ string, data json.RawMessage) error {
@@ -132,7 +146,7 @@ func DeleteUnrecoverableCiphers(inst *instance.Instance) error {
 		if err := json.Unmarshal(data, &c); err != nil {
 			return err
 		}
-		if !c.SharedWithCozy {
+		if !c.SharedWithCozy && c.OrganizationID == "" {
 			ciphers = append(ciphers, &c)
 		}
 		return nil
@@ -143,6 +157,11 @@ func DeleteUnrecoverableCiphers(inst *instance.Instance) error {
 		}
 		return err
 	}
+	for _, c := range ciphers {
+		if err := DeleteAttachments(inst, c.(*Cipher)); err != nil {
+			return err
+		}
+	}
 	return couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, ciphers)
 }
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
@@ -55,6 +56,46 @@ func TestDeleteUnrecoverableCiphers(t *testing.T) {
 	}
 }
 
+func TestPurgeTrash(t *testing.T) {
+	domain := "cozy.example.net"
+	err := lifecycle.Destroy(domain)
+	if err != instance.ErrNotFound {
+		assert.NoError(t, err)
+	}
+	inst, err := lifecycle.Create(&lifecycle.Options{
+		Domain:     domain,
+		Passphrase: "cozy",
+		PublicName: "Pierre",
+	})
+	assert.NoError(t, err)
+	defer func() {
+		_ = lifecycle.Destroy(inst.Domain)
+	}()
+
+	for i := 0; i < 3; i++ {
+		md := metadata.New()
+		md.DocTypeVersion = DocTypeVersion
+		cipher := &Cipher{
+			Type:     SecureNoteType,
+			Name:     fmt.Sprintf("2.%d|%d|%d", i, i, i),
+			Metadata: md,
+		}
+		if i > 0 {
+			deleted := time.Now().Add(-time.Duration(i) * 24 * time.Hour)
+			cipher.DeletedDate = &deleted
+		}
+		assert.NoError(t, couchdb.CreateDoc(inst, cipher))
+	}
+
+	deleted, err := PurgeTrash(inst, time.Now().Add(-36*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	var ciphers []*Cipher
+	err = couchdb.GetAllDocs(inst, consts.BitwardenCiphers, nil, &ciphers)
+	assert.NoError(t, err)
+	assert.Len(t, ciphers, 2)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	testutils.NeedCouchdb()
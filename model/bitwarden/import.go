@@ -0,0 +1,118 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// importBatchSize is the number of documents sent to CouchDB in a single bulk
+// request when a vault is imported.
+const importBatchSize = 1000
+
+// ImportVault persists the folders and the ciphers of an import. The folders
+// must already have an identifier, as the ciphers can reference them.
+//
+// The documents are written in bulk, without a realtime event for each of
+// them: a single event is published at the end on the com.bitwarden.profiles
+// doctype, and the clients do a full sync when they receive it. If some
+// documents can't be written, the import is rolled back, so that it can be
+// retried without creating duplicates.
+func ImportVault(inst *instance.Instance, folders []*Folder, ciphers []*Cipher) error {
+	folderDocs := make([]couchdb.Doc, len(folders))
+	for i, f := range folders {
+		folderDocs[i] = f
+	}
+	importedFolders, err := bulkImport(inst, consts.BitwardenFolders, folderDocs)
+	if err != nil {
+		rollbackImport(inst, consts.BitwardenFolders, importedFolders)
+		return err
+	}
+	cipherDocs := make([]couchdb.Doc, len(ciphers))
+	for i, c := range ciphers {
+		cipherDocs[i] = c
+	}
+	importedCiphers, err := bulkImport(inst, consts.BitwardenCiphers, cipherDocs)
+	if err != nil {
+		rollbackImport(inst, consts.BitwardenCiphers, importedCiphers)
+		rollbackImport(inst, consts.BitwardenFolders, importedFolders)
+		return err
+	}
+
+	doc := couchdb.JSONDoc{
+		Type: consts.BitwardenProfiles,
+		M: map[string]interface{}{
+			"_id":  inst.ID(),
+			"date": time.Now().UTC(),
+		},
+	}
+	realtime.GetHub().Publish(inst, realtime.EventUpdate, &doc, nil)
+	return nil
+}
+
+// bulkImport writes the documents by batches. It returns the documents that
+// have been written (with only their identifier and revision), and an error
+// with the indexes of the documents that have not been written.
+func bulkImport(inst *instance.Instance, doctype string, docs []couchdb.Doc) ([]couchdb.Doc, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	if err := couchdb.EnsureDBExist(inst, doctype); err != nil {
+		return nil, err
+	}
+	var imported []couchdb.Doc
+	var failed []int
+	var reason string
+	for offset := 0; offset < len(docs); offset += importBatchSize {
+		n := len(docs) - offset
+		if n > importBatchSize {
+			n = importBatchSize
+		}
+		// The documents are given to CouchDB as raw JSON: BulkUpdateDocs
+		// publishes a realtime event only for the values that implement the
+		// couchdb.Doc interface.
+		raws := make([]interface{}, n)
+		olds := make([]interface{}, n)
+		for i, doc := range docs[offset : offset+n] {
+			raw, err := json.Marshal(doc)
+			if err != nil {
+				return imported, err
+			}
+			raws[i] = json.RawMessage(raw)
+		}
+		res, err := couchdb.BulkUpdateDocsWithResponses(inst, doctype, raws, olds)
+		if err != nil {
+			return imported, err
+		}
+		for i, r := range res {
+			if r.Error != "" {
+				failed = append(failed, offset+i)
+				reason = r.Error + ": " + r.Reason
+				continue
+			}
+			imported = append(imported, &couchdb.JSONDoc{
+				Type: doctype,
+				M:    map[string]interface{}{"_id": r.ID, "_rev": r.Rev},
+			})
+		}
+	}
+	if len(failed) > 0 {
+		return imported, fmt.Errorf("%d documents of %s have not been imported (%s): %v",
+			len(failed), doctype, reason, failed)
+	}
+	return imported, nil
+}
+
+// rollbackImport deletes the documents written by an import that has failed.
+// The errors are only logged, as the error of the import is more relevant.
+func rollbackImport(inst *instance.Instance, doctype string, docs []couchdb.Doc) {
+	if err := couchdb.BulkDeleteDocs(inst, doctype, docs); err != nil {
+		inst.Logger().WithField("nspace", "bitwarden").
+			Warnf("Cannot roll back the import of %d %s: %s", len(docs), doctype, err)
+	}
+}
@@ -20,6 +20,7 @@ var BitwardenScope = strings.Join([]string{
 	consts.BitwardenCiphers,
 	consts.BitwardenFolders,
 	consts.BitwardenOrganizations,
+	consts.BitwardenSends,
 	consts.Konnectors,
 	consts.AppsSuggestion,
 }, " ")
@@ -0,0 +1,481 @@
+package bitwarden
+
+import (
+	"errors"
+
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+)
+
+// OrgMemberStatus is the status of a member of an organization.
+// See https://github.com/bitwarden/server/blob/master/src/Core/Enums/OrganizationUserStatusType.cs
+type OrgMemberStatus int
+
+const (
+	// OrgMemberInvited is used when the member has been invited, but their
+	// Cozy has not yet received the organization
+	OrgMemberInvited OrgMemberStatus = 0
+	// OrgMemberAccepted is used when the Cozy of the member has received the
+	// organization, and has added the public key of the member
+	OrgMemberAccepted OrgMemberStatus = 1
+	// OrgMemberConfirmed is used when the organization key has been encrypted
+	// with the public key of the member
+	OrgMemberConfirmed OrgMemberStatus = 2
+)
+
+// OrgMemberType is the role of a member in an organization.
+// See https://github.com/bitwarden/server/blob/master/src/Core/Enums/OrganizationUserType.cs
+type OrgMemberType int
+
+const (
+	// OrgMemberOwner is the role of the member who has created the
+	// organization
+	OrgMemberOwner OrgMemberType = 0
+	// OrgMemberAdmin is the role of a member who can manage the members and
+	// the collections
+	OrgMemberAdmin OrgMemberType = 1
+	// OrgMemberUser is the role of a member who can only use the collections
+	OrgMemberUser OrgMemberType = 2
+)
+
+var (
+	// ErrOrganizationNotFound is used when the organization doesn't exist, or
+	// when the user is not one of its members
+	ErrOrganizationNotFound = errors.New("Organization not found")
+	// ErrOrgMemberNotFound is used when the member is not in the organization
+	ErrOrgMemberNotFound = errors.New("Member not found")
+	// ErrCollectionNotFound is used when the collection is not in the
+	// organization
+	ErrCollectionNotFound = errors.New("Collection not found")
+	// ErrNotOrganizationAdmin is used when a member who is not an owner or an
+	// admin of the organization tries to manage it
+	ErrNotOrganizationAdmin = errors.New("Only the owners and admins can manage the organization")
+	// ErrOrgMemberNotAccepted is used when a member is confirmed before their
+	// Cozy has added their public key
+	ErrOrgMemberNotAccepted = errors.New("The member has not yet accepted the invitation")
+)
+
+// OrgMember is a member of an organization. Key is the organization key,
+// encrypted by a client with the public key of the member.
+type OrgMember struct {
+	ID        string          `json:"id"`
+	UserID    string          `json:"user_id,omitempty"`
+	Email     string          `json:"email"`
+	Name      string          `json:"name,omitempty"`
+	PublicKey string          `json:"public_key,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	Status    OrgMemberStatus `json:"status"`
+	Type      OrgMemberType   `json:"type"`
+}
+
+// Collection is a group of ciphers inside an organization. Its name is
+// encrypted with the organization key.
+type Collection struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Organization is a group of users who share some collections of ciphers. It
+// is shared between the Cozy instances of its members with a sharing on the
+// organization, on the ciphers that have its identifier as organization_id,
+// and on the directory with the content of their attachments.
+type Organization struct {
+	CouchID     string                 `json:"_id,omitempty"`
+	CouchRev    string                 `json:"_rev,omitempty"`
+	Name        string                 `json:"name"`
+	Members     []OrgMember            `json:"members"`
+	Collections []Collection           `json:"collections"`
+	SharingID   string                 `json:"sharing_id,omitempty"`
+	Metadata    *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// ID returns the organization qualified identifier
+func (o *Organization) ID() string { return o.CouchID }
+
+// Rev returns the organization revision
+func (o *Organization) Rev() string { return o.CouchRev }
+
+// DocType returns the organization document type
+func (o *Organization) DocType() string { return consts.BitwardenOrganizations }
+
+// Clone implements couchdb.Doc
+func (o *Organization) Clone() couchdb.Doc {
+	cloned := *o
+	cloned.Members = make([]OrgMember, len(o.Members))
+	copy(cloned.Members, o.Members)
+	cloned.Collections = make([]Collection, len(o.Collections))
+	copy(cloned.Collections, o.Collections)
+	if o.Metadata != nil {
+		cloned.Metadata = o.Metadata.Clone()
+	}
+	return &cloned
+}
+
+// SetID changes the organization qualified identifier
+func (o *Organization) SetID(id string) { o.CouchID = id }
+
+// SetRev changes the organization revision
+func (o *Organization) SetRev(rev string) { o.CouchRev = rev }
+
+// Self returns the member of the organization for the given instance, or nil
+// if the user is not a member. An invited member is recognized by their email
+// address.
+func (o *Organization) Self(inst *instance.Instance) *OrgMember {
+	for i, m := range o.Members {
+		if m.UserID == inst.ID() {
+			return &o.Members[i]
+		}
+	}
+	for i, m := range o.Members {
+		if m.UserID == "" && isInstanceEmail(inst, m.Email) {
+			return &o.Members[i]
+		}
+	}
+	return nil
+}
+
+// IsConfirmed returns true if the user of the given instance is a member of
+// the organization, and has received the organization key.
+func (o *Organization) IsConfirmed(inst *instance.Instance) bool {
+	self := o.Self(inst)
+	return self != nil && self.Status == OrgMemberConfirmed
+}
+
+// CanManage returns true if the user of the given instance is an owner or an
+// admin of the organization.
+func (o *Organization) CanManage(inst *instance.Instance) bool {
+	self := o.Self(inst)
+	return self != nil && self.Status == OrgMemberConfirmed &&
+		(self.Type == OrgMemberOwner || self.Type == OrgMemberAdmin)
+}
+
+// FindMember returns the member with the given identifier.
+func (o *Organization) FindMember(id string) (*OrgMember, error) {
+	for i, m := range o.Members {
+		if m.ID == id {
+			return &o.Members[i], nil
+		}
+	}
+	return nil, ErrOrgMemberNotFound
+}
+
+// FindCollection returns the collection with the given identifier.
+func (o *Organization) FindCollection(id string) (*Collection, error) {
+	for i, c := range o.Collections {
+		if c.ID == id {
+			return &o.Collections[i], nil
+		}
+	}
+	return nil, ErrCollectionNotFound
+}
+
+// Invite adds a member to the organization for the given email address. The
+// member will accept the invitation when the organization is received by their
+// Cozy.
+func (o *Organization) Invite(inst *instance.Instance, email string, typ OrgMemberType) (*OrgMember, error) {
+	for i, m := range o.Members {
+		if m.Email == email {
+			return &o.Members[i], nil
+		}
+	}
+	id, err := couchdb.UUID(inst)
+	if err != nil {
+		return nil, err
+	}
+	if typ == OrgMemberOwner {
+		typ = OrgMemberAdmin
+	}
+	o.Members = append(o.Members, OrgMember{
+		ID:     id,
+		Email:  email,
+		Status: OrgMemberInvited,
+		Type:   typ,
+	})
+	return &o.Members[len(o.Members)-1], nil
+}
+
+// Accept is called on the Cozy of an invited member to add their public key
+// to the organization. It returns false if there was no invitation to accept.
+func (o *Organization) Accept(inst *instance.Instance, setting *settings.Settings) bool {
+	self := o.Self(inst)
+	if self == nil || self.Status != OrgMemberInvited || setting.PublicKey == "" {
+		return false
+	}
+	self.UserID = inst.ID()
+	self.PublicKey = setting.PublicKey
+	self.Name = instancePublicName(inst)
+	self.Status = OrgMemberAccepted
+	return true
+}
+
+// Confirm saves the organization key encrypted with the public key of the
+// member.
+func (o *Organization) Confirm(memberID, key string) error {
+	m, err := o.FindMember(memberID)
+	if err != nil {
+		return err
+	}
+	if m.Status == OrgMemberInvited {
+		return ErrOrgMemberNotAccepted
+	}
+	m.Key = key
+	m.Status = OrgMemberConfirmed
+	return nil
+}
+
+// RemoveMember removes a member from the organization.
+func (o *Organization) RemoveMember(memberID string) error {
+	if _, err := o.FindMember(memberID); err != nil {
+		return err
+	}
+	members := o.Members[:0]
+	for _, m := range o.Members {
+		if m.ID != memberID {
+			members = append(members, m)
+		}
+	}
+	o.Members = members
+	return nil
+}
+
+// AddCollection creates a new collection in the organization.
+func (o *Organization) AddCollection(inst *instance.Instance, name string) (*Collection, error) {
+	id, err := couchdb.UUID(inst)
+	if err != nil {
+		return nil, err
+	}
+	o.Collections = append(o.Collections, Collection{ID: id, Name: name})
+	return &o.Collections[len(o.Collections)-1], nil
+}
+
+// RemoveCollection removes a collection from the organization. The ciphers
+// of this collection are kept in the organization, without a collection.
+func (o *Organization) RemoveCollection(inst *instance.Instance, id string) error {
+	if _, err := o.FindCollection(id); err != nil {
+		return err
+	}
+	collections := o.Collections[:0]
+	for _, c := range o.Collections {
+		if c.ID != id {
+			collections = append(collections, c)
+		}
+	}
+	o.Collections = collections
+
+	ciphers, err := FindCiphersInOrganization(inst, o.CouchID)
+	if err != nil {
+		return err
+	}
+	docs := make([]interface{}, 0, len(ciphers))
+	olds := make([]interface{}, 0, len(ciphers))
+	for _, old := range ciphers {
+		if old.CollectionID != id {
+			continue
+		}
+		cipher := old.Clone().(*Cipher)
+		cipher.CollectionID = ""
+		docs = append(docs, cipher)
+		olds = append(olds, old)
+	}
+	return couchdb.BulkUpdateDocs(inst, consts.BitwardenCiphers, docs, olds)
+}
+
+// Save persists the organization in CouchDB.
+func (o *Organization) Save(inst *instance.Instance) error {
+	if o.Metadata == nil {
+		md := metadata.New()
+		md.DocTypeVersion = DocTypeVersion
+		o.Metadata = md
+	} else {
+		o.Metadata.ChangeUpdatedAt()
+	}
+	if o.CouchID == "" {
+		return couchdb.CreateDoc(inst, o)
+	}
+	return couchdb.UpdateDoc(inst, o)
+}
+
+// CreateOrganization creates a new organization, with the user of the given
+// instance as its owner, and a first collection. The key is the organization
+// key, encrypted by the client with the public key of the user.
+func CreateOrganization(inst *instance.Instance, setting *settings.Settings, name, key, collectionName string) (*Organization, error) {
+	memberID, err := couchdb.UUID(inst)
+	if err != nil {
+		return nil, err
+	}
+	org := &Organization{
+		Name: name,
+		Members: []OrgMember{
+			{
+				ID:        memberID,
+				UserID:    inst.ID(),
+				Email:     string(inst.PassphraseSalt()),
+				Name:      instancePublicName(inst),
+				PublicKey: setting.PublicKey,
+				Key:       key,
+				Status:    OrgMemberConfirmed,
+				Type:      OrgMemberOwner,
+			},
+		},
+	}
+	if _, err := org.AddCollection(inst, collectionName); err != nil {
+		return nil, err
+	}
+	if err := org.Save(inst); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// FindOrganization returns the organization with the given identifier, if
+// the user of the instance is one of its members.
+func FindOrganization(inst *instance.Instance, id string) (*Organization, error) {
+	if id == "" {
+		return nil, ErrOrganizationNotFound
+	}
+	org := &Organization{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenOrganizations, id, org); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+	if org.Self(inst) == nil {
+		return nil, ErrOrganizationNotFound
+	}
+	return org, nil
+}
+
+// ListOrganizations returns the organizations where the user of the instance
+// is a member.
+func ListOrganizations(inst *instance.Instance) ([]*Organization, error) {
+	var docs []*Organization
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenOrganizations, req, &docs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	orgs := docs[:0]
+	for _, org := range docs {
+		if org.Self(inst) != nil {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs, nil
+}
+
+// AcceptOrganizations accepts the invitations in the organizations that have
+// been received by the Cozy of the user via a sharing.
+func AcceptOrganizations(inst *instance.Instance, setting *settings.Settings) error {
+	orgs, err := ListOrganizations(inst)
+	if err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		if org.Accept(inst, setting) {
+			if err := org.Save(inst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteOrganization revokes the sharing of the organization, and deletes
+// the organization with its ciphers.
+func DeleteOrganization(inst *instance.Instance, org *Organization) error {
+	if err := org.RevokeSharing(inst); err != nil {
+		return err
+	}
+	ciphers, err := FindCiphersInOrganization(inst, org.CouchID)
+	if err != nil {
+		return err
+	}
+	docs := make([]couchdb.Doc, len(ciphers))
+	for i, c := range ciphers {
+		if err := DeleteAttachments(inst, c); err != nil {
+			return err
+		}
+		docs[i] = c
+	}
+	if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, docs); err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(inst, org)
+}
+
+// FindCiphersInOrganization finds the ciphers in the given organization.
+func FindCiphersInOrganization(inst *instance.Instance, orgID string) ([]*Cipher, error) {
+	var ciphers []*Cipher
+	req := &couchdb.FindRequest{
+		UseIndex: "by-organization-id",
+		Selector: mango.Equal("organization_id", orgID),
+		Limit:    10000,
+	}
+	err := couchdb.FindDocs(inst, consts.BitwardenCiphers, req, &ciphers)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ciphers, nil
+}
+
+// SetCollection puts the cipher in a collection. It can be the collection of
+// the Cozy organization, or a collection of an organization where the user is
+// a confirmed member. The cipher is not persisted.
+func SetCollection(inst *instance.Instance, setting *settings.Settings, c *Cipher, orgID string, collectionIDs []string) error {
+	if len(collectionIDs) != 1 {
+		return ErrCollectionNotFound
+	}
+	collectionID := collectionIDs[0]
+	if collectionID == setting.CollectionID {
+		c.SharedWithCozy = true
+		c.OrganizationID = ""
+		c.CollectionID = ""
+		return nil
+	}
+	org, err := FindOrganization(inst, orgID)
+	if err != nil {
+		return err
+	}
+	if !org.IsConfirmed(inst) {
+		return ErrOrganizationNotFound
+	}
+	if _, err := org.FindCollection(collectionID); err != nil {
+		return err
+	}
+	c.SharedWithCozy = false
+	c.OrganizationID = org.CouchID
+	c.CollectionID = collectionID
+	return nil
+}
+
+func isInstanceEmail(inst *instance.Instance, email string) bool {
+	if email == "" {
+		return false
+	}
+	if email == string(inst.PassphraseSalt()) {
+		return true
+	}
+	addr, err := inst.SettingsEMail()
+	return err == nil && addr == email
+}
+
+func instancePublicName(inst *instance.Instance) string {
+	doc, err := inst.SettingsDocument()
+	if err != nil {
+		return ""
+	}
+	name, _ := doc.M["public_name"].(string)
+	return name
+}
+
+var _ couchdb.Doc = &Organization{}
@@ -0,0 +1,205 @@
+package bitwarden
+
+import (
+	"errors"
+	"os"
+	"path"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+)
+
+// ErrNotOrganizationSharer is used when the members of an organization are
+// changed on the Cozy of a member who is not the owner of the sharing of the
+// organization: only the owner can add or revoke the recipients.
+var ErrNotOrganizationSharer = errors.New("Only the Cozy of the owner can change the members of the organization")
+
+// organizationSharingRules returns the rules of the sharing for the given
+// organization: the organization itself, its ciphers, and the directory with
+// the content of their attachments.
+func organizationSharingRules(orgID, dirID string) []sharing.Rule {
+	return []sharing.Rule{
+		{
+			Title:   "Organization",
+			DocType: consts.BitwardenOrganizations,
+			Values:  []string{orgID},
+			Add:     "sync",
+			Update:  "sync",
+			Remove:  "sync",
+		},
+		{
+			Title:    "Ciphers",
+			DocType:  consts.BitwardenCiphers,
+			Selector: "organization_id",
+			Values:   []string{orgID},
+			Add:      "sync",
+			Update:   "sync",
+			Remove:   "sync",
+		},
+		{
+			Title:   "Attachments",
+			DocType: consts.Files,
+			Values:  []string{dirID},
+			Add:     "sync",
+			Update:  "sync",
+			Remove:  "sync",
+		},
+	}
+}
+
+// findSharing returns the sharing of the organization, or nil if the
+// organization has not been shared.
+func (o *Organization) findSharing(inst *instance.Instance) (*sharing.Sharing, error) {
+	if o.SharingID == "" {
+		return nil, nil
+	}
+	return sharing.FindSharing(inst, o.SharingID)
+}
+
+// attachmentsDir returns the directory where the content of the attachments
+// of the ciphers of the organization is stored. On the Cozy of the owner of
+// the sharing, it is a sub-directory of the attachments directory, and on the
+// Cozy of a recipient, it is the directory of the sharing.
+func (o *Organization) attachmentsDir(inst *instance.Instance) (*vfs.DirDoc, error) {
+	s, err := o.findSharing(inst)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil && !s.Owner {
+		return s.GetSharingDir(inst)
+	}
+	return vfs.MkdirAll(inst.VFS(), path.Join(AttachmentsDirName, o.CouchID))
+}
+
+// ShareWithMembers shares the organization with the Cozy instances of the
+// members, in a new sharing or in the existing one. The organization must be
+// saved after that, as the identifier of the sharing is kept in it.
+func (o *Organization) ShareWithMembers(inst *instance.Instance, emails []string) error {
+	s, err := o.findSharing(inst)
+	if err != nil {
+		return err
+	}
+	if s != nil && !s.Owner {
+		return ErrNotOrganizationSharer
+	}
+
+	// A new sharing is created when the previous one has been deactivated,
+	// after the revocation of all its recipients
+	if s == nil || !s.Active {
+		dir, err := o.attachmentsDir(inst)
+		if err != nil {
+			return err
+		}
+		s = &sharing.Sharing{
+			Description: o.Name,
+			Rules:       organizationSharingRules(o.CouchID, dir.ID()),
+		}
+		if err := s.BeOwner(inst, consts.PasswordsSlug); err != nil {
+			return err
+		}
+		addSharingRecipients(inst, s, emails)
+		codes, err := s.Create(inst)
+		if err != nil {
+			return err
+		}
+		o.SharingID = s.SID
+		return s.SendMails(inst, codes)
+	}
+
+	addSharingRecipients(inst, s, emails)
+	if err := s.SendMails(inst, nil); err != nil {
+		return err
+	}
+	cloned := s.Clone().(*sharing.Sharing)
+	go cloned.NotifyRecipients(inst, nil)
+	return nil
+}
+
+// addSharingRecipients adds the emails to the recipients of the sharing,
+// except the ones of the members who have already accepted it.
+func addSharingRecipients(inst *instance.Instance, s *sharing.Sharing, emails []string) {
+	for _, email := range emails {
+		ready := false
+		for i, m := range s.Members {
+			if i > 0 && m.Email == email && m.Status == sharing.MemberStatusReady {
+				ready = true
+			}
+		}
+		if !ready {
+			s.AddEmail(inst, email, false)
+		}
+	}
+}
+
+// RevokeMemberSharing revokes the access of a removed member to the sharing of
+// the organization.
+func (o *Organization) RevokeMemberSharing(inst *instance.Instance, m *OrgMember) error {
+	s, err := o.findSharing(inst)
+	if err != nil || s == nil {
+		return err
+	}
+	if !s.Owner {
+		return ErrNotOrganizationSharer
+	}
+	for i, member := range s.Members {
+		if i == 0 || member.Email != m.Email || member.Status == sharing.MemberStatusRevoked {
+			continue
+		}
+		if err := s.RevokeRecipient(inst, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LeaveSharing revokes the sharing of the organization on the Cozy of a
+// member who leaves it.
+func (o *Organization) LeaveSharing(inst *instance.Instance) error {
+	s, err := o.findSharing(inst)
+	if err != nil || s == nil || s.Owner || !s.Active {
+		return err
+	}
+	return s.RevokeRecipientBySelf(inst)
+}
+
+// RevokeSharing revokes the sharing of the organization for all its members.
+func (o *Organization) RevokeSharing(inst *instance.Instance) error {
+	s, err := o.findSharing(inst)
+	if err != nil || s == nil || !s.Active {
+		return err
+	}
+	if !s.Owner {
+		return s.RevokeRecipientBySelf(inst)
+	}
+	return s.Revoke(inst)
+}
+
+// MoveAttachments moves the content of the attachments of a cipher that has
+// been put in an organization to the directory of this organization, so that
+// they are shared with its members.
+func MoveAttachments(inst *instance.Instance, c *Cipher) error {
+	if len(c.Attachments) == 0 || c.OrganizationID == "" {
+		return nil
+	}
+	dir, err := attachmentsDir(inst, c)
+	if err != nil {
+		return err
+	}
+	fs := inst.VFS()
+	dirID := dir.ID()
+	for _, att := range c.Attachments {
+		doc, err := fs.FileByPath(attachmentPath(att.ID))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if _, err := vfs.ModifyFileMetadata(fs, doc, &vfs.DocPatch{DirID: &dirID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
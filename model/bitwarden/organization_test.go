@@ -0,0 +1,128 @@
+package bitwarden
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func createOrgInstance(t *testing.T, domain, name string) *instance.Instance {
+	err := lifecycle.Destroy(domain)
+	if err != instance.ErrNotFound {
+		assert.NoError(t, err)
+	}
+	inst, err := lifecycle.Create(&lifecycle.Options{
+		Domain:     domain,
+		Passphrase: "cozy",
+		PublicName: name,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inst
+}
+
+func TestOrganization(t *testing.T) {
+	alice := createOrgInstance(t, "alice.cozy.example.net", "Alice")
+	defer func() {
+		_ = lifecycle.Destroy(alice.Domain)
+	}()
+	bob := createOrgInstance(t, "bob.cozy.example.net", "Bob")
+	defer func() {
+		_ = lifecycle.Destroy(bob.Domain)
+	}()
+
+	aliceSettings := &settings.Settings{PublicKey: "alice-public-key"}
+	bobSettings := &settings.Settings{PublicKey: "bob-public-key"}
+
+	org, err := CreateOrganization(alice, aliceSettings, "Family", "alice-org-key", "2.coll|coll|coll")
+	assert.NoError(t, err)
+	assert.Len(t, org.Members, 1)
+	assert.Len(t, org.Collections, 1)
+	assert.True(t, org.IsConfirmed(alice))
+	assert.True(t, org.CanManage(alice))
+	assert.Nil(t, org.Self(bob))
+
+	invited, err := org.Invite(alice, string(bob.PassphraseSalt()), OrgMemberOwner)
+	assert.NoError(t, err)
+	assert.Equal(t, OrgMemberAdmin, invited.Type)
+	assert.Equal(t, OrgMemberInvited, invited.Status)
+	memberID := invited.ID
+	assert.Equal(t, ErrOrgMemberNotAccepted, org.Confirm(memberID, "bob-org-key"))
+	assert.NoError(t, org.Save(alice))
+
+	// Simulate the replication of the organization by a Cozy sharing
+	replicated := org.Clone().(*Organization)
+	replicated.SetRev("")
+	assert.NoError(t, couchdb.CreateNamedDocWithDB(bob, replicated))
+	assert.NoError(t, AcceptOrganizations(bob, bobSettings))
+	bobOrg, err := FindOrganization(bob, org.ID())
+	assert.NoError(t, err)
+	self := bobOrg.Self(bob)
+	if !assert.NotNil(t, self) {
+		return
+	}
+	assert.Equal(t, OrgMemberAccepted, self.Status)
+	assert.Equal(t, bob.ID(), self.UserID)
+	assert.Equal(t, "bob-public-key", self.PublicKey)
+	assert.Equal(t, "Bob", self.Name)
+	assert.False(t, bobOrg.IsConfirmed(bob))
+	assert.False(t, bobOrg.CanManage(bob))
+
+	assert.NoError(t, bobOrg.Confirm(memberID, "bob-org-key"))
+	assert.NoError(t, bobOrg.Save(bob))
+	assert.True(t, bobOrg.IsConfirmed(bob))
+	assert.True(t, bobOrg.CanManage(bob))
+
+	collID := bobOrg.Collections[0].ID
+	cipher := &Cipher{
+		Type:     SecureNoteType,
+		Name:     "2.note|note|note",
+		Metadata: metadata.New(),
+	}
+	err = SetCollection(bob, bobSettings, cipher, bobOrg.ID(), []string{"unknown"})
+	assert.Equal(t, ErrCollectionNotFound, err)
+	assert.NoError(t, SetCollection(bob, bobSettings, cipher, bobOrg.ID(), []string{collID}))
+	assert.Equal(t, bobOrg.ID(), cipher.OrganizationID)
+	assert.Equal(t, collID, cipher.CollectionID)
+	assert.NoError(t, couchdb.CreateDoc(bob, cipher))
+
+	assert.NoError(t, bobOrg.RemoveCollection(bob, collID))
+	ciphers, err := FindCiphersInOrganization(bob, bobOrg.ID())
+	assert.NoError(t, err)
+	if assert.Len(t, ciphers, 1) {
+		assert.Empty(t, ciphers[0].CollectionID)
+	}
+
+	// The ciphers of an organization are not unrecoverable
+	assert.NoError(t, DeleteUnrecoverableCiphers(bob))
+	ciphers, err = FindCiphersInOrganization(bob, bobOrg.ID())
+	assert.NoError(t, err)
+	assert.Len(t, ciphers, 1)
+
+	assert.NoError(t, DeleteOrganization(bob, bobOrg))
+	_, err = FindOrganization(bob, bobOrg.ID())
+	assert.Equal(t, ErrOrganizationNotFound, err)
+	var docs []*Cipher
+	err = couchdb.GetAllDocs(bob, consts.BitwardenCiphers, nil, &docs)
+	assert.NoError(t, err)
+	assert.Len(t, docs, 0)
+}
+
+func TestOrganizationSharingRules(t *testing.T) {
+	s := &sharing.Sharing{Rules: organizationSharingRules("org1", "dir1")}
+	assert.NoError(t, s.ValidateRules())
+	assert.Len(t, s.Rules, 3)
+	assert.Equal(t, consts.BitwardenOrganizations, s.Rules[0].DocType)
+	assert.Equal(t, []string{"org1"}, s.Rules[1].Values)
+	if rule := s.FirstFilesRule(); assert.NotNil(t, rule) {
+		assert.Equal(t, []string{"dir1"}, rule.Values)
+	}
+}
@@ -0,0 +1,429 @@
+package bitwarden
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/pkg/utils"
+)
+
+// SendsDirName is the path of the hidden directory in the VFS where the
+// encrypted content of the file sends is stored.
+const SendsDirName = "/.cozy_bitwarden/sends"
+
+// MaxSendLifetime is the maximal duration between the creation of a send and
+// its deletion date.
+const MaxSendLifetime = 31 * 24 * time.Hour
+
+var (
+	// ErrSendNotFound is used when the send doesn't exist, or can no longer
+	// be accessed.
+	ErrSendNotFound = errors.New("Send not found")
+	// ErrSendPasswordRequired is used when the send is protected by a
+	// password, and no password has been given.
+	ErrSendPasswordRequired = errors.New("Password required")
+	// ErrSendInvalidPassword is used when the password is not the good one.
+	ErrSendInvalidPassword = errors.New("Invalid password")
+)
+
+var sendFileMACConfig = crypto.MACConfig{
+	Name:   "bitwarden-send-file",
+	MaxAge: 5 * time.Minute,
+	MaxLen: 256,
+}
+
+// SendType is used to know if a send is a text or a file.
+type SendType int
+
+// SendTextType and SendFileType are the 2 possible types of sends.
+const (
+	SendTextType SendType = 0
+	SendFileType SendType = 1
+)
+
+// SendText is the encrypted text of a send.
+type SendText struct {
+	Text   string `json:"text"`
+	Hidden bool   `json:"hidden"`
+}
+
+// SendFile is the file of a send. Its name is encrypted, and its encrypted
+// content is stored in the VFS, where it counts in the disk usage of the
+// instance.
+type SendFile struct {
+	ID       string `json:"id"`
+	FileName string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// Send is an ephemeral text or file, encrypted on client-side, that can be
+// shared with anyone via a link. The key to decrypt it is in the fragment of
+// the link, and is never sent to the stack.
+type Send struct {
+	CouchID        string                 `json:"_id,omitempty"`
+	CouchRev       string                 `json:"_rev,omitempty"`
+	Type           SendType               `json:"type"`
+	Name           string                 `json:"name"`
+	Notes          string                 `json:"notes,omitempty"`
+	Key            string                 `json:"key"`
+	Text           *SendText              `json:"text,omitempty"`
+	File           *SendFile              `json:"file,omitempty"`
+	Password       string                 `json:"password,omitempty"`
+	MaxAccessCount *int                   `json:"max_access_count,omitempty"`
+	AccessCount    int                    `json:"access_count"`
+	Disabled       bool                   `json:"disabled,omitempty"`
+	HideEmail      bool                   `json:"hide_email,omitempty"`
+	ExpirationDate *time.Time             `json:"expiration_date,omitempty"`
+	DeletionDate   time.Time              `json:"deletion_date"`
+	Metadata       *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// ID returns the send qualified identifier
+func (s *Send) ID() string { return s.CouchID }
+
+// Rev returns the send revision
+func (s *Send) Rev() string { return s.CouchRev }
+
+// DocType returns the send document type
+func (s *Send) DocType() string { return consts.BitwardenSends }
+
+// Clone implements couchdb.Doc
+func (s *Send) Clone() couchdb.Doc {
+	cloned := *s
+	if s.Text != nil {
+		text := *s.Text
+		cloned.Text = &text
+	}
+	if s.File != nil {
+		file := *s.File
+		cloned.File = &file
+	}
+	if s.MaxAccessCount != nil {
+		max := *s.MaxAccessCount
+		cloned.MaxAccessCount = &max
+	}
+	if s.ExpirationDate != nil {
+		date := *s.ExpirationDate
+		cloned.ExpirationDate = &date
+	}
+	if s.Metadata != nil {
+		cloned.Metadata = s.Metadata.Clone()
+	}
+	return &cloned
+}
+
+// SetID changes the send qualified identifier
+func (s *Send) SetID(id string) { s.CouchID = id }
+
+// SetRev changes the send revision
+func (s *Send) SetRev(rev string) { s.CouchRev = rev }
+
+// AccessID returns the identifier used in the link given to the recipients of
+// the send. It is the identifier of the document, encoded in base64url.
+func (s *Send) AccessID() string {
+	raw, err := hex.DecodeString(s.CouchID)
+	if err != nil {
+		raw = []byte(s.CouchID)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// SendIDFromAccessID returns the identifier of the send document for the
+// given access identifier.
+func SendIDFromAccessID(accessID string) string {
+	raw, err := base64.RawURLEncoding.DecodeString(accessID)
+	if err != nil {
+		return ""
+	}
+	if len(raw) == 16 {
+		return hex.EncodeToString(raw)
+	}
+	return string(raw)
+}
+
+// SetPassword protects the send with a password. The client sends a hash of
+// the password, and it is hashed again before being persisted. An empty
+// password removes the protection.
+func (s *Send) SetPassword(password string) error {
+	if password == "" {
+		s.Password = ""
+		return nil
+	}
+	hash, err := crypto.GenerateFromPassphrase([]byte(password))
+	if err != nil {
+		return err
+	}
+	s.Password = string(hash)
+	return nil
+}
+
+// CheckPassword returns an error if the send is protected by a password, and
+// the given password is not the good one.
+func (s *Send) CheckPassword(password string) error {
+	if s.Password == "" {
+		return nil
+	}
+	if password == "" {
+		return ErrSendPasswordRequired
+	}
+	if _, err := crypto.CompareHashAndPassphrase([]byte(s.Password), []byte(password)); err != nil {
+		return ErrSendInvalidPassword
+	}
+	return nil
+}
+
+// maxAccessRetries is the number of times the access counter of a send is
+// re-fetched and incremented again after a conflict.
+const maxAccessRetries = 5
+
+// Accessible returns true if the recipients can still access the send: it
+// is not disabled, not expired, and the maximal number of accesses has not
+// been reached.
+func (s *Send) Accessible() bool {
+	return s.accessible(false)
+}
+
+// Downloadable returns true if the file of the send can still be downloaded.
+// The access counter has already been incremented when the download URL has
+// been given, so the file can be downloaded when the counter is at the
+// maximal number of accesses.
+func (s *Send) Downloadable() bool {
+	return s.accessible(true)
+}
+
+func (s *Send) accessible(counted bool) bool {
+	now := time.Now()
+	if s.Disabled || now.After(s.DeletionDate) {
+		return false
+	}
+	if s.ExpirationDate != nil && now.After(*s.ExpirationDate) {
+		return false
+	}
+	if s.MaxAccessCount != nil {
+		if s.AccessCount > *s.MaxAccessCount {
+			return false
+		}
+		if s.AccessCount == *s.MaxAccessCount && !counted {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckAccess returns an error if the send can't be accessed with the given
+// password.
+func (s *Send) CheckAccess(password string) error {
+	if !s.Accessible() {
+		return ErrSendNotFound
+	}
+	return s.CheckPassword(password)
+}
+
+// Access checks that the send can be accessed with the given password, and
+// increments its access counter. The counter is persisted. On a conflict, the
+// send is fetched again, and it must still be accessible, so that the
+// concurrent accesses can't exceed the maximal number of accesses.
+func (s *Send) Access(inst *instance.Instance, password string) error {
+	if err := s.CheckAccess(password); err != nil {
+		return err
+	}
+	var err error
+	for i := 0; i < maxAccessRetries; i++ {
+		s.AccessCount++
+		err = couchdb.UpdateDoc(inst, s)
+		if !couchdb.IsConflictError(err) {
+			return err
+		}
+		fresh, errf := FindSend(inst, s.CouchID)
+		if errf != nil {
+			return errf
+		}
+		*s = *fresh
+		if !s.Accessible() {
+			return ErrSendNotFound
+		}
+	}
+	return err
+}
+
+// FindSend returns the send with the given identifier.
+func FindSend(inst *instance.Instance, id string) (*Send, error) {
+	if id == "" {
+		return nil, ErrSendNotFound
+	}
+	send := &Send{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenSends, id, send); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrSendNotFound
+		}
+		return nil, err
+	}
+	return send, nil
+}
+
+func sendFilePath(id string) string {
+	return path.Join(SendsDirName, id)
+}
+
+// AddSendFile stores the encrypted content of the file of a send in the VFS.
+// The send is not persisted. An error is returned if the disk quota is
+// exceeded.
+func AddSendFile(inst *instance.Instance, s *Send, fileName string, size int64, content io.Reader) error {
+	fs := inst.VFS()
+	dir, err := vfs.MkdirAll(fs, SendsDirName)
+	if err != nil {
+		return err
+	}
+
+	file := SendFile{
+		ID:       utils.RandomString(20),
+		FileName: fileName,
+	}
+	doc, err := vfs.NewFileDoc(file.ID, dir.ID(), size, nil,
+		"application/octet-stream", "files", time.Now(), false, false, nil)
+	if err != nil {
+		return err
+	}
+	f, err := fs.CreateFile(doc, nil)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, content)
+	if errc := f.Close(); errc != nil && err == nil {
+		err = errc
+	}
+	if err != nil {
+		return err
+	}
+	file.Size = n
+	s.File = &file
+	return nil
+}
+
+// OpenSendFile returns the VFS file with the encrypted content of the file of
+// a send.
+func OpenSendFile(inst *instance.Instance, s *Send) (*vfs.FileDoc, error) {
+	if s.File == nil {
+		return nil, os.ErrNotExist
+	}
+	return inst.VFS().FileByPath(sendFilePath(s.File.ID))
+}
+
+// DeleteSend deletes the send, with the content of its file.
+func DeleteSend(inst *instance.Instance, s *Send) error {
+	if err := DestroySendFile(inst, s); err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(inst, s)
+}
+
+// DestroySendFile destroys the content of the file of a send.
+func DestroySendFile(inst *instance.Instance, s *Send) error {
+	if s.File == nil {
+		return nil
+	}
+	fs := inst.VFS()
+	doc, err := fs.FileByPath(sendFilePath(s.File.ID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return fs.DestroyFile(doc)
+}
+
+// SendFileToken returns a short-lived token that can be used by a recipient
+// to download the content of the file of a send.
+func SendFileToken(inst *instance.Instance, sendID, fileID string) (string, error) {
+	additionalData := []byte(inst.Domain + sendID + fileID)
+	token, err := crypto.EncodeAuthMessage(sendFileMACConfig, inst.SessionSecret(), nil, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// CheckSendFileToken returns true if the token has been generated for
+// downloading the file of the given send.
+func CheckSendFileToken(inst *instance.Instance, sendID, fileID, token string) bool {
+	additionalData := []byte(inst.Domain + sendID + fileID)
+	_, err := crypto.DecodeAuthMessage(sendFileMACConfig, inst.SessionSecret(), []byte(token), additionalData)
+	return err == nil
+}
+
+// EnsureSendsTrigger adds the trigger that deletes the sends after their
+// deletion date, if it doesn't already exist. The identifier of the trigger
+// is kept in the settings, but they are not persisted.
+func EnsureSendsTrigger(inst *instance.Instance, setting *settings.Settings) error {
+	sched := job.System()
+	if setting.SendsTriggerID != "" {
+		if _, err := sched.GetTrigger(inst, setting.SendsTriggerID); err == nil {
+			return nil
+		}
+	}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Domain:     inst.ContextualDomain(),
+		Type:       "@every",
+		WorkerType: "bitwarden-sends-purge",
+		Arguments:  "1h",
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err = sched.AddTrigger(t); err != nil {
+		return err
+	}
+	setting.SendsTriggerID = t.ID()
+	return nil
+}
+
+// PurgeSends deletes the sends with a deletion date before the given date,
+// with their files. It returns the number of deleted sends.
+func PurgeSends(inst *instance.Instance, before time.Time) (int, error) {
+	deleted := 0
+	for {
+		var sends []*Send
+		req := &couchdb.FindRequest{
+			UseIndex: "by-deletion-date",
+			Selector: mango.Lt("deletion_date", before.UTC()),
+			Limit:    purgeBatchSize,
+		}
+		if err := couchdb.FindDocs(inst, consts.BitwardenSends, req, &sends); err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return deleted, nil
+			}
+			return deleted, err
+		}
+		docs := make([]couchdb.Doc, len(sends))
+		for i, s := range sends {
+			if err := DestroySendFile(inst, s); err != nil {
+				return deleted, err
+			}
+			docs[i] = s
+		}
+		if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenSends, docs); err != nil {
+			return deleted, err
+		}
+		deleted += len(sends)
+		if len(sends) < purgeBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+var _ couchdb.Doc = &Send{}
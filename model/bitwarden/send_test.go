@@ -0,0 +1,93 @@
+package bitwarden
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendAccessID(t *testing.T) {
+	s := &Send{CouchID: "9a7f6e5c4b3a29180716253443526170"}
+	accessID := s.AccessID()
+	assert.Equal(t, "mn9uXEs6KRgHFiU0Q1JhcA", accessID)
+	assert.Equal(t, s.CouchID, SendIDFromAccessID(accessID))
+	assert.Empty(t, SendIDFromAccessID("not base64!"))
+}
+
+func TestSendAccessible(t *testing.T) {
+	max := 2
+	s := &Send{
+		MaxAccessCount: &max,
+		DeletionDate:   time.Now().Add(time.Hour),
+	}
+	assert.True(t, s.Accessible())
+	s.AccessCount = 2
+	assert.False(t, s.Accessible())
+	assert.True(t, s.Downloadable())
+	s.AccessCount = 3
+	assert.False(t, s.Downloadable())
+	s.AccessCount = 0
+	expired := time.Now().Add(-time.Minute)
+	s.ExpirationDate = &expired
+	assert.False(t, s.Accessible())
+	s.ExpirationDate = nil
+	s.Disabled = true
+	assert.False(t, s.Accessible())
+	assert.False(t, s.Downloadable())
+
+	s.Disabled = false
+	assert.NoError(t, s.SetPassword("secret"))
+	assert.NotEqual(t, "secret", s.Password)
+	assert.Equal(t, ErrSendPasswordRequired, s.CheckAccess(""))
+	assert.Equal(t, ErrSendInvalidPassword, s.CheckAccess("wrong"))
+	assert.NoError(t, s.CheckAccess("secret"))
+}
+
+func TestPurgeSends(t *testing.T) {
+	domain := "cozy.example.net"
+	err := lifecycle.Destroy(domain)
+	if err != instance.ErrNotFound {
+		assert.NoError(t, err)
+	}
+	inst, err := lifecycle.Create(&lifecycle.Options{
+		Domain:     domain,
+		Passphrase: "cozy",
+		PublicName: "Pierre",
+	})
+	assert.NoError(t, err)
+	defer func() {
+		_ = lifecycle.Destroy(inst.Domain)
+	}()
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		md := metadata.New()
+		md.DocTypeVersion = DocTypeVersion
+		send := &Send{
+			Type:         SendTextType,
+			Name:         "2.name|name|name",
+			Key:          "2.key|key|key",
+			Text:         &SendText{Text: "2.text|text|text"},
+			DeletionDate: now.Add(time.Duration(2*i-3) * time.Hour),
+			Metadata:     md,
+		}
+		assert.NoError(t, couchdb.CreateDoc(inst, send))
+	}
+
+	deleted, err := PurgeSends(inst, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	var sends []*Send
+	err = couchdb.GetAllDocs(inst, consts.BitwardenSends, nil, &sends)
+	assert.NoError(t, err)
+	assert.Len(t, sends, 2)
+	for _, s := range sends {
+		assert.True(t, s.DeletionDate.After(now))
+	}
+}
@@ -34,6 +34,8 @@ type Settings struct {
 	CollectionID            string                 `json:"collection_id,omitempty"`
 	EquivalentDomains       [][]string             `json:"equivalent_domains,omitempty"`
 	GlobalEquivalentDomains []int                  `json:"global_equivalent_domains,omitempty"`
+	TrashTriggerID          string                 `json:"trash_trigger_id,omitempty"`
+	SendsTriggerID          string                 `json:"sends_trigger_id,omitempty"`
 	Metadata                *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 }
 
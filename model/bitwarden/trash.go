@@ -0,0 +1,124 @@
+package bitwarden
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+)
+
+// DefaultTrashRetention is the duration a cipher stays in the trash before
+// being purged, when the context of the instance doesn't define another one.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// purgeBatchSize is the number of ciphers deleted in a batch when the trash is
+// purged.
+const purgeBatchSize = 1000
+
+// TrashRetention returns the duration a cipher stays in the trash before being
+// purged. It can be configured with the bitwarden_trash_days parameter of the
+// context.
+func TrashRetention(inst *instance.Instance) time.Duration {
+	ctxSettings, err := inst.SettingsContext()
+	if err != nil {
+		return DefaultTrashRetention
+	}
+	var days int
+	switch v := ctxSettings["bitwarden_trash_days"].(type) {
+	case int:
+		days = v
+	case float64:
+		days = int(v)
+	}
+	if days <= 0 {
+		return DefaultTrashRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Trashed returns true if the cipher is in the trash.
+func (c *Cipher) Trashed() bool {
+	return c.DeletedDate != nil
+}
+
+// SoftDelete puts the cipher in the trash. It is not persisted.
+func (c *Cipher) SoftDelete() {
+	now := time.Now().UTC()
+	c.DeletedDate = &now
+	if c.Metadata != nil {
+		c.Metadata.ChangeUpdatedAt()
+	}
+}
+
+// Restore takes the cipher out of the trash. It is not persisted.
+func (c *Cipher) Restore() {
+	c.DeletedDate = nil
+	if c.Metadata != nil {
+		c.Metadata.ChangeUpdatedAt()
+	}
+}
+
+// EnsureTrashTrigger adds the trigger that purges the trash of the ciphers,
+// if it doesn't already exist. The identifier of the trigger is kept in the
+// settings, but they are not persisted.
+func EnsureTrashTrigger(inst *instance.Instance, setting *settings.Settings) error {
+	sched := job.System()
+	if setting.TrashTriggerID != "" {
+		if _, err := sched.GetTrigger(inst, setting.TrashTriggerID); err == nil {
+			return nil
+		}
+	}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Domain:     inst.ContextualDomain(),
+		Type:       "@every",
+		WorkerType: "bitwarden-trash-purge",
+		Arguments:  "24h",
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err = sched.AddTrigger(t); err != nil {
+		return err
+	}
+	setting.TrashTriggerID = t.ID()
+	return nil
+}
+
+// PurgeTrash deletes the ciphers that have been put in the trash before the
+// given date, with their attachments. It returns the number of deleted
+// ciphers.
+func PurgeTrash(inst *instance.Instance, before time.Time) (int, error) {
+	deleted := 0
+	for {
+		var ciphers []*Cipher
+		req := &couchdb.FindRequest{
+			UseIndex: "by-deleted-date",
+			Selector: mango.Lt("deleted_date", before.UTC()),
+			Limit:    purgeBatchSize,
+		}
+		if err := couchdb.FindDocs(inst, consts.BitwardenCiphers, req, &ciphers); err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return deleted, nil
+			}
+			return deleted, err
+		}
+		docs := make([]couchdb.Doc, len(ciphers))
+		for i, c := range ciphers {
+			if err := DeleteAttachments(inst, c); err != nil {
+				return deleted, err
+			}
+			docs[i] = c
+		}
+		if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, docs); err != nil {
+			return deleted, err
+		}
+		deleted += len(ciphers)
+		if len(ciphers) < purgeBatchSize {
+			return deleted, nil
+		}
+	}
+}
@@ -50,6 +50,11 @@ const (
 	Basic AuthMode = iota
 	// TwoFactorMail authentication mode, with passcode sent via email
 	TwoFactorMail
+	// TwoFactorTOTP authentication mode, with a passcode generated by an
+	// authenticator app (RFC 6238)
+	TwoFactorTOTP
+	// TwoFactorWebAuthn authentication mode, with a security key
+	TwoFactorWebAuthn
 )
 
 // AuthModeToString encode authentication mode in a string
@@ -57,6 +62,10 @@ func AuthModeToString(authMode AuthMode) string {
 	switch authMode {
 	case TwoFactorMail:
 		return "two_factor_mail"
+	case TwoFactorTOTP:
+		return "two_factor_totp"
+	case TwoFactorWebAuthn:
+		return "two_factor_webauthn"
 	default:
 		return "basic"
 	}
@@ -68,6 +77,10 @@ func StringToAuthMode(authMode string) (AuthMode, error) {
 	switch authMode {
 	case "two_factor_mail":
 		return TwoFactorMail, nil
+	case "two_factor_totp":
+		return TwoFactorTOTP, nil
+	case "two_factor_webauthn":
+		return TwoFactorWebAuthn, nil
 	case "basic":
 		return Basic, nil
 	default:
@@ -81,6 +94,12 @@ func (i *Instance) HasAuthMode(authMode AuthMode) bool {
 	return i.AuthMode == authMode
 }
 
+// HasTwoFactor returns whether or not a second factor is required to log in
+// on the instance.
+func (i *Instance) HasTwoFactor() bool {
+	return i.AuthMode != Basic
+}
+
 // GenerateTwoFactorSecrets generates a (token, passcode) pair that can be
 // used as a two factor authentication secret value. The token is used to allow
 // the two-factor form — meaning the user has correctly entered its passphrase
@@ -97,7 +116,32 @@ func (i *Instance) GenerateTwoFactorSecrets() (token []byte, passcode string, er
 	if err != nil {
 		return
 	}
+	passcode, err = i.mailPasscode(salt)
+	return
+}
+
+// GenerateTwoFactorToken generates a token that can be used to allow the
+// two-factor form when the second factor is not sent by mail. The salt of the
+// token is used as the challenge for the security keys.
+func (i *Instance) GenerateTwoFactorToken() ([]byte, error) {
+	salt := crypto.GenerateRandomBytes(sha256.Size)
+	return crypto.EncodeAuthMessage(totpMACConfig, i.SessionSecret(), salt, nil)
+}
+
+// twoFactorSalt checks the given two-factor token and returns its salt.
+func (i *Instance) twoFactorSalt(token []byte) ([]byte, error) {
+	return crypto.DecodeAuthMessage(totpMACConfig, i.SessionSecret(), token, nil)
+}
+
+// ValidateTwoFactorToken returns true if the two-factor token is valid, ie the
+// user has done the first step of the two factor authentication.
+func (i *Instance) ValidateTwoFactorToken(token []byte) bool {
+	_, err := i.twoFactorSalt(token)
+	return err == nil
+}
 
+// mailPasscode returns the passcode to send by mail for the given salt.
+func (i *Instance) mailPasscode(salt []byte) (passcode string, err error) {
 	h := hkdf.New(sha256.New, i.SessionSecret(), salt, nil)
 	key := make([]byte, 32)
 	_, err = io.ReadFull(h, key)
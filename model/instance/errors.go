@@ -20,6 +20,18 @@ var (
 	// ErrInvalidTwoFactor is returned when the two-factor authentication
 	// verification is invalid.
 	ErrInvalidTwoFactor = errors.New("Invalid two-factor parameters")
+	// ErrTwoFactorNotEnrolled is returned when trying to use an
+	// authentication mode with a second factor that has not been enrolled.
+	ErrTwoFactorNotEnrolled = errors.New("The second factor has not been enrolled")
+	// ErrTwoFactorInUse is returned when trying to remove the last second
+	// factor used by the authentication mode.
+	ErrTwoFactorInUse = errors.New("The second factor is used by the authentication mode")
+	// ErrWebAuthnCredentialNotFound is returned when the security key is not
+	// enrolled for the instance.
+	ErrWebAuthnCredentialNotFound = errors.New("Security key not found")
+	// ErrWebAuthnCredentialExists is returned when the security key has
+	// already been enrolled.
+	ErrWebAuthnCredentialExists = errors.New("Security key already enrolled")
 	// ErrContextNotFound is returned when the instance has no context
 	ErrContextNotFound = errors.New("Context not found")
 	// ErrResetAlreadyRequested is returned when a passphrase reset token is already set and valid
@@ -31,4 +43,10 @@ var (
 	ErrBadTOSVersion = errors.New("Bad format for TOS version")
 	// ErrInvalidSwiftLayout is returned when the Swift layout is unknown.
 	ErrInvalidSwiftLayout = errors.New("Invalid Swift layout")
+	// ErrNoVaultKey is returned when the vault credentials keys are needed
+	// for the encryption of the binaries, but they are not configured.
+	ErrNoVaultKey = errors.New("The vault credentials keys are not configured")
+	// ErrEncryptionNotSupported is returned when the encryption of the
+	// binaries is asked for an instance on a Swift layout v1 or v2.
+	ErrEncryptionNotSupported = errors.New("The encryption of the files is not supported by this Swift layout")
 )
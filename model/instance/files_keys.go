@@ -0,0 +1,144 @@
+package instance
+
+import (
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/keymgmt"
+)
+
+// FilesKeyLen is the length of the keys used for the encryption at rest of
+// the binaries.
+const FilesKeyLen = 32
+
+// FilesKey is a key used for the encryption at rest of the binaries (files,
+// versions and thumbnails) of an instance. It is persisted wrapped by the
+// vault credentials key.
+type FilesKey struct {
+	ID      uint32 `json:"id"`
+	Wrapped []byte `json:"wrapped"`
+}
+
+// SupportsEncryption returns true if the VFS of the instance can encrypt the
+// binaries. The Swift layouts v1 and v2 can't.
+func (i *Instance) SupportsEncryption() bool {
+	switch config.FsURL().Scheme {
+	case config.SchemeSwift, config.SchemeSwiftSecure:
+		return i.SwiftLayout >= 2
+	}
+	return true
+}
+
+// CurrentFilesKey returns the key that must be used to encrypt the new
+// binaries, or a nil key if the instance has no key yet.
+//
+// It implements the vfs.Keyring interface.
+func (i *Instance) CurrentFilesKey() (uint32, []byte, error) {
+	if len(i.FilesKeys) == 0 {
+		return 0, nil, nil
+	}
+	current := i.FilesKeys[len(i.FilesKeys)-1]
+	key, err := unwrapFilesKey(current)
+	if err != nil {
+		return 0, nil, err
+	}
+	return current.ID, key, nil
+}
+
+// FilesKey returns the key with the given identifier.
+//
+// It implements the vfs.Keyring interface.
+func (i *Instance) FilesKey(id uint32) ([]byte, error) {
+	for _, k := range i.FilesKeys {
+		if k.ID == id {
+			return unwrapFilesKey(k)
+		}
+	}
+	return nil, vfs.ErrUnknownFilesKey
+}
+
+// AddFilesKey generates a new key for the encryption of the binaries, that
+// becomes the current key. The old keys are kept for decrypting the content
+// until it has been encrypted again. The instance must be saved after that.
+func (i *Instance) AddFilesKey() error {
+	if !i.SupportsEncryption() {
+		return ErrEncryptionNotSupported
+	}
+	encryptorKey := config.GetVault().CredentialsEncryptorKey()
+	if encryptorKey == nil {
+		return ErrNoVaultKey
+	}
+	wrapped, err := keymgmt.WrapKey(encryptorKey, crypto.GenerateRandomBytes(FilesKeyLen))
+	if err != nil {
+		return err
+	}
+	var id uint32 = 1
+	if len(i.FilesKeys) > 0 {
+		id = i.FilesKeys[len(i.FilesKeys)-1].ID + 1
+	}
+	i.FilesKeys = append(i.FilesKeys, FilesKey{ID: id, Wrapped: wrapped})
+	return nil
+}
+
+// CheckFilesKey returns vfs.ErrUnknownFilesKey if the key with the given
+// identifier has been removed. The keys are checked on a fresh copy of the
+// instance document, as they can be removed by another process.
+//
+// It implements the vfs.Keyring interface.
+func (i *Instance) CheckFilesKey(id uint32) error {
+	fresh, err := GetFromCouch(i.Domain)
+	if err != nil {
+		return err
+	}
+	for _, k := range fresh.FilesKeys {
+		if k.ID == id {
+			return nil
+		}
+	}
+	return vfs.ErrUnknownFilesKey
+}
+
+// OldFilesKeysIDs returns the identifiers of the keys that are no longer used
+// to encrypt the new binaries.
+func (i *Instance) OldFilesKeysIDs() []uint32 {
+	var ids []uint32
+	for idx, k := range i.FilesKeys {
+		if idx < len(i.FilesKeys)-1 {
+			ids = append(ids, k.ID)
+		}
+	}
+	return ids
+}
+
+// RemoveFilesKeys removes the keys with the given identifiers, except the
+// current one. It must be called only when no binaries are encrypted with
+// those keys. The instance must be saved after that.
+func (i *Instance) RemoveFilesKeys(ids []uint32) {
+	if len(i.FilesKeys) == 0 {
+		return
+	}
+	current := i.FilesKeys[len(i.FilesKeys)-1]
+	keys := i.FilesKeys[:0]
+	for _, k := range i.FilesKeys {
+		removed := false
+		for _, id := range ids {
+			if k.ID == id && k.ID != current.ID {
+				removed = true
+			}
+		}
+		if !removed {
+			keys = append(keys, k)
+		}
+	}
+	i.FilesKeys = keys
+}
+
+func unwrapFilesKey(k FilesKey) ([]byte, error) {
+	decryptorKey := config.GetVault().CredentialsDecryptorKey()
+	if decryptorKey == nil {
+		return nil, ErrNoVaultKey
+	}
+	return keymgmt.UnwrapKey(decryptorKey, k.Wrapped)
+}
+
+var _ vfs.Keyring = &Instance{}
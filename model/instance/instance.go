@@ -72,6 +72,20 @@ type Instance struct {
 	PassphraseResetToken []byte     `json:"passphrase_reset_token,omitempty"`
 	PassphraseResetTime  *time.Time `json:"passphrase_reset_time,omitempty"`
 
+	// TOTPSecret is the secret shared with the authenticator app for the
+	// TOTP second factor (base32 encoded). TOTPPendingSecret is a secret that
+	// is waiting for a first valid passcode to finish the enrollment.
+	// TOTPLastStep is the time step of the last accepted passcode, to refuse
+	// a passcode that has already been used.
+	TOTPSecret        string `json:"totp_secret,omitempty"`
+	TOTPPendingSecret string `json:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64  `json:"totp_last_step,omitempty"`
+	// WebAuthnCredentials are the security keys enrolled as second factor.
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+	// RecoveryCodes are the hashes of the single-use codes that can be used
+	// instead of the second factor.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+
 	// Secure assets
 
 	// Register token is used on registration to prevent from stealing instances
@@ -84,12 +98,18 @@ type Instance struct {
 	OAuthSecret []byte `json:"oauth_secret,omitempty"`
 	// CLISecret is used to authenticate request from the CLI
 	CLISecret []byte `json:"cli_secret,omitempty"`
+	// FilesKeys are the keys used for the encryption at rest of the binaries,
+	// the last one being the current key
+	FilesKeys []FilesKey `json:"files_keys,omitempty"`
 
 	// FeatureFlags is the feature flags that are specific to this instance
 	FeatureFlags map[string]interface{} `json:"feature_flags,omitempty"`
 	// FeatureSets is a list of feature sets from the manager
 	FeatureSets []string `json:"feature_sets,omitempty"`
 
+	// PermissionsAudit are the settings of the audit trail of the permissions
+	PermissionsAudit *permission.AuditSettings `json:"permissions_audit,omitempty"`
+
 	vfs              vfs.VFS
 	contextualDomain string
 }
@@ -138,6 +158,20 @@ func (i *Instance) Clone() couchdb.Doc {
 
 	cloned.CLISecret = make([]byte, len(i.CLISecret))
 	copy(cloned.CLISecret, i.CLISecret)
+
+	cloned.FilesKeys = make([]FilesKey, len(i.FilesKeys))
+	copy(cloned.FilesKeys, i.FilesKeys)
+
+	cloned.WebAuthnCredentials = make([]WebAuthnCredential, len(i.WebAuthnCredentials))
+	copy(cloned.WebAuthnCredentials, i.WebAuthnCredentials)
+
+	cloned.RecoveryCodes = make([]string, len(i.RecoveryCodes))
+	copy(cloned.RecoveryCodes, i.RecoveryCodes)
+
+	if i.PermissionsAudit != nil {
+		tmp := *i.PermissionsAudit
+		cloned.PermissionsAudit = &tmp
+	}
 	return &cloned
 }
 
@@ -202,7 +236,7 @@ func (i *Instance) MakeVFS() error {
 	var err error
 	switch fsURL.Scheme {
 	case config.SchemeFile, config.SchemeMem:
-		i.vfs, err = vfsafero.New(i, index, disk, mutex, fsURL, i.DirName())
+		i.vfs, err = vfsafero.New(i, index, disk, i, mutex, fsURL, i.DirName())
 	case config.SchemeSwift, config.SchemeSwiftSecure:
 		switch i.SwiftLayout {
 		case 0:
@@ -210,7 +244,7 @@ func (i *Instance) MakeVFS() error {
 		case 1:
 			i.vfs, err = vfsswift.NewV2(i, index, disk, mutex)
 		case 2:
-			i.vfs, err = vfsswift.NewV3(i, index, disk, mutex)
+			i.vfs, err = vfsswift.NewV3(i, index, disk, i, mutex)
 		default:
 			err = ErrInvalidSwiftLayout
 		}
@@ -352,6 +386,12 @@ func (i *Instance) DiskQuota() int64 {
 	return i.BytesDiskQuota
 }
 
+// PermissionsAuditEnabled returns true if the audit trail of the permissions
+// has been enabled for this instance.
+func (i *Instance) PermissionsAuditEnabled() bool {
+	return i.PermissionsAudit != nil && i.PermissionsAudit.Enabled
+}
+
 // WithContextualDomain the current instance context with the given hostname.
 func (i *Instance) WithContextualDomain(domain string) *Instance {
 	if i.HasDomain(domain) {
@@ -2,13 +2,16 @@ package instance_test
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	jwt "gopkg.in/dgrijalva/jwt-go.v3"
 )
@@ -56,6 +59,39 @@ func TestBuildAppToken(t *testing.T) {
 	assert.Equal(t, "my-app", claims["sub"])
 }
 
+func TestTwoFactorTOTP(t *testing.T) {
+	inst := &instance.Instance{
+		Domain:     "test-totp.example.com",
+		SessSecret: crypto.GenerateRandomBytes(64),
+	}
+	key, err := inst.GenerateTOTPKey()
+	assert.NoError(t, err)
+	passcode, err := totp.GenerateCode(key.Secret(), time.Now().UTC())
+	assert.NoError(t, err)
+
+	assert.False(t, inst.ValidateTOTPPasscode(passcode))
+	inst.TOTPPendingSecret = key.Secret()
+	assert.True(t, inst.ValidatePendingTOTPPasscode(passcode))
+	inst.TOTPSecret = key.Secret()
+	assert.False(t, inst.ValidateTOTPPasscode(passcode))
+	inst.TOTPLastStep = 0
+	assert.True(t, inst.ValidateTOTPPasscode(passcode))
+	assert.NotZero(t, inst.TOTPLastStep)
+	assert.False(t, inst.ValidateTOTPPasscode(passcode))
+	assert.False(t, inst.ValidateTOTPPasscode("000000x"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes := instance.GenerateRecoveryCodes()
+	assert.Len(t, codes, instance.RecoveryCodesCount)
+	assert.Len(t, hashes, instance.RecoveryCodesCount)
+	inst := &instance.Instance{RecoveryCodes: hashes}
+	assert.Equal(t, 0, inst.RecoveryCodeIndex(codes[0]))
+	assert.Equal(t, 3, inst.RecoveryCodeIndex(strings.ToUpper(codes[3])))
+	assert.Equal(t, -1, inst.RecoveryCodeIndex("abcde-fghij"))
+	assert.Equal(t, -1, inst.RecoveryCodeIndex(""))
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	res := m.Run()
@@ -9,6 +9,8 @@ import (
 	"github.com/cozy/cozy-stack/model/contact"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -45,6 +47,7 @@ type Options struct {
 	BlockingReason string
 
 	OnboardingFinished *bool
+	PermissionsAudit   *permission.AuditSettings
 }
 
 // Create builds an instance and initializes it
@@ -108,6 +111,12 @@ func CreateWithoutHooks(opts *Options) (*instance.Instance, error) {
 		i.SwiftLayout = config.GetConfig().Fs.DefaultLayout
 	}
 
+	if vfs.EncryptionEnabled() {
+		if err = i.AddFilesKey(); err != nil {
+			return nil, err
+		}
+	}
+
 	if opts.AuthMode != "" {
 		var authMode instance.AuthMode
 		if authMode, err = instance.StringToAuthMode(opts.AuthMode); err == nil {
@@ -276,8 +285,8 @@ func buildSettings(opts *Options) *couchdb.JSONDoc {
 
 // Triggers returns the list of the triggers to add when an instance is created
 func Triggers(db prefixer.Prefixer) []job.TriggerInfos {
-	// Create/update/remove thumbnails when an image is created/updated/removed
 	return []job.TriggerInfos{
+		// Create/update/remove thumbnails when an image is created/updated/removed
 		{
 			Domain:     db.DomainName(),
 			Prefix:     db.DBPrefix(),
@@ -285,5 +294,13 @@ func Triggers(db prefixer.Prefixer) []job.TriggerInfos {
 			WorkerType: "thumbnail",
 			Arguments:  "io.cozy.files:CREATED,UPDATED,DELETED:image:class",
 		},
+		// Keep the search index up-to-date with the files
+		{
+			Domain:     db.DomainName(),
+			Prefix:     db.DBPrefix(),
+			Type:       "@event",
+			WorkerType: "search-index",
+			Arguments:  "io.cozy.files:CREATED,UPDATED,DELETED",
+		},
 	}
 }
@@ -216,8 +216,8 @@ func UpdatePassphrase(
 	// With two factor authentication, we do not check the validity of the
 	// current passphrase, but the validity of the pair passcode/token which has
 	// been exchanged against the current passphrase.
-	if inst.HasAuthMode(instance.TwoFactorMail) {
-		if !inst.ValidateTwoFactorPasscode(twoFactorToken, twoFactorPasscode) {
+	if inst.HasTwoFactor() {
+		if !CheckTwoFactor(inst, twoFactorToken, "", twoFactorPasscode) {
 			return instance.ErrInvalidTwoFactor
 		}
 	} else {
@@ -98,6 +98,12 @@ func Patch(i *instance.Instance, opts *Options) error {
 			needUpdate = true
 		}
 
+		if opts.PermissionsAudit != nil {
+			audit := *opts.PermissionsAudit
+			i.PermissionsAudit = &audit
+			needUpdate = true
+		}
+
 		if opts.TOSLatest != "" {
 			if _, date, ok := instance.ParseTOSVersion(opts.TOSLatest); !ok || date.IsZero() {
 				return instance.ErrBadTOSVersion
@@ -0,0 +1,41 @@
+package lifecycle
+
+import (
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/lock"
+)
+
+// EnsureSearchIndexTrigger adds the trigger that keeps the full-text search
+// index of the files up-to-date, if the instance doesn't already have one. It
+// is useful for the instances created before the search was available. It
+// returns true if the trigger has been added.
+func EnsureSearchIndexTrigger(i *instance.Instance) (bool, error) {
+	mu := lock.ReadWrite(i, "search-index-trigger")
+	if err := mu.Lock(); err != nil {
+		return false, err
+	}
+	defer mu.Unlock()
+
+	sched := job.System()
+	triggers, err := sched.GetAllTriggers(i)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range triggers {
+		if t.Infos().WorkerType == "search-index" {
+			return false, nil
+		}
+	}
+	for _, infos := range Triggers(i) {
+		if infos.WorkerType != "search-index" {
+			continue
+		}
+		t, err := job.NewTrigger(i, infos, nil)
+		if err != nil {
+			return false, err
+		}
+		return true, sched.AddTrigger(t)
+	}
+	return false, nil
+}
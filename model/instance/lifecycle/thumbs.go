@@ -20,10 +20,10 @@ func ThumbsFS(i *instance.Instance) vfs.Thumbser {
 	case config.SchemeFile:
 		baseFS := afero.NewBasePathFs(afero.NewOsFs(),
 			path.Join(fsURL.Path, i.DirName(), vfs.ThumbsDirName))
-		return vfsafero.NewThumbsFs(baseFS)
+		return vfsafero.NewThumbsFs(baseFS, i)
 	case config.SchemeMem:
 		baseFS := vfsafero.GetMemFS(i.DomainName() + "-thumbs")
-		return vfsafero.NewThumbsFs(baseFS)
+		return vfsafero.NewThumbsFs(baseFS, i)
 	case config.SchemeSwift, config.SchemeSwiftSecure:
 		switch i.SwiftLayout {
 		case 0:
@@ -31,7 +31,7 @@ func ThumbsFS(i *instance.Instance) vfs.Thumbser {
 		case 1:
 			return vfsswift.NewThumbsFsV2(config.GetSwiftConnection(), i)
 		case 2:
-			return vfsswift.NewThumbsFsV3(config.GetSwiftConnection(), i)
+			return vfsswift.NewThumbsFsV3(config.GetSwiftConnection(), i, i)
 		default:
 			panic(instance.ErrInvalidSwiftLayout)
 		}
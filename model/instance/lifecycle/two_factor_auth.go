@@ -1,6 +1,11 @@
 package lifecycle
 
-import "github.com/cozy/cozy-stack/model/instance"
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/pquerna/otp"
+)
 
 // SendTwoFactorPasscode sends by mail the two factor secret to the owner of
 // the instance. It returns the generated token.
@@ -31,3 +36,201 @@ func SendMailConfirmationCode(inst *instance.Instance) error {
 		TemplateValues: map[string]interface{}{"TwoFactorActivationPasscode": passcode},
 	})
 }
+
+// The methods that can be used for the second factor
+const (
+	// TwoFactorMethodMail is for a passcode sent by mail
+	TwoFactorMethodMail = "mail"
+	// TwoFactorMethodTOTP is for a passcode generated by an authenticator app
+	TwoFactorMethodTOTP = "totp"
+	// TwoFactorMethodWebAuthn is for a security key
+	TwoFactorMethodWebAuthn = "webauthn"
+	// TwoFactorMethodRecovery is for a single-use recovery code
+	TwoFactorMethodRecovery = "recovery"
+)
+
+// DefaultTwoFactorMethod returns the method used for the second factor with
+// the authentication mode of the instance.
+func DefaultTwoFactorMethod(inst *instance.Instance) string {
+	switch inst.AuthMode {
+	case instance.TwoFactorTOTP:
+		return TwoFactorMethodTOTP
+	case instance.TwoFactorWebAuthn:
+		return TwoFactorMethodWebAuthn
+	default:
+		return TwoFactorMethodMail
+	}
+}
+
+// StartTwoFactor is called after the passphrase has been checked, when the
+// instance requires a second factor. It returns the token for the two-factor
+// form. With the mail method, the passcode is sent by mail.
+func StartTwoFactor(inst *instance.Instance, method string) ([]byte, error) {
+	if method == "" {
+		method = DefaultTwoFactorMethod(inst)
+	}
+	if method == TwoFactorMethodMail {
+		return SendTwoFactorPasscode(inst)
+	}
+	return inst.GenerateTwoFactorToken()
+}
+
+// CheckTwoFactor returns true if the passcode is valid for the given method
+// of two-factor authentication (the default method of the instance if it is
+// empty). For the security keys, the passcode is the assertion serialized in
+// JSON. The mail method is always accepted as a fallback. The recovery codes
+// are consumed when used.
+func CheckTwoFactor(inst *instance.Instance, token []byte, method, passcode string) bool {
+	if method == "" {
+		method = DefaultTwoFactorMethod(inst)
+	}
+	switch method {
+	case TwoFactorMethodMail:
+		return inst.ValidateTwoFactorPasscode(token, passcode)
+	case TwoFactorMethodTOTP:
+		return inst.ValidateTwoFactorToken(token) && CheckTOTPPasscode(inst, passcode)
+	case TwoFactorMethodWebAuthn:
+		var assertion instance.WebAuthnAssertion
+		if err := json.Unmarshal([]byte(passcode), &assertion); err != nil {
+			return false
+		}
+		index, count, err := inst.VerifyWebAuthnAssertion(token, &assertion)
+		if err != nil {
+			inst.Logger().WithField("nspace", "auth").
+				Infof("Invalid security key assertion: %s", err)
+			return false
+		}
+		inst.WebAuthnCredentials[index].SignCount = count
+		if err := update(inst); err != nil {
+			inst.Logger().WithField("nspace", "auth").
+				Errorf("Could not update the signature counter: %s", err)
+		}
+		return true
+	case TwoFactorMethodRecovery:
+		if !inst.ValidateTwoFactorToken(token) {
+			return false
+		}
+		index := inst.RecoveryCodeIndex(passcode)
+		if index < 0 {
+			return false
+		}
+		inst.RecoveryCodes = append(inst.RecoveryCodes[:index], inst.RecoveryCodes[index+1:]...)
+		if err := update(inst); err != nil {
+			inst.Logger().WithField("nspace", "auth").
+				Errorf("Could not consume the recovery code: %s", err)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// CheckTOTPPasscode returns true if the passcode has been generated by the
+// authenticator app of the instance and has not been used before. The time
+// step of the passcode is persisted, and the passcode is refused if it can't
+// be, as it could then be replayed.
+func CheckTOTPPasscode(inst *instance.Instance, passcode string) bool {
+	if !inst.ValidateTOTPPasscode(passcode) {
+		return false
+	}
+	if err := update(inst); err != nil {
+		inst.Logger().WithField("nspace", "auth").
+			Errorf("Could not persist the TOTP time step: %s", err)
+		return false
+	}
+	return true
+}
+
+// EnrollTOTP starts the enrollment of an authenticator app: it generates a
+// new key that must be confirmed with a passcode by ConfirmTOTP.
+func EnrollTOTP(inst *instance.Instance) (*otp.Key, error) {
+	key, err := inst.GenerateTOTPKey()
+	if err != nil {
+		return nil, err
+	}
+	inst.TOTPPendingSecret = key.Secret()
+	if err := update(inst); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ConfirmTOTP finishes the enrollment of an authenticator app, with a
+// passcode generated by this app.
+func ConfirmTOTP(inst *instance.Instance, passcode string) error {
+	if !inst.ValidatePendingTOTPPasscode(passcode) {
+		return instance.ErrInvalidTwoFactor
+	}
+	inst.TOTPSecret = inst.TOTPPendingSecret
+	inst.TOTPPendingSecret = ""
+	return update(inst)
+}
+
+// RemoveTOTP removes the authenticator app enrolled for the instance.
+func RemoveTOTP(inst *instance.Instance) error {
+	if inst.HasAuthMode(instance.TwoFactorTOTP) {
+		return instance.ErrTwoFactorInUse
+	}
+	inst.TOTPSecret = ""
+	inst.TOTPPendingSecret = ""
+	inst.TOTPLastStep = 0
+	return update(inst)
+}
+
+// AddWebAuthnCredential enrolls a new security key, from its response to the
+// challenge of the two-factor token.
+func AddWebAuthnCredential(inst *instance.Instance, token []byte, name string, clientDataJSON, attestationObject []byte) (*instance.WebAuthnCredential, error) {
+	cred, err := inst.VerifyWebAuthnRegistration(token, name, clientDataJSON, attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	inst.WebAuthnCredentials = append(inst.WebAuthnCredentials, *cred)
+	if err := update(inst); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// RemoveWebAuthnCredential removes the security key with the given
+// identifier. The last security key can't be removed if it is used by the
+// authentication mode.
+func RemoveWebAuthnCredential(inst *instance.Instance, id []byte) error {
+	index := inst.WebAuthnCredentialIndex(id)
+	if index < 0 {
+		return instance.ErrWebAuthnCredentialNotFound
+	}
+	if len(inst.WebAuthnCredentials) == 1 && inst.HasAuthMode(instance.TwoFactorWebAuthn) {
+		return instance.ErrTwoFactorInUse
+	}
+	creds := inst.WebAuthnCredentials
+	inst.WebAuthnCredentials = append(creds[:index:index], creds[index+1:]...)
+	return update(inst)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the instance by new
+// ones, and returns them. They can't be retrieved later, only their hashes are
+// persisted.
+func RegenerateRecoveryCodes(inst *instance.Instance) ([]string, error) {
+	codes, hashes := instance.GenerateRecoveryCodes()
+	inst.RecoveryCodes = hashes
+	if err := update(inst); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CheckTwoFactorEnrolled returns an error if the second factor for the given
+// authentication mode has not been enrolled.
+func CheckTwoFactorEnrolled(inst *instance.Instance, authMode instance.AuthMode) error {
+	switch authMode {
+	case instance.TwoFactorTOTP:
+		if inst.TOTPSecret == "" {
+			return instance.ErrTwoFactorNotEnrolled
+		}
+	case instance.TwoFactorWebAuthn:
+		if len(inst.WebAuthnCredentials) == 0 {
+			return instance.ErrTwoFactorNotEnrolled
+		}
+	}
+	return nil
+}
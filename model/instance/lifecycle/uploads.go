@@ -0,0 +1,113 @@
+package lifecycle
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/model/vfs/vfsafero"
+	"github.com/cozy/cozy-stack/model/vfs/vfsswift"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/lock"
+	"github.com/spf13/afero"
+)
+
+// UploadsFS returns the hidden filesystem for storing the chunks of the
+// resumable uploads
+func UploadsFS(i *instance.Instance) vfs.Chunker {
+	fsURL := config.FsURL()
+	switch fsURL.Scheme {
+	case config.SchemeFile:
+		baseFS := afero.NewBasePathFs(afero.NewOsFs(),
+			path.Join(fsURL.Path, i.DirName(), vfs.UploadsDirName))
+		return vfsafero.NewChunksFs(baseFS)
+	case config.SchemeMem:
+		baseFS := vfsafero.GetMemFS(i.DomainName() + "-uploads")
+		return vfsafero.NewChunksFs(baseFS)
+	case config.SchemeSwift, config.SchemeSwiftSecure:
+		switch i.SwiftLayout {
+		case 0:
+			return vfsswift.NewChunksFs(config.GetSwiftConnection(), i.Domain)
+		case 1:
+			return vfsswift.NewChunksFsV2(config.GetSwiftConnection(), i)
+		case 2:
+			return vfsswift.NewChunksFsV3(config.GetSwiftConnection(), i)
+		default:
+			panic(instance.ErrInvalidSwiftLayout)
+		}
+	default:
+		panic(fmt.Sprintf("instance: unknown storage provider %s", fsURL.Scheme))
+	}
+}
+
+// UploadsCleanupInterval is the interval between two runs of the job that
+// removes the chunks of the abandoned upload sessions.
+const UploadsCleanupInterval = "24h"
+
+// EnsureUploadsCleanupTrigger adds the trigger that garbage-collects the
+// chunks of the abandoned upload sessions, if the instance doesn't already
+// have one.
+func EnsureUploadsCleanupTrigger(i *instance.Instance) error {
+	mu := lock.ReadWrite(i, "uploads-cleanup-trigger")
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	sched := job.System()
+	triggers, err := sched.GetAllTriggers(i)
+	if err != nil {
+		return err
+	}
+	for _, t := range triggers {
+		if t.Infos().WorkerType == "uploads-cleanup" {
+			return nil
+		}
+	}
+	t, err := job.NewTrigger(i, job.TriggerInfos{
+		Domain:     i.ContextualDomain(),
+		Type:       "@every",
+		WorkerType: "uploads-cleanup",
+		Arguments:  UploadsCleanupInterval,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return sched.AddTrigger(t)
+}
+
+// CleanUploads removes the chunks of the upload sessions that no longer exist
+// in the store, i.e. the sessions that have expired after UploadSessionTTL
+// without being completed or cancelled. It returns the number of sessions
+// whose chunks have been removed.
+func CleanUploads(i *instance.Instance) (int, error) {
+	chunks := UploadsFS(i)
+	sessions, err := chunks.ListSessions()
+	if err != nil {
+		return 0, err
+	}
+	store := vfs.GetStore()
+	removed := 0
+	for _, secret := range sessions {
+		// The lock is the same as the one taken by the HTTP handlers, so a
+		// chunk can't be written while its session is checked.
+		mu := lock.ReadWrite(i, "uploads/"+secret)
+		if err := mu.Lock(); err != nil {
+			return removed, err
+		}
+		session, err := store.GetUploadSession(i, secret)
+		if err == nil && session == nil {
+			err = chunks.RemoveSession(secret)
+			if err == nil {
+				removed++
+			}
+		}
+		mu.Unlock()
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
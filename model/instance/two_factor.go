@@ -0,0 +1,251 @@
+package instance
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/webauthn"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// RecoveryCodesCount is the number of recovery codes generated for an
+// instance.
+const RecoveryCodesCount = 10
+
+// recoveryCodeLen is the number of characters of a recovery code.
+const recoveryCodeLen = 10
+
+// totpAppOptions are the options for the passcodes generated by an
+// authenticator app. They are the defaults of the most common apps, and the
+// skew allows a small clock drift.
+var totpAppOptions = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// WebAuthnCredential is a security key enrolled as a second factor.
+type WebAuthnCredential struct {
+	webauthn.Credential
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebAuthnAssertion is the response of a security key to a challenge, as sent
+// by the client. The binary values are encoded in base64url.
+type WebAuthnAssertion struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+	Signature         string `json:"signature"`
+}
+
+// GenerateTOTPKey generates a new key for the TOTP second factor. The key can
+// be shown to the user as a QR code to be scanned by an authenticator app.
+func (i *Instance) GenerateTOTPKey() (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      i.TemplateTitle(),
+		AccountName: i.Domain,
+		Period:      uint(totpAppOptions.Period),
+		Digits:      totpAppOptions.Digits,
+		Algorithm:   totpAppOptions.Algorithm,
+	})
+}
+
+// ValidateTOTPPasscode returns true if the passcode has been generated by the
+// authenticator app enrolled for the instance, for a time step after the one
+// of the last accepted passcode. The time step of the passcode is then kept
+// in TOTPLastStep, and the instance must be persisted to avoid a replay.
+func (i *Instance) ValidateTOTPPasscode(passcode string) bool {
+	step, ok := validateTOTP(i.TOTPSecret, passcode, i.TOTPLastStep)
+	if ok {
+		i.TOTPLastStep = step
+	}
+	return ok
+}
+
+// ValidatePendingTOTPPasscode returns true if the passcode has been generated
+// by the authenticator app that is being enrolled. The time step of the
+// passcode is kept in TOTPLastStep.
+func (i *Instance) ValidatePendingTOTPPasscode(passcode string) bool {
+	step, ok := validateTOTP(i.TOTPPendingSecret, passcode, 0)
+	if ok {
+		i.TOTPLastStep = step
+	}
+	return ok
+}
+
+// validateTOTP checks the passcode for the time steps around the current time
+// that are after the last step, and returns the matching step.
+func validateTOTP(secret, passcode string, lastStep int64) (int64, bool) {
+	passcode = strings.TrimSpace(passcode)
+	if secret == "" || len(passcode) != totpAppOptions.Digits.Length() {
+		return 0, false
+	}
+	period := int64(totpAppOptions.Period)
+	current := time.Now().UTC().Unix() / period
+	skew := int64(totpAppOptions.Skew)
+	for step := current - skew; step <= current+skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		code, err := totp.GenerateCodeCustom(secret, time.Unix(step*period, 0).UTC(), totpAppOptions)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(passcode)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes generates new recovery codes. It returns the codes to
+// show to the user, and their hashes to persist in the instance.
+func GenerateRecoveryCodes() (codes []string, hashes []string) {
+	codes = make([]string, RecoveryCodesCount)
+	hashes = make([]string, RecoveryCodesCount)
+	for k := range codes {
+		code := strings.ToLower(crypto.GenerateRandomString(recoveryCodeLen))
+		codes[k] = code[:recoveryCodeLen/2] + "-" + code[recoveryCodeLen/2:]
+		hashes[k] = hashRecoveryCode(code)
+	}
+	return
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecoveryCodeIndex returns the index of the given recovery code in the list
+// of the recovery codes for the instance, or -1 if the code is not valid.
+func (i *Instance) RecoveryCodeIndex(code string) int {
+	if code == "" {
+		return -1
+	}
+	hash := hashRecoveryCode(code)
+	for k, h := range i.RecoveryCodes {
+		if h == hash {
+			return k
+		}
+	}
+	return -1
+}
+
+// WebAuthnRPID returns the identifier of the instance as a relying party for
+// the security keys.
+func (i *Instance) WebAuthnRPID() string {
+	return strings.Split(i.ContextualDomain(), ":")[0] // Skip the optional port
+}
+
+func (i *Instance) webAuthnOrigin() string {
+	return i.Scheme() + "://" + i.ContextualDomain()
+}
+
+func (i *Instance) webAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(i.WebAuthnCredentials))
+	for k, c := range i.WebAuthnCredentials {
+		creds[k] = c.Credential
+	}
+	return creds
+}
+
+// WebAuthnCreationOptions returns the options for enrolling a new security
+// key, with the salt of the two-factor token as the challenge.
+func (i *Instance) WebAuthnCreationOptions(token []byte) (map[string]interface{}, error) {
+	challenge, err := i.twoFactorSalt(token)
+	if err != nil {
+		return nil, ErrInvalidTwoFactor
+	}
+	return webauthn.CreationOptions(i.WebAuthnRPID(), i.TemplateTitle(), []byte(i.DocID),
+		i.Domain, challenge, i.webAuthnCredentials()), nil
+}
+
+// WebAuthnRequestOptions returns the options for using one of the enrolled
+// security keys, with the salt of the two-factor token as the challenge.
+func (i *Instance) WebAuthnRequestOptions(token []byte) (map[string]interface{}, error) {
+	challenge, err := i.twoFactorSalt(token)
+	if err != nil {
+		return nil, ErrInvalidTwoFactor
+	}
+	return webauthn.RequestOptions(i.WebAuthnRPID(), challenge, i.webAuthnCredentials()), nil
+}
+
+// VerifyWebAuthnRegistration checks the response of a security key for its
+// enrollment, and returns the new credential. It is not persisted.
+func (i *Instance) VerifyWebAuthnRegistration(token []byte, name string, clientDataJSON, attestationObject []byte) (*WebAuthnCredential, error) {
+	challenge, err := i.twoFactorSalt(token)
+	if err != nil {
+		return nil, ErrInvalidTwoFactor
+	}
+	cred, err := webauthn.VerifyRegistration(i.WebAuthnRPID(), i.webAuthnOrigin(),
+		challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	if i.WebAuthnCredentialIndex(cred.ID) >= 0 {
+		return nil, ErrWebAuthnCredentialExists
+	}
+	return &WebAuthnCredential{
+		Credential: *cred,
+		Name:       name,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// VerifyWebAuthnAssertion checks the response of a security key to the
+// challenge of the two-factor token. It returns the index of the credential
+// that has been used, and its new signature counter.
+func (i *Instance) VerifyWebAuthnAssertion(token []byte, assertion *WebAuthnAssertion) (int, uint32, error) {
+	challenge, err := i.twoFactorSalt(token)
+	if err != nil {
+		return -1, 0, ErrInvalidTwoFactor
+	}
+	id, err := webauthn.Decode(assertion.ID)
+	if err != nil {
+		return -1, 0, ErrInvalidTwoFactor
+	}
+	index := i.WebAuthnCredentialIndex(id)
+	if index < 0 {
+		return -1, 0, ErrWebAuthnCredentialNotFound
+	}
+	clientData, err := webauthn.Decode(assertion.ClientDataJSON)
+	if err != nil {
+		return -1, 0, ErrInvalidTwoFactor
+	}
+	authData, err := webauthn.Decode(assertion.AuthenticatorData)
+	if err != nil {
+		return -1, 0, ErrInvalidTwoFactor
+	}
+	signature, err := webauthn.Decode(assertion.Signature)
+	if err != nil {
+		return -1, 0, ErrInvalidTwoFactor
+	}
+	count, err := webauthn.VerifyAssertion(i.WebAuthnRPID(), i.webAuthnOrigin(), challenge,
+		&i.WebAuthnCredentials[index].Credential, clientData, authData, signature)
+	if err != nil {
+		return -1, 0, err
+	}
+	return index, count, nil
+}
+
+// WebAuthnCredentialIndex returns the index of the security key with the
+// given identifier, or -1 if it is not enrolled.
+func (i *Instance) WebAuthnCredentialIndex(id []byte) int {
+	for k, c := range i.WebAuthnCredentials {
+		if bytes.Equal(c.ID, id) {
+			return k
+		}
+	}
+	return -1
+}
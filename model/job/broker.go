@@ -18,6 +18,8 @@ import (
 )
 
 const (
+	// Waiting state, for a job that waits for other jobs to be done
+	Waiting State = "waiting"
 	// Queued state
 	Queued State = "queued"
 	// Running state
@@ -31,12 +33,25 @@ const (
 // defaultMaxLimits defines the maximum limit of how much jobs will be returned
 // for each job state
 var defaultMaxLimits map[State]int = map[State]int{
+	Waiting: 50,
 	Queued:  50,
 	Running: 50,
 	Done:    50,
 	Errored: 50,
 }
 
+const (
+	// MinPriority is the lowest priority for a job.
+	MinPriority = 1
+	// MaxPriority is the highest priority for a job.
+	MaxPriority = 100
+	// DefaultPriority is the priority of the jobs without an explicit one.
+	DefaultPriority = 50
+	// ManualPriority is the priority of the manual jobs without an explicit
+	// one.
+	ManualPriority = 75
+)
+
 const (
 	// WorkerType is the key in JSON for the type of worker
 	WorkerType = "worker"
@@ -93,6 +108,12 @@ type (
 		FinishedAt  time.Time   `json:"finished_at"`
 		Error       string      `json:"error,omitempty"`
 		ForwardLogs bool        `json:"forward_logs,omitempty"`
+		WaitFor     []string    `json:"wait_for,omitempty"`
+		WorkflowID  string      `json:"workflow_id,omitempty"`
+		Step        string      `json:"step,omitempty"`
+		// HasDependents is true when other jobs wait for this one: the jobs
+		// to queue are looked for only when it is set or for a workflow.
+		HasDependents bool `json:"has_dependents,omitempty"`
 	}
 
 	// JobRequest struct is used to represent a new job request.
@@ -106,6 +127,11 @@ type (
 		Debounced   bool
 		ForwardLogs bool
 		Options     *JobOptions
+		// WaitFor is the list of the identifiers of the jobs that must be
+		// done before this job can be queued.
+		WaitFor    []string
+		WorkflowID string
+		Step       string
 	}
 
 	// JobOptions struct contains the execution properties of the jobs.
@@ -113,6 +139,9 @@ type (
 		MaxExecCount int           `json:"max_exec_count"`
 		MaxExecTime  time.Duration `json:"max_exec_time"`
 		Timeout      time.Duration `json:"timeout"`
+		// Priority is used to order the jobs of an instance in the queue of a
+		// worker: the jobs with a higher priority are executed first.
+		Priority int `json:"priority,omitempty"`
 	}
 )
 
@@ -154,6 +183,10 @@ func (j *Job) Clone() couchdb.Doc {
 		j.Event = make([]byte, len(tmp))
 		copy(j.Event[:], tmp)
 	}
+	if j.WaitFor != nil {
+		cloned.WaitFor = make([]string, len(j.WaitFor))
+		copy(cloned.WaitFor, j.WaitFor)
+	}
 	return &cloned
 }
 
@@ -195,13 +228,30 @@ func (j *Job) Logger() *logrus.Entry {
 	return logger.WithDomain(j.Domain).WithField("nspace", "jobs")
 }
 
+// Priority returns the priority of the job in the queue of its worker.
+func (j *Job) Priority() int {
+	if j.Options == nil || j.Options.Priority == 0 {
+		if j.Manual {
+			return ManualPriority
+		}
+		return DefaultPriority
+	}
+	if j.Options.Priority < MinPriority {
+		return MinPriority
+	}
+	if j.Options.Priority > MaxPriority {
+		return MaxPriority
+	}
+	return j.Options.Priority
+}
+
 // AckConsumed sets the job infos state to Running an sends the new job infos
 // on the channel.
 func (j *Job) AckConsumed() error {
 	j.Logger().Debugf("ack_consume %s ", j.ID())
 	j.StartedAt = time.Now()
 	j.State = Running
-	return j.Update()
+	return j.updateState()
 }
 
 // Ack sets the job infos state to Done an sends the new job infos on the
@@ -211,7 +261,7 @@ func (j *Job) Ack() error {
 	j.FinishedAt = time.Now()
 	j.State = Done
 	j.Event = nil
-	return j.Update()
+	return j.updateState()
 }
 
 // Nack sets the job infos state to Errored, set the specified error has the
@@ -222,7 +272,7 @@ func (j *Job) Nack(err error) error {
 	j.State = Errored
 	j.Error = err.Error()
 	j.Event = nil
-	return j.Update()
+	return j.updateState()
 }
 
 // Update updates the job in couchdb
@@ -230,6 +280,24 @@ func (j *Job) Update() error {
 	return couchdb.UpdateDoc(j, j)
 }
 
+// updateState updates the job in couchdb after a change of its state. A
+// conflict can happen when another job has been pushed to wait for this one,
+// as the job is then marked as having dependents: the update is tried again,
+// with the marker.
+func (j *Job) updateState() error {
+	err := j.Update()
+	if !couchdb.IsConflictError(err) || j.HasDependents {
+		return err
+	}
+	current, errg := Get(j, j.ID())
+	if errg != nil || !current.HasDependents {
+		return err
+	}
+	j.HasDependents = true
+	j.SetRev(current.Rev())
+	return j.Update()
+}
+
 // Create creates the job in couchdb
 func (j *Job) Create() error {
 	return couchdb.CreateDoc(j, j)
@@ -301,6 +369,10 @@ func (m Message) MarshalJSON() ([]byte, error) {
 
 // NewJob creates a new Job instance from a job request.
 func NewJob(db prefixer.Prefixer, req *JobRequest) *Job {
+	state := Queued
+	if len(req.WaitFor) > 0 {
+		state = Waiting
+	}
 	return &Job{
 		Domain:      db.DomainName(),
 		Prefix:      db.DBPrefix(),
@@ -312,7 +384,10 @@ func NewJob(db prefixer.Prefixer, req *JobRequest) *Job {
 		Event:       req.Event,
 		Options:     req.Options,
 		ForwardLogs: req.ForwardLogs,
-		State:       Queued,
+		WaitFor:     req.WaitFor,
+		WorkflowID:  req.WorkflowID,
+		Step:        req.Step,
+		State:       state,
 		QueuedAt:    time.Now(),
 	}
 }
@@ -429,7 +504,7 @@ func GetLastsJobs(jobs []*Job, workerType string) ([]*Job, error) {
 	// Ordering by QueuedAt before filtering jobs
 	sort.Slice(jobs, func(i, j int) bool { return jobs[i].QueuedAt.Before(jobs[j].QueuedAt) })
 
-	for _, state := range []State{Queued, Running, Done, Errored} {
+	for _, state := range []State{Waiting, Queued, Running, Done, Errored} {
 		limit := defaultMaxLimits[state]
 
 		filtered := FilterByWorkerAndState(jobs, workerType, state, limit)
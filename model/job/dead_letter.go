@@ -0,0 +1,232 @@
+package job
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// MaxDeadLettersListed is the maximal number of dead letters that can be
+// listed in one request.
+const MaxDeadLettersListed = 1000
+
+// DeadLettersMaxAge is the duration after which a dead letter that has not
+// been replayed or discarded is deleted by the purge of the old jobs.
+const DeadLettersMaxAge = 30 * 24 * time.Hour
+
+// DeadLetter is a job that has failed after all its executions. It is kept
+// with its message and its last error, so that it can be inspected, modified,
+// and replayed.
+type DeadLetter struct {
+	DocID      string      `json:"_id,omitempty"`
+	DocRev     string      `json:"_rev,omitempty"`
+	JobID      string      `json:"job_id"`
+	WorkerType string      `json:"worker"`
+	TriggerID  string      `json:"trigger_id,omitempty"`
+	Message    Message     `json:"message"`
+	Event      Event       `json:"event,omitempty"`
+	Manual     bool        `json:"manual_execution,omitempty"`
+	Options    *JobOptions `json:"options,omitempty"`
+	Error      string      `json:"error"`
+	ExecCount  int         `json:"exec_count"`
+	QueuedAt   time.Time   `json:"queued_at"`
+	FailedAt   time.Time   `json:"failed_at"`
+}
+
+// ID implements the couchdb.Doc interface
+func (l *DeadLetter) ID() string { return l.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (l *DeadLetter) Rev() string { return l.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (l *DeadLetter) DocType() string { return consts.JobsDeadLetters }
+
+// SetID implements the couchdb.Doc interface
+func (l *DeadLetter) SetID(id string) { l.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (l *DeadLetter) SetRev(rev string) { l.DocRev = rev }
+
+// Clone implements the couchdb.Doc interface
+func (l *DeadLetter) Clone() couchdb.Doc {
+	cloned := *l
+	if l.Options != nil {
+		tmp := *l.Options
+		cloned.Options = &tmp
+	}
+	if l.Message != nil {
+		cloned.Message = make([]byte, len(l.Message))
+		copy(cloned.Message, l.Message)
+	}
+	if l.Event != nil {
+		cloned.Event = make([]byte, len(l.Event))
+		copy(cloned.Event, l.Event)
+	}
+	return &cloned
+}
+
+// Fetch implements the permission.Fetcher interface
+func (l *DeadLetter) Fetch(field string) []string {
+	switch field {
+	case WorkerType:
+		return []string{l.WorkerType}
+	}
+	return nil
+}
+
+// newDeadLetter returns a dead letter for the given job, that has failed with
+// the given error. It must be called before the job is nacked, as the event
+// is removed from the job by Nack.
+func newDeadLetter(job *Job, err error, execCount int) *DeadLetter {
+	l := &DeadLetter{
+		JobID:      job.ID(),
+		WorkerType: job.WorkerType,
+		TriggerID:  job.TriggerID,
+		Message:    job.Message,
+		Event:      job.Event,
+		Manual:     job.Manual,
+		Options:    job.Options,
+		Error:      err.Error(),
+		ExecCount:  execCount,
+		QueuedAt:   job.QueuedAt,
+		FailedAt:   time.Now(),
+	}
+	return l.Clone().(*DeadLetter)
+}
+
+// GetDeadLetter returns the dead letter with the given identifier.
+func GetDeadLetter(db prefixer.Prefixer, id string) (*DeadLetter, error) {
+	var l DeadLetter
+	if err := couchdb.GetDoc(db, consts.JobsDeadLetters, id, &l); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrNotFoundDeadLetter
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+// ListDeadLetters returns the dead letters for the given worker type (or for
+// all the workers if workerType is empty), whose error contains the given
+// string. They are sorted by worker type and then by the date of failure.
+func ListDeadLetters(db prefixer.Prefixer, workerType, errContains string, limit int) ([]*DeadLetter, error) {
+	if limit <= 0 || limit > MaxDeadLettersListed {
+		limit = MaxDeadLettersListed
+	}
+	selector := mango.Gt("worker", "")
+	if workerType != "" {
+		selector = mango.Equal("worker", workerType)
+	}
+	var letters []*DeadLetter
+	bookmark := ""
+	for len(letters) < limit {
+		var docs []*DeadLetter
+		req := &couchdb.FindRequest{
+			UseIndex: "by-worker-and-failed-at",
+			Selector: selector,
+			Sort: mango.SortBy{
+				{Field: "worker", Direction: mango.Asc},
+				{Field: "failed_at", Direction: mango.Asc},
+			},
+			Bookmark: bookmark,
+			Limit:    limit,
+		}
+		res, err := couchdb.FindDocsRaw(db, consts.JobsDeadLetters, req, &docs)
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return letters, nil
+			}
+			return nil, err
+		}
+		for _, l := range docs {
+			if strings.Contains(l.Error, errContains) && len(letters) < limit {
+				letters = append(letters, l)
+			}
+		}
+		if len(docs) < limit || res.Bookmark == "" {
+			break
+		}
+		bookmark = res.Bookmark
+	}
+	return letters, nil
+}
+
+// UpdateMessage changes the message that will be used for the job when the
+// dead letter is replayed.
+func (l *DeadLetter) UpdateMessage(db prefixer.Prefixer, msg Message) error {
+	l.Message = msg
+	return couchdb.UpdateDoc(db, l)
+}
+
+// Replay pushes a new job for the dead letter, and deletes the dead letter.
+// If the job fails again, a new dead letter will be created.
+func (l *DeadLetter) Replay(b Broker, db prefixer.Prefixer) (*Job, error) {
+	j, err := b.PushJob(db, &JobRequest{
+		WorkerType: l.WorkerType,
+		TriggerID:  l.TriggerID,
+		Message:    l.Message,
+		Event:      l.Event,
+		Manual:     l.Manual,
+		Options:    l.Options,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := couchdb.DeleteDoc(db, l); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Discard deletes the dead letter, without replaying it.
+func (l *DeadLetter) Discard(db prefixer.Prefixer) error {
+	return couchdb.DeleteDoc(db, l)
+}
+
+// PurgeDeadLetters deletes the dead letters that have failed before the given
+// date, by batches of at most MaxDeadLettersListed. It returns the number of
+// dead letters that have been deleted.
+func PurgeDeadLetters(db prefixer.Prefixer, before time.Time) (int, error) {
+	var letters []*DeadLetter
+	req := &couchdb.FindRequest{
+		UseIndex: "by-failed-at",
+		Selector: mango.Lt("failed_at", before),
+		Limit:    MaxDeadLettersListed,
+	}
+	err := couchdb.FindDocs(db, consts.JobsDeadLetters, req, &letters)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	docs := make([]couchdb.Doc, len(letters))
+	for i, l := range letters {
+		docs[i] = l
+	}
+	if err := couchdb.BulkDeleteDocs(db, consts.JobsDeadLetters, docs); err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// ReplayDeadLetters replays all the dead letters for the given worker type
+// (or all the workers if workerType is empty) whose error contains the given
+// string. It returns the number of jobs that have been pushed.
+func ReplayDeadLetters(b Broker, db prefixer.Prefixer, workerType, errContains string) (int, error) {
+	letters, err := ListDeadLetters(db, workerType, errContains, MaxDeadLettersListed)
+	if err != nil {
+		return 0, err
+	}
+	for i, l := range letters {
+		if _, err := l.Replay(b, db); err != nil {
+			return i, err
+		}
+	}
+	return len(letters), nil
+}
@@ -0,0 +1,113 @@
+package job_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	jobs "github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetters(t *testing.T) {
+	var w sync.WaitGroup
+
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:   "dead-letter",
+			Concurrency:  1,
+			MaxExecCount: 2,
+			RetryDelay:   1 * time.Millisecond,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				defer w.Done()
+				var msg string
+				if err := ctx.UnmarshalMessage(&msg); err != nil {
+					return err
+				}
+				if msg != "ok" {
+					return errors.New("bad message " + msg)
+				}
+				return nil
+			},
+		},
+	}))
+
+	w.Add(2)
+	msg, _ := jobs.NewMessage("ko")
+	j, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "dead-letter",
+		Message:    msg,
+	})
+	assert.NoError(t, err)
+	w.Wait()
+
+	var letters []*jobs.DeadLetter
+	for i := 0; i < 50; i++ {
+		letters, err = jobs.ListDeadLetters(testInstance, "dead-letter", "", 0)
+		assert.NoError(t, err)
+		if len(letters) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !assert.Len(t, letters, 1) {
+		return
+	}
+	letter := letters[0]
+	assert.Equal(t, j.ID(), letter.JobID)
+	assert.Equal(t, "bad message ko", letter.Error)
+	assert.Equal(t, 2, letter.ExecCount)
+	assert.EqualValues(t, msg, letter.Message)
+
+	letters, err = jobs.ListDeadLetters(testInstance, "dead-letter", "timeout", 0)
+	assert.NoError(t, err)
+	assert.Len(t, letters, 0)
+	letters, err = jobs.ListDeadLetters(testInstance, "", "bad message", 0)
+	assert.NoError(t, err)
+	assert.Len(t, letters, 1)
+
+	msg, _ = jobs.NewMessage("ok")
+	assert.NoError(t, letter.UpdateMessage(testInstance, msg))
+	letter, err = jobs.GetDeadLetter(testInstance, letter.ID())
+	assert.NoError(t, err)
+	assert.EqualValues(t, msg, letter.Message)
+
+	w.Add(1)
+	replayed, err := letter.Replay(broker, testInstance)
+	assert.NoError(t, err)
+	assert.NotEqual(t, j.ID(), replayed.ID())
+	w.Wait()
+
+	_, err = jobs.GetDeadLetter(testInstance, letter.ID())
+	assert.Equal(t, jobs.ErrNotFoundDeadLetter, err)
+	n, err := jobs.ReplayDeadLetters(broker, testInstance, "dead-letter", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestPurgeDeadLetters(t *testing.T) {
+	old := &jobs.DeadLetter{
+		WorkerType: "dead-letter-purge",
+		Error:      "old failure",
+		FailedAt:   time.Now().Add(-jobs.DeadLettersMaxAge - time.Hour),
+	}
+	assert.NoError(t, couchdb.CreateDoc(testInstance, old))
+	recent := &jobs.DeadLetter{
+		WorkerType: "dead-letter-purge",
+		Error:      "recent failure",
+		FailedAt:   time.Now(),
+	}
+	assert.NoError(t, couchdb.CreateDoc(testInstance, recent))
+
+	n, err := jobs.PurgeDeadLetters(testInstance, time.Now().Add(-jobs.DeadLettersMaxAge))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = jobs.GetDeadLetter(testInstance, old.ID())
+	assert.Equal(t, jobs.ErrNotFoundDeadLetter, err)
+	_, err = jobs.GetDeadLetter(testInstance, recent.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, recent.Discard(testInstance))
+}
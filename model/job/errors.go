@@ -20,6 +20,19 @@ var (
 	ErrMessageNil = errors.New("jobs: message is nil")
 	// ErrMessageUnmarshal is used when unmarshalling a message causes an error
 	ErrMessageUnmarshal = errors.New("jobs: message unmarshal")
+	// ErrNotFoundDeadLetter is used when the dead letter could not be found
+	ErrNotFoundDeadLetter = errors.New("jobs: dead letter not found")
+	// ErrNotFoundWorkflow is used when the workflow could not be found
+	ErrNotFoundWorkflow = errors.New("jobs: workflow not found")
+	// ErrInvalidWorkflow is used when the steps of a workflow are not a valid
+	// directed acyclic graph
+	ErrInvalidWorkflow = errors.New("jobs: invalid workflow")
+	// ErrUnknownDependency is used when a job waits for a job that does not
+	// exist
+	ErrUnknownDependency = errors.New("jobs: unknown dependency")
+	// ErrDependencyFailed is used for a job that has not been executed
+	// because a job it was waiting for has failed
+	ErrDependencyFailed = errors.New("jobs: a dependency has failed")
 	// ErrAbort can be used to abort the execution of the job without causing
 	// errors.
 	ErrAbort = errors.New("jobs: abort")
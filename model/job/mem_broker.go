@@ -16,14 +16,26 @@ import (
 
 type (
 	// memQueue is a queue in-memory implementation of the Queue interface.
+	// The jobs are dispatched in a round-robin fashion between the instances,
+	// and the jobs of an instance are sorted by priority.
 	memQueue struct {
 		MaxCapacity int
 		Jobs        chan *Job
 		closed      chan struct{}
 
-		list *list.List
-		run  bool
-		jmu  sync.RWMutex
+		workerType string
+		instances  map[string]*memInstanceQueue
+		ring       *list.List // the prefixes of the instances with jobs
+		count      int
+		run        bool
+		jmu        sync.RWMutex
+	}
+
+	// memInstanceQueue is the list of the jobs of an instance in a memQueue.
+	memInstanceQueue struct {
+		domain string
+		jobs   *list.List
+		elem   *list.Element // the element of the ring for this instance
 	}
 
 	// memBroker is an in-memory broker implementation of the Broker interface.
@@ -38,9 +50,11 @@ type (
 // newMemQueue creates and a new in-memory queue.
 func newMemQueue(workerType string) *memQueue {
 	return &memQueue{
-		list:   list.New(),
-		Jobs:   make(chan *Job),
-		closed: make(chan struct{}),
+		workerType: workerType,
+		instances:  make(map[string]*memInstanceQueue),
+		ring:       list.New(),
+		Jobs:       make(chan *Job),
+		closed:     make(chan struct{}),
 	}
 }
 
@@ -48,7 +62,27 @@ func newMemQueue(workerType string) *memQueue {
 func (q *memQueue) Enqueue(job *Job) error {
 	q.jmu.Lock()
 	defer q.jmu.Unlock()
-	q.list.PushBack(job.Clone())
+	prefix := job.DBPrefix()
+	iq, ok := q.instances[prefix]
+	if !ok {
+		iq = &memInstanceQueue{domain: job.DomainName(), jobs: list.New()}
+		iq.elem = q.ring.PushBack(prefix)
+		q.instances[prefix] = iq
+	}
+	// Insert the job after the jobs with the same or a higher priority
+	cloned := job.Clone().(*Job)
+	priority := cloned.Priority()
+	e := iq.jobs.Back()
+	for e != nil && e.Value.(*Job).Priority() < priority {
+		e = e.Prev()
+	}
+	if e == nil {
+		iq.jobs.PushFront(cloned)
+	} else {
+		iq.jobs.InsertAfter(cloned, e)
+	}
+	q.count++
+	setQueueDepth(q.workerType, iq.domain, iq.jobs.Len())
 	if !q.run {
 		q.run = true
 		go q.send()
@@ -56,21 +90,44 @@ func (q *memQueue) Enqueue(job *Job) error {
 	return nil
 }
 
+// dequeue takes the next job of the next instance in the ring. It must be
+// called with the lock.
+func (q *memQueue) dequeue() *Job {
+	e := q.ring.Front()
+	if e == nil {
+		return nil
+	}
+	prefix := e.Value.(string)
+	iq := q.instances[prefix]
+	job := iq.jobs.Remove(iq.jobs.Front()).(*Job)
+	q.count--
+	if iq.jobs.Len() == 0 {
+		q.ring.Remove(e)
+		delete(q.instances, prefix)
+	} else {
+		q.ring.MoveToBack(e)
+	}
+	setQueueDepth(q.workerType, iq.domain, iq.jobs.Len())
+	return job
+}
+
 func (q *memQueue) send() {
 	for {
 		q.jmu.Lock()
-		e := q.list.Front()
-		if e == nil || !q.run {
+		var job *Job
+		if q.run {
+			job = q.dequeue()
+		}
+		if job == nil {
 			q.run = false
 			q.jmu.Unlock()
 			return
 		}
-		q.list.Remove(e)
 		q.jmu.Unlock()
 		select {
 		case <-q.closed:
 			return
-		case q.Jobs <- e.Value.(*Job):
+		case q.Jobs <- job:
 		}
 	}
 }
@@ -89,7 +146,7 @@ func (q *memQueue) close() {
 func (q *memQueue) Len() int {
 	q.jmu.RLock()
 	defer q.jmu.RUnlock()
-	return q.list.Len()
+	return q.count
 }
 
 // NewMemBroker creates a new in-memory broker system.
@@ -114,6 +171,7 @@ func (b *memBroker) StartWorkers(ws WorkersList) error {
 		}
 		q := newMemQueue(conf.WorkerType)
 		w := NewWorker(conf)
+		w.broker = b
 		b.queues[conf.WorkerType] = q
 		b.workers = append(b.workers, w)
 		if err := w.Start(q.Jobs); err != nil {
@@ -203,6 +261,10 @@ func (b *memBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error)
 		}
 	}
 
+	if err := checkDependencies(db, req.WaitFor); err != nil {
+		return nil, err
+	}
+
 	job := NewJob(db, req)
 	if worker.Conf.BeforeHook != nil {
 		ok, err := worker.Conf.BeforeHook(job)
@@ -218,13 +280,28 @@ func (b *memBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error)
 		return nil, err
 	}
 
-	q := b.queues[workerType]
-	if err := q.Enqueue(job); err != nil {
+	if job.State == Waiting {
+		if err := startWhenReady(b, job); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+
+	if err := b.enqueue(job); err != nil {
 		return nil, err
 	}
 	return job, nil
 }
 
+// enqueue puts in the queue a job that has already been created.
+func (b *memBroker) enqueue(job *Job) error {
+	q, ok := b.queues[job.WorkerType]
+	if !ok {
+		return ErrUnknownWorker
+	}
+	return q.Enqueue(job)
+}
+
 // WorkerQueueLen returns the size of the number of elements in queue of the
 // specified worker type.
 func (b *memBroker) WorkerQueueLen(workerType string) (int, error) {
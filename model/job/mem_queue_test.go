@@ -0,0 +1,51 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemQueueFairness(t *testing.T) {
+	q := newMemQueue("test")
+	// Do not start the goroutine that sends the jobs to the workers
+	q.run = true
+
+	push := func(domain, id string, priority int, manual bool) {
+		j := &Job{JobID: id, Domain: domain, Manual: manual}
+		if priority > 0 {
+			j.Options = &JobOptions{Priority: priority}
+		}
+		assert.NoError(t, q.Enqueue(j))
+	}
+	push("alice.cozy.tools", "a1", 0, false)
+	push("alice.cozy.tools", "a2", 0, false)
+	push("alice.cozy.tools", "a3", 0, false)
+	push("alice.cozy.tools", "a4", 90, false)
+	push("alice.cozy.tools", "a5", 0, true)
+	push("bob.cozy.tools", "b1", 0, false)
+	push("bob.cozy.tools", "b2", 10, false)
+	push("bob.cozy.tools", "b3", 0, false)
+	assert.Equal(t, 8, q.Len())
+
+	var ids []string
+	for j := q.dequeue(); j != nil; j = q.dequeue() {
+		ids = append(ids, j.ID())
+	}
+	expected := []string{"a4", "b1", "a5", "b3", "a1", "b2", "a2", "a3"}
+	assert.Equal(t, expected, ids)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestJobPriority(t *testing.T) {
+	j := &Job{}
+	assert.Equal(t, DefaultPriority, j.Priority())
+	j.Manual = true
+	assert.Equal(t, ManualPriority, j.Priority())
+	j.Options = &JobOptions{Priority: 20}
+	assert.Equal(t, 20, j.Priority())
+	j.Options.Priority = 1000
+	assert.Equal(t, MaxPriority, j.Priority())
+	j.Options.Priority = -3
+	assert.Equal(t, MinPriority, j.Priority())
+}
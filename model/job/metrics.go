@@ -1,6 +1,9 @@
 package job
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/cozy/cozy-stack/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 type workersQueuesCollector struct {
 	prometheus.Desc
@@ -34,6 +37,16 @@ func (i *workersQueuesCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+// setQueueDepth updates the metric for the number of jobs of an instance in
+// the queue of a worker.
+func setQueueDepth(workerType, domain string, depth int) {
+	if depth > 0 {
+		metrics.WorkerQueueDepth.WithLabelValues(workerType, domain).Set(float64(depth))
+	} else {
+		metrics.WorkerQueueDepth.DeleteLabelValues(workerType, domain)
+	}
+}
+
 func init() {
 	prometheus.MustRegister(newWorkersQueuesCollector())
 }
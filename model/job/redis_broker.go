@@ -2,8 +2,9 @@ package job
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -20,9 +21,105 @@ const (
 	// redisPrefix is the prefix for jobs queues in redis.
 	redisPrefix = "j/"
 	// redisHighPrioritySuffix suffix is the suffix used for prioritized queue.
+	// It is no longer used for pushing jobs, but the queue is still emptied
+	// for the jobs pushed by older versions of the stack.
 	redisHighPrioritySuffix = "/p0"
+	// redisInstanceQueueInfix is used for the key of the queue of an instance
+	// for a worker: it is a sorted set of job identifiers.
+	redisInstanceQueueInfix = "/q/"
+	// redisLenSuffix is used for the key of the number of jobs in the queue
+	// of a worker.
+	redisLenSuffix = "/len"
 )
 
+// The queue of a worker in redis is made of:
+// - a sorted set for each instance with jobs, where the score is computed
+//   from the priority and the date of the job
+// - a list of the instances with jobs, used as a ring for the round-robin
+//   between the instances
+// - a counter for the total number of jobs.
+//
+// An instance is in the ring only if its sorted set is not empty. When a
+// stack pops a job, it rotates the ring (the instance at the end is moved to
+// the start), takes the first job of this instance, and removes the instance
+// from the ring if it has no more jobs, all in the same script. The keys of a
+// worker use a hash tag on the worker type (j/{<worker>}...), so that they are
+// in the same slot with redis cluster.
+//
+// The lists j/<worker> and j/<worker>/p0 are the FIFO queues of the older
+// versions of the stack: they are still emptied.
+
+// luaPushJob adds a job to the queue of an instance, and adds the instance to
+// the ring if it was not already here. It returns the number of jobs in the
+// queue of the instance.
+//
+// KEYS[1]: the queue of the instance, KEYS[2]: the ring, KEYS[3]: the counter
+// ARGV[1]: the score, ARGV[2]: the job ID, ARGV[3]: the prefix of the instance
+const luaPushJob = `
+if redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2]) == 1 then
+  redis.call("INCR", KEYS[3])
+end
+local depth = redis.call("ZCARD", KEYS[1])
+if depth == 1 then
+  redis.call("LPUSH", KEYS[2], ARGV[3])
+end
+return depth`
+
+// luaPopJob rotates the ring, takes the first job of the queue of the
+// instance that was at the end of the ring, and removes the instance from the
+// ring if it has no more jobs. It returns the prefix of the instance, the job
+// ID (or an empty string) and the number of jobs left in the queue of the
+// instance, or nil if the ring is empty.
+//
+// The key of the queue of the instance must be declared in KEYS for redis
+// cluster, so the caller reads the end of the ring first. If another stack
+// has rotated the ring in the meantime, nothing is changed, and only the
+// prefix of the instance now at the end of the ring is returned.
+//
+// KEYS[1]: the ring, KEYS[2]: the counter, KEYS[3]: the queue of the instance
+// ARGV[1]: the prefix of the instance
+const luaPopJob = `
+local prefix = redis.call("LINDEX", KEYS[1], -1)
+if not prefix then
+  return false
+end
+if prefix ~= ARGV[1] then
+  return {prefix}
+end
+redis.call("RPOPLPUSH", KEYS[1], KEYS[1])
+local id = ""
+local s = redis.call("ZRANGE", KEYS[3], 0, 0)
+if #s > 0 then
+  id = s[1]
+  redis.call("ZREM", KEYS[3], id)
+  redis.call("DECR", KEYS[2])
+end
+local depth = redis.call("ZCARD", KEYS[3])
+if depth == 0 then
+  redis.call("LREM", KEYS[1], 0, prefix)
+end
+return {prefix, id, depth}`
+
+// redisPopJobRetries is the number of times a stack tries again to pop a job
+// when the ring has been rotated by another stack.
+const redisPopJobRetries = 10
+
+// redisWorkerKey returns the key of the ring of the instances with jobs for
+// the given worker. The other keys of the queue of the worker start with it.
+func redisWorkerKey(workerType string) string {
+	return redisPrefix + "{" + workerType + "}"
+}
+
+// redisJobScore returns the score of a job in the queue of its instance: the
+// jobs with a higher priority are first, and then the oldest jobs.
+func redisJobScore(job *Job) float64 {
+	// The milliseconds since epoch are lower than 1e13 until the year 2286,
+	// and the score is lower than 2^53, the limit for the integers that can
+	// be represented exactly as a float64.
+	ms := job.QueuedAt.UnixNano() / int64(time.Millisecond)
+	return float64(int64(MaxPriority-job.Priority())*1e13 + ms)
+}
+
 type redisBroker struct {
 	client         redis.UniversalClient
 	workers        []*Worker
@@ -50,6 +147,7 @@ func (b *redisBroker) StartWorkers(ws WorkersList) error {
 	for _, conf := range ws {
 		b.workersTypes = append(b.workersTypes, conf.WorkerType)
 		w := NewWorker(conf)
+		w.broker = b
 		b.workers = append(b.workers, w)
 		if conf.Concurrency <= 0 {
 			continue
@@ -59,7 +157,7 @@ func (b *redisBroker) StartWorkers(ws WorkersList) error {
 		if err := w.Start(ch); err != nil {
 			return err
 		}
-		go b.pollLoop(redisPrefix+conf.WorkerType, ch)
+		go b.pollLoop(conf.WorkerType, ch)
 	}
 
 	if len(b.workersRunning) > 0 {
@@ -122,58 +220,108 @@ func (b *redisBroker) ShutdownWorkers(ctx context.Context) error {
 
 var redisBRPopTimeout = 10 * time.Second
 
-func (b *redisBroker) pollLoop(key string, ch chan<- *Job) {
+func (b *redisBroker) pollLoop(workerType string, ch chan<- *Job) {
 	defer func() {
 		b.closed <- struct{}{}
 	}()
 
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	key := redisWorkerKey(workerType)
 	for {
 		if atomic.LoadUint32(&b.running) == 0 {
 			return
 		}
 
-		// The brpop redis command will always take elements in priority from the
-		// first key containing elements at the call. By always priorizing the
-		// manual queue, this would cause a starvation for our main queue if too
-		// many "manual" jobs are pushed. By randomizing the order we make sure we
-		// avoid such starvation. For one in three call, the main queue is
-		// selected.
-		keyP0 := key + redisHighPrioritySuffix
-		keyP1 := key
-		if rng.Intn(3) == 0 {
-			keyP1, keyP0 = keyP0, keyP1
+		prefix, jobID, depth, err := b.popJob(workerType)
+		legacy := err == redis.Nil
+		if legacy {
+			// The old queues are emptied before waiting for a new job, as
+			// they are only used by the older versions of the stack.
+			prefix, jobID, err = b.popLegacyJob(workerType)
 		}
-		results, err := b.client.BRPop(redisBRPopTimeout, keyP0, keyP1).Result()
-		if err != nil || len(results) < 2 {
-			time.Sleep(100 * time.Millisecond)
+		if err == redis.Nil {
+			// BRPOPLPUSH on the ring only rotates it, so an instance can't be
+			// lost: it is just used to wait until a job is pushed.
+			err = b.client.BRPopLPush(key, key, redisBRPopTimeout).Err()
+			if err != nil && err != redis.Nil {
+				time.Sleep(100 * time.Millisecond)
+			}
 			continue
 		}
-
-		key := results[0]
-		val := results[1]
-		if len(key) < len(redisPrefix) {
-			joblog.Warnf("Invalid key %s", key)
+		if err != nil {
+			joblog.Warnf("Cannot pop a job on %s: %s", workerType, err)
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
-
-		parts := strings.SplitN(val, "/", 2)
-		if len(parts) != 2 {
-			joblog.Warnf("Invalid val %s", val)
+		if jobID == "" {
 			continue
 		}
 
-		prefix, jobID := parts[0], parts[1]
 		job, err := Get(prefixer.NewPrefixer("", prefix), jobID)
 		if err != nil {
-			joblog.Warnf("Cannot find job %s on domain %s: %s", parts[1], parts[0], err)
+			joblog.Warnf("Cannot find job %s on domain %s: %s", jobID, prefix, err)
 			continue
 		}
+		if !legacy {
+			setQueueDepth(workerType, job.Domain, depth)
+		}
 
 		ch <- job
 	}
 }
 
+// popJob takes the next job in the queue of a worker, with a round-robin
+// between the instances. It returns redis.Nil if the queue is empty, and an
+// empty job ID if the instance at the end of the ring had no job. It also
+// returns the number of jobs left in the queue of the instance.
+func (b *redisBroker) popJob(workerType string) (string, string, int, error) {
+	key := redisWorkerKey(workerType)
+	prefix, err := b.client.LIndex(key, -1).Result()
+	if err != nil {
+		return "", "", 0, err
+	}
+	for i := 0; i < redisPopJobRetries; i++ {
+		keys := []string{key, key + redisLenSuffix, key + redisInstanceQueueInfix + prefix}
+		res, err := b.client.Eval(luaPopJob, keys, prefix).Result()
+		if err != nil {
+			return "", "", 0, err
+		}
+		results, ok := res.([]interface{})
+		if !ok || (len(results) != 1 && len(results) != 3) {
+			return "", "", 0, errors.New("Unexpected response from redis")
+		}
+		prefix, _ = results[0].(string)
+		if len(results) == 1 {
+			// The ring has been rotated by another stack
+			continue
+		}
+		jobID, _ := results[1].(string)
+		depth, _ := results[2].(int64)
+		return prefix, jobID, int(depth), nil
+	}
+	return "", "", 0, errors.New("Too many concurrent rotations of the ring")
+}
+
+// popLegacyJob takes a job from the old queues of a worker, where the jobs
+// were pushed as <prefix>/<job ID>. It returns redis.Nil if they are empty.
+func (b *redisBroker) popLegacyJob(workerType string) (string, string, error) {
+	key := redisPrefix + workerType
+	for _, k := range []string{key + redisHighPrioritySuffix, key} {
+		val, err := b.client.RPop(k).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		parts := strings.SplitN(val, "/", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("Invalid job %q in the old queue", val)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", redis.Nil
+}
+
 // PushJob will produce a new Job with the given options and enqueue the job in
 // the proper queue.
 func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error) {
@@ -208,6 +356,10 @@ func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, erro
 		}
 	}
 
+	if err := checkDependencies(db, req.WaitFor); err != nil {
+		return nil, err
+	}
+
 	job := NewJob(db, req)
 	if worker.Conf.BeforeHook != nil {
 		ok, err := worker.Conf.BeforeHook(job)
@@ -223,35 +375,50 @@ func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, erro
 		return nil, err
 	}
 
-	key := redisPrefix + job.WorkerType
-	val := job.DBPrefix() + "/" + job.JobID
-
-	// When the job is manual, it is being pushed in a specific prioritized
-	// queue.
-	if job.Manual {
-		key += redisHighPrioritySuffix
+	if job.State == Waiting {
+		if err := startWhenReady(b, job); err != nil {
+			return nil, err
+		}
+		return job, nil
 	}
 
-	if err := b.client.LPush(key, val).Err(); err != nil {
+	if err := b.enqueue(job); err != nil {
 		return nil, err
 	}
-
 	return job, nil
 }
 
+// enqueue puts in the queue a job that has already been created.
+func (b *redisBroker) enqueue(job *Job) error {
+	key := redisWorkerKey(job.WorkerType)
+	prefix := job.DBPrefix()
+	keys := []string{key + redisInstanceQueueInfix + prefix, key, key + redisLenSuffix}
+	score := strconv.FormatFloat(redisJobScore(job), 'f', 0, 64)
+	depth, err := b.client.Eval(luaPushJob, keys, score, job.JobID, prefix).Int()
+	if err != nil {
+		return err
+	}
+	setQueueDepth(job.WorkerType, job.Domain, depth)
+	return nil
+}
+
 // QueueLen returns the size of the number of elements in queue of the
 // specified worker type.
 func (b *redisBroker) WorkerQueueLen(workerType string) (int, error) {
+	l1, err := b.client.Get(redisWorkerKey(workerType) + redisLenSuffix).Int()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
 	key := redisPrefix + workerType
-	l1, err := b.client.LLen(key).Result()
+	l2, err := b.client.LLen(key + redisHighPrioritySuffix).Result()
 	if err != nil {
 		return 0, err
 	}
-	l2, err := b.client.LLen(key + redisHighPrioritySuffix).Result()
+	l3, err := b.client.LLen(key).Result()
 	if err != nil {
 		return 0, err
 	}
-	return int(l1 + l2), nil
+	return l1 + int(l2) + int(l3), nil
 }
 
 func (b *redisBroker) WorkerIsReserved(workerType string) (bool, error) {
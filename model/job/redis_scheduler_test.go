@@ -159,9 +159,11 @@ func TestRedisSchedulerWithTimeTriggers(t *testing.T) {
 			trigger.Infos().Metadata = nil
 			assert.Equal(t, tin.Infos(), trigger.Infos())
 		default:
-			// Just ignore the @event trigger for generating thumbnails
+			// Just ignore the @event triggers for generating thumbnails and
+			// indexing the files for the search
 			infos := trigger.Infos()
-			if infos.Type != "@event" || infos.WorkerType != "thumbnail" {
+			if infos.Type != "@event" ||
+				(infos.WorkerType != "thumbnail" && infos.WorkerType != "search-index") {
 				t.Fatalf("unknown trigger ID %s", trigger.Infos().TID)
 			}
 		}
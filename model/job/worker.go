@@ -13,6 +13,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/metrics"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
@@ -81,6 +82,7 @@ type (
 		jobs    chan *Job
 		running uint32
 		closed  chan struct{}
+		broker  jobEnqueuer
 	}
 
 	// WorkerContext is a context.Context passed to the worker for each job
@@ -308,7 +310,15 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 			parentCtx.Logger().Errorf("error while performing job: %s",
 				errRun.Error())
 			runResultLabel = metrics.WorkerExecResultErrored
+			letter := newDeadLetter(job, errRun, t.execCount)
 			errAck = job.Nack(errRun)
+			// A job for a bad trigger cannot be replayed
+			if _, ok := errRun.(ErrBadTrigger); !ok && errAck == nil {
+				if err := couchdb.CreateDoc(job, letter); err != nil {
+					parentCtx.Logger().Errorf("error while saving the dead letter: %s",
+						err.Error())
+				}
+			}
 		} else {
 			runResultLabel = metrics.WorkerExecResultSuccess
 			errAck = job.Ack()
@@ -332,6 +342,9 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 		if errAck != nil {
 			parentCtx.Logger().Errorf("error while acking job done: %s",
 				errAck.Error())
+		} else if w.broker != nil {
+			// Queue the jobs that were waiting for this one
+			jobFinished(w.broker, job)
 		}
 
 		// Delete the trigger associated with the job (if any) when we receive a
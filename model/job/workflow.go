@@ -0,0 +1,325 @@
+package job
+
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/gofrs/uuid"
+)
+
+// maxWorkflowSteps is the maximal number of steps for a workflow.
+const maxWorkflowSteps = 100
+
+type (
+	// WorkflowStep is a step of a workflow: a job request, with a name that can
+	// be used by the other steps to wait for this job to be done.
+	WorkflowStep struct {
+		Name       string      `json:"name"`
+		WorkerType string      `json:"worker"`
+		Message    Message     `json:"message,omitempty"`
+		Options    *JobOptions `json:"options,omitempty"`
+		// WaitFor is the list of the names of the steps that must be done
+		// before this step can start.
+		WaitFor []string `json:"wait_for,omitempty"`
+	}
+
+	// Workflow is a set of jobs, linked by their dependencies, that forms a
+	// directed acyclic graph.
+	Workflow struct {
+		WorkflowID string `json:"_id"`
+		State      State  `json:"state"`
+		Jobs       []*Job `json:"jobs"`
+	}
+
+	// jobEnqueuer is implemented by the brokers to put in their queues a job
+	// that has already been created (and was waiting for other jobs).
+	jobEnqueuer interface {
+		enqueue(job *Job) error
+	}
+)
+
+// PushWorkflow pushes the jobs for the steps of a workflow. The steps without
+// dependencies are queued immediately, and the other ones are queued when all
+// the steps they are waiting for are done. If a step fails, the steps that
+// depend on it are marked as errored without being executed.
+func PushWorkflow(b Broker, db prefixer.Prefixer, steps []WorkflowStep) (*Workflow, error) {
+	ordered, err := sortSteps(steps)
+	if err != nil {
+		return nil, err
+	}
+	types := b.WorkersTypes()
+	for _, step := range ordered {
+		if !hasWorkerType(types, step.WorkerType) {
+			return nil, ErrUnknownWorker
+		}
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	wf := &Workflow{WorkflowID: id.String()}
+	ids := make(map[string]string, len(ordered))
+	for _, step := range ordered {
+		var waitFor []string
+		for _, name := range step.WaitFor {
+			// The job of a step can be skipped by the BeforeHook of its worker,
+			// and the jobs that depend on it can run as if it was done.
+			if ids[name] != "" {
+				waitFor = append(waitFor, ids[name])
+			}
+		}
+		j, err := b.PushJob(db, &JobRequest{
+			WorkerType: step.WorkerType,
+			Message:    step.Message,
+			Options:    step.Options,
+			WaitFor:    waitFor,
+			WorkflowID: wf.WorkflowID,
+			Step:       step.Name,
+		})
+		if err != nil {
+			// The jobs already pushed that are waiting would never start
+			for _, pushed := range wf.Jobs {
+				if pushed.State == Waiting {
+					_ = pushed.Nack(err)
+				}
+			}
+			return nil, err
+		}
+		ids[step.Name] = j.ID()
+		wf.Jobs = append(wf.Jobs, j)
+	}
+	wf.State = workflowState(wf.Jobs)
+	return wf, nil
+}
+
+// GetWorkflow returns the workflow with the given identifier, with its jobs.
+func GetWorkflow(db prefixer.Prefixer, workflowID string) (*Workflow, error) {
+	var jobs []*Job
+	req := &couchdb.FindRequest{
+		UseIndex: "by-workflow-id",
+		Selector: mango.Equal("workflow_id", workflowID),
+		Sort: mango.SortBy{
+			{Field: "workflow_id", Direction: mango.Asc},
+			{Field: "queued_at", Direction: mango.Asc},
+		},
+		Limit: maxWorkflowSteps,
+	}
+	if err := couchdb.FindDocs(db, consts.Jobs, req, &jobs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, ErrNotFoundWorkflow
+		}
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, ErrNotFoundWorkflow
+	}
+	return &Workflow{
+		WorkflowID: workflowID,
+		State:      workflowState(jobs),
+		Jobs:       jobs,
+	}, nil
+}
+
+// workflowState returns the global state of a workflow from the states of
+// its jobs: errored if one job has failed, done if all the jobs are done,
+// and else the most advanced state of its jobs.
+func workflowState(jobs []*Job) State {
+	var queued, running, done int
+	for _, j := range jobs {
+		switch j.State {
+		case Errored:
+			return Errored
+		case Queued:
+			queued++
+		case Running:
+			running++
+		case Done:
+			done++
+		}
+	}
+	switch {
+	case done == len(jobs):
+		return Done
+	case running > 0 || done > 0:
+		return Running
+	case queued > 0:
+		return Queued
+	}
+	return Waiting
+}
+
+// sortSteps checks that the steps form a directed acyclic graph, and returns
+// them in a topological order (the dependencies of a step are before it).
+func sortSteps(steps []WorkflowStep) ([]WorkflowStep, error) {
+	if len(steps) == 0 || len(steps) > maxWorkflowSteps {
+		return nil, ErrInvalidWorkflow
+	}
+	byName := make(map[string]WorkflowStep, len(steps))
+	for _, step := range steps {
+		if step.Name == "" {
+			return nil, ErrInvalidWorkflow
+		}
+		if _, ok := byName[step.Name]; ok {
+			return nil, ErrInvalidWorkflow
+		}
+		byName[step.Name] = step
+	}
+
+	// Kahn's algorithm, keeping the order of the request for the steps that
+	// are ready at the same time.
+	remaining := make(map[string]int, len(steps))
+	for _, step := range steps {
+		for _, name := range step.WaitFor {
+			if _, ok := byName[name]; !ok || name == step.Name {
+				return nil, ErrInvalidWorkflow
+			}
+		}
+		remaining[step.Name] = len(step.WaitFor)
+	}
+	sorted := make([]WorkflowStep, 0, len(steps))
+	added := make(map[string]bool, len(steps))
+	for len(sorted) < len(steps) {
+		progress := false
+		for _, step := range steps {
+			if added[step.Name] || remaining[step.Name] > 0 {
+				continue
+			}
+			added[step.Name] = true
+			sorted = append(sorted, step)
+			progress = true
+			for _, other := range steps {
+				for _, name := range other.WaitFor {
+					if name == step.Name {
+						remaining[other.Name]--
+					}
+				}
+			}
+		}
+		if !progress {
+			// There is a cycle
+			return nil, ErrInvalidWorkflow
+		}
+	}
+	return sorted, nil
+}
+
+func hasWorkerType(types []string, workerType string) bool {
+	for _, t := range types {
+		if t == workerType {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDependencies checks that the jobs to wait for exist, and marks them as
+// having dependents. They are marked before the new job is created, so that
+// they will look for it when they finish.
+func checkDependencies(db prefixer.Prefixer, waitFor []string) error {
+	for _, id := range waitFor {
+		if err := markDependency(db, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func markDependency(db prefixer.Prefixer, id string) error {
+	for {
+		parent, err := Get(db, id)
+		if err == ErrNotFoundJob {
+			return ErrUnknownDependency
+		}
+		if err != nil || parent.HasDependents {
+			return err
+		}
+		parent.HasDependents = true
+		err = parent.Update()
+		if !couchdb.IsConflictError(err) {
+			return err
+		}
+	}
+}
+
+// startWhenReady looks at the jobs a waiting job depends on. If they are all
+// done, the job is queued. If one of them has failed, the job is marked as
+// errored.
+func startWhenReady(b jobEnqueuer, job *Job) error {
+	ready := true
+	for _, id := range job.WaitFor {
+		parent, err := Get(job, id)
+		if err == ErrNotFoundJob {
+			return cancelJob(b, job, ErrUnknownDependency)
+		}
+		if err != nil {
+			return err
+		}
+		switch parent.State {
+		case Done:
+		case Errored:
+			return cancelJob(b, job, ErrDependencyFailed)
+		default:
+			ready = false
+		}
+	}
+	if !ready {
+		return nil
+	}
+
+	// The update of the document is used as a lock: when two jobs finish at
+	// the same time, only one of them will queue the job.
+	job.State = Queued
+	if err := job.Update(); err != nil {
+		if couchdb.IsConflictError(err) {
+			return nil
+		}
+		return err
+	}
+	return b.enqueue(job)
+}
+
+// cancelJob marks a waiting job as errored, and does the same for the jobs
+// that depend on it.
+func cancelJob(b jobEnqueuer, job *Job, reason error) error {
+	if err := job.Nack(reason); err != nil {
+		if couchdb.IsConflictError(err) {
+			return nil
+		}
+		return err
+	}
+	jobFinished(b, job)
+	return nil
+}
+
+// jobFinished must be called when a job is done or errored, to queue (or
+// cancel) the jobs that were waiting for it.
+func jobFinished(b jobEnqueuer, job *Job) {
+	if job.WorkflowID == "" && !job.HasDependents {
+		return
+	}
+	var res couchdb.ViewResponse
+	err := couchdb.ExecView(job, couchdb.JobsWaitingForView, &couchdb.ViewRequest{
+		Key:         job.ID(),
+		IncludeDocs: true,
+	}, &res)
+	if err != nil {
+		if !couchdb.IsNoDatabaseError(err) {
+			job.Logger().Errorf("Cannot find the jobs waiting for %s: %s", job.ID(), err)
+		}
+		return
+	}
+	for _, row := range res.Rows {
+		var child Job
+		if err := json.Unmarshal(row.Doc, &child); err != nil {
+			job.Logger().Errorf("Cannot read the job %s: %s", row.ID, err)
+			continue
+		}
+		if err := startWhenReady(b, &child); err != nil {
+			job.Logger().Errorf("Cannot start the job %s: %s", child.ID(), err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package job_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	jobs "github.com/cozy/cozy-stack/model/job"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForWorkflow(t *testing.T, id string) *jobs.Workflow {
+	for i := 0; i < 100; i++ {
+		wf, err := jobs.GetWorkflow(testInstance, id)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if wf.State == jobs.Done || wf.State == jobs.Errored {
+			return wf
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("the workflow has not finished")
+	return nil
+}
+
+func TestInMemoryWorkflow(t *testing.T) {
+	var mu sync.Mutex
+	var executed []string
+
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:   "step",
+			Concurrency:  4,
+			MaxExecCount: 1,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				var name string
+				if err := ctx.UnmarshalMessage(&name); err != nil {
+					return err
+				}
+				mu.Lock()
+				executed = append(executed, name)
+				mu.Unlock()
+				if name == "fail" {
+					return errors.New("failure")
+				}
+				return nil
+			},
+		},
+	}))
+
+	step := func(name string, waitFor ...string) jobs.WorkflowStep {
+		msg, _ := jobs.NewMessage(name)
+		return jobs.WorkflowStep{
+			Name:       name,
+			WorkerType: "step",
+			Message:    msg,
+			WaitFor:    waitFor,
+		}
+	}
+
+	t.Run("InvalidWorkflow", func(t *testing.T) {
+		_, err := jobs.PushWorkflow(broker, testInstance, nil)
+		assert.Equal(t, jobs.ErrInvalidWorkflow, err)
+		_, err = jobs.PushWorkflow(broker, testInstance, []jobs.WorkflowStep{
+			step("a", "b"), step("b", "a"),
+		})
+		assert.Equal(t, jobs.ErrInvalidWorkflow, err)
+		_, err = jobs.PushWorkflow(broker, testInstance, []jobs.WorkflowStep{
+			step("a", "missing"),
+		})
+		assert.Equal(t, jobs.ErrInvalidWorkflow, err)
+		_, err = jobs.PushWorkflow(broker, testInstance, []jobs.WorkflowStep{
+			{Name: "a", WorkerType: "unknown"},
+		})
+		assert.Equal(t, jobs.ErrUnknownWorker, err)
+		_, err = broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: "step",
+			WaitFor:    []string{"missing"},
+		})
+		assert.Equal(t, jobs.ErrUnknownDependency, err)
+	})
+
+	t.Run("FanOutFanIn", func(t *testing.T) {
+		mu.Lock()
+		executed = nil
+		mu.Unlock()
+		wf, err := jobs.PushWorkflow(broker, testInstance, []jobs.WorkflowStep{
+			step("d", "b", "c"), step("b", "a"), step("c", "a"), step("a"),
+		})
+		if !assert.NoError(t, err) || !assert.Len(t, wf.Jobs, 4) {
+			return
+		}
+		assert.Equal(t, "a", wf.Jobs[0].Step)
+
+		wf = waitForWorkflow(t, wf.WorkflowID)
+		assert.Equal(t, jobs.Done, wf.State)
+		mu.Lock()
+		defer mu.Unlock()
+		if !assert.Len(t, executed, 4) {
+			return
+		}
+		assert.Equal(t, "a", executed[0])
+		assert.ElementsMatch(t, []string{"b", "c"}, executed[1:3])
+		assert.Equal(t, "d", executed[3])
+	})
+
+	t.Run("DependencyFailed", func(t *testing.T) {
+		mu.Lock()
+		executed = nil
+		mu.Unlock()
+		wf, err := jobs.PushWorkflow(broker, testInstance, []jobs.WorkflowStep{
+			step("fail"), step("after", "fail"), step("last", "after"),
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		wf = waitForWorkflow(t, wf.WorkflowID)
+		assert.Equal(t, jobs.Errored, wf.State)
+		// Wait for the cancellation of the last step
+		time.Sleep(100 * time.Millisecond)
+		wf, err = jobs.GetWorkflow(testInstance, wf.WorkflowID)
+		assert.NoError(t, err)
+		for _, j := range wf.Jobs {
+			assert.Equal(t, jobs.Errored, j.State)
+			if j.Step != "fail" {
+				assert.Equal(t, jobs.ErrDependencyFailed.Error(), j.Error)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []string{"fail"}, executed)
+	})
+
+	t.Run("StandaloneDependency", func(t *testing.T) {
+		msg, _ := jobs.NewMessage("parent")
+		parent, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: "step",
+			Message:    msg,
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		msg, _ = jobs.NewMessage("child")
+		child, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: "step",
+			Message:    msg,
+			WaitFor:    []string{parent.ID()},
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		for i := 0; i < 100; i++ {
+			child, err = jobs.Get(testInstance, child.ID())
+			assert.NoError(t, err)
+			if child.State == jobs.Done {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		assert.Equal(t, jobs.Done, child.State)
+		parent, err = jobs.Get(testInstance, parent.ID())
+		assert.NoError(t, err)
+		assert.Equal(t, jobs.Done, parent.State)
+		assert.True(t, parent.HasDependents)
+		assert.False(t, child.HasDependents)
+	})
+}
@@ -0,0 +1,510 @@
+package note
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/prosemirror-go/transform"
+)
+
+const (
+	// CommentThread is the kind of threads with only comments.
+	CommentThread = "comment"
+	// SuggestionThread is the kind of threads that propose to replace the
+	// anchored range by another content.
+	SuggestionThread = "suggestion"
+)
+
+const (
+	// ThreadOpen is the state of a thread that has not been closed.
+	ThreadOpen = "open"
+	// ThreadResolved is the state of a thread that has been marked as
+	// resolved. It can be reopened.
+	ThreadResolved = "resolved"
+	// ThreadAccepted is the state of a suggestion that has been applied to
+	// the note.
+	ThreadAccepted = "accepted"
+	// ThreadRejected is the state of a suggestion that has been discarded.
+	ThreadRejected = "rejected"
+)
+
+// maxThreadsListed is the maximal number of threads that are returned for a
+// note.
+const maxThreadsListed = 1000
+
+// Anchor is the range of a note where a thread is attached. When the anchored
+// content is removed from the note, the anchor is marked as orphan.
+type Anchor struct {
+	From   int  `json:"from"`
+	To     int  `json:"to"`
+	Orphan bool `json:"orphan,omitempty"`
+}
+
+// mapThrough returns the anchor with its positions mapped through the changes
+// of a step.
+func (a Anchor) mapThrough(m *transform.StepMap) Anchor {
+	if a.Orphan {
+		return a
+	}
+	if a.From == a.To {
+		res := m.MapResult(a.From, -1)
+		return Anchor{From: res.Pos, To: res.Pos, Orphan: res.Deleted}
+	}
+	// The content inserted just before or just after the range is not
+	// included in it.
+	from := m.Map(a.From, 1)
+	to := m.Map(a.To, -1)
+	if from >= to {
+		return Anchor{From: from, To: from, Orphan: true}
+	}
+	return Anchor{From: from, To: to}
+}
+
+func (d *Document) mapAnchors(m *transform.StepMap) {
+	for id, a := range d.Anchors {
+		d.Anchors[id] = a.mapThrough(m)
+	}
+}
+
+func anchorsFromMetadata(meta vfs.Metadata) map[string]Anchor {
+	switch anchors := meta["anchors"].(type) {
+	case map[string]Anchor:
+		res := make(map[string]Anchor, len(anchors))
+		for id, a := range anchors {
+			res[id] = a
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]Anchor, len(anchors))
+		for id, v := range anchors {
+			raw, _ := v.(map[string]interface{})
+			from, _ := raw["from"].(float64)
+			to, _ := raw["to"].(float64)
+			orphan, _ := raw["orphan"].(bool)
+			res[id] = Anchor{From: int(from), To: int(to), Orphan: orphan}
+		}
+		return res
+	}
+	return nil
+}
+
+func sameAnchors(a, b map[string]Anchor) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, anchor := range a {
+		if other, ok := b[id]; !ok || other != anchor {
+			return false
+		}
+	}
+	return true
+}
+
+// Comment is a message in a thread.
+type Comment struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *Comment) prepare() error {
+	if strings.TrimSpace(c.Body) == "" {
+		return ErrEmptyComment
+	}
+	c.ID = utils.RandomString(16)
+	c.CreatedAt = time.Now()
+	return nil
+}
+
+// Thread is a discussion anchored to a range of a note. For a suggestion, it
+// also has the content that can replace the anchored range.
+type Thread struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+	NoteID string `json:"note_id"`
+	Kind   string `json:"kind"`
+	State  string `json:"state"`
+	// Suggestion is the prosemirror slice for the suggested content. It can
+	// be empty to suggest a deletion.
+	Suggestion map[string]interface{} `json:"suggestion,omitempty"`
+	Comments   []Comment              `json:"comments"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+
+	// Anchor is not persisted with the thread, but with the content of the
+	// note, as it changes with the steps.
+	Anchor *Anchor `json:"anchor,omitempty"`
+}
+
+// ID returns the thread qualified identifier
+func (t *Thread) ID() string { return t.DocID }
+
+// Rev returns the thread revision
+func (t *Thread) Rev() string { return t.DocRev }
+
+// DocType returns the document type
+func (t *Thread) DocType() string { return consts.NotesComments }
+
+// Clone implements couchdb.Doc
+func (t *Thread) Clone() couchdb.Doc {
+	cloned := *t
+	cloned.Comments = make([]Comment, len(t.Comments))
+	copy(cloned.Comments, t.Comments)
+	if t.Anchor != nil {
+		tmp := *t.Anchor
+		cloned.Anchor = &tmp
+	}
+	// XXX The suggestion is not modified after the creation of the thread,
+	// and it is not cloned.
+	return &cloned
+}
+
+// SetID changes the thread qualified identifier
+func (t *Thread) SetID(id string) { t.DocID = id }
+
+// SetRev changes the thread revision
+func (t *Thread) SetRev(rev string) { t.DocRev = rev }
+
+// Included is part of the jsonapi.Object interface
+func (t *Thread) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (t *Thread) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (t *Thread) Relationships() jsonapi.RelationshipMap { return nil }
+
+func (t *Thread) save(inst *instance.Instance) error {
+	t.UpdatedAt = time.Now()
+	doc := t.Clone().(*Thread)
+	doc.Anchor = nil
+	var err error
+	if doc.DocID == "" {
+		err = couchdb.CreateDoc(inst, doc)
+	} else {
+		err = couchdb.UpdateDoc(inst, doc)
+	}
+	if err != nil {
+		return err
+	}
+	t.DocID = doc.DocID
+	t.DocRev = doc.DocRev
+	return nil
+}
+
+func (t *Thread) setAnchor(doc *Document) {
+	t.Anchor = nil
+	if a, ok := doc.Anchors[t.ID()]; ok {
+		t.Anchor = &a
+	}
+}
+
+// suggestionStep returns the step that replaces the anchored range by the
+// suggested content.
+func (t *Thread) suggestionStep(anchor Anchor) Step {
+	step := Step{
+		"stepType": "replace",
+		"from":     anchor.From,
+		"to":       anchor.To,
+	}
+	if len(t.Suggestion) > 0 {
+		step["slice"] = t.Suggestion
+	}
+	return step
+}
+
+// ListThreads returns the threads of comments and suggestions on a note, with
+// their anchors for the last version of the note.
+func ListThreads(inst *instance.Instance, file *vfs.FileDoc) ([]*Thread, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+
+	var threads []*Thread
+	req := &couchdb.FindRequest{
+		UseIndex: "by-note-id",
+		Selector: mango.Equal("note_id", file.ID()),
+		Sort: mango.SortBy{
+			{Field: "note_id", Direction: mango.Asc},
+			{Field: "created_at", Direction: mango.Asc},
+		},
+		Limit: maxThreadsListed,
+	}
+	if err := couchdb.FindDocs(inst, consts.NotesComments, req, &threads); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Thread{}, nil
+		}
+		return nil, err
+	}
+	for _, t := range threads {
+		t.setAnchor(doc)
+	}
+	return threads, nil
+}
+
+// CreateThread adds a thread of comments, or a suggestion, on a note. The
+// anchor of the thread is given for the version of the note known by the
+// client, and it is mapped to the last version of the note.
+func CreateThread(inst *instance.Instance, file *vfs.FileDoc, t *Thread, version int64, sessionID string) error {
+	if t.Anchor == nil {
+		return ErrInvalidAnchor
+	}
+	switch t.Kind {
+	case CommentThread:
+		t.Suggestion = nil
+		if len(t.Comments) == 0 {
+			return ErrEmptyComment
+		}
+	case SuggestionThread:
+	default:
+		return ErrInvalidThreadKind
+	}
+	for i := range t.Comments {
+		if err := t.Comments[i].prepare(); err != nil {
+			return err
+		}
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return err
+	}
+	anchor, err := anchorForLastVersion(inst, doc, *t.Anchor, version)
+	if err != nil {
+		return err
+	}
+	if t.Kind == CommentThread && anchor.From == anchor.To {
+		return ErrInvalidAnchor
+	}
+	if t.Kind == SuggestionThread {
+		if err := checkSuggestion(doc, t.suggestionStep(anchor)); err != nil {
+			return err
+		}
+	}
+
+	t.DocID = ""
+	t.DocRev = ""
+	t.NoteID = file.ID()
+	t.State = ThreadOpen
+	t.CreatedAt = time.Now()
+	if err := t.save(inst); err != nil {
+		return err
+	}
+	if doc.Anchors == nil {
+		doc.Anchors = make(map[string]Anchor)
+	}
+	doc.Anchors[t.ID()] = anchor
+	t.setAnchor(doc)
+	if err := saveToCache(inst, doc); err != nil {
+		return err
+	}
+	publishThread(inst, t, sessionID)
+	return nil
+}
+
+// anchorForLastVersion maps an anchor from the given version of the note to
+// its last version, by using the steps applied since this version.
+func anchorForLastVersion(inst *instance.Instance, doc *Document, anchor Anchor, version int64) (Anchor, error) {
+	if anchor.From < 0 || anchor.To < anchor.From || version > doc.Version {
+		return anchor, ErrInvalidAnchor
+	}
+	if version < doc.Version {
+		steps, err := getSteps(inst, doc.ID(), version)
+		if err != nil {
+			return anchor, err
+		}
+		schema, err := doc.Schema()
+		if err != nil {
+			return anchor, err
+		}
+		for _, s := range steps {
+			step, err := transform.StepFromJSON(schema, s)
+			if err != nil {
+				return anchor, ErrInvalidSteps
+			}
+			anchor = anchor.mapThrough(step.GetMap())
+		}
+		if anchor.Orphan {
+			return anchor, ErrInvalidAnchor
+		}
+	}
+	content, err := doc.Content()
+	if err != nil {
+		return anchor, err
+	}
+	if anchor.To > content.Content.Size {
+		return anchor, ErrInvalidAnchor
+	}
+	return anchor, nil
+}
+
+// checkSuggestion checks that the step for a suggestion can be applied on the
+// last version of the note.
+func checkSuggestion(doc *Document, s Step) error {
+	schema, err := doc.Schema()
+	if err != nil {
+		return err
+	}
+	content, err := doc.Content()
+	if err != nil {
+		return err
+	}
+	step, err := transform.StepFromJSON(schema, s)
+	if err != nil {
+		return ErrInvalidSuggestion
+	}
+	if result := step.Apply(content); result.Failed != "" {
+		return ErrInvalidSuggestion
+	}
+	return nil
+}
+
+func getThread(inst *instance.Instance, noteID, threadID string) (*Thread, error) {
+	var t Thread
+	if err := couchdb.GetDoc(inst, consts.NotesComments, threadID, &t); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrNotFoundThread
+		}
+		return nil, err
+	}
+	if t.NoteID != noteID {
+		return nil, ErrNotFoundThread
+	}
+	return &t, nil
+}
+
+// updateThread loads the note and the thread, calls fn to modify them, and
+// then saves them and sends the thread to the realtime hub.
+func updateThread(
+	inst *instance.Instance,
+	file *vfs.FileDoc,
+	threadID, sessionID string,
+	fn func(doc *Document, t *Thread) error,
+) (*Thread, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	t, err := getThread(inst, file.ID(), threadID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(doc, t); err != nil {
+		return nil, err
+	}
+	if err := t.save(inst); err != nil {
+		return nil, err
+	}
+	if err := saveToCache(inst, doc); err != nil {
+		return nil, err
+	}
+	t.setAnchor(doc)
+	publishThread(inst, t, sessionID)
+	return t, nil
+}
+
+// AddComment adds a comment to an existing thread.
+func AddComment(inst *instance.Instance, file *vfs.FileDoc, threadID string, c Comment, sessionID string) (*Thread, error) {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	return updateThread(inst, file, threadID, sessionID, func(doc *Document, t *Thread) error {
+		if t.State == ThreadAccepted || t.State == ThreadRejected {
+			return ErrThreadClosed
+		}
+		t.Comments = append(t.Comments, c)
+		return nil
+	})
+}
+
+// ResolveThread marks a thread as resolved. Its anchor is kept, so that the
+// thread can be reopened later.
+func ResolveThread(inst *instance.Instance, file *vfs.FileDoc, threadID, sessionID string) (*Thread, error) {
+	return updateThread(inst, file, threadID, sessionID, func(doc *Document, t *Thread) error {
+		if t.State != ThreadOpen && t.State != ThreadResolved {
+			return ErrThreadClosed
+		}
+		t.State = ThreadResolved
+		return nil
+	})
+}
+
+// ReopenThread reopens a thread that was resolved.
+func ReopenThread(inst *instance.Instance, file *vfs.FileDoc, threadID, sessionID string) (*Thread, error) {
+	return updateThread(inst, file, threadID, sessionID, func(doc *Document, t *Thread) error {
+		if t.State != ThreadOpen && t.State != ThreadResolved {
+			return ErrThreadClosed
+		}
+		t.State = ThreadOpen
+		return nil
+	})
+}
+
+// AcceptSuggestion applies the suggested content on the note, as a new step
+// that is sent to the other editors like the steps of the clients.
+func AcceptSuggestion(inst *instance.Instance, file *vfs.FileDoc, threadID, sessionID string) (*Thread, error) {
+	return updateThread(inst, file, threadID, sessionID, func(doc *Document, t *Thread) error {
+		if t.Kind != SuggestionThread {
+			return ErrNotSuggestion
+		}
+		if t.State != ThreadOpen {
+			return ErrThreadClosed
+		}
+		anchor, ok := doc.Anchors[t.ID()]
+		if !ok || anchor.Orphan {
+			return ErrCannotApply
+		}
+		delete(doc.Anchors, t.ID())
+		// The step has no sessionID, as it doesn't come from the editor of a
+		// client, and must be applied by all of them.
+		if err := applySteps(inst, doc, []Step{t.suggestionStep(anchor)}); err != nil {
+			return err
+		}
+		t.State = ThreadAccepted
+		return nil
+	})
+}
+
+// RejectSuggestion closes a suggestion without applying it.
+func RejectSuggestion(inst *instance.Instance, file *vfs.FileDoc, threadID, sessionID string) (*Thread, error) {
+	return updateThread(inst, file, threadID, sessionID, func(doc *Document, t *Thread) error {
+		if t.Kind != SuggestionThread {
+			return ErrNotSuggestion
+		}
+		if t.State != ThreadOpen {
+			return ErrThreadClosed
+		}
+		delete(doc.Anchors, t.ID())
+		t.State = ThreadRejected
+		return nil
+	})
+}
+
+var _ jsonapi.Object = &Thread{}
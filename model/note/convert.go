@@ -0,0 +1,118 @@
+package note
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/russross/blackfriday"
+)
+
+const (
+	// MarkdownFormat is the format for importing and exporting a note as
+	// markdown.
+	MarkdownFormat = "markdown"
+	// HTMLFormat is the format for importing and exporting a note as HTML.
+	HTMLFormat = "html"
+	// DOCXFormat is the format for exporting a note as an Office Open XML
+	// document.
+	DOCXFormat = "docx"
+)
+
+// maxImportSize is the maximal size of a file that can be imported as a note.
+const maxImportSize = 10 << 20
+
+// Exported is a note converted to another format.
+type Exported struct {
+	Filename string
+	Mime     string
+	Content  []byte
+}
+
+// Export returns the last version of the note converted in the given format.
+func Export(inst *instance.Instance, file *vfs.FileDoc, format string) (*Exported, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	doc, err := get(inst, file)
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(titleToFilename(inst, doc.Title), ".cozy-note")
+	var exported *Exported
+	switch format {
+	case MarkdownFormat:
+		md, err := doc.Markdown()
+		if err != nil {
+			return nil, err
+		}
+		exported = &Exported{Filename: name + ".md", Mime: "text/markdown", Content: md}
+	case HTMLFormat:
+		html, err := doc.HTML()
+		if err != nil {
+			return nil, err
+		}
+		exported = &Exported{Filename: name + ".html", Mime: "text/html", Content: html}
+	case DOCXFormat:
+		docx, err := doc.DOCX()
+		if err != nil {
+			return nil, err
+		}
+		exported = &Exported{
+			Filename: name + ".docx",
+			Mime:     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			Content:  docx,
+		}
+	default:
+		return nil, ErrUnknownFormat
+	}
+	return exported, nil
+}
+
+// Import creates a note from a markdown or HTML content. The document must
+// have the schema and the title for the note. The content is converted with
+// the schema, and the elements that have no equivalent in it are dropped.
+func Import(inst *instance.Instance, doc *Document, format string, r io.Reader) (*vfs.FileDoc, error) {
+	schema, err := doc.Schema()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxImportSize {
+		return nil, vfs.ErrFileTooBig
+	}
+
+	var content *model.Node
+	switch format {
+	case MarkdownFormat:
+		html := blackfriday.MarkdownCommon(buf)
+		content, err = contentFromHTML(schema, bytes.NewReader(html))
+	case HTMLFormat:
+		content, err = contentFromHTML(schema, bytes.NewReader(buf))
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc.Version = 0
+	doc.Anchors = nil
+	return create(inst, doc, content)
+}
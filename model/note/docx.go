@@ -0,0 +1,289 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+const (
+	docxWordNS = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	docxRelsNS = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	docxPkgNS  = "http://schemas.openxmlformats.org/package/2006/relationships"
+
+	// docxBulletsNumID is the numbering used for all the bullet lists. The
+	// ordered lists have their own numbering, to restart the count.
+	docxBulletsNumID = 1
+)
+
+// docxWriter builds the main part of a DOCX document (word/document.xml),
+// and keeps track of the hyperlinks and lists that need to be declared in
+// the other parts.
+type docxWriter struct {
+	body  strings.Builder
+	links []string
+	lists []int // The start of the ordered lists
+}
+
+// docxContext is the context of a paragraph, given by its parent nodes.
+type docxContext struct {
+	style  string
+	numID  int
+	level  int
+	indent bool
+}
+
+// DOCX returns the content of the note as an Office Open XML document, that
+// can be opened with a word processor. The images are exported as links.
+func (d *Document) DOCX() ([]byte, error) {
+	content, err := d.Content()
+	if err != nil {
+		return nil, err
+	}
+	w := &docxWriter{}
+	w.blocks(content, docxContext{level: -1})
+	return w.archive(d.Title)
+}
+
+func (w *docxWriter) blocks(node *model.Node, ctx docxContext) {
+	node.ForEach(func(child *model.Node, _, _ int) {
+		w.block(child, ctx)
+	})
+}
+
+func (w *docxWriter) block(node *model.Node, ctx docxContext) {
+	switch node.Type.Name {
+	case "paragraph":
+		w.paragraph(node, ctx)
+	case "heading":
+		ctx.style = fmt.Sprintf("Heading%d", headingLevel(node))
+		w.paragraph(node, ctx)
+	case "blockquote":
+		ctx.style = "Quote"
+		w.blocks(node, ctx)
+	case "code_block":
+		ctx.style = "Code"
+		w.startParagraph(ctx)
+		for i, line := range strings.Split(node.TextContent(), "\n") {
+			if i > 0 {
+				w.body.WriteString("<w:r><w:br/></w:r>")
+			}
+			w.text(line, nil)
+		}
+		w.body.WriteString("</w:p>")
+	case "horizontal_rule":
+		w.body.WriteString(`<w:p><w:pPr><w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="auto"/></w:pBdr></w:pPr></w:p>`)
+	case "bullet_list", "ordered_list":
+		ctx.level++
+		ctx.numID = docxBulletsNumID
+		if node.Type.Name == "ordered_list" {
+			w.lists = append(w.lists, listOrder(node))
+			ctx.numID = docxBulletsNumID + len(w.lists)
+		}
+		w.blocks(node, ctx)
+	case "list_item":
+		// Only the first paragraph of an item has a bullet or a number
+		node.ForEach(func(child *model.Node, _, index int) {
+			itemCtx := ctx
+			itemCtx.indent = index > 0
+			w.block(child, itemCtx)
+		})
+	default:
+		if node.Type.InlineContent {
+			w.paragraph(node, ctx)
+		} else {
+			w.blocks(node, ctx)
+		}
+	}
+}
+
+func (w *docxWriter) startParagraph(ctx docxContext) {
+	w.body.WriteString("<w:p><w:pPr>")
+	if ctx.style != "" {
+		fmt.Fprintf(&w.body, `<w:pStyle w:val="%s"/>`, ctx.style)
+	}
+	if ctx.numID > 0 && !ctx.indent {
+		fmt.Fprintf(&w.body, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, ctx.level, ctx.numID)
+	} else if ctx.level >= 0 {
+		fmt.Fprintf(&w.body, `<w:ind w:left="%d"/>`, 720*(ctx.level+1))
+	}
+	w.body.WriteString("</w:pPr>")
+}
+
+func (w *docxWriter) paragraph(node *model.Node, ctx docxContext) {
+	w.startParagraph(ctx)
+	node.ForEach(func(child *model.Node, _, _ int) {
+		switch {
+		case child.IsText():
+			w.text(*child.Text, child.Marks)
+		case child.Type.Name == "hard_break":
+			w.body.WriteString("<w:r><w:br/></w:r>")
+		case child.Type.Name == "image":
+			src, _ := child.Attrs["src"].(string)
+			alt, _ := child.Attrs["alt"].(string)
+			if alt == "" {
+				alt = src
+			}
+			if src == "" {
+				w.text(alt, child.Marks)
+				return
+			}
+			link := &model.Mark{
+				Type:  &model.MarkType{Name: "link"},
+				Attrs: map[string]interface{}{"href": src},
+			}
+			w.text(alt, append([]*model.Mark{link}, child.Marks...))
+		default:
+			w.text(child.TextContent(), child.Marks)
+		}
+	})
+	w.body.WriteString("</w:p>")
+}
+
+func (w *docxWriter) text(text string, marks []*model.Mark) {
+	if text == "" {
+		return
+	}
+	var href string
+	var bold, italic, code bool
+	for _, mark := range marks {
+		switch mark.Type.Name {
+		case "link":
+			href, _ = mark.Attrs["href"].(string)
+		case "strong":
+			bold = true
+		case "em":
+			italic = true
+		case "code":
+			code = true
+		}
+	}
+
+	// The order of the properties is imposed by the OOXML schema
+	var props strings.Builder
+	if href != "" {
+		props.WriteString(`<w:rStyle w:val="Hyperlink"/>`)
+	}
+	if code {
+		props.WriteString(`<w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/>`)
+	}
+	if bold {
+		props.WriteString("<w:b/>")
+	}
+	if italic {
+		props.WriteString("<w:i/>")
+	}
+	run := fmt.Sprintf(`<w:r><w:rPr>%s</w:rPr><w:t xml:space="preserve">%s</w:t></w:r>`, props.String(), xmlEscape(text))
+	if href == "" {
+		w.body.WriteString(run)
+		return
+	}
+	w.links = append(w.links, href)
+	fmt.Fprintf(&w.body, `<w:hyperlink r:id="rIdLink%d">%s</w:hyperlink>`, len(w.links), run)
+}
+
+func (w *docxWriter) archive(title string) ([]byte, error) {
+	var rels strings.Builder
+	fmt.Fprintf(&rels, `<Relationships xmlns="%s">`, docxPkgNS)
+	fmt.Fprintf(&rels, `<Relationship Id="rIdStyles" Type="%s/styles" Target="styles.xml"/>`, docxRelsNS)
+	fmt.Fprintf(&rels, `<Relationship Id="rIdNumbering" Type="%s/numbering" Target="numbering.xml"/>`, docxRelsNS)
+	for i, href := range w.links {
+		fmt.Fprintf(&rels, `<Relationship Id="rIdLink%d" Type="%s/hyperlink" Target="%s" TargetMode="External"/>`,
+			i+1, docxRelsNS, xmlEscape(href))
+	}
+	rels.WriteString(`</Relationships>`)
+
+	var numbering strings.Builder
+	fmt.Fprintf(&numbering, `<w:numbering xmlns:w="%s">`, docxWordNS)
+	for abstractID, format := range []string{"bullet", "decimal"} {
+		fmt.Fprintf(&numbering, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, abstractID)
+		for level := 0; level < 9; level++ {
+			text := "•"
+			if format == "decimal" {
+				text = fmt.Sprintf("%%%d.", level+1)
+			}
+			fmt.Fprintf(&numbering, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`,
+				level, format, text, 720*(level+1))
+		}
+		numbering.WriteString(`</w:abstractNum>`)
+	}
+	fmt.Fprintf(&numbering, `<w:num w:numId="%d"><w:abstractNumId w:val="0"/></w:num>`, docxBulletsNumID)
+	for i, start := range w.lists {
+		fmt.Fprintf(&numbering, `<w:num w:numId="%d"><w:abstractNumId w:val="1"/><w:lvlOverride w:ilvl="0"><w:startOverride w:val="%d"/></w:lvlOverride></w:num>`,
+			docxBulletsNumID+i+1, start)
+	}
+	numbering.WriteString(`</w:numbering>`)
+
+	document := fmt.Sprintf(`<w:document xmlns:w="%s" xmlns:r="%s"><w:body>%s<w:sectPr/></w:body></w:document>`,
+		docxWordNS, docxRelsNS, w.body.String())
+
+	core := fmt.Sprintf(`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><dc:title>%s</dc:title><dcterms:created xsi:type="dcterms:W3CDTF">%s</dcterms:created></cp:coreProperties>`,
+		xmlEscape(title), time.Now().UTC().Format(time.RFC3339))
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxPackageRels},
+		{"docProps/core.xml", core},
+		{"word/_rels/document.xml.rels", rels.String()},
+		{"word/document.xml", document},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", numbering.String()},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write([]byte(xml.Header + part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+const docxContentTypes = `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+	`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+	`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>` +
+	`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+	`</Types>`
+
+const docxPackageRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+	`</Relationships>`
+
+const docxStyles = `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:pPr><w:spacing w:after="120"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="36"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="32"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="200"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:sz w:val="28"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading4"><w:name w:val="heading 4"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="200"/><w:outlineLvl w:val="3"/></w:pPr><w:rPr><w:b/><w:sz w:val="26"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading5"><w:name w:val="heading 5"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="4"/></w:pPr><w:rPr><w:b/><w:sz w:val="24"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading6"><w:name w:val="heading 6"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="5"/></w:pPr><w:rPr><w:b/><w:i/><w:sz w:val="24"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:pPr><w:ind w:left="720"/></w:pPr><w:rPr><w:i/><w:color w:val="555555"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:pPr><w:shd w:val="clear" w:color="auto" w:fill="F2F2F2"/></w:pPr><w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/></w:rPr></w:style>` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>` +
+	`</w:styles>`
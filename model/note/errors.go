@@ -20,4 +20,39 @@ var (
 	ErrTooOld = errors.New("The revision is too old")
 	// ErrMissingSessionID is used when a telepointer has no identifier.
 	ErrMissingSessionID = errors.New("The session id is missing")
+	// ErrUnknownFormat is used when a note is imported or exported in a
+	// format that is not supported.
+	ErrUnknownFormat = errors.New("Unknown format")
+	// ErrInvalidImport is used when the imported content cannot be converted
+	// to a note.
+	ErrInvalidImport = errors.New("The content cannot be converted to a note")
+	// ErrNotFoundThread is used when a thread of comments has not been found
+	// for a note.
+	ErrNotFoundThread = errors.New("The thread has not been found")
+	// ErrInvalidThreadKind is used when a thread is neither a comment nor a
+	// suggestion.
+	ErrInvalidThreadKind = errors.New("Invalid kind for the thread")
+	// ErrInvalidAnchor is used when the range for a thread is not valid for
+	// the note.
+	ErrInvalidAnchor = errors.New("Invalid range for the thread")
+	// ErrEmptyComment is used when a comment has no body.
+	ErrEmptyComment = errors.New("The comment is empty")
+	// ErrInvalidSuggestion is used when the suggested content cannot replace
+	// the anchored range.
+	ErrInvalidSuggestion = errors.New("Invalid suggestion")
+	// ErrNotSuggestion is used when trying to accept or reject a thread that
+	// is not a suggestion.
+	ErrNotSuggestion = errors.New("The thread is not a suggestion")
+	// ErrThreadClosed is used when trying to change a suggestion that has
+	// already been accepted or rejected.
+	ErrThreadClosed = errors.New("The thread is closed")
+	// ErrVersionNotFound is used when a version of a note cannot be rebuilt
+	// from the saved versions and the retained steps.
+	ErrVersionNotFound = errors.New("The version of the note has not been found")
+	// ErrNotFoundSnapshot is used when a snapshot has not been found for a
+	// note.
+	ErrNotFoundSnapshot = errors.New("The snapshot has not been found")
+	// ErrEmptySnapshotName is used when trying to create a snapshot without a
+	// name.
+	ErrEmptySnapshotName = errors.New("The name of the snapshot is missing")
 )
@@ -96,4 +96,23 @@ func publishSteps(inst *instance.Instance, fileID string, steps []Step) {
 	}
 }
 
+func publishThread(inst *instance.Instance, t *Thread, sessionID string) {
+	event := Event{
+		"doctype":   t.DocType(),
+		"sessionID": sessionID,
+		"thread_id": t.ID(),
+		"kind":      t.Kind,
+		"state":     t.State,
+		"comments":  t.Comments,
+	}
+	if t.Anchor != nil {
+		event["anchor"] = *t.Anchor
+	}
+	if len(t.Suggestion) > 0 {
+		event["suggestion"] = t.Suggestion
+	}
+	event.SetID(t.NoteID)
+	event.publish(inst)
+}
+
 var _ jsonapi.Object = &Event{}
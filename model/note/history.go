@@ -0,0 +1,611 @@
+package note
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/transform"
+)
+
+const (
+	// SnapshotEntry is the kind of the history entries for the snapshots.
+	SnapshotEntry = "snapshot"
+	// VersionEntry is the kind of the history entries for the versions of
+	// the note persisted in the VFS.
+	VersionEntry = "version"
+	// StepsEntry is the kind of the history entries for a group of
+	// consecutive steps sent by the same editor.
+	StepsEntry = "steps"
+)
+
+const (
+	// DiffEqual is used for the lines that are in both versions of a diff.
+	DiffEqual = "equal"
+	// DiffInsert is used for the lines that have been added.
+	DiffInsert = "insert"
+	// DiffDelete is used for the lines that have been removed.
+	DiffDelete = "delete"
+)
+
+// maxSnapshotsListed is the maximal number of snapshots that are returned for
+// a note.
+const maxSnapshotsListed = 1000
+
+// maxDiffCells is the maximal size of the table used to compute the longest
+// common subsequence of lines for a diff. Above it, the diff just says that
+// all the lines have been replaced.
+const maxDiffCells = 4 << 20
+
+// Snapshot is a named version of a note. Its content is kept until the
+// snapshot is deleted, even when the steps and the old versions of the file
+// have been cleaned.
+type Snapshot struct {
+	DocID      string                 `json:"_id,omitempty"`
+	DocRev     string                 `json:"_rev,omitempty"`
+	NoteID     string                 `json:"note_id"`
+	Name       string                 `json:"name"`
+	Version    int64                  `json:"version"`
+	Title      string                 `json:"title"`
+	SchemaSpec map[string]interface{} `json:"schema"`
+	RawContent map[string]interface{} `json:"content"`
+	CreatedBy  string                 `json:"created_by,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// ID returns the snapshot qualified identifier
+func (s *Snapshot) ID() string { return s.DocID }
+
+// Rev returns the snapshot revision
+func (s *Snapshot) Rev() string { return s.DocRev }
+
+// DocType returns the snapshot document type
+func (s *Snapshot) DocType() string { return consts.NotesSnapshots }
+
+// Clone implements couchdb.Doc
+func (s *Snapshot) Clone() couchdb.Doc {
+	cloned := *s
+	// XXX The schema and the content are supposed to be immutable and, as
+	// such, are not cloned.
+	return &cloned
+}
+
+// SetID changes the snapshot qualified identifier
+func (s *Snapshot) SetID(id string) { s.DocID = id }
+
+// SetRev changes the snapshot revision
+func (s *Snapshot) SetRev(rev string) { s.DocRev = rev }
+
+// Included is part of the jsonapi.Object interface
+func (s *Snapshot) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (s *Snapshot) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (s *Snapshot) Relationships() jsonapi.RelationshipMap { return nil }
+
+func (s *Snapshot) document(dirID string) *Document {
+	return &Document{
+		DocID:      s.NoteID,
+		DirID:      dirID,
+		Title:      s.Title,
+		Version:    s.Version,
+		SchemaSpec: s.SchemaSpec,
+		RawContent: s.RawContent,
+	}
+}
+
+// HistoryEntry is an item of the timeline of the changes on a note.
+type HistoryEntry struct {
+	Kind string `json:"kind"`
+	// ID is the identifier of the snapshot, or of the version of the file.
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Title string `json:"title,omitempty"`
+	// FromVersion is the first version of a group of steps.
+	FromVersion int64     `json:"from_version,omitempty"`
+	Version     int64     `json:"version"`
+	SessionID   string    `json:"sessionID,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Date        time.Time `json:"date"`
+}
+
+// DiffChunk is a list of consecutive lines of the markdown serialization of
+// a note that are equal, inserted, or deleted between two versions.
+type DiffChunk struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff is the list of changes between two versions of a note.
+type Diff struct {
+	From    int64       `json:"from"`
+	To      int64       `json:"to"`
+	Changes []DiffChunk `json:"changes"`
+}
+
+// ListSnapshots returns the snapshots of a note, the most recent first.
+func ListSnapshots(inst *instance.Instance, file *vfs.FileDoc) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	req := &couchdb.FindRequest{
+		UseIndex: "by-note-id",
+		Selector: mango.Equal("note_id", file.ID()),
+		Sort: mango.SortBy{
+			{Field: "note_id", Direction: mango.Desc},
+			{Field: "version", Direction: mango.Desc},
+		},
+		Limit: maxSnapshotsListed,
+	}
+	if err := couchdb.FindDocs(inst, consts.NotesSnapshots, req, &snapshots); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Snapshot{}, nil
+		}
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// CreateSnapshot saves the given version of a note with a name. If the
+// version is nil, the last version of the note is used.
+func CreateSnapshot(inst *instance.Instance, file *vfs.FileDoc, name string, version *int64, createdBy string) (*Snapshot, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrEmptySnapshotName
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil {
+		doc, err = documentAt(inst, file, doc, *version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Snapshot{
+		NoteID:     file.ID(),
+		Name:       name,
+		Version:    doc.Version,
+		Title:      doc.Title,
+		SchemaSpec: doc.SchemaSpec,
+		RawContent: doc.RawContent,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now(),
+	}
+	if err := couchdb.CreateDoc(inst, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteSnapshot removes a snapshot of a note.
+func DeleteSnapshot(inst *instance.Instance, file *vfs.FileDoc, snapshotID string) error {
+	var s Snapshot
+	if err := couchdb.GetDoc(inst, consts.NotesSnapshots, snapshotID, &s); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return ErrNotFoundSnapshot
+		}
+		return err
+	}
+	if s.NoteID != file.ID() {
+		return ErrNotFoundSnapshot
+	}
+	return couchdb.DeleteDoc(inst, &s)
+}
+
+// History returns the timeline of a note, the most recent entries first. It
+// is built from the snapshots, the versions of the file in the VFS, and the
+// steps that have not yet been purged.
+func History(inst *instance.Instance, file *vfs.FileDoc) ([]HistoryEntry, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	var entries []HistoryEntry
+
+	snapshots, err := ListSnapshots(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		entries = append(entries, HistoryEntry{
+			Kind:    SnapshotEntry,
+			ID:      s.ID(),
+			Name:    s.Name,
+			Title:   s.Title,
+			Version: s.Version,
+			Author:  s.CreatedBy,
+			Date:    s.CreatedAt,
+		})
+	}
+
+	versions, err := vfs.VersionsFor(inst, file.ID())
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	for _, v := range versions {
+		if entry, ok := versionEntry(v.ID(), v.Metadata, &v.CozyMetadata, v.UpdatedAt); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if entry, ok := versionEntry(file.ID(), file.Metadata, file.CozyMetadata, file.UpdatedAt); ok {
+		entries = append(entries, entry)
+	}
+
+	steps, err := getSteps(inst, file.ID(), 0)
+	if err != nil && err != ErrTooOld && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	entries = append(entries, stepsEntries(steps)...)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Version > entries[j].Version
+	})
+	return entries, nil
+}
+
+func versionEntry(id string, meta vfs.Metadata, fcm *vfs.FilesCozyMetadata, date time.Time) (HistoryEntry, bool) {
+	version, err := versionFromMetadata(meta)
+	if err != nil {
+		return HistoryEntry{}, false
+	}
+	title, _ := meta["title"].(string)
+	entry := HistoryEntry{
+		Kind:    VersionEntry,
+		ID:      id,
+		Title:   title,
+		Version: version,
+		Date:    date,
+	}
+	if fcm != nil && fcm.UploadedBy != nil {
+		entry.Author = fcm.UploadedBy.Slug
+	}
+	return entry, true
+}
+
+// stepsEntries groups the consecutive steps sent by the same editor.
+func stepsEntries(steps []Step) []HistoryEntry {
+	var entries []HistoryEntry
+	for _, s := range steps {
+		sessionID, _ := s["sessionID"].(string)
+		date := time.Unix(s.timestamp(), 0).UTC()
+		if n := len(entries); n > 0 && entries[n-1].SessionID == sessionID {
+			entries[n-1].Version = s.version()
+			entries[n-1].Date = date
+			continue
+		}
+		entries = append(entries, HistoryEntry{
+			Kind:        StepsEntry,
+			FromVersion: s.version(),
+			Version:     s.version(),
+			SessionID:   sessionID,
+			Date:        date,
+		})
+	}
+	return entries
+}
+
+// GetDiff returns the changes between two versions of a note, computed on
+// the lines of their markdown serializations.
+func GetDiff(inst *instance.Instance, file *vfs.FileDoc, from, to int64) (*Diff, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	last, err := get(inst, file)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	before, err := documentAt(inst, file, last, from)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	after, err := documentAt(inst, file, last, to)
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := before.Markdown()
+	if err != nil {
+		return nil, err
+	}
+	b, err := after.Markdown()
+	if err != nil {
+		return nil, err
+	}
+	return &Diff{
+		From:    from,
+		To:      to,
+		Changes: diffLines(splitLines(string(a)), splitLines(string(b))),
+	}, nil
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the changes between two lists of lines, with the
+// longest common subsequence.
+func diffLines(a, b []string) []DiffChunk {
+	var chunks []DiffChunk
+	add := func(op, line string) {
+		if n := len(chunks); n > 0 && chunks[n-1].Op == op {
+			chunks[n-1].Text += line
+			return
+		}
+		chunks = append(chunks, DiffChunk{Op: op, Text: line})
+	}
+
+	// The common prefix and suffix are removed before computing the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, line := range a[:prefix] {
+		add(DiffEqual, line)
+	}
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+
+	if (len(midA)+1)*(len(midB)+1) > maxDiffCells {
+		for _, line := range midA {
+			add(DiffDelete, line)
+		}
+		for _, line := range midB {
+			add(DiffInsert, line)
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// midA[i:] and midB[j:]
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(midA) && j < len(midB) {
+			switch {
+			case midA[i] == midB[j]:
+				add(DiffEqual, midA[i])
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				add(DiffDelete, midA[i])
+				i++
+			default:
+				add(DiffInsert, midB[j])
+				j++
+			}
+		}
+		for ; i < len(midA); i++ {
+			add(DiffDelete, midA[i])
+		}
+		for ; j < len(midB); j++ {
+			add(DiffInsert, midB[j])
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		add(DiffEqual, line)
+	}
+	if chunks == nil {
+		chunks = []DiffChunk{}
+	}
+	return chunks
+}
+
+// Restore brings back a past version of a note. It is done by applying new
+// steps on the last version, so that the editors that are connected to the
+// realtime can rebase on them and keep their session.
+func Restore(inst *instance.Instance, file *vfs.FileDoc, version int64, sessionID string) (*vfs.FileDoc, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	old, err := documentAt(inst, file, doc, version)
+	if err != nil {
+		return nil, err
+	}
+
+	step, err := restoreStep(doc, old)
+	if err != nil {
+		return nil, err
+	}
+	if step != nil {
+		// The step has no sessionID, as it doesn't come from an editor: all
+		// the editors must apply it.
+		if err := applySteps(inst, doc, []Step{step}); err != nil {
+			return nil, err
+		}
+	}
+
+	if doc.Title != old.Title {
+		doc.Title = old.Title
+		if err := saveToCache(inst, doc); err != nil {
+			return nil, err
+		}
+		publishUpdatedTitle(inst, file.ID(), doc.Title, sessionID)
+	}
+	return doc.asFile(inst, file), nil
+}
+
+// restoreStep returns a step that transforms the content of the document
+// into the content of the old document, or nil if they have the same
+// content. The step only replaces the range that has changed when possible.
+func restoreStep(doc, old *Document) (Step, error) {
+	schema, err := doc.Schema()
+	if err != nil {
+		return nil, err
+	}
+	current, err := doc.Content()
+	if err != nil {
+		return nil, err
+	}
+	// The old content is read with the schema of the current document, as
+	// prosemirror compares the node types by identity.
+	past, err := model.NodeFromJSON(schema, old.RawContent)
+	if err != nil {
+		return nil, ErrInvalidFile
+	}
+
+	start := current.Content.FindDiffStart(past.Content)
+	if start == nil {
+		return nil, nil
+	}
+	end := current.Content.FindDiffEnd(past.Content)
+	endA, endB := current.Content.Size, past.Content.Size
+	if end != nil {
+		endA, endB = end.A, end.B
+	}
+	// Same adjustment as prosemirror when the changed ranges overlap, for
+	// example with a repeated character.
+	if overlap := *start - min(endA, endB); overlap > 0 {
+		endA += overlap
+		endB += overlap
+	}
+
+	candidates := []Step{
+		replaceStep(*start, endA, past.Slice(*start, endB).ToJSON()),
+		replaceStep(0, current.Content.Size, past.Slice(0, past.Content.Size).ToJSON()),
+	}
+	for _, candidate := range candidates {
+		step, err := transform.StepFromJSON(schema, candidate)
+		if err != nil {
+			continue
+		}
+		result := step.Apply(current)
+		if result.Failed == "" && result.Doc.Eq(past) {
+			return candidate, nil
+		}
+	}
+	return nil, ErrCannotApply
+}
+
+func replaceStep(from, to int, slice interface{}) Step {
+	step := Step{
+		"stepType": "replace",
+		"from":     from,
+		"to":       to,
+	}
+	if slice != nil {
+		step["slice"] = slice
+	}
+	return step
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// documentAt must be called with the notes lock already acquired. It returns
+// the note as it was for the given version. The last document is used if the
+// version is the current one. Else, the note is rebuilt from a snapshot or a
+// version of the file, and the steps that have been retained since it.
+func documentAt(inst *instance.Instance, file *vfs.FileDoc, last *Document, version int64) (*Document, error) {
+	if version == last.Version {
+		return last, nil
+	}
+	if version < 0 || version > last.Version {
+		return nil, ErrVersionNotFound
+	}
+
+	var bases []*Document
+	if doc, err := fromMetadata(file); err == nil {
+		bases = append(bases, doc)
+	}
+	if versions, err := vfs.VersionsFor(inst, file.ID()); err == nil {
+		for _, v := range versions {
+			if doc, err := documentFromMetadata(file.ID(), file.DirID, v.Metadata); err == nil {
+				bases = append(bases, doc)
+			}
+		}
+	}
+	if snapshots, err := ListSnapshots(inst, file); err == nil {
+		for _, s := range snapshots {
+			bases = append(bases, s.document(file.DirID))
+		}
+	}
+	sort.SliceStable(bases, func(i, j int) bool {
+		return bases[i].Version > bases[j].Version
+	})
+
+	for _, base := range bases {
+		if base.Version > version {
+			continue
+		}
+		if base.Version == version {
+			return base, nil
+		}
+		steps, err := getSteps(inst, file.ID(), base.Version)
+		if err != nil {
+			continue
+		}
+		var toApply []Step
+		for _, s := range steps {
+			if v := s.version(); v > base.Version && v <= version {
+				toApply = append(toApply, s)
+			}
+		}
+		if int64(len(toApply)) != version-base.Version {
+			continue
+		}
+		if err := apply(inst, base, toApply); err != nil {
+			continue
+		}
+		return base, nil
+	}
+	return nil, ErrVersionNotFound
+}
+
+var _ jsonapi.Object = &Snapshot{}
@@ -0,0 +1,491 @@
+package note
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/cozy/prosemirror-go/model"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTML returns a standalone HTML page with the content of the note.
+func (d *Document) HTML() ([]byte, error) {
+	content, err := d.Content()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", html.EscapeString(d.Title))
+	buf.WriteString("</head>\n<body>\n")
+	serializeHTML(&buf, content)
+	buf.WriteString("</body>\n</html>\n")
+	return buf.Bytes(), nil
+}
+
+// serializeHTML writes the HTML for a prosemirror node. The node types and
+// marks are the ones of the basic schema, like for the markdown serializer.
+func serializeHTML(w *bytes.Buffer, node *model.Node) {
+	switch node.Type.Name {
+	case "doc":
+		serializeHTMLChildren(w, node)
+	case "paragraph":
+		w.WriteString("<p>")
+		serializeHTMLInline(w, node)
+		w.WriteString("</p>\n")
+	case "heading":
+		level := headingLevel(node)
+		fmt.Fprintf(w, "<h%d>", level)
+		serializeHTMLInline(w, node)
+		fmt.Fprintf(w, "</h%d>\n", level)
+	case "blockquote":
+		w.WriteString("<blockquote>\n")
+		serializeHTMLChildren(w, node)
+		w.WriteString("</blockquote>\n")
+	case "horizontal_rule":
+		w.WriteString("<hr>\n")
+	case "code_block":
+		w.WriteString("<pre><code>")
+		w.WriteString(html.EscapeString(node.TextContent()))
+		w.WriteString("</code></pre>\n")
+	case "bullet_list":
+		w.WriteString("<ul>\n")
+		serializeHTMLChildren(w, node)
+		w.WriteString("</ul>\n")
+	case "ordered_list":
+		if order := listOrder(node); order != 1 {
+			fmt.Fprintf(w, "<ol start=\"%d\">\n", order)
+		} else {
+			w.WriteString("<ol>\n")
+		}
+		serializeHTMLChildren(w, node)
+		w.WriteString("</ol>\n")
+	case "list_item":
+		w.WriteString("<li>")
+		serializeHTMLChildren(w, node)
+		w.WriteString("</li>\n")
+	default:
+		if node.Type.InlineContent {
+			w.WriteString("<p>")
+			serializeHTMLInline(w, node)
+			w.WriteString("</p>\n")
+		} else if !node.IsLeaf() {
+			w.WriteString("<div>\n")
+			serializeHTMLChildren(w, node)
+			w.WriteString("</div>\n")
+		}
+	}
+}
+
+func serializeHTMLChildren(w *bytes.Buffer, node *model.Node) {
+	node.ForEach(func(child *model.Node, _, _ int) {
+		serializeHTML(w, child)
+	})
+}
+
+// serializeHTMLInline writes the inline content of a text block. The marks
+// are opened and closed only when they change between two nodes.
+func serializeHTMLInline(w *bytes.Buffer, node *model.Node) {
+	var open []*model.Mark
+	closeMarks := func(keep int) {
+		for i := len(open) - 1; i >= keep; i-- {
+			w.WriteString(markTags(open[i], false))
+		}
+		open = open[:keep]
+	}
+	node.ForEach(func(child *model.Node, _, _ int) {
+		keep := 0
+		for keep < len(open) && keep < len(child.Marks) && open[keep].Eq(child.Marks[keep]) {
+			keep++
+		}
+		closeMarks(keep)
+		for _, mark := range child.Marks[keep:] {
+			w.WriteString(markTags(mark, true))
+			open = append(open, mark)
+		}
+		switch {
+		case child.IsText():
+			w.WriteString(html.EscapeString(*child.Text))
+		case child.Type.Name == "hard_break":
+			w.WriteString("<br>")
+		case child.Type.Name == "image":
+			src, _ := child.Attrs["src"].(string)
+			alt, _ := child.Attrs["alt"].(string)
+			fmt.Fprintf(w, "<img src=\"%s\" alt=\"%s\"", html.EscapeString(src), html.EscapeString(alt))
+			if title, _ := child.Attrs["title"].(string); title != "" {
+				fmt.Fprintf(w, " title=\"%s\"", html.EscapeString(title))
+			}
+			w.WriteString(">")
+		default:
+			w.WriteString(html.EscapeString(child.TextContent()))
+		}
+	})
+	closeMarks(0)
+}
+
+func markTags(mark *model.Mark, opening bool) string {
+	var tag string
+	switch mark.Type.Name {
+	case "em":
+		tag = "em"
+	case "strong":
+		tag = "strong"
+	case "code":
+		tag = "code"
+	case "link":
+		if !opening {
+			return "</a>"
+		}
+		href, _ := mark.Attrs["href"].(string)
+		attrs := fmt.Sprintf(" href=\"%s\"", html.EscapeString(href))
+		if title, _ := mark.Attrs["title"].(string); title != "" {
+			attrs += fmt.Sprintf(" title=\"%s\"", html.EscapeString(title))
+		}
+		return "<a" + attrs + ">"
+	default:
+		tag = "span"
+	}
+	if opening {
+		return "<" + tag + ">"
+	}
+	return "</" + tag + ">"
+}
+
+func headingLevel(node *model.Node) int {
+	level := 1
+	switch l := node.Attrs["level"].(type) {
+	case float64:
+		level = int(l)
+	case int:
+		level = l
+	}
+	if level < 1 || level > 6 {
+		level = 1
+	}
+	return level
+}
+
+func listOrder(node *model.Node) int {
+	switch o := node.Attrs["order"].(type) {
+	case float64:
+		return int(o)
+	case int:
+		return o
+	}
+	return 1
+}
+
+// htmlParser converts an HTML document to a prosemirror node for a schema.
+// The elements that have no equivalent in the schema are unwrapped.
+type htmlParser struct {
+	schema *model.Schema
+	marks  []*model.Mark
+}
+
+func contentFromHTML(schema *model.Schema, r io.Reader) (*model.Node, error) {
+	root, err := nethtml.Parse(r)
+	if err != nil {
+		return nil, ErrInvalidImport
+	}
+	p := &htmlParser{schema: schema}
+	blocks := p.blocks(root)
+	typ, err := schema.NodeType(schema.Spec.TopNode)
+	if err != nil {
+		return nil, ErrInvalidSchema
+	}
+	doc, err := typ.CreateAndFill(nil, blocks)
+	if err != nil || doc == nil {
+		return nil, ErrInvalidImport
+	}
+	return doc, nil
+}
+
+func isBlockElement(n *nethtml.Node) bool {
+	switch n.DataAtom {
+	case atom.P, atom.Div, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Blockquote, atom.Pre, atom.Hr, atom.Ul, atom.Ol, atom.Li,
+		atom.Html, atom.Body, atom.Section, atom.Article, atom.Header,
+		atom.Footer, atom.Main, atom.Nav, atom.Aside, atom.Figure,
+		atom.Table, atom.Thead, atom.Tbody, atom.Tr, atom.Td, atom.Th,
+		atom.Dl, atom.Dt, atom.Dd:
+		return true
+	}
+	return false
+}
+
+func isIgnoredElement(n *nethtml.Node) bool {
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Template, atom.Noscript:
+		return true
+	}
+	return false
+}
+
+// blocks returns the block nodes for the children of an HTML node. The
+// inline content between the block elements is wrapped in paragraphs.
+func (p *htmlParser) blocks(n *nethtml.Node) []*model.Node {
+	var blocks, inline []*model.Node
+	flush := func() {
+		if len(inline) > 0 {
+			if para := p.create("paragraph", nil, trimInline(inline)); para != nil {
+				blocks = append(blocks, para)
+			}
+			inline = nil
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case nethtml.DocumentNode:
+			blocks = append(blocks, p.blocks(c)...)
+		case nethtml.TextNode:
+			if len(inline) == 0 && strings.TrimSpace(c.Data) == "" {
+				continue
+			}
+			inline = append(inline, p.inline(c)...)
+		case nethtml.ElementNode:
+			if isIgnoredElement(c) {
+				continue
+			}
+			if isBlockElement(c) {
+				flush()
+				blocks = append(blocks, p.block(c)...)
+			} else {
+				inline = append(inline, p.inline(c)...)
+			}
+		}
+	}
+	flush()
+	return blocks
+}
+
+func (p *htmlParser) block(n *nethtml.Node) []*model.Node {
+	var node *model.Node
+	switch n.DataAtom {
+	case atom.P:
+		node = p.create("paragraph", nil, trimInline(p.inlineChildren(n)))
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		content := trimInline(p.inlineChildren(n))
+		node = p.create("heading", map[string]interface{}{"level": level}, content)
+		if node == nil {
+			node = p.create("paragraph", nil, content)
+		}
+	case atom.Blockquote:
+		node = p.create("blockquote", nil, p.blocks(n))
+	case atom.Pre:
+		text := strings.TrimSuffix(textContent(n), "\n")
+		var content []*model.Node
+		if text != "" {
+			content = append(content, p.schema.Text(text))
+		}
+		node = p.create("code_block", nil, content)
+		if node == nil && text != "" {
+			node = p.create("paragraph", nil, content)
+		}
+	case atom.Hr:
+		node = p.create("horizontal_rule", nil, nil)
+		if node == nil {
+			return nil
+		}
+	case atom.Ul, atom.Ol:
+		name := "bullet_list"
+		var attrs map[string]interface{}
+		if n.DataAtom == atom.Ol {
+			name = "ordered_list"
+			attrs = map[string]interface{}{"order": 1}
+			for _, a := range n.Attr {
+				var start int
+				if a.Key == "start" {
+					if _, err := fmt.Sscanf(a.Val, "%d", &start); err == nil {
+						attrs["order"] = start
+					}
+				}
+			}
+		}
+		var items []*model.Node
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != nethtml.ElementNode || c.DataAtom != atom.Li {
+				continue
+			}
+			if item := p.create("list_item", nil, p.blocks(c)); item != nil {
+				items = append(items, item)
+			}
+		}
+		node = p.create(name, attrs, items)
+	}
+	if node == nil {
+		// No equivalent in the schema: keep the content of the element
+		return p.blocks(n)
+	}
+	return []*model.Node{node}
+}
+
+func (p *htmlParser) inlineChildren(n *nethtml.Node) []*model.Node {
+	var nodes []*model.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nodes = append(nodes, p.inline(c)...)
+	}
+	return nodes
+}
+
+func (p *htmlParser) inline(n *nethtml.Node) []*model.Node {
+	switch n.Type {
+	case nethtml.TextNode:
+		text := collapseWhitespace(n.Data)
+		if text == "" {
+			return nil
+		}
+		return []*model.Node{p.schema.Text(text, p.marks)}
+	case nethtml.ElementNode:
+	default:
+		return nil
+	}
+	if isIgnoredElement(n) {
+		return nil
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		if node := p.create("hard_break", nil, nil); node != nil {
+			return []*model.Node{node}
+		}
+		return []*model.Node{p.schema.Text(" ", p.marks)}
+	case atom.Img:
+		attrs := map[string]interface{}{}
+		for _, a := range n.Attr {
+			switch a.Key {
+			case "src", "alt", "title":
+				attrs[a.Key] = a.Val
+			}
+		}
+		if node := p.create("image", attrs, nil); node != nil {
+			return []*model.Node{node}
+		}
+		return nil
+	}
+
+	var mark *model.Mark
+	switch n.DataAtom {
+	case atom.Em, atom.I:
+		mark = p.mark("em", nil)
+	case atom.Strong, atom.B:
+		mark = p.mark("strong", nil)
+	case atom.Code:
+		mark = p.mark("code", nil)
+	case atom.A:
+		attrs := map[string]interface{}{}
+		for _, a := range n.Attr {
+			switch a.Key {
+			case "href", "title":
+				attrs[a.Key] = a.Val
+			}
+		}
+		if attrs["href"] != nil {
+			mark = p.mark("link", attrs)
+		}
+	}
+	if mark == nil {
+		return p.inlineChildren(n)
+	}
+	saved := p.marks
+	p.marks = mark.AddToSet(p.marks)
+	nodes := p.inlineChildren(n)
+	p.marks = saved
+	return nodes
+}
+
+func (p *htmlParser) mark(name string, attrs map[string]interface{}) *model.Mark {
+	typ, err := p.schema.MarkType(name)
+	if err != nil {
+		return nil
+	}
+	return typ.Create(fillAttrs(typ.Attrs, attrs))
+}
+
+// fillAttrs adds a nil value for the attributes without a default value that
+// are missing, as prosemirror requires a value for them.
+func fillAttrs(defs map[string]*model.Attribute, attrs map[string]interface{}) map[string]interface{} {
+	filled := make(map[string]interface{}, len(defs))
+	for name, def := range defs {
+		if value, ok := attrs[name]; ok {
+			filled[name] = value
+		} else if !def.HasDefault {
+			filled[name] = nil
+		}
+	}
+	return filled
+}
+
+// create returns a node of the given type, or nil if the schema has no node
+// type with this name, or if the content is not allowed for this type.
+func (p *htmlParser) create(name string, attrs map[string]interface{}, content []*model.Node) *model.Node {
+	typ, err := p.schema.NodeType(name)
+	if err != nil {
+		return nil
+	}
+	attrs = fillAttrs(typ.Attrs, attrs)
+	if node, err := typ.CreateChecked(attrs, content); err == nil {
+		return node
+	}
+	node, err := typ.CreateAndFill(attrs, content)
+	if err != nil {
+		return nil
+	}
+	return node
+}
+
+func textContent(n *nethtml.Node) string {
+	if n.Type == nethtml.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+func collapseWhitespace(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		if text == "" {
+			return ""
+		}
+		return " "
+	}
+	collapsed := strings.Join(fields, " ")
+	if strings.TrimLeft(text[:1], " \t\r\n") == "" {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(text[len(text)-1:], " \t\r\n") == "" {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+// trimInline removes the whitespaces at the start and at the end of the
+// inline content of a text block.
+func trimInline(nodes []*model.Node) []*model.Node {
+	for len(nodes) > 0 && nodes[0].IsText() {
+		text := strings.TrimLeft(*nodes[0].Text, " ")
+		if text != "" {
+			nodes[0] = nodes[0].WithText(text)
+			break
+		}
+		nodes = nodes[1:]
+	}
+	for len(nodes) > 0 && nodes[len(nodes)-1].IsText() {
+		last := len(nodes) - 1
+		text := strings.TrimRight(*nodes[last].Text, " ")
+		if text != "" {
+			nodes[last] = nodes[last].WithText(text)
+			break
+		}
+		nodes = nodes[:last]
+	}
+	return nodes
+}
@@ -37,6 +37,10 @@ type Document struct {
 	Version    int64                  `json:"version"`
 	SchemaSpec map[string]interface{} `json:"schema"`
 	RawContent map[string]interface{} `json:"content"`
+	// Anchors are the positions of the threads of comments, by thread id.
+	// They are kept with the content, as they are remapped when the steps
+	// are applied.
+	Anchors map[string]Anchor `json:"anchors,omitempty"`
 
 	// Use cache for some computed properties
 	schema   *model.Schema
@@ -56,6 +60,12 @@ func (d *Document) DocType() string { return consts.NotesDocuments }
 // Clone implements couchdb.Doc
 func (d *Document) Clone() couchdb.Doc {
 	cloned := *d
+	if d.Anchors != nil {
+		cloned.Anchors = make(map[string]Anchor, len(d.Anchors))
+		for k, v := range d.Anchors {
+			cloned.Anchors[k] = v
+		}
+	}
 	// XXX The schema and the content are supposed to be immutable and, as
 	// such, are not cloned.
 	return &cloned
@@ -69,12 +79,16 @@ func (d *Document) SetRev(rev string) { d.DocRev = rev }
 
 // Metadata returns the file metadata for this note.
 func (d *Document) Metadata() map[string]interface{} {
-	return map[string]interface{}{
+	meta := map[string]interface{}{
 		"title":   d.Title,
 		"content": d.RawContent,
 		"version": d.Version,
 		"schema":  d.SchemaSpec,
 	}
+	if len(d.Anchors) > 0 {
+		meta["anchors"] = d.Anchors
+	}
+	return meta
 }
 
 // Schema returns the prosemirror schema for this note
@@ -181,12 +195,18 @@ func Create(inst *instance.Instance, doc *Document) (*vfs.FileDoc, error) {
 	defer lock.Unlock()
 
 	doc.Version = 0
+	doc.Anchors = nil
 	content, err := initialContent(inst, doc)
 	if err != nil {
 		return nil, err
 	}
-	doc.SetContent(content)
+	return create(inst, doc, content)
+}
 
+// create must be called with the notes lock already acquired. It writes the
+// file for a new note with the given content.
+func create(inst *instance.Instance, doc *Document, content *model.Node) (*vfs.FileDoc, error) {
+	doc.SetContent(content)
 	file, err := writeFile(inst, doc, nil)
 	if err != nil {
 		return nil, err
@@ -391,7 +411,7 @@ func get(inst *instance.Instance, file *vfs.FileDoc) (*Document, error) {
 	if doc := getFromCache(inst, file.ID()); doc != nil {
 		return doc, nil
 	}
-	version, _ := versionFromMetadata(file)
+	version, _ := versionFromMetadata(file.Metadata)
 	steps, err := getSteps(inst, file.ID(), version)
 	if err != nil && err != ErrTooOld && !couchdb.IsNoDatabaseError(err) {
 		return nil, err
@@ -413,8 +433,8 @@ func get(inst *instance.Instance, file *vfs.FileDoc) (*Document, error) {
 	return doc, nil
 }
 
-func versionFromMetadata(file *vfs.FileDoc) (int64, error) {
-	switch v := file.Metadata["version"].(type) {
+func versionFromMetadata(meta vfs.Metadata) (int64, error) {
+	switch v := meta["version"].(type) {
 	case float64:
 		return int64(v), nil
 	case int64:
@@ -424,26 +444,33 @@ func versionFromMetadata(file *vfs.FileDoc) (int64, error) {
 }
 
 func fromMetadata(file *vfs.FileDoc) (*Document, error) {
-	version, err := versionFromMetadata(file)
+	return documentFromMetadata(file.ID(), file.DirID, file.Metadata)
+}
+
+// documentFromMetadata builds a note from the metadata of its file, or of a
+// previous version of its file.
+func documentFromMetadata(id, dirID string, meta vfs.Metadata) (*Document, error) {
+	version, err := versionFromMetadata(meta)
 	if err != nil {
 		return nil, err
 	}
-	title, _ := file.Metadata["title"].(string)
-	schema, ok := file.Metadata["schema"].(map[string]interface{})
+	title, _ := meta["title"].(string)
+	schema, ok := meta["schema"].(map[string]interface{})
 	if !ok {
 		return nil, ErrInvalidFile
 	}
-	content, ok := file.Metadata["content"].(map[string]interface{})
+	content, ok := meta["content"].(map[string]interface{})
 	if !ok {
 		return nil, ErrInvalidFile
 	}
 	return &Document{
-		DocID:      file.ID(),
-		DirID:      file.DirID,
+		DocID:      id,
+		DirID:      dirID,
 		Title:      title,
 		Version:    version,
 		SchemaSpec: schema,
 		RawContent: content,
+		Anchors:    anchorsFromMetadata(meta),
 	}, nil
 }
 
@@ -521,7 +548,8 @@ func Update(inst *instance.Instance, fileID string) error {
 		return err
 	}
 
-	if doc.Title == old.Metadata["title"] && doc.Version == old.Metadata["version"] {
+	if doc.Title == old.Metadata["title"] && doc.Version == old.Metadata["version"] &&
+		sameAnchors(doc.Anchors, anchorsFromMetadata(old.Metadata)) {
 		// Nothing to do
 		return nil
 	}
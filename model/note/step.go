@@ -76,6 +76,16 @@ func (s Step) timestamp() int64 {
 	return 0
 }
 
+func (s Step) version() int64 {
+	switch v := s["version"].(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
+
 func stepID(noteID string, version int64) string {
 	return fmt.Sprintf("%s/%08d", noteID, version)
 }
@@ -140,18 +150,23 @@ func ApplySteps(inst *instance.Instance, file *vfs.FileDoc, lastVersion string,
 		return nil, ErrCannotApply
 	}
 
-	if err := apply(inst, doc, steps); err != nil {
+	if err := applySteps(inst, doc, steps); err != nil {
 		return nil, err
 	}
-	if err := saveSteps(inst, steps); err != nil {
-		return nil, err
-	}
-	publishSteps(inst, file.ID(), steps)
+	return doc.asFile(inst, file), nil
+}
 
-	if err := saveToCache(inst, doc); err != nil {
-		return nil, err
+// applySteps must be called with the notes lock already acquired. It applies
+// the steps on the document, saves them, and sends them to the realtime hub.
+func applySteps(inst *instance.Instance, doc *Document, steps []Step) error {
+	if err := apply(inst, doc, steps); err != nil {
+		return err
 	}
-	return doc.asFile(inst, file), nil
+	if err := saveSteps(inst, steps); err != nil {
+		return err
+	}
+	publishSteps(inst, doc.ID(), steps)
+	return saveToCache(inst, doc)
 }
 
 func apply(inst *instance.Instance, doc *Document, steps []Step) error {
@@ -184,6 +199,7 @@ func apply(inst *instance.Instance, doc *Document, steps []Step) error {
 			return ErrCannotApply
 		}
 		content = result.Doc
+		doc.mapAnchors(step.GetMap())
 		doc.Version++
 		steps[i].SetID(stepID(doc.ID(), doc.Version))
 		steps[i]["version"] = doc.Version
@@ -25,6 +25,12 @@ const (
 	// NotificationDiskQuota category for sending alert when reaching 90% of disk
 	// usage quota.
 	NotificationDiskQuota = "disk-quota"
+	// NotificationSharingConflict category for warning the user that a
+	// conflict has happened on a file of a sharing.
+	NotificationSharingConflict = "sharing-conflict"
+	// NotificationSharingExpiry category for reminding the user that a sharing,
+	// or the access of a recipient, will expire soon.
+	NotificationSharingExpiry = "sharing-expiry"
 )
 
 var (
@@ -36,6 +42,18 @@ var (
 			MailTemplate: "notifications_diskquota",
 			MinInterval:  7 * 24 * time.Hour,
 		},
+		NotificationSharingConflict: {
+			Description:  "Warn about the conflicts on the files of a sharing",
+			Collapsible:  true,
+			Stateful:     true,
+			MailTemplate: "notifications_sharing_conflict",
+			MinInterval:  time.Hour,
+		},
+		NotificationSharingExpiry: {
+			Description:  "Remind that a sharing will expire soon",
+			Stateful:     true,
+			MailTemplate: "notifications_sharing_expiry",
+		},
 	}
 )
 
@@ -57,11 +75,13 @@ func init() {
 				"CozyDriveLink": cozyDriveLink.String(),
 			},
 		}
-		_ = pushStack(domain, NotificationDiskQuota, n)
+		_ = PushStack(domain, NotificationDiskQuota, n)
 	})
 }
 
-func pushStack(domain string, category string, n *notification.Notification) error {
+// PushStack creates and sends a new notification for one of the categories
+// defined by the stack itself.
+func PushStack(domain string, category string, n *notification.Notification) error {
 	inst, err := lifecycle.GetInstance(domain)
 	if err != nil {
 		return err
@@ -157,7 +177,7 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 					Debugf("Notification %v was not sent (collapsed by same state %s)", p, n.State)
 				return nil
 			}
-			if p.MinInterval > 0 && time.Until(last.LastSent) <= p.MinInterval {
+			if p.MinInterval > 0 && time.Since(last.LastSent) <= p.MinInterval {
 				skipNotification = true
 			}
 		}
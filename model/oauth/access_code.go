@@ -1,21 +1,48 @@
 package oauth
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"regexp"
+
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/crypto"
 )
 
+// The methods that can be used by a client to derive the code challenge from
+// the code verifier, for PKCE (RFC 7636).
+const (
+	// PKCEMethodPlain is the method where the code challenge is the code
+	// verifier. It is the default method.
+	PKCEMethodPlain = "plain"
+	// PKCEMethodS256 is the method where the code challenge is the SHA-256 of
+	// the code verifier, encoded in base64url.
+	PKCEMethodS256 = "S256"
+)
+
+// ErrInvalidCodeChallenge is used when the code challenge or its method are
+// not valid.
+var ErrInvalidCodeChallenge = errors.New("Invalid code_challenge")
+
+// A code verifier (and a plain code challenge) is a string of 43 to 128
+// unreserved characters.
+var codeVerifierRegexp = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
 // AccessCode is struct used during the OAuth2 flow. It has to be persisted in
 // CouchDB, not just sent as a JSON Web Token, because it can be used only
 // once (no replay attacks).
 type AccessCode struct {
-	Code     string `json:"_id,omitempty"`
-	CouchRev string `json:"_rev,omitempty"`
-	ClientID string `json:"client_id"`
-	IssuedAt int64  `json:"issued_at"`
-	Scope    string `json:"scope"`
+	Code            string `json:"_id,omitempty"`
+	CouchRev        string `json:"_rev,omitempty"`
+	ClientID        string `json:"client_id"`
+	IssuedAt        int64  `json:"issued_at"`
+	Scope           string `json:"scope"`
+	Challenge       string `json:"code_challenge,omitempty"`
+	ChallengeMethod string `json:"code_challenge_method,omitempty"`
 }
 
 // ID returns the access code qualified identifier
@@ -36,19 +63,69 @@ func (ac *AccessCode) SetID(id string) { ac.Code = id }
 // SetRev changes the access code revision
 func (ac *AccessCode) SetRev(rev string) { ac.CouchRev = rev }
 
-// CreateAccessCode an access code for the given clientID, persisted in CouchDB
-func CreateAccessCode(i *instance.Instance, clientID, scope string) (*AccessCode, error) {
+// CreateAccessCode an access code for the given clientID, persisted in CouchDB.
+// The code challenge is optional, and must have been checked with
+// CheckCodeChallenge.
+func CreateAccessCode(i *instance.Instance, clientID, scope, challenge, challengeMethod string) (*AccessCode, error) {
 	ac := &AccessCode{
 		ClientID: clientID,
 		IssuedAt: crypto.Timestamp(),
 		Scope:    scope,
 	}
+	if challenge != "" {
+		ac.Challenge = challenge
+		ac.ChallengeMethod = challengeMethod
+	}
 	if err := couchdb.CreateDoc(i, ac); err != nil {
 		return nil, err
 	}
 	return ac, nil
 }
 
+// CheckCodeChallenge checks the code challenge sent by a client for PKCE, and
+// returns the method to derive it from the code verifier.
+func CheckCodeChallenge(challenge, method string) (string, error) {
+	if challenge == "" {
+		if method != "" {
+			return "", ErrInvalidCodeChallenge
+		}
+		return "", nil
+	}
+	switch method {
+	case "", PKCEMethodPlain:
+		if !codeVerifierRegexp.MatchString(challenge) {
+			return "", ErrInvalidCodeChallenge
+		}
+		return PKCEMethodPlain, nil
+	case PKCEMethodS256:
+		// The SHA-256 is 32 bytes, ie 43 characters in base64url
+		if len(challenge) != 43 || !codeVerifierRegexp.MatchString(challenge) {
+			return "", ErrInvalidCodeChallenge
+		}
+		return PKCEMethodS256, nil
+	default:
+		return "", ErrInvalidCodeChallenge
+	}
+}
+
+// ValidateCodeVerifier returns true if the code verifier sent with the access
+// code matches the code challenge sent when the code was created. If the
+// client has not used PKCE, no code verifier is expected.
+func (ac *AccessCode) ValidateCodeVerifier(verifier string) bool {
+	if ac.Challenge == "" {
+		return verifier == ""
+	}
+	if !codeVerifierRegexp.MatchString(verifier) {
+		return false
+	}
+	expected := verifier
+	if ac.ChallengeMethod == PKCEMethodS256 {
+		sum := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(ac.Challenge)) == 1
+}
+
 var (
 	_ couchdb.Doc = &AccessCode{}
 )
@@ -1,7 +1,10 @@
 package oauth_test
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -171,6 +174,63 @@ func TestParseHttpSoftwareID(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestCodeChallenge(t *testing.T) {
+	verifier := strings.Repeat("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", 2)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	_, err := oauth.CheckCodeChallenge("", oauth.PKCEMethodS256)
+	assert.Equal(t, oauth.ErrInvalidCodeChallenge, err)
+	_, err = oauth.CheckCodeChallenge(challenge, "S512")
+	assert.Equal(t, oauth.ErrInvalidCodeChallenge, err)
+	_, err = oauth.CheckCodeChallenge("too-short", "")
+	assert.Equal(t, oauth.ErrInvalidCodeChallenge, err)
+	method, err := oauth.CheckCodeChallenge(verifier, "")
+	assert.NoError(t, err)
+	assert.Equal(t, oauth.PKCEMethodPlain, method)
+
+	ac := &oauth.AccessCode{Challenge: challenge, ChallengeMethod: oauth.PKCEMethodS256}
+	assert.True(t, ac.ValidateCodeVerifier(verifier))
+	assert.False(t, ac.ValidateCodeVerifier(""))
+	assert.False(t, ac.ValidateCodeVerifier(challenge))
+
+	ac = &oauth.AccessCode{Challenge: verifier, ChallengeMethod: oauth.PKCEMethodPlain}
+	assert.True(t, ac.ValidateCodeVerifier(verifier))
+	assert.False(t, ac.ValidateCodeVerifier(challenge))
+
+	ac = &oauth.AccessCode{}
+	assert.True(t, ac.ValidateCodeVerifier(""))
+	assert.False(t, ac.ValidateCodeVerifier(verifier))
+}
+
+func TestDeviceCode(t *testing.T) {
+	dc, err := oauth.CreateDeviceCode(testInstance, "my-client-id", "io.cozy.files:GET")
+	assert.NoError(t, err)
+	assert.Equal(t, oauth.DeviceCodePending, dc.State)
+	assert.Len(t, dc.FormattedUserCode(), 9)
+
+	typed := strings.ToLower(dc.FormattedUserCode())
+	found, err := oauth.FindDeviceCodeByUserCode(testInstance, typed)
+	assert.NoError(t, err)
+	assert.Equal(t, dc.Code, found.Code)
+	_, err = oauth.FindDeviceCodeByUserCode(testInstance, "BCDF")
+	assert.Equal(t, oauth.ErrDeviceCodeNotFound, err)
+
+	assert.NoError(t, found.Poll(testInstance))
+	assert.Equal(t, oauth.ErrSlowDown, found.Poll(testInstance))
+
+	assert.NoError(t, found.Answer(testInstance, true))
+	found, err = oauth.FindDeviceCode(testInstance, dc.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, oauth.DeviceCodeApproved, found.State)
+	assert.False(t, found.Expired())
+
+	// The device code can be consumed only once
+	other := found.Clone().(*oauth.DeviceCode)
+	assert.NoError(t, found.Consume(testInstance))
+	assert.Equal(t, oauth.ErrDeviceCodeNotFound, other.Consume(testInstance))
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	setup := testutils.NewSetup(m, "oauth_client")
@@ -0,0 +1,226 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+)
+
+// DeviceCodeGrantType is the grant type used by a client to exchange a device
+// code for an access token (RFC 8628).
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceCodeTTL is the duration of validity of a device code.
+const DeviceCodeTTL = 10 * time.Minute
+
+// DeviceCodeInterval is the minimal duration a client must wait between two
+// requests for exchanging a device code.
+const DeviceCodeInterval = 5 * time.Second
+
+// The states of a device code
+const (
+	// DeviceCodePending is when the user has not yet answered
+	DeviceCodePending = "pending"
+	// DeviceCodeApproved is when the user has given the permissions to the
+	// client
+	DeviceCodeApproved = "approved"
+	// DeviceCodeDenied is when the user has refused to give the permissions
+	// to the client
+	DeviceCodeDenied = "denied"
+)
+
+// userCodeAlphabet is the set of characters for the user codes. There are no
+// vowels to avoid forming words, and the letters are easy to type on a TV.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLen is the number of characters of a user code, without the dash.
+const userCodeLen = 8
+
+var (
+	// ErrDeviceCodeNotFound is used when the device code or the user code is
+	// not known (or has expired)
+	ErrDeviceCodeNotFound = errors.New("Device code not found")
+	// ErrSlowDown is used when a client asks for the access token of a
+	// device code too frequently
+	ErrSlowDown = errors.New("The client is polling too frequently")
+)
+
+// DeviceCode is used for the device authorization grant (RFC 8628). The
+// client gets a device code to poll the token endpoint, and a short user code
+// that the user types on another device where they are logged in, to give the
+// permissions to the client.
+type DeviceCode struct {
+	Code         string    `json:"_id,omitempty"`
+	CouchRev     string    `json:"_rev,omitempty"`
+	ClientID     string    `json:"client_id"`
+	Scope        string    `json:"scope"`
+	UserCode     string    `json:"user_code"`
+	State        string    `json:"state"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	LastPolledAt time.Time `json:"last_polled_at,omitempty"`
+}
+
+// ID returns the device code qualified identifier
+func (dc *DeviceCode) ID() string { return dc.Code }
+
+// Rev returns the device code revision
+func (dc *DeviceCode) Rev() string { return dc.CouchRev }
+
+// DocType returns the device code document type
+func (dc *DeviceCode) DocType() string { return consts.OAuthDeviceCodes }
+
+// Clone implements couchdb.Doc
+func (dc *DeviceCode) Clone() couchdb.Doc { cloned := *dc; return &cloned }
+
+// SetID changes the device code qualified identifier
+func (dc *DeviceCode) SetID(id string) { dc.Code = id }
+
+// SetRev changes the device code revision
+func (dc *DeviceCode) SetRev(rev string) { dc.CouchRev = rev }
+
+// Expired returns true if the device code can no longer be used.
+func (dc *DeviceCode) Expired() bool {
+	return time.Now().After(dc.ExpiresAt)
+}
+
+// FormattedUserCode returns the user code as it should be displayed to the
+// user, ie with a dash in the middle.
+func (dc *DeviceCode) FormattedUserCode() string {
+	return dc.UserCode[:userCodeLen/2] + "-" + dc.UserCode[userCodeLen/2:]
+}
+
+// Poll is called when the client asks for the access token of the device
+// code. It returns ErrSlowDown if the client has not waited long enough since
+// its last request.
+func (dc *DeviceCode) Poll(i *instance.Instance) error {
+	now := time.Now().UTC()
+	tooFast := now.Sub(dc.LastPolledAt) < DeviceCodeInterval
+	dc.LastPolledAt = now
+	if err := couchdb.UpdateDoc(i, dc); err != nil {
+		return err
+	}
+	if tooFast {
+		return ErrSlowDown
+	}
+	return nil
+}
+
+// Answer saves the choice of the user for giving or not the permissions to
+// the client.
+func (dc *DeviceCode) Answer(i *instance.Instance, approved bool) error {
+	dc.State = DeviceCodeDenied
+	if approved {
+		dc.State = DeviceCodeApproved
+	}
+	return couchdb.UpdateDoc(i, dc)
+}
+
+// Consume deletes the device code, so that it can't be used again. It returns
+// ErrDeviceCodeNotFound if it has already been consumed by another request.
+func (dc *DeviceCode) Consume(i *instance.Instance) error {
+	err := couchdb.DeleteDoc(i, dc)
+	if couchdb.IsConflictError(err) || couchdb.IsNotFoundError(err) {
+		return ErrDeviceCodeNotFound
+	}
+	return err
+}
+
+// CreateDeviceCode creates a device code for the given client, with a new
+// user code, and persists it in CouchDB.
+func CreateDeviceCode(i *instance.Instance, clientID, scope string) (*DeviceCode, error) {
+	var userCode string
+	for {
+		code, err := generateUserCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := FindDeviceCodeByUserCode(i, code); err == ErrDeviceCodeNotFound {
+			userCode = code
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	dc := &DeviceCode{
+		ClientID:  clientID,
+		Scope:     scope,
+		UserCode:  userCode,
+		State:     DeviceCodePending,
+		ExpiresAt: time.Now().UTC().Add(DeviceCodeTTL),
+	}
+	if err := couchdb.CreateDoc(i, dc); err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+// FindDeviceCode returns the device code with the given identifier. The
+// caller must check if it has expired.
+func FindDeviceCode(i *instance.Instance, code string) (*DeviceCode, error) {
+	dc := &DeviceCode{}
+	if err := couchdb.GetDoc(i, consts.OAuthDeviceCodes, code, dc); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrDeviceCodeNotFound
+		}
+		return nil, err
+	}
+	return dc, nil
+}
+
+// FindDeviceCodeByUserCode returns the device code for the user code typed by
+// the user, if it has not expired.
+func FindDeviceCodeByUserCode(i *instance.Instance, userCode string) (*DeviceCode, error) {
+	userCode = NormalizeUserCode(userCode)
+	if len(userCode) != userCodeLen {
+		return nil, ErrDeviceCodeNotFound
+	}
+	var codes []*DeviceCode
+	req := &couchdb.FindRequest{
+		UseIndex: "by-user-code",
+		Selector: mango.Equal("user_code", userCode),
+		Limit:    10,
+	}
+	if err := couchdb.FindDocs(i, consts.OAuthDeviceCodes, req, &codes); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, ErrDeviceCodeNotFound
+		}
+		return nil, err
+	}
+	for _, dc := range codes {
+		if !dc.Expired() {
+			return dc, nil
+		}
+	}
+	return nil, ErrDeviceCodeNotFound
+}
+
+// NormalizeUserCode removes the dashes and spaces from a user code typed by
+// the user, and puts it in upper case.
+func NormalizeUserCode(userCode string) string {
+	userCode = strings.NewReplacer("-", "", " ", "").Replace(userCode)
+	return strings.ToUpper(userCode)
+}
+
+func generateUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, userCodeLen)
+	for k := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[k] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+var (
+	_ couchdb.Doc = &DeviceCode{}
+)
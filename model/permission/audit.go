@@ -0,0 +1,391 @@
+package permission
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/gofrs/uuid"
+)
+
+// The actions recorded in the audit trail of the permissions
+const (
+	// AuditCreate is the action when a permission document is created
+	AuditCreate = "create"
+	// AuditRevoke is the action when a permission document is revoked
+	AuditRevoke = "revoke"
+	// AuditAccess is the action when a permission is used to access a
+	// resource, and the decision that has been made
+	AuditAccess = "access"
+)
+
+// DefaultAuditRetentionDays is the number of days the entries of the audit
+// trail are kept when no retention has been configured.
+const DefaultAuditRetentionDays = 90
+
+// MaxAuditRetentionDays is the maximal number of days the entries of the
+// audit trail can be kept.
+const MaxAuditRetentionDays = 3650
+
+// MaxAuditEntriesListed is the maximal number of entries returned in a page
+// of the export of the audit trail.
+const MaxAuditEntriesListed = 1000
+
+// AuditSettings are the settings of the audit trail of the permissions for an
+// instance. The audit trail is opt-in.
+type AuditSettings struct {
+	Enabled       bool   `json:"enabled"`
+	RetentionDays int    `json:"retention_days,omitempty"`
+	TriggerID     string `json:"trigger_id,omitempty"`
+}
+
+// Retention returns how long the entries of the audit trail are kept.
+func (s *AuditSettings) Retention() time.Duration {
+	days := DefaultAuditRetentionDays
+	if s != nil && s.RetentionDays > 0 {
+		days = s.RetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Validate checks that the settings are correct.
+func (s *AuditSettings) Validate() error {
+	if s.RetentionDays < 0 || s.RetentionDays > MaxAuditRetentionDays {
+		return ErrInvalidAuditSettings
+	}
+	return nil
+}
+
+// Auditable is implemented by the databases that can have an audit trail of
+// the permissions, ie the instances.
+type Auditable interface {
+	prefixer.Prefixer
+	PermissionsAuditEnabled() bool
+}
+
+func auditEnabled(db prefixer.Prefixer) bool {
+	a, ok := db.(Auditable)
+	return ok && a.PermissionsAuditEnabled()
+}
+
+// AuditEntry is an entry of the audit trail of the permissions. It is keyed
+// by the permission identifier and by its source: the app, the OAuth client,
+// or the sharing for a share code (with the name of the code).
+type AuditEntry struct {
+	EID            string    `json:"_id,omitempty"`
+	ERev           string    `json:"_rev,omitempty"`
+	Action         string    `json:"action"`
+	PermissionID   string    `json:"permission_id,omitempty"`
+	PermissionType string    `json:"permission_type,omitempty"`
+	SourceID       string    `json:"source_id,omitempty"`
+	Code           string    `json:"code,omitempty"`
+	Verb           string    `json:"verb,omitempty"`
+	ResourceType   string    `json:"resource_type,omitempty"`
+	ResourceID     string    `json:"resource_id,omitempty"`
+	Allowed        *bool     `json:"allowed,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ID returns the entry qualified identifier
+func (e *AuditEntry) ID() string { return e.EID }
+
+// Rev returns the entry revision
+func (e *AuditEntry) Rev() string { return e.ERev }
+
+// DocType returns the entry document type
+func (e *AuditEntry) DocType() string { return consts.PermissionsAudit }
+
+// SetID changes the entry qualified identifier
+func (e *AuditEntry) SetID(id string) { e.EID = id }
+
+// SetRev changes the entry revision
+func (e *AuditEntry) SetRev(rev string) { e.ERev = rev }
+
+// Clone implements couchdb.Doc
+func (e *AuditEntry) Clone() couchdb.Doc {
+	cloned := *e
+	if e.Allowed != nil {
+		allowed := *e.Allowed
+		cloned.Allowed = &allowed
+	}
+	return &cloned
+}
+
+// Included is part of the jsonapi.Object interface
+func (e *AuditEntry) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (e *AuditEntry) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (e *AuditEntry) Links() *jsonapi.LinksList { return nil }
+
+var _ jsonapi.Object = (*AuditEntry)(nil)
+
+func newAuditEntry(action string, p *Permission) *AuditEntry {
+	return &AuditEntry{
+		Action:         action,
+		PermissionID:   p.PID,
+		PermissionType: p.Type,
+		SourceID:       p.SourceID,
+		CreatedAt:      time.Now().UTC(),
+	}
+}
+
+// auditFlushInterval is the maximal delay before the entries of the audit
+// trail kept in memory are written to CouchDB.
+const auditFlushInterval = 5 * time.Second
+
+// auditBatchSize is the number of entries for an instance that triggers a
+// write to CouchDB without waiting for the flush interval.
+const auditBatchSize = 100
+
+// auditQueue is the list of the entries of the audit trail for a database
+// that have not yet been persisted.
+type auditQueue struct {
+	db      prefixer.Prefixer
+	entries []*AuditEntry
+}
+
+var (
+	auditQueues     map[string]*auditQueue
+	auditQueuesLock sync.Mutex
+)
+
+// recordAudit adds the entry to the queue of the database if the audit trail
+// is enabled for it. The entries are written by batches, to avoid a request
+// to CouchDB each time a permission is checked.
+func recordAudit(db prefixer.Prefixer, e *AuditEntry) {
+	if !auditEnabled(db) {
+		return
+	}
+	key := db.DBPrefix()
+	auditQueuesLock.Lock()
+	if auditQueues == nil {
+		auditQueues = make(map[string]*auditQueue)
+	}
+	q, ok := auditQueues[key]
+	if !ok {
+		q = &auditQueue{db: db}
+		auditQueues[key] = q
+	}
+	q.entries = append(q.entries, e)
+	if len(q.entries) < auditBatchSize {
+		auditQueuesLock.Unlock()
+		return
+	}
+	delete(auditQueues, key)
+	auditQueuesLock.Unlock()
+	go q.flush()
+}
+
+// flush writes the entries of the queue in a single bulk request. The errors
+// are only logged, as the audit must not prevent the action to be done.
+func (q *auditQueue) flush() {
+	docs := make([]interface{}, len(q.entries))
+	olds := make([]interface{}, len(q.entries))
+	for i, e := range q.entries {
+		if e.EID == "" {
+			id, _ := uuid.NewV4()
+			e.EID = id.String()
+		}
+		docs[i] = e
+	}
+	err := couchdb.EnsureDBExist(q.db, consts.PermissionsAudit)
+	if err == nil {
+		err = couchdb.BulkUpdateDocs(q.db, consts.PermissionsAudit, docs, olds)
+	}
+	if err != nil {
+		logger.WithDomain(q.db.DomainName()).WithField("nspace", "permissions").
+			Warnf("Cannot record %d audit entries: %s", len(q.entries), err)
+	}
+}
+
+// FlushAuditEntries writes all the entries of the audit trail kept in memory.
+func FlushAuditEntries() {
+	auditQueuesLock.Lock()
+	queues := auditQueues
+	auditQueues = nil
+	auditQueuesLock.Unlock()
+	for _, q := range queues {
+		q.flush()
+	}
+}
+
+// StartAuditFlusher starts a process that periodically writes the entries of
+// the audit trail kept in memory. The remaining entries are written when the
+// process is shut down.
+func StartAuditFlusher() utils.Shutdowner {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(auditFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				FlushAuditEntries()
+			case <-closed:
+				FlushAuditEntries()
+				return
+			}
+		}
+	}()
+	return &auditFlusher{closed, done}
+}
+
+type auditFlusher struct {
+	closed chan struct{}
+	done   chan struct{}
+}
+
+func (f *auditFlusher) Shutdown(ctx context.Context) error {
+	select {
+	case f.closed <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// AuditCreation records the creation of a permission document.
+func AuditCreation(db prefixer.Prefixer, p *Permission) {
+	recordAudit(db, newAuditEntry(AuditCreate, p))
+}
+
+// AuditRevocation records the revocation of a permission document.
+func AuditRevocation(db prefixer.Prefixer, p *Permission) {
+	recordAudit(db, newAuditEntry(AuditRevoke, p))
+}
+
+// AuditAccessDecision records that the permission has been used to access a
+// resource, and if it was allowed. For a share by link, code is the name of
+// the code used by the request. The resource identifier can be empty for an
+// access to the whole doctype.
+func AuditAccessDecision(db prefixer.Prefixer, p *Permission, code string, v Verb, doctype, id string, allowed bool) {
+	e := newAuditEntry(AuditAccess, p)
+	e.Code = code
+	e.Verb = string(v)
+	e.ResourceType = doctype
+	e.ResourceID = id
+	e.Allowed = &allowed
+	recordAudit(db, e)
+}
+
+// AuditFilter is used to select the entries of the audit trail to export.
+type AuditFilter struct {
+	PermissionID string
+	SourceID     string
+	Since        *time.Time
+	Until        *time.Time
+}
+
+func (f *AuditFilter) request() *couchdb.FindRequest {
+	dates := mango.Exists("created_at")
+	if f.Since != nil {
+		dates = mango.And(dates, mango.Gte("created_at", f.Since.UTC()))
+	}
+	if f.Until != nil {
+		dates = mango.And(dates, mango.Lt("created_at", f.Until.UTC()))
+	}
+	switch {
+	case f.PermissionID != "":
+		selector := mango.And(mango.Equal("permission_id", f.PermissionID), dates)
+		if f.SourceID != "" {
+			selector = mango.And(selector, mango.Equal("source_id", f.SourceID))
+		}
+		return &couchdb.FindRequest{
+			UseIndex: "by-permission-id",
+			Selector: selector,
+			Sort: mango.SortBy{
+				{Field: "permission_id", Direction: mango.Asc},
+				{Field: "created_at", Direction: mango.Asc},
+			},
+		}
+	case f.SourceID != "":
+		return &couchdb.FindRequest{
+			UseIndex: "by-source-id",
+			Selector: mango.And(mango.Equal("source_id", f.SourceID), dates),
+			Sort: mango.SortBy{
+				{Field: "source_id", Direction: mango.Asc},
+				{Field: "created_at", Direction: mango.Asc},
+			},
+		}
+	default:
+		return &couchdb.FindRequest{
+			UseIndex: "by-created-at",
+			Selector: dates,
+			Sort: mango.SortBy{
+				{Field: "created_at", Direction: mango.Asc},
+			},
+		}
+	}
+}
+
+// ListAuditEntries returns a page of the entries of the audit trail that
+// match the filter, the oldest first, and the bookmark for the next page (or
+// an empty string if it was the last page).
+func ListAuditEntries(db prefixer.Prefixer, filter AuditFilter, bookmark string, limit int) ([]*AuditEntry, string, error) {
+	if limit <= 0 || limit > MaxAuditEntriesListed {
+		limit = MaxAuditEntriesListed
+	}
+	req := filter.request()
+	req.Bookmark = bookmark
+	req.Limit = limit
+	var entries []*AuditEntry
+	res, err := couchdb.FindDocsRaw(db, consts.PermissionsAudit, req, &entries)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*AuditEntry{}, "", nil
+		}
+		return nil, "", err
+	}
+	next := ""
+	if len(entries) == limit {
+		next = res.Bookmark
+	}
+	return entries, next, nil
+}
+
+// PurgeAuditEntries deletes the entries of the audit trail that have been
+// created before the given date, and returns how many entries have been
+// deleted.
+func PurgeAuditEntries(db prefixer.Prefixer, before time.Time) (int, error) {
+	filter := AuditFilter{Until: &before}
+	deleted := 0
+	for {
+		req := filter.request()
+		req.Limit = MaxAuditEntriesListed
+		var entries []*AuditEntry
+		if err := couchdb.FindDocs(db, consts.PermissionsAudit, req, &entries); err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return deleted, nil
+			}
+			return deleted, err
+		}
+		docs := make([]couchdb.Doc, len(entries))
+		for i, e := range entries {
+			docs[i] = e
+		}
+		if err := couchdb.BulkDeleteDocs(db, consts.PermissionsAudit, docs); err != nil {
+			return deleted, err
+		}
+		deleted += len(entries)
+		if len(entries) < MaxAuditEntriesListed {
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package permission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditableDB struct {
+	prefixer.Prefixer
+	enabled bool
+}
+
+func (db auditableDB) PermissionsAuditEnabled() bool { return db.enabled }
+
+func TestAuditSettings(t *testing.T) {
+	var nilSettings *AuditSettings
+	assert.Equal(t, 90*24*time.Hour, nilSettings.Retention())
+	settings := &AuditSettings{Enabled: true}
+	assert.Equal(t, 90*24*time.Hour, settings.Retention())
+	settings.RetentionDays = 7
+	assert.Equal(t, 7*24*time.Hour, settings.Retention())
+	assert.NoError(t, settings.Validate())
+	settings.RetentionDays = -1
+	assert.Equal(t, ErrInvalidAuditSettings, settings.Validate())
+	settings.RetentionDays = MaxAuditRetentionDays + 1
+	assert.Equal(t, ErrInvalidAuditSettings, settings.Validate())
+}
+
+func TestAuditEnabled(t *testing.T) {
+	db := prefixer.NewPrefixer("alice.cozy.example", "alice")
+	assert.False(t, auditEnabled(db))
+	assert.False(t, auditEnabled(auditableDB{db, false}))
+	assert.True(t, auditEnabled(auditableDB{db, true}))
+}
+
+func TestRecordAuditQueue(t *testing.T) {
+	db := prefixer.NewPrefixer("bob.cozy.example", "bob")
+	p := &Permission{PID: "perm1", Type: TypeShareByLink}
+	AuditCreation(auditableDB{db, false}, p)
+	AuditCreation(auditableDB{db, true}, p)
+	AuditRevocation(auditableDB{db, true}, p)
+
+	auditQueuesLock.Lock()
+	q := auditQueues[db.DBPrefix()]
+	auditQueuesLock.Unlock()
+	if assert.NotNil(t, q) && assert.Len(t, q.entries, 2) {
+		assert.Equal(t, AuditCreate, q.entries[0].Action)
+		assert.Equal(t, AuditRevoke, q.entries[1].Action)
+	}
+
+	auditQueuesLock.Lock()
+	delete(auditQueues, db.DBPrefix())
+	auditQueuesLock.Unlock()
+}
+
+func TestAuditEntry(t *testing.T) {
+	p := &Permission{PID: "perm1", Type: TypeShareByLink, SourceID: "io.cozy.apps/drive"}
+	e := newAuditEntry(AuditAccess, p)
+	assert.Equal(t, "perm1", e.PermissionID)
+	assert.Equal(t, TypeShareByLink, e.PermissionType)
+	assert.Equal(t, "io.cozy.apps/drive", e.SourceID)
+	assert.False(t, e.CreatedAt.IsZero())
+
+	allowed := true
+	e.Allowed = &allowed
+	cloned := e.Clone().(*AuditEntry)
+	*cloned.Allowed = false
+	assert.True(t, *e.Allowed)
+}
+
+func TestAuditFilterRequest(t *testing.T) {
+	since := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	req := (&AuditFilter{}).request()
+	assert.Equal(t, "by-created-at", req.UseIndex)
+	req = (&AuditFilter{SourceID: "io.cozy.apps/drive", Since: &since}).request()
+	assert.Equal(t, "by-source-id", req.UseIndex)
+	req = (&AuditFilter{PermissionID: "perm1", SourceID: "io.cozy.apps/drive"}).request()
+	assert.Equal(t, "by-permission-id", req.UseIndex)
+	assert.Equal(t, "permission_id", req.Sort[0].Field)
+}
@@ -18,9 +18,12 @@ var blackList = map[string]bool{
 	consts.Intents:          none,
 	consts.OAuthClients:     none,
 	consts.OAuthAccessCodes: none,
+	consts.OAuthDeviceCodes: none,
 	consts.Archives:         none,
 	consts.Sharings:         none,
 	consts.Shared:           none,
+	consts.FilesBlobs:       none,
+	consts.PermissionsAudit: none,
 
 	// TODO: uncomment to restric jobs permissions (make these none instead of
 	// readable).
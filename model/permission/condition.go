@@ -0,0 +1,390 @@
+package permission
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const conditionSep = ";"
+
+// The types of conditions on a rule
+const (
+	// ConditionField is a comparison between a field of the document and a
+	// value
+	ConditionField = "field"
+	// ConditionCreator checks the app that has created the document, from its
+	// cozyMetadata
+	ConditionCreator = "creator"
+	// ConditionTime restricts the rule to a time window
+	ConditionTime = "time"
+)
+
+// CreatorField is the field of the documents used for the creator conditions
+const CreatorField = "cozyMetadata.createdByApp"
+
+var conditionOps = map[string]string{
+	"eq":  "=",
+	"ne":  "!=",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+}
+
+var weekDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Condition is an optional restriction on a rule. All the conditions of a
+// rule must be satisfied for the rule to apply.
+type Condition struct {
+	Type string `json:"type"`
+
+	// For the field conditions, and the slug of the app for the creator
+	// conditions (empty means the app of the permission)
+	Field string `json:"field,omitempty"`
+	Op    string `json:"op,omitempty"`
+	Value string `json:"value,omitempty"`
+
+	// For the time conditions: hours as HH:MM, days as mon,tue,... and a
+	// timezone name (UTC by default)
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+	Days     []string `json:"days,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler on Condition. It accepts numbers
+// for the value, and validates the condition.
+func (c *Condition) UnmarshalJSON(b []byte) error {
+	type alias Condition
+	var raw struct {
+		alias
+		Value interface{} `json:"value,omitempty"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*c = Condition(raw.alias)
+	switch v := raw.Value.(type) {
+	case nil:
+		c.Value = ""
+	case string:
+		c.Value = v
+	case float64:
+		c.Value = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		c.Value = strconv.FormatBool(v)
+	default:
+		return ErrBadScope
+	}
+	return c.Validate()
+}
+
+// Validate checks that the condition is well-formed
+func (c Condition) Validate() error {
+	switch c.Type {
+	case ConditionField:
+		if c.Field == "" {
+			return ErrBadScope
+		}
+		if _, ok := conditionOps[c.Op]; !ok {
+			return ErrBadScope
+		}
+	case ConditionCreator:
+		// The value can be empty for the app itself
+	case ConditionTime:
+		if _, ok := parseHour(c.From); !ok {
+			return ErrBadScope
+		}
+		if _, ok := parseHour(c.To); !ok {
+			return ErrBadScope
+		}
+		for _, day := range c.Days {
+			if weekDay(day) < 0 {
+				return ErrBadScope
+			}
+		}
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return ErrBadScope
+		}
+	default:
+		return ErrBadScope
+	}
+	return nil
+}
+
+// String returns the condition in the format used in the scope strings:
+// size<10485760, creator=drive or time=09:00-18:00@mon,tue@Europe/Paris
+func (c Condition) String() string {
+	switch c.Type {
+	case ConditionField:
+		return c.Field + conditionOps[c.Op] + c.Value
+	case ConditionCreator:
+		return ConditionCreator + "=" + c.Value
+	case ConditionTime:
+		out := ConditionTime + "=" + c.From + "-" + c.To
+		if len(c.Days) > 0 || c.Timezone != "" {
+			out += "@" + strings.Join(c.Days, ",")
+		}
+		if c.Timezone != "" {
+			out += "@" + c.Timezone
+		}
+		return out
+	}
+	return ""
+}
+
+// parseCondition parses a condition from the format of the scope strings
+func parseCondition(in string) (Condition, error) {
+	var c Condition
+	if strings.HasPrefix(in, ConditionCreator+"=") {
+		c.Type = ConditionCreator
+		c.Value = strings.TrimPrefix(in, ConditionCreator+"=")
+		return c, c.Validate()
+	}
+	if strings.HasPrefix(in, ConditionTime+"=") {
+		c.Type = ConditionTime
+		parts := strings.SplitN(strings.TrimPrefix(in, ConditionTime+"="), "@", 3)
+		hours := strings.SplitN(parts[0], "-", 2)
+		if len(hours) != 2 {
+			return c, ErrBadScope
+		}
+		c.From, c.To = hours[0], hours[1]
+		if len(parts) > 1 && parts[1] != "" {
+			c.Days = strings.Split(parts[1], ",")
+		}
+		if len(parts) > 2 {
+			c.Timezone = parts[2]
+		}
+		return c, c.Validate()
+	}
+	idx := strings.IndexAny(in, "<>!=")
+	if idx <= 0 {
+		return c, ErrBadScope
+	}
+	sym := in[idx : idx+1]
+	if sym != "=" && strings.HasPrefix(in[idx+1:], "=") {
+		sym += "="
+	}
+	for op, opSym := range conditionOps {
+		if opSym == sym {
+			c.Type = ConditionField
+			c.Field = in[:idx]
+			c.Op = op
+			c.Value = in[idx+len(sym):]
+			return c, c.Validate()
+		}
+	}
+	return c, ErrBadScope
+}
+
+func parseConditions(in string) ([]Condition, error) {
+	if in == "" {
+		return nil, nil
+	}
+	parts := strings.Split(in, conditionSep)
+	conditions := make([]Condition, len(parts))
+	for i, part := range parts {
+		c, err := parseCondition(part)
+		if err != nil {
+			return nil, err
+		}
+		conditions[i] = c
+	}
+	return conditions, nil
+}
+
+func conditionsString(conditions []Condition) string {
+	parts := make([]string, len(conditions))
+	for i, c := range conditions {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, conditionSep)
+}
+
+// dependsOnDoc returns true if the condition needs a document to be evaluated
+func (c Condition) dependsOnDoc() bool {
+	return c.Type != ConditionTime
+}
+
+// Match returns true if the document satisfies the condition at the given
+// time. The document can be nil for the conditions that don't depend on it.
+func (c Condition) Match(o Fetcher, now time.Time) bool {
+	switch c.Type {
+	case ConditionField:
+		if o == nil {
+			return false
+		}
+		for _, candidate := range o.Fetch(c.Field) {
+			if compareValues(candidate, c.Op, c.Value) {
+				return true
+			}
+		}
+		return false
+	case ConditionCreator:
+		if o == nil || c.Value == "" {
+			return false
+		}
+		return contains(o.Fetch(CreatorField), c.Value)
+	case ConditionTime:
+		return c.matchTime(now)
+	}
+	return false
+}
+
+// compareValues compares the two values as numbers if possible, or else as
+// strings (which works for the dates in the RFC3339 format).
+func compareValues(candidate, op, value string) bool {
+	cmp := strings.Compare(candidate, value)
+	if a, err := strconv.ParseFloat(candidate, 64); err == nil {
+		if b, err := strconv.ParseFloat(value, 64); err == nil {
+			switch {
+			case a < b:
+				cmp = -1
+			case a > b:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+	switch op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "lt":
+		return cmp < 0
+	case "lte":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	}
+	return false
+}
+
+func (c Condition) matchTime(now time.Time) bool {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return false
+	}
+	now = now.In(loc)
+	from, ok := parseHour(c.From)
+	if !ok {
+		return false
+	}
+	to, ok := parseHour(c.To)
+	if !ok {
+		return false
+	}
+	minutes := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+	var inWindow bool
+	if from <= to {
+		inWindow = from <= minutes && minutes < to
+	} else {
+		// The window crosses midnight: the day is the one of the start
+		inWindow = minutes >= from || minutes < to
+		if minutes < to {
+			day = (day + 6) % 7
+		}
+	}
+	if !inWindow {
+		return false
+	}
+	if len(c.Days) == 0 {
+		return true
+	}
+	for _, d := range c.Days {
+		if weekDay(d) == int(day) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHour parses an hour in the HH:MM format, and returns it as a number of
+// minutes since midnight.
+func parseHour(in string) (int, bool) {
+	t, err := time.Parse("15:04", in)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func weekDay(in string) int {
+	for i, d := range weekDays {
+		if d == strings.ToLower(in) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ConditionsMatch returns true if the document satisfies all the conditions
+// of the rule. The document can be nil, and in that case, only the rules
+// without conditions on the documents can match.
+func (r Rule) ConditionsMatch(o Fetcher) bool {
+	now := time.Now()
+	for _, c := range r.Conditions {
+		if !c.Match(o, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasDocConditions returns true if the rule has some conditions that need a
+// document to be evaluated
+func (r Rule) hasDocConditions() bool {
+	for _, c := range r.Conditions {
+		if c.dependsOnDoc() {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionsInSubset returns true if the rule r2 has at least all the
+// conditions of r, ie it can't allow more documents than r because of the
+// conditions.
+func (r Rule) conditionsInSubset(r2 Rule) bool {
+	for _, c := range r.Conditions {
+		found := false
+		for _, c2 := range r2.Conditions {
+			if reflect.DeepEqual(c, c2) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// withCreator returns a copy of the set where the creator conditions without
+// an app are filled with the given slug.
+func (ps Set) withCreator(slug string) Set {
+	out := make(Set, len(ps))
+	for i, r := range ps {
+		out[i] = r
+		if len(r.Conditions) == 0 {
+			continue
+		}
+		out[i].Conditions = make([]Condition, len(r.Conditions))
+		for j, c := range r.Conditions {
+			if c.Type == ConditionCreator && c.Value == "" {
+				c.Value = slug
+			}
+			out[i].Conditions[j] = c
+		}
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package permission
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionJSON(t *testing.T) {
+	var s Set
+	err := json.Unmarshal([]byte(`{
+    "small-files": {
+      "type": "io.cozy.files",
+      "verbs": ["GET"],
+      "conditions": [
+        {"type": "field", "field": "size", "op": "lt", "value": 10485760},
+        {"type": "creator"},
+        {"type": "time", "from": "09:00", "to": "18:00", "days": ["mon", "fri"], "timezone": "Europe/Paris"}
+      ]
+    }
+  }`), &s)
+	assert.NoError(t, err)
+	if assert.Len(t, s, 1) && assert.Len(t, s[0].Conditions, 3) {
+		assert.Equal(t, "10485760", s[0].Conditions[0].Value)
+		assert.Equal(t, ConditionCreator, s[0].Conditions[1].Type)
+		assert.Equal(t, []string{"mon", "fri"}, s[0].Conditions[2].Days)
+	}
+
+	err = json.Unmarshal([]byte(`{
+    "bad": {
+      "type": "io.cozy.files",
+      "conditions": [{"type": "field", "field": "size", "op": "like", "value": 1}]
+    }
+  }`), &s)
+	assert.Equal(t, ErrBadScope, err)
+
+	err = json.Unmarshal([]byte(`{
+    "bad": {
+      "type": "io.cozy.files",
+      "conditions": [{"type": "time", "from": "9h", "to": "18:00"}]
+    }
+  }`), &s)
+	assert.Equal(t, ErrBadScope, err)
+}
+
+func TestConditionScopeString(t *testing.T) {
+	rule, err := UnmarshalRuleString("io.cozy.files:GET:::size<=1000;creator=drive;time=22:00-06:00@mon,tue@Europe/Paris")
+	assert.NoError(t, err)
+	assert.Nil(t, rule.Values)
+	assert.Equal(t, "", rule.Selector)
+	if assert.Len(t, rule.Conditions, 3) {
+		assert.Equal(t, Condition{Type: ConditionField, Field: "size", Op: "lte", Value: "1000"}, rule.Conditions[0])
+		assert.Equal(t, Condition{Type: ConditionCreator, Value: "drive"}, rule.Conditions[1])
+		assert.Equal(t, "22:00", rule.Conditions[2].From)
+		assert.Equal(t, "06:00", rule.Conditions[2].To)
+		assert.Equal(t, "Europe/Paris", rule.Conditions[2].Timezone)
+	}
+	out, err := rule.MarshalScopeString()
+	assert.NoError(t, err)
+	assert.Equal(t, "io.cozy.files:GET:::size<=1000;creator=drive;time=22:00-06:00@mon,tue@Europe/Paris", out)
+
+	_, err = UnmarshalRuleString("io.cozy.files:GET:::size~1000")
+	assert.Error(t, err)
+	_, err = UnmarshalRuleString("io.cozy.files:GET:::time=09:00@mon")
+	assert.Error(t, err)
+}
+
+func TestConditionsMatch(t *testing.T) {
+	s := Set{Rule{
+		Type:  "io.cozy.contacts",
+		Verbs: Verbs(GET),
+		Conditions: []Condition{
+			{Type: ConditionField, Field: "size", Op: "lt", Value: "100"},
+			{Type: ConditionCreator, Value: "contacts"},
+		},
+	}}
+	small := &validable{doctype: "io.cozy.contacts", values: map[string]string{
+		"size":       "42",
+		CreatorField: "contacts",
+	}}
+	big := &validable{doctype: "io.cozy.contacts", values: map[string]string{
+		"size":       "1000",
+		CreatorField: "contacts",
+	}}
+	other := &validable{doctype: "io.cozy.contacts", values: map[string]string{
+		"size":       "42",
+		CreatorField: "drive",
+	}}
+	assert.True(t, s.Allow(GET, small))
+	assert.False(t, s.Allow(GET, big))
+	assert.False(t, s.Allow(GET, other))
+	assert.False(t, s.AllowWholeType(GET, "io.cozy.contacts"))
+	assert.False(t, s.AllowID(GET, "io.cozy.contacts", "id1"))
+
+	// A share set can't drop the conditions of its parent
+	child := Set{Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET)}}
+	assert.False(t, child.IsSubSetOf(s))
+	child[0].Conditions = s[0].Conditions
+	assert.True(t, child.IsSubSetOf(s))
+}
+
+func TestConditionTime(t *testing.T) {
+	c := Condition{Type: ConditionTime, From: "09:00", To: "18:00", Days: []string{"mon"}}
+	monday := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+	assert.True(t, c.Match(nil, monday))
+	assert.False(t, c.Match(nil, monday.Add(9*time.Hour)))
+	assert.False(t, c.Match(nil, monday.Add(24*time.Hour)))
+
+	night := Condition{Type: ConditionTime, From: "22:00", To: "06:00", Days: []string{"mon"}}
+	assert.True(t, night.Match(nil, monday.Add(13*time.Hour)))
+	// Tuesday at 2am is still in the window that has started on monday
+	assert.True(t, night.Match(nil, monday.Add(16*time.Hour)))
+	assert.False(t, night.Match(nil, monday))
+}
+
+func TestWithCreator(t *testing.T) {
+	s := Set{Rule{
+		Type:       "io.cozy.files",
+		Conditions: []Condition{{Type: ConditionCreator}},
+	}}
+	resolved := s.withCreator("drive")
+	assert.Equal(t, "drive", resolved[0].Conditions[0].Value)
+	assert.Equal(t, "", s[0].Conditions[0].Value)
+}
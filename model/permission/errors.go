@@ -38,4 +38,9 @@ var (
 	// ErrNotParent is used when the permissions should have a specific parent.
 	ErrNotParent = echo.NewHTTPError(http.StatusForbidden,
 		"Permissions can be updated only by its parent")
+
+	// ErrInvalidAuditSettings is used when the settings for the audit trail
+	// of the permissions are not valid.
+	ErrInvalidAuditSettings = echo.NewHTTPError(http.StatusUnprocessableEntity,
+		"The retention of the audit trail is not valid")
 )
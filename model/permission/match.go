@@ -8,6 +8,9 @@ type Fetcher interface {
 }
 
 func matchValues(r Rule, o Fetcher) bool {
+	if !r.ConditionsMatch(o) {
+		return false
+	}
 	// empty r.Values = any value
 	if len(r.Values) == 0 {
 		return true
@@ -41,12 +44,15 @@ func matchVerbAndType(r Rule, v Verb, doctype string) bool {
 	return r.Verbs.Contains(v) && r.Type == doctype
 }
 
+// matchWholeType and matchID have no document for evaluating the conditions,
+// so only the rules without conditions on the documents can match.
 func matchWholeType(r Rule) bool {
-	return len(r.Values) == 0
+	return len(r.Values) == 0 && !r.hasDocConditions() && r.ConditionsMatch(nil)
 }
 
 func matchID(r Rule, id string) bool {
-	return r.Selector == "" && r.ValuesContain(id)
+	return r.Selector == "" && r.ValuesContain(id) &&
+		!r.hasDocConditions() && r.ConditionsMatch(nil)
 }
 
 // AllowWholeType returns true if the set allows to apply verb to every
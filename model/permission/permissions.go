@@ -144,7 +144,11 @@ func (p *Permission) PatchCodes(codes map[string]string) {
 
 // Revoke destroy a Permission
 func (p *Permission) Revoke(db prefixer.Prefixer) error {
-	return couchdb.DeleteDoc(db, p)
+	if err := couchdb.DeleteDoc(db, p); err != nil {
+		return err
+	}
+	AuditRevocation(db, p)
+	return nil
 }
 
 // ParentOf check if child has been created by p
@@ -357,13 +361,14 @@ func createAppSet(db prefixer.Prefixer, typ, docType, slug string, set Set, md *
 	doc := &Permission{
 		Type:        typ,
 		SourceID:    docType + "/" + slug,
-		Permissions: set,
+		Permissions: set.withCreator(slug),
 		Metadata:    md,
 	}
 	err := couchdb.CreateDoc(db, doc)
 	if err != nil {
 		return nil, err
 	}
+	AuditCreation(db, doc)
 	return doc, nil
 }
 
@@ -427,7 +432,7 @@ func UpdateKonnectorSet(db prefixer.Prefixer, slug string, set Set) (*Permission
 }
 
 func updateAppSet(db prefixer.Prefixer, doc *Permission, typ, docType, slug string, set Set) (*Permission, error) {
-	doc.Permissions = set
+	doc.Permissions = set.withCreator(slug)
 	if doc.Metadata == nil {
 		doc.Metadata, _ = metadata.NewWithApp(slug, "", DocTypeVersion)
 	} else {
@@ -451,6 +456,15 @@ func CreateShareSet(db prefixer.Prefixer, parent *Permission, sourceID string, c
 		return nil, ErrNotSubset
 	}
 
+	// The app must be allowed to share further the documents
+	for _, rule := range set {
+		shared := rule
+		shared.Verbs = Verbs(SHARE)
+		if !parent.Permissions.RuleInSubset(shared) {
+			return nil, ErrNotSubset
+		}
+	}
+
 	for _, rule := range set {
 		// XXX io.cozy.files is allowed and handled with specific code for sharings
 		if rule.Type == consts.Files {
@@ -476,6 +490,7 @@ func CreateShareSet(db prefixer.Prefixer, parent *Permission, sourceID string, c
 	if err != nil {
 		return nil, err
 	}
+	AuditCreation(db, doc)
 
 	return doc, nil
 }
@@ -493,6 +508,7 @@ func CreateSharePreviewSet(db prefixer.Prefixer, sharingID string, codes map[str
 	if err != nil {
 		return nil, err
 	}
+	AuditCreation(db, doc)
 	return doc, nil
 }
 
@@ -502,10 +518,14 @@ func ForceWebapp(db prefixer.Prefixer, slug string, set Set) error {
 	doc := &Permission{
 		Type:        TypeWebapp,
 		SourceID:    consts.Apps + "/" + slug,
-		Permissions: set,
+		Permissions: set.withCreator(slug),
 	}
 	if existing == nil {
-		return couchdb.CreateDoc(db, doc)
+		if err := couchdb.CreateDoc(db, doc); err != nil {
+			return err
+		}
+		AuditCreation(db, doc)
+		return nil
 	}
 
 	doc.SetID(existing.ID())
@@ -540,6 +560,7 @@ func destroyApp(db prefixer.Prefixer, permType, docType, slug string) error {
 		if err != nil {
 			return err
 		}
+		AuditRevocation(db, &p)
 	}
 	return nil
 }
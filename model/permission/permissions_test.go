@@ -21,6 +21,40 @@ func TestVerbToString(t *testing.T) {
 	assert.Equal(t, "ALL", vs4.String())
 }
 
+func TestDomainVerbs(t *testing.T) {
+	legacy := Verbs(GET)
+	assert.True(t, legacy.Contains(DOWNLOAD))
+	assert.True(t, legacy.Contains(PREVIEW))
+	assert.True(t, legacy.Contains(SHARE))
+	assert.False(t, legacy.Contains(COMMENT))
+	assert.True(t, legacy.ReadOnly())
+
+	downloadOnly := Verbs(GET, DOWNLOAD)
+	assert.True(t, downloadOnly.Contains(DOWNLOAD))
+	assert.False(t, downloadOnly.Contains(PREVIEW))
+	assert.False(t, downloadOnly.Contains(SHARE))
+	assert.True(t, downloadOnly.ReadOnly())
+	assert.Equal(t, "GET,DOWNLOAD", downloadOnly.String())
+
+	// A legacy set implies the domain verbs, so it is larger
+	assert.True(t, legacy.ContainsAll(downloadOnly))
+	assert.False(t, downloadOnly.ContainsAll(legacy))
+
+	commenter := Verbs(GET, COMMENT)
+	assert.False(t, commenter.ReadOnly())
+	assert.False(t, commenter.Contains(POST))
+
+	var vs VerbSet
+	err := json.Unmarshal([]byte(`["GET","PREVIEW"]`), &vs)
+	assert.NoError(t, err)
+	assert.EqualValues(t, Verbs(GET, PREVIEW), vs)
+	b, err := json.Marshal(vs)
+	assert.NoError(t, err)
+	assert.Equal(t, `["GET","PREVIEW"]`, string(b))
+	err = json.Unmarshal([]byte(`["GET","UNKNOWN"]`), &vs)
+	assert.Equal(t, ErrBadScope, err)
+}
+
 func TestRuleToJSON(t *testing.T) {
 	r := Rule{
 		Type:  "io.cozy.contacts",
@@ -33,6 +33,10 @@ type Rule struct {
 	// Selector is the field which must be one of Values.
 	Selector string   `json:"selector,omitempty"`
 	Values   []string `json:"values,omitempty"`
+
+	// Conditions are optional restrictions on the documents, or on the time
+	// when the rule applies.
+	Conditions []Condition `json:"conditions,omitempty"`
 }
 
 // MarshalScopeString transform a Rule into a string of the shape
@@ -42,32 +46,47 @@ func (r Rule) MarshalScopeString() (string, error) {
 	hasVerbs := len(r.Verbs) != 0
 	hasValues := len(r.Values) != 0
 	hasSelector := r.Selector != ""
+	hasConditions := len(r.Conditions) != 0
 
-	if hasVerbs || hasValues || hasSelector {
+	if hasVerbs || hasValues || hasSelector || hasConditions {
 		out += partSep + r.Verbs.String()
 	}
 
-	if hasValues {
+	if hasValues || hasSelector || hasConditions {
 		out += partSep + strings.Join(r.Values, valueSep)
 	}
 
-	if hasSelector {
+	if hasSelector || hasConditions {
 		out += partSep + r.Selector
 	}
 
+	if hasConditions {
+		out += partSep + conditionsString(r.Conditions)
+	}
+
 	return out, nil
 }
 
-// UnmarshalRuleString parse a scope formated rule
+// UnmarshalRuleString parse a scope formated rule. The conditions are the
+// optional fifth part, and they can contain the separator (for the hours).
 func UnmarshalRuleString(in string) (Rule, error) {
 	var out Rule
-	parts := strings.Split(in, partSep)
+	parts := strings.SplitN(in, partSep, 5)
 	switch len(parts) {
+	case 5:
+		conditions, err := parseConditions(parts[4])
+		if err != nil {
+			return out, err
+		}
+		out.Conditions = conditions
+		fallthrough
 	case 4:
 		out.Selector = parts[3]
 		fallthrough
 	case 3:
-		out.Values = strings.Split(parts[2], valueSep)
+		if parts[2] != "" || len(parts) < 5 {
+			out.Values = strings.Split(parts[2], valueSep)
+		}
 		fallthrough
 	case 2:
 		out.Verbs = VerbSplit(parts[1])
@@ -102,8 +121,12 @@ func contains(haystack []string, needle string) bool {
 	return false
 }
 
-// ValuesMatch returns true if any value statisfy the predicate
+// ValuesMatch returns true if any value statisfy the predicate, and the
+// conditions of the rule are satisfied
 func (r Rule) ValuesMatch(o Fetcher) bool {
+	if !r.ConditionsMatch(o) {
+		return false
+	}
 	candidates := o.Fetch(r.Selector)
 	for _, v := range r.Values {
 		if contains(candidates, v) {
@@ -163,10 +186,8 @@ func (r Rule) Merge(r2 Rule) (*Rule, error) {
 	newRule := &r
 
 	// Verbs
-	for verb, content := range r2.Verbs {
-		if !newRule.Verbs.Contains(verb) {
-			newRule.Verbs[verb] = content
-		}
+	if len(newRule.Verbs) > 0 {
+		newRule.Verbs.Merge(&r2.Verbs)
 	}
 
 	for _, value := range r2.Values {
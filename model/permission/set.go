@@ -157,6 +157,10 @@ func (ps *Set) RuleInSubset(r2 Rule) bool {
 			continue
 		}
 
+		if !r.conditionsInSubset(r2) {
+			continue
+		}
+
 		if r.Selector == "" && len(r.Values) == 0 {
 			return true
 		}
@@ -198,6 +202,7 @@ func (ps Set) HasSameRules(other Set) bool {
 				rule.Selector == otherRule.Selector &&
 				rule.Verbs.ContainsAll(otherRule.Verbs) &&
 				otherRule.Verbs.ContainsAll(rule.Verbs) &&
+				reflect.DeepEqual(rule.Conditions, otherRule.Conditions) &&
 				reflect.DeepEqual(otherRule.Type, rule.Type) {
 				match = true
 				break
@@ -7,12 +7,11 @@ import (
 
 const verbSep = ","
 const allVerbs = "ALL"
-const allVerbsLength = 5
 
-// Verb is one of GET,POST,PUT,PATCH,DELETE
+// Verb is one of GET,POST,PUT,PATCH,DELETE, or a domain verb
 type Verb string
 
-// All possible Verbs, a subset of http methods
+// All possible HTTP Verbs, a subset of http methods
 const (
 	GET    = Verb("GET")
 	POST   = Verb("POST")
@@ -21,8 +20,57 @@ const (
 	DELETE = Verb("DELETE")
 )
 
+// Domain verbs, for the actions that are finer than the HTTP methods
+const (
+	// DOWNLOAD is the verb for reading the content of a file
+	DOWNLOAD = Verb("DOWNLOAD")
+	// PREVIEW is the verb for seeing the thumbnails of a file
+	PREVIEW = Verb("PREVIEW")
+	// COMMENT is the verb for adding comments on a document
+	COMMENT = Verb("COMMENT")
+	// SHARE is the verb for sharing a document further, by link or with
+	// another cozy
+	SHARE = Verb("SHARE")
+)
+
 var allVerbsOrder = []Verb{GET, POST, PUT, PATCH, DELETE}
 
+// impliedBy maps a domain verb to the HTTP verb that implies it, for the
+// VerbSets that don't use any domain verb. It keeps the behavior of the
+// permissions that were declared before the domain verbs.
+var impliedBy = map[Verb]Verb{}
+
+func init() {
+	RegisterDomainVerb(DOWNLOAD, GET)
+	RegisterDomainVerb(PREVIEW, GET)
+	RegisterDomainVerb(COMMENT, POST)
+	RegisterDomainVerb(SHARE, GET)
+}
+
+// RegisterDomainVerb adds a domain verb to the list of the known verbs. When
+// a VerbSet has no domain verb, the given HTTP verb implies this domain verb.
+// It is meant to be called in an init function.
+func RegisterDomainVerb(v, implied Verb) {
+	if _, ok := impliedBy[v]; !ok {
+		allVerbsOrder = append(allVerbsOrder, v)
+	}
+	impliedBy[v] = implied
+}
+
+// IsDomainVerb returns true if the verb is a registered domain verb
+func IsDomainVerb(v Verb) bool {
+	_, ok := impliedBy[v]
+	return ok
+}
+
+func isKnownVerb(v Verb) bool {
+	switch v {
+	case GET, POST, PUT, PATCH, DELETE:
+		return true
+	}
+	return IsDomainVerb(v)
+}
+
 // VerbSet is a Set of Verbs
 type VerbSet map[Verb]struct{}
 
@@ -31,8 +79,14 @@ func (vs VerbSet) Contains(v Verb) bool {
 	if len(vs) == 0 {
 		return true // empty set = ALL
 	}
-	_, has := vs[v]
-	return has
+	if _, has := vs[v]; has {
+		return true
+	}
+	if implied, ok := impliedBy[v]; ok && !vs.HasDomainVerbs() {
+		_, has := vs[implied]
+		return has
+	}
+	return false
 }
 
 // ContainsAll check if VerbSet contains all passed verbs
@@ -41,27 +95,59 @@ func (vs VerbSet) ContainsAll(verbs VerbSet) bool {
 		return true // empty set = ALL
 	}
 
-	for v := range verbs {
-		_, has := vs[v]
-		if !has {
+	for v := range verbs.expand() {
+		if !vs.Contains(v) {
 			return false
 		}
 	}
 	return true
 }
 
-// ReadOnly returns true if the set contains only the verb GET
+// HasDomainVerbs returns true if the set contains at least one domain verb
+func (vs VerbSet) HasDomainVerbs() bool {
+	for v := range vs {
+		if IsDomainVerb(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// expand returns the set with the domain verbs that are implied by its HTTP
+// verbs, if it has no domain verb.
+func (vs VerbSet) expand() VerbSet {
+	if vs.HasDomainVerbs() {
+		return vs
+	}
+	out := make(VerbSet, len(vs))
+	for v := range vs {
+		out[v] = struct{}{}
+	}
+	for v, implied := range impliedBy {
+		if _, has := vs[implied]; has {
+			out[v] = struct{}{}
+		}
+	}
+	return out
+}
+
+// ReadOnly returns true if the set contains only the verb GET, with the
+// domain verbs for reading (DOWNLOAD and PREVIEW)
 func (vs VerbSet) ReadOnly() bool {
-	if len(vs) != 1 {
+	if _, has := vs[GET]; !has {
 		return false
 	}
-	_, has := vs[GET]
-	return has
+	for v := range vs {
+		if v != GET && v != DOWNLOAD && v != PREVIEW {
+			return false
+		}
+	}
+	return true
 }
 
 func (vs VerbSet) String() string {
 	out := ""
-	if len(vs) == 0 || len(vs) == allVerbsLength {
+	if len(vs) == 0 || vs.isAll() {
 		return allVerbs
 	}
 	for _, v := range allVerbsOrder {
@@ -102,18 +188,31 @@ func (vs *VerbSet) UnmarshalJSON(b []byte) error {
 		}
 	}
 	for _, v := range s {
-		switch v {
-		case "GET", "POST", "PUT", "PATCH", "DELETE":
-			(*vs)[Verb(v)] = struct{}{}
-		default:
+		if !isKnownVerb(Verb(v)) {
 			return ErrBadScope
 		}
+		(*vs)[Verb(v)] = struct{}{}
 	}
 	return nil
 }
 
+// isAll returns true if the set contains all the known verbs
+func (vs VerbSet) isAll() bool {
+	for _, v := range allVerbsOrder {
+		if !vs.Contains(v) {
+			return false
+		}
+	}
+	return true
+}
+
 // Merge add verbs to the set
 func (vs *VerbSet) Merge(verbs *VerbSet) {
+	if vs.HasDomainVerbs() != verbs.HasDomainVerbs() {
+		*vs = vs.expand()
+		expanded := verbs.expand()
+		verbs = &expanded
+	}
 	for v := range *verbs {
 		(*vs)[v] = struct{}{}
 	}
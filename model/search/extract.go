@@ -0,0 +1,189 @@
+package search
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+// maxTextSize is the maximal number of bytes of text that are read from a
+// file for indexing its content.
+const maxTextSize = 1 << 20 // 1MB
+
+// maxExtractSize is the maximal size of a PDF or office file for extracting
+// its text. The bigger files are indexed only by their names.
+const maxExtractSize = 50 << 20 // 50MB
+
+// officeParts lists the XML files inside the office documents (OOXML and
+// OpenDocument) that contain the text.
+var officeParts = map[string][]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {"word/document.xml"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {"xl/sharedStrings.xml"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {"ppt/slides/*.xml"},
+	"application/vnd.oasis.opendocument.text":                                   {"content.xml"},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {"content.xml"},
+	"application/vnd.oasis.opendocument.presentation":                           {"content.xml"},
+}
+
+// Extractable returns true if the content of the given file can be indexed.
+func Extractable(doc *vfs.FileDoc) bool {
+	if doc.Mime == "application/pdf" {
+		return true
+	}
+	if _, ok := officeParts[doc.Mime]; ok {
+		return true
+	}
+	return isPlainText(doc)
+}
+
+func isPlainText(doc *vfs.FileDoc) bool {
+	return strings.HasPrefix(doc.Mime, "text/") ||
+		doc.Mime == "application/json" ||
+		doc.Mime == "application/xml"
+}
+
+// ExtractText returns the text of the content of the given file. It supports
+// the plain text files (including markdown and notes), the PDF files (via the
+// pdftotext command), and the office documents. An empty string is returned
+// for the other files.
+func ExtractText(ctx context.Context, fs vfs.VFS, doc *vfs.FileDoc) (string, error) {
+	if !Extractable(doc) {
+		return "", nil
+	}
+	if !isPlainText(doc) && doc.ByteSize > maxExtractSize {
+		return "", nil
+	}
+
+	f, err := fs.OpenFile(doc)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if isPlainText(doc) {
+		buf, err := ioutil.ReadAll(io.LimitReader(f, maxTextSize))
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+	if doc.Mime == "application/pdf" {
+		return extractPDF(ctx, f)
+	}
+	return extractOffice(f, doc.ByteSize, officeParts[doc.Mime])
+}
+
+// extractPDF uses the pdftotext command to extract the text of a PDF. The PDF
+// is copied in a temporary file, as pdftotext cannot read it from stdin.
+func extractPDF(ctx context.Context, in io.Reader) (string, error) {
+	cmdName := config.GetConfig().Jobs.PdfToTextCmd
+	if cmdName == "" {
+		cmdName = "pdftotext"
+	}
+	tmp, err := ioutil.TempFile("", "cozy-search-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, in)
+	if errc := tmp.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, cmdName, "-q", "-enc", "UTF-8", tmp.Name(), "-")
+	cmd.Stdout = &limitedBuffer{&stdout, maxTextSize}
+	if err = cmd.Run(); err != nil {
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// extractOffice extracts the text of an office document, which is a zip of
+// XML files.
+func extractOffice(in io.ReaderAt, size int64, parts []string) (string, error) {
+	z, err := zip.NewReader(in, size)
+	if err != nil {
+		return "", err
+	}
+	var text bytes.Buffer
+	out := &limitedBuffer{&text, maxTextSize}
+	for _, file := range z.File {
+		if !matchPart(file.Name, parts) {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		err = extractXMLText(r, out)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return text.String(), nil
+}
+
+func matchPart(name string, parts []string) bool {
+	for _, part := range parts {
+		if ok, _ := path.Match(part, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extractXMLText writes the character data of an XML document, separated by
+// spaces.
+func extractXMLText(r io.Reader, w io.Writer) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if data, ok := token.(xml.CharData); ok {
+			if _, err = w.Write(data); err != nil {
+				return err
+			}
+			if _, err = w.Write([]byte{' '}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// limitedBuffer is a writer that silently drops the bytes after the limit.
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if remaining := l.limit - l.buf.Len(); remaining < len(p) {
+		if remaining <= 0 {
+			return n, nil
+		}
+		p = p[:remaining]
+	}
+	l.buf.Write(p)
+	return n, nil
+}
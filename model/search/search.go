@@ -0,0 +1,348 @@
+// Package search is used for the full-text search on the files. It maintains
+// an index of the terms found in the names, paths, tags, and content of the
+// files, and can query it to find the files that match some text.
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// The weights of the terms depend on where they have been found.
+const (
+	nameWeight    = 4.0
+	tagWeight     = 3.0
+	pathWeight    = 1.5
+	contentWeight = 1.0
+
+	// A term that matches only the prefix of an indexed term has its weight
+	// lowered by this factor
+	prefixFactor = 0.5
+)
+
+// maxContentTerms is the maximal number of terms indexed for the content of a
+// file. The most frequent terms are kept.
+const maxContentTerms = 2000
+
+// maxRowsPerTerm is the maximal number of rows fetched from the index for
+// each term of a query.
+const maxRowsPerTerm = 5000
+
+// Entry is the document of the search index for a file. The terms of the
+// name, tags and path are kept apart from the terms of the content, as the
+// latter can be reused when only the metadata of the file has changed.
+type Entry struct {
+	DocID     string             `json:"_id,omitempty"`
+	DocRev    string             `json:"_rev,omitempty"`
+	Terms     map[string]float64 `json:"terms"`
+	Content   map[string]float64 `json:"content,omitempty"`
+	MD5Sum    []byte             `json:"md5sum,omitempty"`
+	IndexedAt time.Time          `json:"indexed_at"`
+}
+
+// ID returns the entry qualified identifier (the same as the file)
+func (e *Entry) ID() string { return e.DocID }
+
+// Rev returns the entry revision
+func (e *Entry) Rev() string { return e.DocRev }
+
+// DocType returns the entry document type
+func (e *Entry) DocType() string { return consts.FilesSearch }
+
+// Clone implements couchdb.Doc
+func (e *Entry) Clone() couchdb.Doc {
+	cloned := *e
+	cloned.Terms = make(map[string]float64, len(e.Terms))
+	for k, v := range e.Terms {
+		cloned.Terms[k] = v
+	}
+	cloned.Content = make(map[string]float64, len(e.Content))
+	for k, v := range e.Content {
+		cloned.Content[k] = v
+	}
+	cloned.MD5Sum = make([]byte, len(e.MD5Sum))
+	copy(cloned.MD5Sum, e.MD5Sum)
+	return &cloned
+}
+
+// SetID changes the entry qualified identifier
+func (e *Entry) SetID(id string) { e.DocID = id }
+
+// SetRev changes the entry revision
+func (e *Entry) SetRev(rev string) { e.DocRev = rev }
+
+// Result is a file that matches a query, with its relevance score.
+type Result struct {
+	ID    string
+	Score float64
+}
+
+// Indexable returns true if the file should be in the search index. The
+// trashed files are excluded.
+func Indexable(doc *vfs.FileDoc) bool {
+	return !doc.Trashed
+}
+
+// IndexFile adds or updates the entry for the given file in the index. The
+// content of the file is extracted again only if it has changed since the
+// last indexation.
+func IndexFile(ctx context.Context, inst *instance.Instance, doc *vfs.FileDoc) error {
+	fs := inst.VFS()
+	entry := &Entry{}
+	err := couchdb.GetDoc(inst, consts.FilesSearch, doc.ID(), entry)
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+	isNew := err != nil
+	if isNew {
+		entry = &Entry{DocID: doc.ID()}
+	}
+
+	fullpath, err := doc.Path(fs)
+	if err != nil {
+		return err
+	}
+	entry.Terms = metadataTerms(doc, fullpath)
+
+	if isNew || string(entry.MD5Sum) != string(doc.MD5Sum) {
+		text, err := ExtractText(ctx, fs, doc)
+		if err != nil {
+			inst.Logger().WithField("nspace", "search").
+				Infof("Cannot extract the text of %s: %s", doc.ID(), err)
+		}
+		entry.Content = contentTerms(text)
+		entry.MD5Sum = doc.MD5Sum
+	}
+	entry.IndexedAt = time.Now()
+
+	if isNew {
+		return couchdb.CreateNamedDocWithDB(inst, entry)
+	}
+	return couchdb.UpdateDoc(inst, entry)
+}
+
+// RemoveFile removes the entry for the given file from the index, if any.
+func RemoveFile(db prefixer.Prefixer, fileID string) error {
+	entry := &Entry{}
+	err := couchdb.GetDoc(db, consts.FilesSearch, fileID, entry)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = couchdb.DeleteDoc(db, entry)
+	if couchdb.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// IndexDir updates the entries of the files inside the given directory, for
+// example after the directory has been moved or renamed.
+func IndexDir(ctx context.Context, inst *instance.Instance, dir *vfs.DirDoc) error {
+	return vfs.Walk(inst.VFS(), dir.Fullpath, func(_ string, _ *vfs.DirDoc, file *vfs.FileDoc, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+		if !Indexable(file) {
+			return RemoveFile(inst, file.ID())
+		}
+		return IndexFile(ctx, inst, file)
+	})
+}
+
+// Rebuild indexes all the files of the instance, and removes the entries for
+// the files that no longer exist.
+func Rebuild(ctx context.Context, inst *instance.Instance) error {
+	stale := make(map[string]struct{})
+	err := couchdb.ForeachDocs(inst, consts.FilesSearch, func(id string, _ json.RawMessage) error {
+		stale[id] = struct{}{}
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+
+	log := inst.Logger().WithField("nspace", "search")
+	err = vfs.Walk(inst.VFS(), "/", func(name string, _ *vfs.DirDoc, file *vfs.FileDoc, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(name, vfs.TrashDirName) {
+			return vfs.ErrSkipDir
+		}
+		if file == nil || !Indexable(file) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		delete(stale, file.ID())
+		if erri := IndexFile(ctx, inst, file); erri != nil {
+			log.Warnf("Cannot index %s: %s", file.ID(), erri)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id := range stale {
+		if err := RemoveFile(inst, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the files that match all the terms of the given text, sorted
+// by relevance. The last term of the text can match the prefix of an indexed
+// term, like for a search-as-you-type input.
+func Query(db prefixer.Prefixer, text string) ([]Result, error) {
+	terms := uniqueTerms(Tokenize(text))
+	if len(terms) == 0 {
+		return []Result{}, nil
+	}
+
+	var scores map[string]float64
+	for i, term := range terms {
+		prefix := i == len(terms)-1
+		matches, err := queryTerm(db, term, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for id, score := range scores {
+			if match, ok := matches[id]; ok {
+				scores[id] = score + match
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, Result{ID: id, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+// queryTerm returns the best score for the given term of each file that
+// matches it.
+func queryTerm(db prefixer.Prefixer, term string, prefix bool) (map[string]float64, error) {
+	req := &couchdb.ViewRequest{Key: term, Limit: maxRowsPerTerm}
+	if prefix {
+		req = &couchdb.ViewRequest{
+			StartKey: term,
+			EndKey:   term + "\uffff",
+			Limit:    maxRowsPerTerm,
+		}
+	}
+	var res couchdb.ViewResponse
+	err := couchdb.ExecView(db, couchdb.SearchTermsView, req, &res)
+	if couchdb.IsNoDatabaseError(err) {
+		return map[string]float64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[string]float64)
+	for _, row := range res.Rows {
+		weight, _ := row.Value.(float64)
+		if key, _ := row.Key.(string); key != term {
+			weight *= prefixFactor
+		}
+		if weight > matches[row.ID] {
+			matches[row.ID] = weight
+		}
+	}
+	return matches, nil
+}
+
+func metadataTerms(doc *vfs.FileDoc, fullpath string) map[string]float64 {
+	terms := make(map[string]float64)
+	add := func(text string, weight float64) {
+		for _, term := range Tokenize(text) {
+			if weight > terms[term] {
+				terms[term] = weight
+			}
+		}
+	}
+	add(path.Dir(fullpath), pathWeight)
+	for _, tag := range doc.Tags {
+		add(tag, tagWeight)
+	}
+	add(doc.DocName, nameWeight)
+	// The title of a note is not always the same as the file name
+	if title, ok := doc.Metadata["title"].(string); ok {
+		add(title, nameWeight)
+	}
+	return terms
+}
+
+// contentTerms returns the weights of the most frequent terms of the text. The
+// weight grows with the number of occurrences, but it stays lower than the
+// weight of a term in the name of the file.
+func contentTerms(text string) map[string]float64 {
+	counts := make(map[string]int)
+	for _, term := range Tokenize(text) {
+		counts[term]++
+	}
+	terms := make([]string, 0, len(counts))
+	for term := range counts {
+		terms = append(terms, term)
+	}
+	if len(terms) > maxContentTerms {
+		sort.Slice(terms, func(i, j int) bool {
+			if counts[terms[i]] != counts[terms[j]] {
+				return counts[terms[i]] > counts[terms[j]]
+			}
+			return terms[i] < terms[j]
+		})
+		terms = terms[:maxContentTerms]
+	}
+	weights := make(map[string]float64, len(terms))
+	for _, term := range terms {
+		w := contentWeight * (0.5 + 0.1*math.Log2(float64(counts[term])))
+		weights[term] = math.Min(w, contentWeight)
+	}
+	return weights
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
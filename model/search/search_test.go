@@ -0,0 +1,61 @@
+package search
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	terms := Tokenize("Facture d'électricité - Avril 2020.pdf")
+	assert.Equal(t, []string{"facture", "electricite", "avril", "2020", "pdf"}, terms)
+	assert.Empty(t, Tokenize(" a - b "))
+	assert.Empty(t, Tokenize(""))
+}
+
+func TestMetadataTerms(t *testing.T) {
+	doc := &vfs.FileDoc{
+		DocName: "report.md",
+		Tags:    []string{"work"},
+	}
+	terms := metadataTerms(doc, "/Documents/Work/report.md")
+	assert.Equal(t, nameWeight, terms["report"])
+	assert.Equal(t, nameWeight, terms["md"])
+	assert.Equal(t, tagWeight, terms["work"])
+	assert.Equal(t, pathWeight, terms["documents"])
+	assert.NotContains(t, terms, "cozy")
+}
+
+func TestContentTerms(t *testing.T) {
+	terms := contentTerms("foo bar foo baz foo bar")
+	assert.Len(t, terms, 3)
+	assert.True(t, terms["foo"] > terms["bar"])
+	assert.True(t, terms["bar"] > terms["baz"])
+	assert.True(t, terms["foo"] <= contentWeight)
+}
+
+func TestExtractOffice(t *testing.T) {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	w, err := z.Create("word/document.xml")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(`<?xml version="1.0"?><w:document xmlns:w="ns"><w:body>` +
+		`<w:p><w:r><w:t>Hello</w:t></w:r></w:p><w:p><w:r><w:t>world</w:t></w:r></w:p>` +
+		`</w:body></w:document>`))
+	assert.NoError(t, err)
+	w, err = z.Create("word/styles.xml")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(`<styles>ignored</styles>`))
+	assert.NoError(t, err)
+	assert.NoError(t, z.Close())
+
+	mime := "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	assert.True(t, Extractable(&vfs.FileDoc{Mime: mime}))
+	r := bytes.NewReader(buf.Bytes())
+	text, err := extractOffice(r, int64(buf.Len()), officeParts[mime])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hello", "world"}, Tokenize(text))
+}
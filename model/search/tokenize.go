@@ -0,0 +1,42 @@
+package search
+
+import (
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// minTermLength is the minimal number of characters for a term to be indexed.
+const minTermLength = 2
+
+// maxTermLength is the maximal number of characters for a term. Longer words
+// are truncated, as they are probably not words but some identifiers.
+const maxTermLength = 40
+
+// Tokenize splits the text in a list of normalized terms: they are lowercased,
+// the diacritics are removed, and the separators are dropped.
+func Tokenize(text string) []string {
+	var terms []string
+	var current []rune
+	flush := func() {
+		if len(current) >= minTermLength {
+			if len(current) > maxTermLength {
+				current = current[:maxTermLength]
+			}
+			terms = append(terms, string(current))
+		}
+		current = current[:0]
+	}
+	for _, r := range norm.NFD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Drop the diacritics, like the accents
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			current = append(current, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
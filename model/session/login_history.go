@@ -57,6 +57,18 @@ func (l *LoginEntry) Clone() couchdb.Doc {
 	return &clone
 }
 
+// clientIP returns the IP address of the client that has sent the request.
+func clientIP(req *http.Request) string {
+	var ip string
+	if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		ip = strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0])
+	}
+	if ip == "" {
+		ip = strings.Split(req.RemoteAddr, ":")[0]
+	}
+	return ip
+}
+
 func lookupIP(ip, locale string) (city, country string) {
 	geodb := config.GetConfig().GeoDB
 	if geodb == "" {
@@ -99,14 +111,7 @@ func lookupIP(ip, locale string) (city, country string) {
 // StoreNewLoginEntry creates a new login entry in the database associated with
 // the given instance.
 func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string, req *http.Request, notifEnabled bool) error {
-	var ip string
-	if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ip = strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0])
-	}
-	if ip == "" {
-		ip = strings.Split(req.RemoteAddr, ":")[0]
-	}
-
+	ip := clientIP(req)
 	city, country := lookupIP(ip, i.Locale)
 	ua := user_agent.New(req.UserAgent())
 
@@ -14,6 +14,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/utils"
 	"github.com/labstack/echo/v4"
+	"github.com/mssola/user_agent"
 )
 
 // SessionCookieName is name of the cookie created by cozy
@@ -22,6 +23,10 @@ const SessionCookieName = "cozysessid"
 // SessionMaxAge is the maximum duration of the session in seconds
 const SessionMaxAge = 30 * 24 * time.Hour
 
+// lastSeenUpdatePeriod is the maximal period for updating the `last_seen`
+// date of a session. It is shorter when the idle timeout is short.
+const lastSeenUpdatePeriod = 24 * time.Hour
+
 var (
 	// ErrNoCookie is returned by GetSession if there is no cookie
 	ErrNoCookie = errors.New("No session cookie")
@@ -31,7 +36,8 @@ var (
 	ErrInvalidID = errors.New("Session cookie has wrong ID")
 )
 
-// A Session is an instance opened in a browser
+// A Session is an instance opened in a browser. The informations about the
+// device are here to help the user to recognize the session.
 type Session struct {
 	Instance  *instance.Instance `json:"-"`
 	DocID     string             `json:"_id,omitempty"`
@@ -39,6 +45,12 @@ type Session struct {
 	CreatedAt time.Time          `json:"created_at"`
 	LastSeen  time.Time          `json:"last_seen"`
 	LongRun   bool               `json:"long_run"`
+	UA        string             `json:"user_agent,omitempty"`
+	OS        string             `json:"os,omitempty"`
+	Browser   string             `json:"browser,omitempty"`
+	IP        string             `json:"ip,omitempty"`
+	City      string             `json:"city,omitempty"`
+	Country   string             `json:"country,omitempty"`
 }
 
 // DocType implements couchdb.Doc
@@ -74,8 +86,51 @@ func (s *Session) OlderThan(t time.Duration) bool {
 	return time.Now().After(s.LastSeen.Add(t))
 }
 
-// New creates a session in couchdb for the given instance
-func New(i *instance.Instance, longRun bool) (*Session, error) {
+// Expired returns true if the session has been idle for too long, or if it
+// is older than the absolute timeout.
+func (s *Session) Expired(idle, absolute time.Duration) bool {
+	if s.OlderThan(idle) {
+		return true
+	}
+	return absolute > 0 && time.Now().After(s.CreatedAt.Add(absolute))
+}
+
+// Timeouts returns the idle and absolute timeouts for the sessions of the
+// instance. They can be configured per context, with durations like "72h".
+// By default, a session expires after 30 days of inactivity, and there is no
+// absolute timeout.
+func Timeouts(i *instance.Instance) (idle, absolute time.Duration) {
+	idle = SessionMaxAge
+	ctxSettings, err := i.SettingsContext()
+	if err != nil {
+		return
+	}
+	if d, ok := parseTimeout(i, ctxSettings["session_idle_timeout"]); ok {
+		idle = d
+	}
+	if d, ok := parseTimeout(i, ctxSettings["session_absolute_timeout"]); ok {
+		absolute = d
+	}
+	return
+}
+
+func parseTimeout(i *instance.Instance, value interface{}) (time.Duration, bool) {
+	str, ok := value.(string)
+	if !ok || str == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil || d <= 0 {
+		i.Logger().WithField("nspace", "sessions").
+			Warnf("Invalid session timeout in the context %q: %s", i.ContextName, str)
+		return 0, false
+	}
+	return d, true
+}
+
+// New creates a session in couchdb for the given instance. The request is
+// used to know from which device the session has been opened.
+func New(i *instance.Instance, longRun bool, req *http.Request) (*Session, error) {
 	now := time.Now()
 	s := &Session{
 		Instance:  i,
@@ -83,6 +138,14 @@ func New(i *instance.Instance, longRun bool) (*Session, error) {
 		CreatedAt: now,
 		LongRun:   longRun,
 	}
+	if req != nil {
+		s.IP = clientIP(req)
+		s.City, s.Country = lookupIP(s.IP, i.Locale)
+		s.UA = req.UserAgent()
+		ua := user_agent.New(s.UA)
+		s.Browser, _ = ua.Browser()
+		s.OS = ua.OS()
+	}
 	if err := couchdb.CreateDoc(i, s); err != nil {
 		return nil, err
 	}
@@ -101,9 +164,10 @@ func Get(i *instance.Instance, sessionID string) (*Session, error) {
 	}
 	s.Instance = i
 
-	// If the session is older than the session max age, it has expired and
-	// should be deleted.
-	if s.OlderThan(SessionMaxAge) {
+	// If the session has been idle for too long, or is older than the
+	// absolute timeout, it has expired and should be deleted.
+	idle, absolute := Timeouts(i)
+	if s.Expired(idle, absolute) {
 		err := couchdb.DeleteDoc(i, s)
 		if err != nil {
 			i.Logger().Warn("[session] Failed to delete expired session:", err)
@@ -113,8 +177,12 @@ func Get(i *instance.Instance, sessionID string) (*Session, error) {
 
 	// In order to avoid too many updates of the session document, we have an
 	// update period of one day for the `last_seen` date, which is a good enough
-	// granularity.
-	if s.OlderThan(24 * time.Hour) {
+	// granularity (except for a short idle timeout).
+	period := lastSeenUpdatePeriod
+	if idle/10 < period {
+		period = idle / 10
+	}
+	if s.OlderThan(period) {
 		lastSeen := s.LastSeen
 		s.LastSeen = time.Now()
 		err := couchdb.UpdateDoc(i, s)
@@ -171,6 +239,20 @@ func GetAll(inst *instance.Instance) ([]*Session, error) {
 	return sessions, nil
 }
 
+// Revoke deletes the session with the given identifier. It can be used to
+// close a session opened on another device.
+func Revoke(i *instance.Instance, sessionID string) error {
+	s := &Session{}
+	err := couchdb.GetDoc(i, consts.Sessions, sessionID, s)
+	if couchdb.IsNotFoundError(err) {
+		return ErrInvalidID
+	}
+	if err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(i, s)
+}
+
 // Delete is a function to delete the session in couchdb,
 // and returns a cookie with a negative MaxAge to clear it
 func (s *Session) Delete(i *instance.Instance) *http.Cookie {
@@ -197,6 +279,9 @@ func (s *Session) ToCookie() (*http.Cookie, error) {
 	maxAge := 0
 	if s.LongRun {
 		maxAge = 10 * 365 * 24 * 3600 // 10 years
+		if _, absolute := Timeouts(s.Instance); absolute > 0 {
+			maxAge = int(time.Until(s.CreatedAt.Add(absolute)).Seconds())
+		}
 	}
 
 	return &http.Cookie{
@@ -4,13 +4,54 @@ import (
 	"encoding/base64"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
 )
 
 var JWTSecret = []byte("foobar")
 
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	s := &Session{
+		CreatedAt: now.Add(-48 * time.Hour),
+		LastSeen:  now.Add(-2 * time.Hour),
+	}
+	assert.False(t, s.Expired(SessionMaxAge, 0))
+	assert.True(t, s.Expired(time.Hour, 0))
+	assert.False(t, s.Expired(SessionMaxAge, 72*time.Hour))
+	assert.True(t, s.Expired(SessionMaxAge, 24*time.Hour))
+}
+
+func TestTimeouts(t *testing.T) {
+	conf := config.GetConfig()
+	was := conf.Contexts
+	defer func() { conf.Contexts = was }()
+	conf.Contexts = map[string]interface{}{
+		"strict": map[string]interface{}{
+			"session_idle_timeout":     "1h",
+			"session_absolute_timeout": "12h",
+		},
+		"invalid": map[string]interface{}{
+			"session_idle_timeout": "forever",
+		},
+	}
+
+	idle, absolute := Timeouts(&instance.Instance{Domain: "a.example.net", ContextName: "strict"})
+	assert.Equal(t, time.Hour, idle)
+	assert.Equal(t, 12*time.Hour, absolute)
+
+	idle, absolute = Timeouts(&instance.Instance{Domain: "b.example.net", ContextName: "invalid"})
+	assert.Equal(t, SessionMaxAge, idle)
+	assert.Equal(t, time.Duration(0), absolute)
+
+	idle, absolute = Timeouts(&instance.Instance{Domain: "c.example.net"})
+	assert.Equal(t, SessionMaxAge, idle)
+	assert.Equal(t, time.Duration(0), absolute)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	conf := config.GetConfig()
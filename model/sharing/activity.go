@@ -0,0 +1,449 @@
+package sharing
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+)
+
+const (
+	// ActivityAdd is the action when a document has been added to a sharing
+	ActivityAdd = "add"
+	// ActivityUpdate is the action when a shared document has been modified
+	ActivityUpdate = "update"
+	// ActivityRemove is the action when a document has been deleted, trashed,
+	// or is no longer in the sharing
+	ActivityRemove = "remove"
+)
+
+// DefaultActivitiesLimit is the number of activities returned by default
+const DefaultActivitiesLimit = 50
+
+// MaxActivitiesLimit is the maximal number of activities that can be asked in
+// one request
+const MaxActivitiesLimit = 1000
+
+// MaxActivitiesKept is the number of entries kept in the activity journal of
+// a sharing: the oldest entries are deleted when new ones are recorded.
+const MaxActivitiesKept = MaxActivitiesLimit
+
+// maxPendingCounted is the maximal number of pending changes that are counted
+// for the sync status of a member
+const maxPendingCounted = 1000
+
+// Activity is an entry of the activity journal of a sharing: it says which
+// member has added, updated or removed a document, and when. The journal is
+// kept on each cozy instance, for the changes made locally and the changes
+// received from the other members.
+type Activity struct {
+	AID        string    `json:"_id,omitempty"`
+	ARev       string    `json:"_rev,omitempty"`
+	SharingID  string    `json:"sharing_id"`
+	Member     int       `json:"member"`
+	MemberName string    `json:"member_name,omitempty"`
+	Action     string    `json:"action"`
+	Doctype    string    `json:"doctype"`
+	DocID      string    `json:"doc_id"`
+	Name       string    `json:"name,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ID returns the activity qualified identifier
+func (a *Activity) ID() string { return a.AID }
+
+// Rev returns the activity revision
+func (a *Activity) Rev() string { return a.ARev }
+
+// DocType returns the activity document type
+func (a *Activity) DocType() string { return consts.SharingsActivities }
+
+// SetID changes the activity qualified identifier
+func (a *Activity) SetID(id string) { a.AID = id }
+
+// SetRev changes the activity revision
+func (a *Activity) SetRev(rev string) { a.ARev = rev }
+
+// Clone implements couchdb.Doc
+func (a *Activity) Clone() couchdb.Doc {
+	cloned := *a
+	return &cloned
+}
+
+// MemberSyncStatus gives informations about the synchronization of the
+// documents of a sharing with a member.
+type MemberSyncStatus struct {
+	Index         int        `json:"index"`
+	Name          string     `json:"name,omitempty"`
+	Status        string     `json:"status"`
+	LastSeq       string     `json:"last_seq,omitempty"`
+	UploadLastSeq string     `json:"upload_last_seq,omitempty"`
+	Pending       int        `json:"pending"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+}
+
+// selfMemberIndex returns the index of the member for this cozy instance, or
+// -1 if it is unknown.
+func (s *Sharing) selfMemberIndex() int {
+	if s.Owner {
+		return 0
+	}
+	for i, m := range s.Members {
+		if i > 0 && m.Instance != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// senderMemberIndex returns the index of the member that has sent some
+// changes to this cozy instance. On a recipient, it is always the owner.
+func (s *Sharing) senderMemberIndex(m *Member) int {
+	if !s.Owner {
+		return 0
+	}
+	for i := range s.Members {
+		if &s.Members[i] == m {
+			return i
+		}
+	}
+	return -1
+}
+
+// newActivity returns an activity for the given member.
+func (s *Sharing) newActivity(index int, action, doctype, docID, name string) *Activity {
+	a := &Activity{
+		SharingID: s.SID,
+		Member:    index,
+		Action:    action,
+		Doctype:   doctype,
+		DocID:     docID,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	if index >= 0 && index < len(s.Members) {
+		a.MemberName = s.Members[index].PrimaryName()
+	}
+	return a
+}
+
+// recordActivities saves the activities in the journal. The errors are only
+// logged, as the journal must not block the replication.
+func (s *Sharing) recordActivities(inst *instance.Instance, activities []*Activity) {
+	if len(activities) == 0 {
+		return
+	}
+	docs := make([]interface{}, len(activities))
+	olds := make([]interface{}, len(activities))
+	for i, a := range activities {
+		docs[i] = a
+	}
+	err := couchdb.BulkUpdateDocs(inst, consts.SharingsActivities, docs, olds)
+	if couchdb.IsNoDatabaseError(err) {
+		if err = couchdb.EnsureDBExist(inst, consts.SharingsActivities); err == nil {
+			err = couchdb.BulkUpdateDocs(inst, consts.SharingsActivities, docs, olds)
+		}
+	}
+	if err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Cannot record the activities for %s: %s", s.SID, err)
+		return
+	}
+	if err = pruneActivities(inst, s.SID); err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Cannot prune the activities for %s: %s", s.SID, err)
+	}
+}
+
+// pruneActivities deletes the oldest entries of the journal of the sharing,
+// to keep at most MaxActivitiesKept entries.
+func pruneActivities(inst *instance.Instance, sharingID string) error {
+	var activities []*Activity
+	req := &couchdb.FindRequest{
+		UseIndex: "by-sharing-id",
+		Selector: mango.Equal("sharing_id", sharingID),
+		Sort: mango.SortBy{
+			{Field: "sharing_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Skip:  MaxActivitiesKept,
+		Limit: MaxActivitiesLimit,
+	}
+	if err := couchdb.FindDocs(inst, consts.SharingsActivities, req, &activities); err != nil {
+		return err
+	}
+	return deleteActivities(inst, activities)
+}
+
+// PurgeActivities deletes the activity journal of the sharing. It is called
+// when the sharing is revoked.
+func PurgeActivities(inst *instance.Instance, sharingID string) error {
+	for {
+		var activities []*Activity
+		req := &couchdb.FindRequest{
+			UseIndex: "by-sharing-id",
+			Selector: mango.Equal("sharing_id", sharingID),
+			Limit:    MaxActivitiesLimit,
+		}
+		err := couchdb.FindDocs(inst, consts.SharingsActivities, req, &activities)
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := deleteActivities(inst, activities); err != nil {
+			return err
+		}
+		if len(activities) < MaxActivitiesLimit {
+			return nil
+		}
+	}
+}
+
+func deleteActivities(inst *instance.Instance, activities []*Activity) error {
+	docs := make([]couchdb.Doc, len(activities))
+	for i, a := range activities {
+		docs[i] = a
+	}
+	return couchdb.BulkDeleteDocs(inst, consts.SharingsActivities, docs)
+}
+
+// recordLocalActivity saves in the journal of the sharing a change that has
+// been made on this cozy instance.
+func recordLocalActivity(inst *instance.Instance, sharingID, action string, doc *couchdb.JSONDoc) {
+	s, err := FindSharing(inst, sharingID)
+	if err != nil {
+		return
+	}
+	name, _ := doc.Get("name").(string)
+	a := s.newActivity(s.selfMemberIndex(), action, doc.DocType(), doc.ID(), name)
+	s.recordActivities(inst, []*Activity{a})
+}
+
+// recordFileActivity saves in the journal of the sharing a change on a file
+// that has been sent by a member.
+func (s *Sharing) recordFileActivity(inst *instance.Instance, m *Member, action string, file *vfs.FileDoc) {
+	index := s.senderMemberIndex(m)
+	a := s.newActivity(index, action, consts.Files, file.DocID, file.DocName)
+	s.recordActivities(inst, []*Activity{a})
+}
+
+// activitiesForBulkDocs returns the activities for the documents sent by a
+// member in a bulk. It must be called before the documents are applied, to
+// make the difference between an addition and an update.
+func (s *Sharing) activitiesForBulkDocs(inst *instance.Instance, m *Member, doctype string, docs DocsList) []*Activity {
+	ids := make([]string, 0, len(docs))
+	withID := make(DocsList, 0, len(docs))
+	for _, doc := range docs {
+		if id, ok := doc["_id"].(string); ok {
+			ids = append(ids, doctype+"/"+id)
+			withID = append(withID, doc)
+		}
+	}
+	refs, err := FindReferences(inst, ids)
+	if err != nil {
+		return nil
+	}
+	index := s.senderMemberIndex(m)
+	activities := make([]*Activity, len(withID))
+	for i, doc := range withID {
+		action := ActivityUpdate
+		if _, ok := doc["_deleted"]; ok {
+			action = ActivityRemove
+		} else if trashed, _ := doc["trashed"].(bool); trashed {
+			action = ActivityRemove
+		} else if refs[i] == nil {
+			action = ActivityAdd
+		} else if infos, ok := refs[i].Infos[s.SID]; !ok || infos.Removed {
+			action = ActivityAdd
+		}
+		name, _ := doc["name"].(string)
+		id := doc["_id"].(string)
+		activities[i] = s.newActivity(index, action, doctype, id, name)
+	}
+	return activities
+}
+
+// filterActivities keeps only the activities for the documents that have
+// been applied.
+func filterActivities(activities []*Activity, docs DocsList) []*Activity {
+	applied := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		if id, ok := doc["_id"].(string); ok {
+			applied[id] = true
+		}
+	}
+	filtered := make([]*Activity, 0, len(activities))
+	for _, a := range activities {
+		if applied[a.DocID] {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// ListActivities returns the last entries of the activity journal of the
+// sharing, the most recent first.
+func ListActivities(inst *instance.Instance, sharingID string, limit int) ([]*Activity, error) {
+	if limit <= 0 {
+		limit = DefaultActivitiesLimit
+	} else if limit > MaxActivitiesLimit {
+		limit = MaxActivitiesLimit
+	}
+	var activities []*Activity
+	req := &couchdb.FindRequest{
+		UseIndex: "by-sharing-id",
+		Selector: mango.Equal("sharing_id", sharingID),
+		Sort: mango.SortBy{
+			{Field: "sharing_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Limit: limit,
+	}
+	if err := couchdb.FindDocs(inst, consts.SharingsActivities, req, &activities); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Activity{}, nil
+		}
+		return nil, err
+	}
+	return activities, nil
+}
+
+// SyncStatuses returns the status of the synchronization with the other
+// members of the sharing: the owner on a recipient, and the recipients on the
+// owner.
+func (s *Sharing) SyncStatuses(inst *instance.Instance) ([]*MemberSyncStatus, error) {
+	if !s.Owner {
+		status, err := s.syncStatus(inst, 0, &s.Members[0])
+		if err != nil {
+			return nil, err
+		}
+		return []*MemberSyncStatus{status}, nil
+	}
+	statuses := make([]*MemberSyncStatus, 0, len(s.Members)-1)
+	for i := range s.Members {
+		if i == 0 {
+			continue
+		}
+		status, err := s.syncStatus(inst, i, &s.Members[i])
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// syncStatus returns the status of the synchronization with a member.
+func (s *Sharing) syncStatus(inst *instance.Instance, index int, m *Member) (*MemberSyncStatus, error) {
+	status := &MemberSyncStatus{
+		Index:  index,
+		Name:   m.PrimaryName(),
+		Status: m.Status,
+	}
+	if m.Status != MemberStatusReady && m.Status != MemberStatusOwner {
+		return status, nil
+	}
+	for _, worker := range []string{"replicator", "upload"} {
+		result, err := s.getLocalSyncInfos(inst, m, worker)
+		if err != nil {
+			return nil, err
+		}
+		seq, _ := result["last_seq"].(string)
+		if worker == "replicator" {
+			status.LastSeq = seq
+		} else {
+			status.UploadLastSeq = seq
+		}
+		if msg, ok := result["last_error"].(string); ok {
+			at := parseSyncTime(result["last_error_at"])
+			if status.LastErrorAt == nil || (at != nil && at.After(*status.LastErrorAt)) {
+				status.LastError = msg
+				status.LastErrorAt = at
+			}
+		}
+		if at := parseSyncTime(result["last_success_at"]); at != nil {
+			if status.LastSuccessAt == nil || at.After(*status.LastSuccessAt) {
+				status.LastSuccessAt = at
+			}
+		}
+		pending, err := s.countPendingChanges(inst, seq, worker == "upload")
+		if err != nil {
+			return nil, err
+		}
+		status.Pending += pending
+	}
+	return status, nil
+}
+
+// getLocalSyncInfos returns the local document where the last sequence number
+// and the result of the last replication or upload are stored for a member.
+func (s *Sharing) getLocalSyncInfos(inst *instance.Instance, m *Member, worker string) (map[string]interface{}, error) {
+	id, err := s.replicationID(m)
+	if err != nil {
+		return nil, err
+	}
+	result, err := couchdb.GetLocal(inst, consts.Shared, id+"/"+worker)
+	if couchdb.IsNotFoundError(err) {
+		return map[string]interface{}{}, nil
+	}
+	return result, err
+}
+
+func parseSyncTime(value interface{}) *time.Time {
+	str, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// countPendingChanges returns the number of documents of the sharing that
+// have been changed since the given sequence number. For binary, only the
+// files with a content to upload are counted, else the other documents.
+func (s *Sharing) countPendingChanges(inst *instance.Instance, since string, binary bool) (int, error) {
+	count := 0
+	for count < maxPendingCounted {
+		response, err := couchdb.GetChanges(inst, &couchdb.ChangesRequest{
+			DocType:     consts.Shared,
+			IncludeDocs: true,
+			Since:       since,
+			Limit:       BatchSize,
+		})
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		for _, r := range response.Results {
+			infos, ok := r.Doc.Get("infos").(map[string]interface{})
+			if !ok {
+				continue
+			}
+			info, ok := infos[s.SID].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			_, isBinary := info["binary"]
+			if isBinary == binary {
+				count++
+			}
+		}
+		if response.Pending == 0 || response.LastSeq == since {
+			break
+		}
+		since = response.LastSeq
+	}
+	return count, nil
+}
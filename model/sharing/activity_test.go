@@ -0,0 +1,84 @@
+package sharing
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemberIndexes(t *testing.T) {
+	owner := &Sharing{
+		Owner: true,
+		Members: []Member{
+			{Status: MemberStatusOwner, Instance: "https://alice.cozy.example"},
+			{Status: MemberStatusReady, Instance: "https://bob.cozy.example"},
+			{Status: MemberStatusReady, Instance: "https://charlie.cozy.example"},
+		},
+	}
+	assert.Equal(t, 0, owner.selfMemberIndex())
+	assert.Equal(t, 2, owner.senderMemberIndex(&owner.Members[2]))
+	assert.Equal(t, -1, owner.senderMemberIndex(nil))
+
+	recipient := &Sharing{
+		Owner: false,
+		Members: []Member{
+			{Status: MemberStatusOwner, Instance: "https://alice.cozy.example"},
+			{Status: MemberStatusReady},
+			{Status: MemberStatusReady, Instance: "https://charlie.cozy.example"},
+		},
+	}
+	assert.Equal(t, 2, recipient.selfMemberIndex())
+	assert.Equal(t, 0, recipient.senderMemberIndex(nil))
+}
+
+func TestActivitiesForBulkDocs(t *testing.T) {
+	s := &Sharing{
+		SID:   uuidv4(),
+		Owner: true,
+		Members: []Member{
+			{Status: MemberStatusOwner, Name: "Alice"},
+			{Status: MemberStatusReady, Name: "Bob"},
+		},
+	}
+	docs := DocsList{
+		{"_id": uuidv4(), "_rev": "1-aaa", "name": "new"},
+		{"_id": uuidv4(), "_rev": "2-bbb", "_deleted": true},
+	}
+	activities := s.activitiesForBulkDocs(inst, &s.Members[1], foos, docs)
+	if assert.Len(t, activities, 2) {
+		assert.Equal(t, ActivityAdd, activities[0].Action)
+		assert.Equal(t, "new", activities[0].Name)
+		assert.Equal(t, 1, activities[0].Member)
+		assert.Equal(t, "Bob", activities[0].MemberName)
+		assert.Equal(t, ActivityRemove, activities[1].Action)
+	}
+
+	filtered := filterActivities(activities, docs[1:])
+	if assert.Len(t, filtered, 1) {
+		assert.Equal(t, docs[1]["_id"], filtered[0].DocID)
+	}
+}
+
+func TestPurgeActivities(t *testing.T) {
+	s := &Sharing{
+		SID:   uuidv4(),
+		Owner: true,
+		Members: []Member{
+			{Status: MemberStatusOwner, Instance: "https://alice.cozy.example"},
+		},
+	}
+	activities := []*Activity{
+		s.newActivity(0, ActivityAdd, consts.Files, uuidv4(), "foo"),
+		s.newActivity(0, ActivityUpdate, consts.Files, uuidv4(), "bar"),
+	}
+	s.recordActivities(inst, activities)
+	list, err := ListActivities(inst, s.SID, 0)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	assert.NoError(t, PurgeActivities(inst, s.SID))
+	list, err = ListActivities(inst, s.SID, 0)
+	assert.NoError(t, err)
+	assert.Len(t, list, 0)
+}
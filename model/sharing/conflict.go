@@ -0,0 +1,337 @@
+package sharing
+
+import (
+	"os"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+const (
+	// ConflictOnContent is the kind of conflict when the content of a file
+	// has been modified at the same time on two cozy instances
+	ConflictOnContent = "content"
+	// ConflictOnPath is the kind of conflict when two files or folders have
+	// the same path, and one of them has been renamed
+	ConflictOnPath = "path"
+)
+
+const (
+	// ConflictStateOpen is the state of a conflict that has not been looked
+	// at by the user
+	ConflictStateOpen = "open"
+	// ConflictStateResolved is the state of a conflict that has been
+	// resolved by the user
+	ConflictStateResolved = "resolved"
+)
+
+const (
+	// ResolutionKeepBoth is used to resolve a conflict by keeping the files
+	// as they are
+	ResolutionKeepBoth = "keep-both"
+	// ResolutionKeepOriginal is used to resolve a conflict by keeping the
+	// original file and moving the copy to the trash
+	ResolutionKeepOriginal = "keep-original"
+	// ResolutionKeepCopy is used to resolve a conflict by putting the content
+	// of the copy in the original file and moving the copy to the trash
+	ResolutionKeepCopy = "keep-copy"
+)
+
+// maxConflictsListed is the maximal number of conflicts returned when listing
+// the conflicts of a sharing.
+const maxConflictsListed = 1000
+
+// Conflict is a record of a conflict that has happened on a file or folder of
+// a sharing, and of how it has been resolved by the stack.
+type Conflict struct {
+	CID        string     `json:"_id,omitempty"`
+	CRev       string     `json:"_rev,omitempty"`
+	SharingID  string     `json:"sharing_id"`
+	Rule       int        `json:"rule"`
+	Policy     string     `json:"policy"`
+	Kind       string     `json:"kind"`
+	FileID     string     `json:"file_id"`
+	DirID      string     `json:"dir_id,omitempty"`
+	Name       string     `json:"name"`
+	KeptRev    string     `json:"kept_rev,omitempty"`
+	DroppedRev string     `json:"dropped_rev,omitempty"`
+	CopyID     string     `json:"copy_id,omitempty"`
+	State      string     `json:"state"`
+	Resolution string     `json:"resolution,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ID returns the conflict qualified identifier
+func (c *Conflict) ID() string { return c.CID }
+
+// Rev returns the conflict revision
+func (c *Conflict) Rev() string { return c.CRev }
+
+// DocType returns the conflict document type
+func (c *Conflict) DocType() string { return consts.SharingsConflicts }
+
+// SetID changes the conflict qualified identifier
+func (c *Conflict) SetID(id string) { c.CID = id }
+
+// SetRev changes the conflict revision
+func (c *Conflict) SetRev(rev string) { c.CRev = rev }
+
+// Clone implements couchdb.Doc
+func (c *Conflict) Clone() couchdb.Doc {
+	cloned := *c
+	if c.ResolvedAt != nil {
+		resolved := *c.ResolvedAt
+		cloned.ResolvedAt = &resolved
+	}
+	return &cloned
+}
+
+// Included is part of the jsonapi.Object interface
+func (c *Conflict) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (c *Conflict) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (c *Conflict) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/sharings/" + c.SharingID + "/conflicts/" + c.CID}
+}
+
+var _ jsonapi.Object = (*Conflict)(nil)
+
+// newContentConflict returns a conflict between two versions of the content
+// of a file. The identifier is computed from the dropped revision, so that the
+// same conflict is recorded only once, even if the upload is retried.
+func (s *Sharing) newContentConflict(ruleIndex int, file *vfs.FileDoc, keptRev, droppedRev, copyID string) *Conflict {
+	return &Conflict{
+		CID:        conflictID(file.DocID, droppedRev),
+		SharingID:  s.SID,
+		Rule:       ruleIndex,
+		Policy:     s.Rules[ruleIndex].conflictPolicy(),
+		Kind:       ConflictOnContent,
+		FileID:     file.DocID,
+		DirID:      file.DirID,
+		Name:       file.DocName,
+		KeptRev:    keptRev,
+		DroppedRev: droppedRev,
+		CopyID:     copyID,
+	}
+}
+
+// newPathConflict returns a conflict between two files or folders with the
+// same path, where the file or folder with the given identifier has been
+// renamed.
+func (s *Sharing) newPathConflict(inst *instance.Instance, renamedID, dirID, name string) *Conflict {
+	c := &Conflict{
+		SharingID: s.SID,
+		Policy:    ConflictPolicyKeepBoth,
+		Kind:      ConflictOnPath,
+		FileID:    renamedID,
+		DirID:     dirID,
+		Name:      name,
+	}
+	var ref SharedRef
+	err := couchdb.GetDoc(inst, consts.Shared, consts.Files+"/"+renamedID, &ref)
+	if infos, ok := ref.Infos[s.SID]; err == nil && ok {
+		c.Rule = infos.Rule
+		return c
+	}
+	for i, rule := range s.Rules {
+		if !rule.Local && rule.DocType == consts.Files {
+			c.Rule = i
+			break
+		}
+	}
+	return c
+}
+
+// recordConflict persists the conflict and notifies the user. The errors are
+// only logged, as the conflict has already been resolved in the VFS and the
+// replication must go on.
+func (s *Sharing) recordConflict(inst *instance.Instance, c *Conflict) {
+	c.State = ConflictStateOpen
+	c.CreatedAt = time.Now()
+	var err error
+	if c.CID == "" {
+		err = couchdb.CreateDoc(inst, c)
+	} else {
+		err = couchdb.CreateNamedDocWithDB(inst, c)
+	}
+	if couchdb.IsConflictError(err) {
+		// The conflict has already been recorded
+		return
+	}
+	if err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Cannot record the conflict for %s: %s", c.FileID, err)
+		return
+	}
+	if err = s.notifyConflict(inst, c); err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Cannot notify the conflict for %s: %s", c.FileID, err)
+	}
+}
+
+// notifyConflict sends a notification to the user of this instance about a
+// new conflict on the files of the sharing.
+func (s *Sharing) notifyConflict(inst *instance.Instance, c *Conflict) error {
+	policyKey := "Notifications Sharing Conflict Policy " + c.Policy
+	if c.Kind == ConflictOnPath {
+		policyKey = "Notifications Sharing Conflict Policy path"
+	}
+	link := inst.SubDomain(consts.DriveSlug)
+	if c.DirID != "" {
+		link.Fragment = "/folder/" + c.DirID
+	}
+	n := &notification.Notification{
+		CategoryID: s.SID,
+		State:      c.CID,
+		Title:      inst.Translate("Notifications Sharing Conflict Subject"),
+		Data: map[string]interface{}{
+			"SharingID":          s.SID,
+			"ConflictID":         c.CID,
+			"SharingDescription": s.Description,
+			"FileName":           c.Name,
+			"PolicyKey":          policyKey,
+			"SharingLink":        link.String(),
+		},
+	}
+	return center.PushStack(inst.Domain, center.NotificationSharingConflict, n)
+}
+
+// ListConflicts returns the conflicts recorded for the given sharing, the
+// most recent first. If state is not empty, only the conflicts in this state
+// are returned.
+func ListConflicts(inst *instance.Instance, sharingID, state string) ([]*Conflict, error) {
+	var conflicts []*Conflict
+	selector := mango.Equal("sharing_id", sharingID)
+	if state != "" {
+		selector = mango.And(selector, mango.Equal("state", state))
+	}
+	req := &couchdb.FindRequest{
+		UseIndex: "by-sharing-id",
+		Selector: selector,
+		Sort: mango.SortBy{
+			{Field: "sharing_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Limit: maxConflictsListed,
+	}
+	if err := couchdb.FindDocs(inst, consts.SharingsConflicts, req, &conflicts); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Conflict{}, nil
+		}
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// FindConflict returns the conflict with the given identifier for the
+// sharing.
+func FindConflict(inst *instance.Instance, sharingID, conflictID string) (*Conflict, error) {
+	var c Conflict
+	if err := couchdb.GetDoc(inst, consts.SharingsConflicts, conflictID, &c); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrConflictNotFound
+		}
+		return nil, err
+	}
+	if c.SharingID != sharingID {
+		return nil, ErrConflictNotFound
+	}
+	return &c, nil
+}
+
+// ResolveConflict marks a conflict as resolved by the user. With a copy of
+// the file, the user can also choose to keep only the original version or
+// only the copy: the other one is moved to the trash, and this change will be
+// replicated to the other members like any other change.
+func (s *Sharing) ResolveConflict(inst *instance.Instance, c *Conflict, resolution string) error {
+	if c.State == ConflictStateResolved {
+		return ErrConflictResolved
+	}
+	switch resolution {
+	case ResolutionKeepBoth:
+		// Nothing to do on the files
+	case ResolutionKeepOriginal:
+		if c.CopyID == "" {
+			return ErrInvalidResolution
+		}
+		if err := trashConflictCopy(inst, c.CopyID); err != nil {
+			return err
+		}
+	case ResolutionKeepCopy:
+		if c.CopyID == "" || c.Kind != ConflictOnContent {
+			return ErrInvalidResolution
+		}
+		if err := replaceWithConflictCopy(inst, c.FileID, c.CopyID); err != nil {
+			return err
+		}
+		if err := trashConflictCopy(inst, c.CopyID); err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidResolution
+	}
+	now := time.Now()
+	c.State = ConflictStateResolved
+	c.Resolution = resolution
+	c.ResolvedAt = &now
+	return couchdb.UpdateDoc(inst, c)
+}
+
+// trashConflictCopy moves the copy made for a conflict to the trash, if it
+// has not already been deleted by the user.
+func trashConflictCopy(inst *instance.Instance, copyID string) error {
+	fs := inst.VFS()
+	copied, err := fs.FileByID(copyID)
+	if err == os.ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if copied.Trashed {
+		return nil
+	}
+	_, err = vfs.TrashFile(fs, copied)
+	return err
+}
+
+// replaceWithConflictCopy puts the content of the copy in the original file,
+// as a new revision.
+func replaceWithConflictCopy(inst *instance.Instance, fileID, copyID string) error {
+	fs := inst.VFS()
+	original, err := fs.FileByID(fileID)
+	if err != nil {
+		return err
+	}
+	copied, err := fs.FileByID(copyID)
+	if err != nil {
+		return err
+	}
+	content, err := fs.OpenFile(copied)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	newdoc := original.Clone().(*vfs.FileDoc)
+	newdoc.ByteSize = copied.ByteSize
+	newdoc.MD5Sum = copied.MD5Sum
+	newdoc.Mime = copied.Mime
+	newdoc.Class = copied.Class
+	newdoc.UpdatedAt = time.Now()
+	file, err := fs.CreateFile(newdoc, original)
+	if err != nil {
+		return err
+	}
+	return copyFileContent(inst, file, content)
+}
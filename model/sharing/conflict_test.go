@@ -0,0 +1,43 @@
+package sharing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncomingWinsConflict(t *testing.T) {
+	now := time.Now()
+	local := &vfs.FileDoc{DocRev: "3-aaa", UpdatedAt: now}
+	incoming := &vfs.FileDoc{DocRev: "3-bbb", UpdatedAt: now.Add(-time.Minute)}
+
+	owner := &Sharing{Owner: true}
+	recipient := &Sharing{Owner: false}
+	assert.False(t, owner.incomingWinsConflict(ConflictPolicyOwnerWins, incoming, local))
+	assert.True(t, recipient.incomingWinsConflict(ConflictPolicyOwnerWins, incoming, local))
+
+	// The two sides of the conflict must agree on the winner
+	assert.False(t, owner.incomingWinsConflict(ConflictPolicyLastWriterWins, incoming, local))
+	assert.True(t, recipient.incomingWinsConflict(ConflictPolicyLastWriterWins, local, incoming))
+
+	incoming.UpdatedAt = now
+	assert.True(t, owner.incomingWinsConflict(ConflictPolicyLastWriterWins, incoming, local))
+	assert.False(t, recipient.incomingWinsConflict(ConflictPolicyLastWriterWins, local, incoming))
+}
+
+func TestIncomingWinsConflictGenerations(t *testing.T) {
+	now := time.Now()
+	local := &vfs.FileDoc{DocRev: "9-fff", UpdatedAt: now}
+	incoming := &vfs.FileDoc{DocRev: "10-aaa", UpdatedAt: now}
+
+	// 10-aaa is after 9-fff, even if "10-aaa" < "9-fff" as strings
+	s := &Sharing{Owner: true}
+	assert.True(t, s.incomingWinsConflict(ConflictPolicyLastWriterWins, incoming, local))
+	assert.False(t, s.incomingWinsConflict(ConflictPolicyLastWriterWins, local, incoming))
+
+	assert.Equal(t, 1, compareRevisions("10-aaa", "9-fff"))
+	assert.Equal(t, -1, compareRevisions("3-aaa", "3-bbb"))
+	assert.Equal(t, 0, compareRevisions("3-aaa", "3-aaa"))
+}
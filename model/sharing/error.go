@@ -46,4 +46,19 @@ var (
 	// ErrAlreadyAccepted is used when someone tries to accept twice a sharing
 	// on the same cozy instance
 	ErrAlreadyAccepted = errors.New("Sharing already accepted by this recipient")
+	// ErrInvalidResolution is used when a conflict cannot be resolved in the
+	// asked way
+	ErrInvalidResolution = errors.New("This resolution is not possible for this conflict")
+	// ErrConflictResolved is used when trying to resolve a conflict that has
+	// already been resolved
+	ErrConflictResolved = errors.New("This conflict has already been resolved")
+	// ErrConflictNotFound is used when a conflict was not found for the
+	// sharing
+	ErrConflictNotFound = errors.New("This conflict was not found")
+	// ErrInvalidExpiry is used when an expiration date for a sharing or a
+	// member is not in the future
+	ErrInvalidExpiry = errors.New("The expiration date must be in the future")
+	// ErrNoSharedFolder is used when a file or folder is added at the top
+	// level of a sharing with several files, but no folder, in its rule
+	ErrNoSharedFolder = errors.New("No shared folder for the files added at the top level of the sharing")
 )
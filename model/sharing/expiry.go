@@ -0,0 +1,279 @@
+package sharing
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// ExpiryReminderDelay is how long before the expiration of a sharing, or of
+// the access of a member, the owner is reminded that it will expire.
+const ExpiryReminderDelay = 3 * 24 * time.Hour
+
+// ExpireMsg is used for jobs on the share-expire worker.
+type ExpireMsg struct {
+	SharingID string `json:"sharing_id"`
+	Reminder  bool   `json:"reminder,omitempty"`
+}
+
+// isExpired returns true if the given expiration date has been reached.
+func isExpired(expiresAt *time.Time, now time.Time) bool {
+	return expiresAt != nil && !expiresAt.After(now)
+}
+
+// isExpiringSoon returns true if the given expiration date is in the window
+// where a reminder should be sent.
+func isExpiringSoon(expiresAt *time.Time, now time.Time) bool {
+	if expiresAt == nil || !expiresAt.After(now) {
+		return false
+	}
+	return !expiresAt.Add(-ExpiryReminderDelay).After(now)
+}
+
+// AccessExpired returns true if the sharing, or the access of the given
+// member (it can be nil), has reached its expiration date. It is checked with
+// the credentials of the recipients, as the access must be refused from this
+// date, even if the share-expire job has not yet cleaned the sharing.
+func (s *Sharing) AccessExpired(m *Member) bool {
+	now := time.Now()
+	if isExpired(s.ExpiresAt, now) {
+		return true
+	}
+	return m != nil && isExpired(m.ExpiresAt, now)
+}
+
+// ValidateExpiry checks that the expiration dates of the sharing and of its
+// recipients are in the future.
+func (s *Sharing) ValidateExpiry() error {
+	now := time.Now()
+	if isExpired(s.ExpiresAt, now) {
+		return ErrInvalidExpiry
+	}
+	for i, m := range s.Members {
+		if i > 0 && m.Status != MemberStatusRevoked && isExpired(m.ExpiresAt, now) {
+			return ErrInvalidExpiry
+		}
+	}
+	return nil
+}
+
+// expiryDates returns the expiration dates of the sharing and of its active
+// recipients, without duplicates, and sorted.
+func (s *Sharing) expiryDates() []time.Time {
+	seen := make(map[int64]bool)
+	var dates []time.Time
+	add := func(at *time.Time) {
+		if at == nil || seen[at.UnixNano()] {
+			return
+		}
+		seen[at.UnixNano()] = true
+		dates = append(dates, at.UTC())
+	}
+	add(s.ExpiresAt)
+	for i, m := range s.Members {
+		if i > 0 && m.Status != MemberStatusRevoked {
+			add(m.ExpiresAt)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates
+}
+
+// AddExpiryTriggers creates the @at triggers for the share-expire worker, to
+// clean the sharing when the expiration dates are reached, and to remind the
+// owner a few days before. The previous triggers for this sharing are removed
+// first. When they are executed, the worker checks the dates on the sharing,
+// so a trigger for a date that has been changed since is harmless. The errors
+// are only logged.
+func (s *Sharing) AddExpiryTriggers(inst *instance.Instance) {
+	if err := s.removeExpiryTriggers(inst); err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Cannot remove the expiry triggers for %s: %s", s.SID, err)
+	}
+	now := time.Now()
+	for _, at := range s.expiryDates() {
+		if !at.After(now) {
+			continue
+		}
+		if reminder := at.Add(-ExpiryReminderDelay); reminder.After(now) {
+			s.addExpiryTrigger(inst, reminder, true)
+		}
+		s.addExpiryTrigger(inst, at, false)
+	}
+}
+
+// removeExpiryTriggers deletes the triggers of the share-expire worker for
+// this sharing.
+func (s *Sharing) removeExpiryTriggers(inst *instance.Instance) error {
+	sched := job.System()
+	triggers, err := sched.GetAllTriggers(inst)
+	if err != nil {
+		return err
+	}
+	for _, t := range triggers {
+		infos := t.Infos()
+		if infos.WorkerType != "share-expire" {
+			continue
+		}
+		var msg ExpireMsg
+		if err := json.Unmarshal(infos.Message, &msg); err != nil || msg.SharingID != s.SID {
+			continue
+		}
+		if err := sched.DeleteTrigger(inst, infos.TID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sharing) addExpiryTrigger(inst *instance.Instance, at time.Time, reminder bool) {
+	msg := &ExpireMsg{
+		SharingID: s.SID,
+		Reminder:  reminder,
+	}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Domain:     inst.ContextualDomain(),
+		Type:       "@at",
+		WorkerType: "share-expire",
+		Arguments:  at.Format(time.RFC3339Nano),
+	}, msg)
+	if err == nil {
+		err = job.System().AddTrigger(t)
+	}
+	if err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Cannot add the expiry trigger for %s: %s", s.SID, err)
+	}
+}
+
+// SetExpiry changes the expiration date of the sharing. A nil date means that
+// the sharing no longer expires.
+func (s *Sharing) SetExpiry(inst *instance.Instance, expiresAt *time.Time) error {
+	if !s.Owner || !s.Active {
+		return ErrInvalidSharing
+	}
+	if isExpired(expiresAt, time.Now()) {
+		return ErrInvalidExpiry
+	}
+	s.ExpiresAt = expiresAt
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+	if s.PreviewPath != "" {
+		if perms, err := permission.GetForSharePreview(inst, s.SID); err == nil {
+			perms.ExpiresAt = expiresAt
+			if err := couchdb.UpdateDoc(inst, perms); err != nil {
+				return err
+			}
+		}
+	}
+	s.AddExpiryTriggers(inst)
+	return nil
+}
+
+// SetMemberExpiry changes the expiration date of the access for the
+// recipient at the given index. A nil date means that the access no longer
+// expires.
+func (s *Sharing) SetMemberExpiry(inst *instance.Instance, index int, expiresAt *time.Time) error {
+	if !s.Owner || !s.Active {
+		return ErrInvalidSharing
+	}
+	if index < 1 || index >= len(s.Members) {
+		return ErrMemberNotFound
+	}
+	if s.Members[index].Status == MemberStatusRevoked {
+		return ErrMemberNotFound
+	}
+	if isExpired(expiresAt, time.Now()) {
+		return ErrInvalidExpiry
+	}
+	s.Members[index].ExpiresAt = expiresAt
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+	s.AddExpiryTriggers(inst)
+	return nil
+}
+
+// ExpireAccess revokes the sharing if its expiration date has been reached,
+// or else the recipients whose access has expired. It is called by the
+// share-expire worker, and it is only a cleanup: the access is already
+// refused by AccessExpired from the expiration date.
+func (s *Sharing) ExpireAccess(inst *instance.Instance) error {
+	if !s.Owner || !s.Active {
+		return nil
+	}
+	now := time.Now()
+	if isExpired(s.ExpiresAt, now) {
+		inst.Logger().WithField("nspace", "sharing").
+			Infof("Sharing %s has expired", s.SID)
+		return s.Revoke(inst)
+	}
+	for i := 1; i < len(s.Members) && s.Active; i++ {
+		m := &s.Members[i]
+		if m.Status == MemberStatusRevoked || !isExpired(m.ExpiresAt, now) {
+			continue
+		}
+		inst.Logger().WithField("nspace", "sharing").
+			Infof("Access of member %d has expired for sharing %s", i, s.SID)
+		if err := s.RevokeRecipient(inst, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemindExpiry sends a notification to the owner for the sharing, or the
+// recipients, that will expire in the next days. It is called by the
+// share-expire worker.
+func (s *Sharing) RemindExpiry(inst *instance.Instance) error {
+	if !s.Owner || !s.Active {
+		return nil
+	}
+	now := time.Now()
+	if isExpiringSoon(s.ExpiresAt, now) {
+		return s.notifyExpiry(inst, 0, s.ExpiresAt)
+	}
+	for i, m := range s.Members {
+		if i == 0 || m.Status == MemberStatusRevoked || !isExpiringSoon(m.ExpiresAt, now) {
+			continue
+		}
+		if err := s.notifyExpiry(inst, i, m.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyExpiry sends a notification to the owner about the coming expiration
+// of the sharing (index is 0), or of the access of a recipient.
+func (s *Sharing) notifyExpiry(inst *instance.Instance, index int, expiresAt *time.Time) error {
+	memberName := ""
+	if index > 0 {
+		memberName = s.Members[index].PrimaryName()
+	}
+	link := inst.SubDomain(consts.DriveSlug)
+	link.Fragment = "/sharings"
+	n := &notification.Notification{
+		CategoryID: s.SID + "-" + strconv.Itoa(index),
+		State:      expiresAt.UTC().Format(time.RFC3339),
+		Title:      inst.Translate("Notifications Sharing Expiry Subject"),
+		Data: map[string]interface{}{
+			"SharingID":          s.SID,
+			"SharingDescription": s.Description,
+			"MemberName":         memberName,
+			"ExpiresAt":          expiresAt.UTC().Format("2006-01-02 15:04 MST"),
+			"SharingLink":        link.String(),
+		},
+	}
+	return center.PushStack(inst.Domain, center.NotificationSharingExpiry, n)
+}
@@ -0,0 +1,88 @@
+package sharing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryChecks(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	soon := now.Add(24 * time.Hour)
+	later := now.Add(10 * 24 * time.Hour)
+
+	assert.False(t, isExpired(nil, now))
+	assert.True(t, isExpired(&past, now))
+	assert.True(t, isExpired(&now, now))
+	assert.False(t, isExpired(&soon, now))
+
+	assert.False(t, isExpiringSoon(nil, now))
+	assert.False(t, isExpiringSoon(&past, now))
+	assert.True(t, isExpiringSoon(&soon, now))
+	assert.False(t, isExpiringSoon(&later, now))
+}
+
+func TestValidateExpiry(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	later := now.Add(10 * 24 * time.Hour)
+
+	s := &Sharing{
+		ExpiresAt: &later,
+		Members: []Member{
+			{Status: MemberStatusOwner},
+			{Status: MemberStatusReady, ExpiresAt: &later},
+			{Status: MemberStatusRevoked, ExpiresAt: &past},
+		},
+	}
+	assert.NoError(t, s.ValidateExpiry())
+	s.Members[1].ExpiresAt = &past
+	assert.Equal(t, ErrInvalidExpiry, s.ValidateExpiry())
+	s.Members[1].ExpiresAt = nil
+	s.ExpiresAt = &past
+	assert.Equal(t, ErrInvalidExpiry, s.ValidateExpiry())
+}
+
+func TestExpiryDates(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(24 * time.Hour)
+	later := now.Add(10 * 24 * time.Hour)
+
+	s := &Sharing{
+		ExpiresAt: &later,
+		Members: []Member{
+			{Status: MemberStatusOwner},
+			{Status: MemberStatusReady, ExpiresAt: &later},
+			{Status: MemberStatusReady, ExpiresAt: &soon},
+			{Status: MemberStatusRevoked, ExpiresAt: &now},
+		},
+	}
+	dates := s.expiryDates()
+	if assert.Len(t, dates, 2) {
+		assert.True(t, dates[0].Equal(soon))
+		assert.True(t, dates[1].Equal(later))
+	}
+}
+
+func TestAccessExpired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	later := now.Add(10 * 24 * time.Hour)
+
+	s := &Sharing{
+		Members: []Member{
+			{Status: MemberStatusOwner},
+			{Status: MemberStatusReady, ExpiresAt: &later},
+			{Status: MemberStatusReady, ExpiresAt: &past},
+		},
+	}
+	assert.False(t, s.AccessExpired(nil))
+	assert.False(t, s.AccessExpired(&s.Members[1]))
+	assert.True(t, s.AccessExpired(&s.Members[2]))
+
+	s.ExpiresAt = &past
+	assert.True(t, s.AccessExpired(nil))
+	assert.True(t, s.AccessExpired(&s.Members[1]))
+}
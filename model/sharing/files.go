@@ -104,12 +104,14 @@ func (s *Sharing) SortFilesToSent(files []map[string]interface{}) {
 // - its identifier is XORed
 // - its dir_id is XORed or removed
 // - the path is removed (directory only)
+// - the encryption key is removed, as it is specific to this instance
 //
 // ruleIndexes is a map of "doctype-docid" -> rule index
 func (s *Sharing) TransformFileToSent(doc map[string]interface{}, xorKey []byte, ruleIndex int) {
 	if doc["type"] == consts.DirType {
 		delete(doc, "path")
 	}
+	delete(doc, "encryption_key_id")
 	id := doc["_id"].(string)
 	doc["_id"] = XorID(id, xorKey)
 	dir, ok := doc["dir_id"].(string)
@@ -144,10 +146,17 @@ func (s *Sharing) TransformFileToSent(doc map[string]interface{}, xorKey []byte,
 		delete(doc, couchdb.SelectorReferencedBy)
 	}
 	if !noDirID {
-		for _, v := range rule.Values {
-			if v == dir {
-				noDirID = true
-				break
+		if rule.hasSeveralRoots() {
+			// The shared folders are not the sharing directory, so their
+			// children keep their dir_id, except for the children of the
+			// sharing directory of a recipient.
+			noDirID = !s.Owner && dir == s.SID
+		} else {
+			for _, v := range rule.Values {
+				if v == dir {
+					noDirID = true
+					break
+				}
 			}
 		}
 	}
@@ -232,11 +241,16 @@ func (s *Sharing) CreateDirForSharing(inst *instance.Instance, rule *Rule) (*vfs
 	}
 	fs := inst.VFS()
 	dir, err := vfs.NewDirDocWithParent(rule.Title, parent, []string{"from-sharing-" + s.SID})
-	parts := strings.Split(rule.Values[0], "/")
-	dir.DocID = parts[len(parts)-1]
 	if err != nil {
 		return nil, err
 	}
+	if rule.hasSeveralRoots() {
+		// The shared files and folders will be put inside this directory
+		dir.DocID = s.SID
+	} else {
+		parts := strings.Split(rule.Values[0], "/")
+		dir.DocID = parts[len(parts)-1]
+	}
 	dir.AddReferencedBy(couchdb.DocReference{
 		ID:   s.SID,
 		Type: consts.Sharings,
@@ -254,11 +268,25 @@ func (s *Sharing) CreateDirForSharing(inst *instance.Instance, rule *Rule) (*vfs
 	return dir, err
 }
 
-// AddReferenceForSharingDir adds a reference to the sharing on the sharing directory
+// AddReferenceForSharingDir adds a reference to the sharing on the shared
+// directories of the rule
 func (s *Sharing) AddReferenceForSharingDir(inst *instance.Instance, rule *Rule) error {
+	values := rule.Values
+	if !rule.hasSeveralRoots() {
+		values = values[:1]
+	}
+	for _, val := range values {
+		parts := strings.Split(val, "/")
+		if err := s.addReferenceForDir(inst, parts[len(parts)-1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sharing) addReferenceForDir(inst *instance.Instance, dirID string) error {
 	fs := inst.VFS()
-	parts := strings.Split(rule.Values[0], "/")
-	dir, _, err := fs.DirOrFileByID(parts[len(parts)-1])
+	dir, _, err := fs.DirOrFileByID(dirID)
 	if err != nil || dir == nil {
 		return err
 	}
@@ -283,6 +311,9 @@ func (s *Sharing) AddReferenceForSharingDir(inst *instance.Instance, rule *Rule)
 // GetSharingDir returns the directory used by this sharing for putting files
 // and folders that have no dir_id.
 func (s *Sharing) GetSharingDir(inst *instance.Instance) (*vfs.DirDoc, error) {
+	if rule := s.FirstFilesRule(); rule != nil && rule.hasSeveralRoots() {
+		return s.getSharingDirForSeveralRoots(inst, rule)
+	}
 	key := []string{consts.Sharings, s.SID}
 	end := []string{key[0], key[1], couchdb.MaxString}
 	req := &couchdb.ViewRequest{
@@ -307,6 +338,37 @@ func (s *Sharing) GetSharingDir(inst *instance.Instance) (*vfs.DirDoc, error) {
 	return inst.VFS().DirByID(res.Rows[0].ID)
 }
 
+// getSharingDirForSeveralRoots returns the directory used for the files and
+// folders that have no dir_id, for a rule with several files and folders. On
+// a recipient's cozy, it is the directory created for the sharing. On the
+// owner's cozy, the shared files and folders are referenced by the sharing
+// but they are not in a common directory: the files and folders added at the
+// top level by a recipient are put in the first shared folder, in the order of
+// the values of the rule, and they are rejected if only files are shared.
+func (s *Sharing) getSharingDirForSeveralRoots(inst *instance.Instance, rule *Rule) (*vfs.DirDoc, error) {
+	fs := inst.VFS()
+	if !s.Owner {
+		dir, err := fs.DirByID(s.SID)
+		if os.IsNotExist(err) {
+			return s.CreateDirForSharing(inst, rule)
+		}
+		return dir, err
+	}
+	for _, val := range rule.Values {
+		dir, _, err := fs.DirOrFileByID(val)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dir != nil {
+			return dir, nil
+		}
+	}
+	return nil, ErrNoSharedFolder
+}
+
 // RemoveSharingDir removes the reference on the sharing directory.
 // It should be called when a sharing is revoked, on the recipient Cozy.
 func (s *Sharing) RemoveSharingDir(inst *instance.Instance) error {
@@ -627,6 +689,8 @@ func copySafeFieldsToDir(target map[string]interface{}, dir *vfs.DirDoc) {
 // If the winner is the local file/folder, this function returns the new name
 // and let the caller do its operation with the new name (the caller should
 // create a dummy revision to let the other cozy know of the renaming).
+//
+// In both cases, the conflict is recorded for the user.
 func (s *Sharing) resolveConflictSamePath(inst *instance.Instance, visitorID, pth string) (string, error) {
 	inst.Logger().WithField("nspace", "replicator").
 		Infof("Resolve conflict for path=%s (docid=%s)", pth, visitorID)
@@ -637,6 +701,7 @@ func (s *Sharing) resolveConflictSamePath(inst *instance.Instance, visitorID, pt
 	}
 	name := conflictName(path.Base(pth), "")
 	xorKey := s.Credentials[0].XorKey
+	renamedID := visitorID
 	if d != nil {
 		homeID := d.DocID
 		if !s.Owner {
@@ -644,11 +709,16 @@ func (s *Sharing) resolveConflictSamePath(inst *instance.Instance, visitorID, pt
 			visitorID = XorID(visitorID, xorKey)
 		}
 		if homeID > visitorID {
+			s.recordConflict(inst, s.newPathConflict(inst, renamedID, d.DirID, name))
 			return name, nil
 		}
 		old := d.Clone().(*vfs.DirDoc)
 		d.DocName = name
-		return "", fs.UpdateDirDoc(old, d)
+		if err = fs.UpdateDirDoc(old, d); err != nil {
+			return "", err
+		}
+		s.recordConflict(inst, s.newPathConflict(inst, d.DocID, d.DirID, name))
+		return "", nil
 	}
 	homeID := f.DocID
 	if !s.Owner {
@@ -656,12 +726,17 @@ func (s *Sharing) resolveConflictSamePath(inst *instance.Instance, visitorID, pt
 		visitorID = XorID(visitorID, xorKey)
 	}
 	if homeID > visitorID {
+		s.recordConflict(inst, s.newPathConflict(inst, renamedID, f.DirID, name))
 		return name, nil
 	}
 	old := f.Clone().(*vfs.FileDoc)
 	f.DocName = name
 	f.ResetFullpath()
-	return "", fs.UpdateFileDoc(old, f)
+	if err = fs.UpdateFileDoc(old, f); err != nil {
+		return "", err
+	}
+	s.recordConflict(inst, s.newPathConflict(inst, f.DocID, f.DirID, name))
+	return "", nil
 }
 
 //getDirDocFromInstance fetches informations about a directory from the given
@@ -850,7 +925,11 @@ func (s *Sharing) CreateDir(inst *instance.Instance, target map[string]interface
 // prepareDirWithAncestors find the parent directory for dir, and recreates it
 // if it is missing.
 func (s *Sharing) prepareDirWithAncestors(inst *instance.Instance, dir *vfs.DirDoc, dirID string) error {
-	if dirID == "" {
+	if dirID == "" && s.Owner && s.isSharedRoot(dir.DocID) {
+		// On the owner's cozy, a folder without dir_id is one of the shared
+		// folders, and it stays where it is.
+		dir.Fullpath = path.Join(path.Dir(dir.Fullpath), dir.DocName)
+	} else if dirID == "" {
 		parent, err := s.GetSharingDir(inst)
 		if err != nil {
 			return err
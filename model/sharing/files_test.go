@@ -2,7 +2,9 @@ package sharing
 
 import (
 	"testing"
+	"time"
 
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/stretchr/testify/assert"
@@ -57,6 +59,36 @@ func TestSortFilesToSent(t *testing.T) {
 	assert.Equal(t, expected, files)
 }
 
+func TestTransformFileToSentSeveralRoots(t *testing.T) {
+	key := []byte{1}
+	s := &Sharing{
+		SID:   "8c2b3eb5a5f58d37",
+		Owner: true,
+		Rules: []Rule{
+			{
+				Title:   "Test several roots",
+				DocType: consts.Files,
+				Values:  []string{"a1", "b2"},
+			},
+		},
+	}
+
+	root := map[string]interface{}{"_id": "a1", "type": "directory", "dir_id": "c3", "path": "/foo"}
+	s.TransformFileToSent(root, key, 0)
+	assert.Equal(t, XorID("a1", key), root["_id"])
+	assert.NotContains(t, root, "dir_id")
+	assert.NotContains(t, root, "path")
+
+	child := map[string]interface{}{"_id": "d4", "type": "file", "dir_id": "a1"}
+	s.TransformFileToSent(child, key, 0)
+	assert.Equal(t, XorID("a1", key), child["dir_id"])
+
+	s.Owner = false
+	top := map[string]interface{}{"_id": "e5", "type": "file", "dir_id": s.SID}
+	s.TransformFileToSent(top, key, 0)
+	assert.NotContains(t, top, "dir_id")
+}
+
 func TestSharingDir(t *testing.T) {
 	s := Sharing{
 		SID: uuidv4(),
@@ -98,6 +130,62 @@ func TestSharingDir(t *testing.T) {
 	assert.Len(t, res.Rows, 0)
 }
 
+func TestSharingDirSeveralRoots(t *testing.T) {
+	fs := inst.VFS()
+	file, err := vfs.NewFileDoc("several-roots.txt", consts.RootDirID, 3, nil,
+		"text/plain", "text", time.Now(), false, false, nil)
+	assert.NoError(t, err)
+	f, err := fs.CreateFile(file, nil)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("foo"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	dir1, err := vfs.Mkdir(fs, "/several-roots-1", nil)
+	assert.NoError(t, err)
+	dir2, err := vfs.Mkdir(fs, "/several-roots-2", nil)
+	assert.NoError(t, err)
+
+	// On the owner's cozy, the first shared folder is used, whatever the
+	// references on the files
+	s := Sharing{
+		SID:   uuidv4(),
+		Owner: true,
+		Rules: []Rule{
+			{
+				Title:   "Test several roots",
+				DocType: consts.Files,
+				Values:  []string{file.ID(), dir2.ID(), dir1.ID()},
+			},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		d, err := s.GetSharingDir(inst)
+		assert.NoError(t, err)
+		if assert.NotNil(t, d) {
+			assert.Equal(t, dir2.ID(), d.ID())
+		}
+	}
+
+	// With only files, the files added at the top level are rejected
+	s.Rules[0].Values = []string{file.ID(), uuidv4()}
+	_, err = s.GetSharingDir(inst)
+	assert.Equal(t, ErrNoSharedFolder, err)
+
+	// On a recipient's cozy, the directory of the sharing is used
+	s.Owner = false
+	d1, err := s.GetSharingDir(inst)
+	assert.NoError(t, err)
+	if assert.NotNil(t, d1) {
+		assert.Equal(t, s.SID, d1.ID())
+		assert.Equal(t, "/Tree Shared with me/Test several roots", d1.Fullpath)
+	}
+	d2, err := s.GetSharingDir(inst)
+	assert.NoError(t, err)
+	if assert.NotNil(t, d2) {
+		assert.Equal(t, s.SID, d2.ID())
+	}
+}
+
 func TestCreateDir(t *testing.T) {
 	s := Sharing{
 		SID: uuidv4(),
@@ -234,6 +234,9 @@ func (s *sharingIndexer) bulkForceUpdateDoc(doc *vfs.FileDoc) error {
 	if doc.InternalID != "" {
 		docs[0]["internal_vfs_id"] = doc.InternalID
 	}
+	if doc.EncryptionKeyID != 0 {
+		docs[0]["encryption_key_id"] = doc.EncryptionKeyID
+	}
 	doc.SetRev(s.bulkRevs.Rev)
 	docs[0]["_rev"] = s.bulkRevs.Rev
 	docs[0]["_revisions"] = s.bulkRevs.Revisions
@@ -354,6 +357,10 @@ func (s *sharingIndexer) CreateVersion(v *vfs.Version) error {
 	return s.indexer.CreateVersion(v)
 }
 
+func (s *sharingIndexer) UpdateVersion(v *vfs.Version) error {
+	return s.indexer.UpdateVersion(v)
+}
+
 func (s *sharingIndexer) DeleteVersion(v *vfs.Version) error {
 	return s.indexer.DeleteVersion(v)
 }
@@ -38,12 +38,13 @@ const (
 
 // Member contains the information about a recipient (or the sharer) for a sharing
 type Member struct {
-	Status     string `json:"status"`
-	Name       string `json:"name,omitempty"`
-	PublicName string `json:"public_name,omitempty"`
-	Email      string `json:"email,omitempty"`
-	Instance   string `json:"instance,omitempty"`
-	ReadOnly   bool   `json:"read_only,omitempty"`
+	Status     string     `json:"status"`
+	Name       string     `json:"name,omitempty"`
+	PublicName string     `json:"public_name,omitempty"`
+	Email      string     `json:"email,omitempty"`
+	Instance   string     `json:"instance,omitempty"`
+	ReadOnly   bool       `json:"read_only,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
 }
 
 // PrimaryName returns the main name of this member
@@ -122,6 +123,31 @@ func (s *Sharing) AddContact(inst *instance.Instance, contactID string, readOnly
 		Instance: cozyURL,
 		ReadOnly: readOnly,
 	}
+	s.addMember(m)
+	return nil
+}
+
+// AddEmail adds a recipient with the given email address. It is used by the
+// stack for the sharings that it creates itself, like the ones for the
+// bitwarden organizations, where the members are known by their email
+// addresses.
+func (s *Sharing) AddEmail(inst *instance.Instance, email string, readOnly bool) {
+	m := Member{
+		Status:   MemberStatusMailNotSent,
+		Email:    email,
+		ReadOnly: readOnly,
+	}
+	if c, err := contact.FindByEmail(inst, email); err == nil {
+		m.Name = c.PrimaryName()
+		m.Instance = c.PrimaryCozyURL()
+	}
+	s.addMember(m)
+}
+
+// addMember adds the member to the sharing, or resets a member with the same
+// email address (or the same instance URL when there is no email address) if
+// the sharing is not ready for this member.
+func (s *Sharing) addMember(m Member) {
 	idx := -1
 	for i, member := range s.Members {
 		if i == 0 {
@@ -154,7 +180,6 @@ func (s *Sharing) AddContact(inst *instance.Instance, contactID string, readOnly
 	} else {
 		s.Credentials[idx-1] = creds
 	}
-	return nil
 }
 
 // APIDelegateAddContacts is used to serialize a request to add contacts to
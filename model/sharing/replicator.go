@@ -46,6 +46,7 @@ func (s *Sharing) Replicate(inst *instance.Instance, errors int) error {
 	var errm error
 	if !s.Owner {
 		pending, errm = s.ReplicateTo(inst, &s.Members[0], false)
+		s.updateSyncStatus(inst, &s.Members[0], "replicator", errm)
 	} else {
 		for i, m := range s.Members {
 			if i == 0 {
@@ -53,6 +54,7 @@ func (s *Sharing) Replicate(inst *instance.Instance, errors int) error {
 			}
 			if m.Status == MemberStatusReady {
 				p, err := s.ReplicateTo(inst, &s.Members[i], false)
+				s.updateSyncStatus(inst, &s.Members[i], "replicator", err)
 				if err != nil {
 					errm = multierror.Append(errm, err)
 				} else if p {
@@ -229,6 +231,35 @@ func (s *Sharing) UpdateLastSequenceNumber(inst *instance.Instance, m *Member, w
 	return couchdb.PutLocal(inst, consts.Shared, id+"/"+worker, result)
 }
 
+// updateSyncStatus saves the result of the last replication or upload to a
+// member, next to the last sequence number, for the sync status.
+func (s *Sharing) updateSyncStatus(inst *instance.Instance, m *Member, worker string, errm error) {
+	id, err := s.replicationID(m)
+	if err != nil {
+		return
+	}
+	result, err := couchdb.GetLocal(inst, consts.Shared, id+"/"+worker)
+	if err != nil {
+		if !couchdb.IsNotFoundError(err) {
+			return
+		}
+		result = make(map[string]interface{})
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	if errm != nil {
+		result["last_error"] = errm.Error()
+		result["last_error_at"] = now
+	} else {
+		delete(result, "last_error")
+		delete(result, "last_error_at")
+		result["last_success_at"] = now
+	}
+	if err = couchdb.PutLocal(inst, consts.Shared, id+"/"+worker, result); err != nil {
+		inst.Logger().WithField("nspace", "replicator").
+			Warnf("Cannot save the sync status for %s: %s", id, err)
+	}
+}
+
 // ClearLastSequenceNumbers removes the last sequence numbers for a member
 func (s *Sharing) ClearLastSequenceNumbers(inst *instance.Instance, m *Member) error {
 	errr := s.clearLastSequenceNumber(inst, m, "replicator")
@@ -576,20 +607,27 @@ func (s *Sharing) sendBulkDocs(inst *instance.Instance, m *Member, creds *Creden
 	return nil
 }
 
-// ApplyBulkDocs is a multi-doctypes version of the POST _bulk_docs endpoint of CouchDB
-func (s *Sharing) ApplyBulkDocs(inst *instance.Instance, payload DocsByDoctype) error {
+// ApplyBulkDocs is a multi-doctypes version of the POST _bulk_docs endpoint of
+// CouchDB. The member is the one who has sent the documents, and the changes
+// are recorded in the activity journal for them.
+func (s *Sharing) ApplyBulkDocs(inst *instance.Instance, m *Member, payload DocsByDoctype) error {
 	var refs []*SharedRef
+	var activities []*Activity
+	defer func() { s.recordActivities(inst, activities) }()
 
 	for doctype, docs := range payload {
 		inst.Logger().WithField("nspace", "replicator").
 			Debugf("Apply bulk docs %s: %#v", doctype, docs)
 		if doctype == consts.Files {
+			acts := s.activitiesForBulkDocs(inst, m, doctype, docs)
 			err := s.ApplyBulkFiles(inst, docs)
 			if err != nil {
 				return err
 			}
+			activities = append(activities, acts...)
 			continue
 		}
+		acts := s.activitiesForBulkDocs(inst, m, doctype, docs)
 		var okDocs, docsToUpdate DocsList
 		var newRefs, existingRefs []*SharedRef
 		newDocs, existingDocs, err := partitionDocsPayload(inst, doctype, docs)
@@ -614,6 +652,7 @@ func (s *Sharing) ApplyBulkDocs(inst *instance.Instance, payload DocsByDoctype)
 			}
 			refs = append(refs, newRefs...)
 			refs = append(refs, existingRefs...)
+			activities = append(activities, filterActivities(acts, okDocs)...)
 		}
 	}
 
@@ -1,8 +1,10 @@
 package sharing
 
 import (
+	"os"
 	"strings"
 
+	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -22,6 +24,18 @@ const (
 	ActionRuleRevoke = "revoke"
 )
 
+const (
+	// ConflictPolicyKeepBoth is the default policy for the conflicts: the
+	// version of the file that has lost the conflict is kept as a copy
+	ConflictPolicyKeepBoth = "keep-both"
+	// ConflictPolicyLastWriterWins is used when the version of the file that
+	// has been modified the last wins the conflict, and the other is dropped
+	ConflictPolicyLastWriterWins = "last-writer-wins"
+	// ConflictPolicyOwnerWins is used when the version of the file on the
+	// owner's cozy wins the conflict, and the other is dropped
+	ConflictPolicyOwnerWins = "owner-wins"
+)
+
 // Rule describes how the sharing behave when a document matching the rule is
 // added, updated or deleted.
 type Rule struct {
@@ -33,6 +47,10 @@ type Rule struct {
 	Add      string   `json:"add"`
 	Update   string   `json:"update"`
 	Remove   string   `json:"remove"`
+
+	// ConflictPolicy says how a conflict between two versions of the content
+	// of a file is resolved (only for the files).
+	ConflictPolicy string `json:"conflict_policy,omitempty"`
 }
 
 // FilesByID returns true if the rule is for the files by doctype and the
@@ -45,6 +63,14 @@ func (r Rule) FilesByID() bool {
 	return r.Selector == "" || r.Selector == "id" || r.Selector == "_id"
 }
 
+// hasSeveralRoots returns true for a rule on files by id with more than one
+// file or folder. For such a rule, the sharing directory of the recipients is
+// a new folder that contains the shared files and folders, instead of being
+// the shared folder itself.
+func (r Rule) hasSeveralRoots() bool {
+	return r.FilesByID() && len(r.Values) > 1
+}
+
 // ValidateRules returns an error if the rules are invalid (the doctype is
 // missing for example)
 func (s *Sharing) ValidateRules() error {
@@ -63,12 +89,23 @@ func (s *Sharing) ValidateRules() error {
 					return ErrInvalidRule
 				}
 			}
-			// XXX Currently, we only support one file/folder per rule for the id selector
-			if rule.Selector == "" || rule.Selector == "id" || rule.Selector == "_id" {
-				if len(rule.Values) > 1 {
-					return ErrInvalidRule
+			if rule.FilesByID() {
+				seen := make(map[string]bool, len(rule.Values))
+				for _, val := range rule.Values {
+					if seen[val] {
+						return ErrInvalidRule
+					}
+					seen[val] = true
 				}
 			}
+			switch strings.ToLower(rule.ConflictPolicy) {
+			case "", ConflictPolicyKeepBoth:
+				s.Rules[i].ConflictPolicy = ""
+			case ConflictPolicyLastWriterWins, ConflictPolicyOwnerWins:
+				s.Rules[i].ConflictPolicy = strings.ToLower(rule.ConflictPolicy)
+			default:
+				return ErrInvalidRule
+			}
 			if rule.Selector == couchdb.SelectorReferencedBy {
 				// For a referenced_by rule, values should be "doctype/docid"
 				for _, val := range rule.Values {
@@ -78,7 +115,8 @@ func (s *Sharing) ValidateRules() error {
 					}
 				}
 			}
-		} else if permission.CheckWritable(rule.DocType) != nil {
+		} else if permission.CheckWritable(rule.DocType) != nil ||
+			rule.ConflictPolicy != "" {
 			return ErrInvalidRule
 		}
 		if rule.Add == "" {
@@ -116,6 +154,15 @@ func (s *Sharing) ValidateRules() error {
 	return nil
 }
 
+// conflictPolicy returns the policy to use for resolving the conflicts on the
+// content of the files.
+func (r Rule) conflictPolicy() string {
+	if r.ConflictPolicy == "" {
+		return ConflictPolicyKeepBoth
+	}
+	return r.ConflictPolicy
+}
+
 // Accept returns true if the document matches the rule criteria
 func (r Rule) Accept(doctype string, doc map[string]interface{}) bool {
 	if r.Local || doctype != r.DocType {
@@ -192,6 +239,62 @@ func (r Rule) TriggerArgs() string {
 	return args
 }
 
+// isSharedRoot returns true if the given identifier is one of the files or
+// folders shared by a rule on files by id.
+func (s *Sharing) isSharedRoot(fileID string) bool {
+	for _, rule := range s.Rules {
+		if rule.Local || !rule.FilesByID() {
+			continue
+		}
+		for _, val := range rule.Values {
+			if val == fileID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkNestedRoots returns an error if a file or folder of a rule on files
+// by id is inside another folder of the same rule, as it would be shared
+// twice.
+func (s *Sharing) checkNestedRoots(inst *instance.Instance) error {
+	fs := inst.VFS()
+	for _, rule := range s.Rules {
+		if rule.Local || !rule.hasSeveralRoots() {
+			continue
+		}
+		var dirs, paths []string
+		for _, val := range rule.Values {
+			dir, file, err := fs.DirOrFileByID(val)
+			if err == os.ErrNotExist {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if dir != nil {
+				dirs = append(dirs, dir.Fullpath)
+				paths = append(paths, dir.Fullpath)
+			} else {
+				pth, err := file.Path(fs)
+				if err != nil {
+					return err
+				}
+				paths = append(paths, pth)
+			}
+		}
+		for _, dir := range dirs {
+			for _, pth := range paths {
+				if strings.HasPrefix(pth, dir+"/") {
+					return ErrInvalidRule
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // FirstFilesRule returns the first not-local rules for the files doctype
 func (s *Sharing) FirstFilesRule() *Rule {
 	for i, rule := range s.Rules {
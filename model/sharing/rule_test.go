@@ -91,6 +91,50 @@ func TestValidatesRules(t *testing.T) {
 		},
 	}
 	assert.NoError(t, s.ValidateRules())
+	s.Rules = []Rule{
+		{
+			Title:   "several files and folders are OK",
+			DocType: consts.Files,
+			Values:  []string{"foo", "bar", "baz"},
+		},
+	}
+	assert.NoError(t, s.ValidateRules())
+	s.Rules = []Rule{
+		{
+			Title:   "a file cannot be shared twice",
+			DocType: consts.Files,
+			Values:  []string{"foo", "bar", "foo"},
+		},
+	}
+	assert.Equal(t, ErrInvalidRule, s.ValidateRules())
+	s.Rules = []Rule{
+		{
+			Title:          "conflict policy is OK",
+			DocType:        consts.Files,
+			Values:         []string{"foo"},
+			ConflictPolicy: "Owner-Wins",
+		},
+	}
+	assert.NoError(t, s.ValidateRules())
+	assert.Equal(t, ConflictPolicyOwnerWins, s.Rules[0].ConflictPolicy)
+	s.Rules = []Rule{
+		{
+			Title:          "conflict policy is invalid",
+			DocType:        consts.Files,
+			Values:         []string{"foo"},
+			ConflictPolicy: "flip",
+		},
+	}
+	assert.Equal(t, ErrInvalidRule, s.ValidateRules())
+	s.Rules = []Rule{
+		{
+			Title:          "conflict policy is only for files",
+			DocType:        consts.Contacts,
+			Values:         []string{"foo"},
+			ConflictPolicy: ConflictPolicyLastWriterWins,
+		},
+	}
+	assert.Equal(t, ErrInvalidRule, s.ValidateRules())
 	s.Rules = []Rule{
 		{
 			Title:   "root cannot be shared",
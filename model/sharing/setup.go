@@ -236,7 +236,7 @@ func findDocsToCopy(inst *instance.Instance, rule Rule) ([]couchdb.JSONDoc, erro
 						return err
 					}
 					if dir != nil {
-						if dir.DocID != fileID {
+						if dir.DocID != fileID || rule.hasSeveralRoots() {
 							docs = append(docs, dirToJSONDoc(dir, instanceURL))
 						}
 					} else if file != nil {
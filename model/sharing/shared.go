@@ -2,6 +2,7 @@ package sharing
 
 import (
 	"encoding/json"
+	"os"
 	"strings"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -186,15 +187,36 @@ func isNoLongerShared(inst *instance.Instance, msg TrackMessage, evt TrackEvent)
 		}
 		docPath = p
 	}
-	sharingDir, err := inst.VFS().DirByID(rule.Values[0])
-	if err != nil {
-		return false, err
+	return isOutsideRoots(inst, rule, evt.Doc.ID(), docPath)
+}
+
+// isOutsideRoots returns true if the file or folder with the given id and
+// path is neither one of the shared files and folders of the rule, nor inside
+// one of the shared folders.
+func isOutsideRoots(inst *instance.Instance, rule Rule, id, docPath string) (bool, error) {
+	fs := inst.VFS()
+	for _, val := range rule.Values {
+		if val == id {
+			return false, nil
+		}
+		dir, _, err := fs.DirOrFileByID(val)
+		if err == os.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if dir != nil && strings.HasPrefix(docPath+"/", dir.Fullpath+"/") {
+			return false, nil
+		}
 	}
-	return !strings.HasPrefix(docPath+"/", sharingDir.Fullpath+"/"), nil
+	return true, nil
 }
 
 // isTheSharingDirectory returns true if the event was for the directory that
 // is the root of the sharing: we don't want to track it in io.cozy.shared.
+// When several files and folders are shared by the rule, they are all tracked,
+// as the recipients put them inside a new sharing directory.
 func isTheSharingDirectory(inst *instance.Instance, msg TrackMessage, evt TrackEvent) (bool, error) {
 	if evt.Doc.Type != consts.Files || evt.Doc.Get("type") != consts.DirType {
 		return false, nil
@@ -204,7 +226,7 @@ func isTheSharingDirectory(inst *instance.Instance, msg TrackMessage, evt TrackE
 		return false, err
 	}
 	rule := s.Rules[msg.RuleIndex]
-	if rule.Selector == couchdb.SelectorReferencedBy {
+	if rule.Selector == couchdb.SelectorReferencedBy || rule.hasSeveralRoots() {
 		return false, nil
 	}
 	id := evt.Doc.ID()
@@ -336,9 +358,20 @@ func UpdateShared(inst *instance.Instance, msg TrackMessage, evt TrackEvent) err
 		}
 	}
 
+	action := ActivityUpdate
+	if ref.Infos[msg.SharingID].Removed {
+		action = ActivityRemove
+	} else if ref.Rev() == "" || wasRemoved {
+		action = ActivityAdd
+	}
+
 	if ref.Rev() == "" {
 		ref.Revisions = &RevsTree{Rev: rev}
-		return couchdb.CreateNamedDoc(inst, &ref)
+		if err := couchdb.CreateNamedDoc(inst, &ref); err != nil {
+			return err
+		}
+		recordLocalActivity(inst, msg.SharingID, action, &evt.Doc)
+		return nil
 	}
 	if evt.OldDoc == nil {
 		inst.Logger().WithField("nspace", "sharing").
@@ -351,6 +384,7 @@ func UpdateShared(inst *instance.Instance, msg TrackMessage, evt TrackEvent) err
 	if err := couchdb.UpdateDoc(inst, &ref); err != nil {
 		return err
 	}
+	recordLocalActivity(inst, msg.SharingID, action, &evt.Doc)
 
 	// For a directory, we have to update the Removed flag for the files inside
 	// it, as we won't have any events for them.
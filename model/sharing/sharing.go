@@ -36,16 +36,17 @@ type Sharing struct {
 	SID  string `json:"_id,omitempty"`
 	SRev string `json:"_rev,omitempty"`
 
-	Triggers    Triggers  `json:"triggers"`
-	Active      bool      `json:"active,omitempty"`
-	Owner       bool      `json:"owner,omitempty"`
-	Open        bool      `json:"open_sharing,omitempty"`
-	Description string    `json:"description,omitempty"`
-	AppSlug     string    `json:"app_slug"`
-	PreviewPath string    `json:"preview_path,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	NbFiles     int       `json:"initial_number_of_files_to_sync,omitempty"`
+	Triggers    Triggers   `json:"triggers"`
+	Active      bool       `json:"active,omitempty"`
+	Owner       bool       `json:"owner,omitempty"`
+	Open        bool       `json:"open_sharing,omitempty"`
+	Description string     `json:"description,omitempty"`
+	AppSlug     string     `json:"app_slug"`
+	PreviewPath string     `json:"preview_path,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	NbFiles     int        `json:"initial_number_of_files_to_sync,omitempty"`
 
 	Rules []Rule `json:"rules"`
 
@@ -216,6 +217,7 @@ func (s *Sharing) CreatePreviewPermissions(inst *instance.Instance) (map[string]
 			}
 		}
 		doc.Codes = codes
+		doc.ExpiresAt = s.ExpiresAt
 		if err := couchdb.UpdateDoc(inst, doc); err != nil {
 			return nil, err
 		}
@@ -225,6 +227,7 @@ func (s *Sharing) CreatePreviewPermissions(inst *instance.Instance) (map[string]
 		subdoc := permission.Permission{
 			Permissions: set,
 			Metadata:    md,
+			ExpiresAt:   s.ExpiresAt,
 		}
 		_, err := permission.CreateSharePreviewSet(inst, s.SID, codes, subdoc)
 		if err != nil {
@@ -242,10 +245,19 @@ func (s *Sharing) Create(inst *instance.Instance) (map[string]string, error) {
 	if len(s.Members) < 2 {
 		return nil, ErrNoRecipients
 	}
+	if err := s.checkNestedRoots(inst); err != nil {
+		return nil, err
+	}
+	if err := s.ValidateExpiry(); err != nil {
+		return nil, err
+	}
 
 	if err := couchdb.CreateDoc(inst, s); err != nil {
 		return nil, err
 	}
+	if s.Owner {
+		s.AddExpiryTriggers(inst)
+	}
 
 	if s.Owner && s.PreviewPath != "" {
 		return s.CreatePreviewPermissions(inst)
@@ -313,6 +325,9 @@ func (s *Sharing) Revoke(inst *instance.Instance) error {
 	if err := RemoveSharedRefs(inst, s.SID); err != nil {
 		return err
 	}
+	if err := PurgeActivities(inst, s.SID); err != nil {
+		return err
+	}
 	if s.PreviewPath != "" {
 		if err := s.RevokePreviewPermissions(inst); err != nil {
 			return err
@@ -334,7 +349,11 @@ func (s *Sharing) RevokePreviewPermissions(inst *instance.Instance) error {
 	}
 	now := time.Now()
 	perms.ExpiresAt = &now
-	return couchdb.UpdateDoc(inst, perms)
+	if err := couchdb.UpdateDoc(inst, perms); err != nil {
+		return err
+	}
+	permission.AuditRevocation(inst, perms)
+	return nil
 }
 
 // RevokeRecipient revoke only one recipient on the sharer. After that, if the
@@ -370,6 +389,9 @@ func (s *Sharing) RevokeRecipientBySelf(inst *instance.Instance) error {
 	if err := RemoveSharedRefs(inst, s.SID); err != nil {
 		return err
 	}
+	if err := PurgeActivities(inst, s.SID); err != nil {
+		return err
+	}
 	if s.FirstFilesRule() != nil {
 		if err := s.RemoveSharingDir(inst); err != nil {
 			return err
@@ -430,6 +452,9 @@ func (s *Sharing) RevokeByNotification(inst *instance.Instance) error {
 	if err := RemoveSharedRefs(inst, s.SID); err != nil {
 		return err
 	}
+	if err := PurgeActivities(inst, s.SID); err != nil {
+		return err
+	}
 	if s.FirstFilesRule() != nil {
 		if err := s.RemoveSharingDir(inst); err != nil {
 			return err
@@ -480,6 +505,9 @@ func (s *Sharing) NoMoreRecipient(inst *instance.Instance) error {
 	if err := RemoveSharedRefs(inst, s.SID); err != nil {
 		return err
 	}
+	if err := PurgeActivities(inst, s.SID); err != nil {
+		return err
+	}
 	s.Active = false
 	return couchdb.UpdateDoc(inst, s)
 }
@@ -56,6 +56,7 @@ func (s *Sharing) Upload(inst *instance.Instance, errors int) error {
 		m := members[0]
 		members = members[1:]
 		more, err := s.UploadTo(inst, m)
+		s.updateSyncStatus(inst, m, "upload", err)
 		if err != nil {
 			errm = multierror.Append(errm, err)
 		}
@@ -83,6 +84,7 @@ func (s *Sharing) InitialUpload(inst *instance.Instance, m *Member) error {
 
 	for i := 0; i < BatchSize; i++ {
 		more, err := s.UploadTo(inst, m)
+		s.updateSyncStatus(inst, m, "upload", err)
 		if err != nil {
 			return err
 		}
@@ -332,8 +334,9 @@ func (s *Sharing) createUploadKey(inst *instance.Instance, target *FileDocWithRe
 }
 
 // SyncFile tries to synchronize a file with just the metadata. If it can't,
-// it will return a key to upload the content.
-func (s *Sharing) SyncFile(inst *instance.Instance, target *FileDocWithRevisions) (*KeyToUpload, error) {
+// it will return a key to upload the content. The member is the one who has
+// sent the file.
+func (s *Sharing) SyncFile(inst *instance.Instance, m *Member, target *FileDocWithRevisions) (*KeyToUpload, error) {
 	inst.Logger().WithField("nspace", "upload").Debugf("SyncFile %#v", target)
 	mu := lock.ReadWrite(inst, "shared")
 	if err := mu.Lock(); err != nil {
@@ -372,13 +375,21 @@ func (s *Sharing) SyncFile(inst *instance.Instance, target *FileDocWithRevisions
 	if !bytes.Equal(target.MD5Sum, current.MD5Sum) {
 		return s.createUploadKey(inst, target)
 	}
-	return nil, s.updateFileMetadata(inst, target, current, &ref)
+	if err = s.updateFileMetadata(inst, target, current, &ref); err != nil {
+		return nil, err
+	}
+	s.recordFileActivity(inst, m, ActivityUpdate, target.FileDoc)
+	return nil, nil
 }
 
 // prepareFileWithAncestors find the parent directory for file, and recreates it
 // if it is missing.
 func (s *Sharing) prepareFileWithAncestors(inst *instance.Instance, newdoc *vfs.FileDoc, dirID string) error {
-	if dirID == "" {
+	if dirID == "" && s.Owner && s.isSharedRoot(newdoc.DocID) {
+		// On the owner's cozy, a file without dir_id is one of the shared
+		// files, and it stays where it is.
+		return nil
+	} else if dirID == "" {
 		parent, err := s.GetSharingDir(inst)
 		if err != nil {
 			return err
@@ -455,8 +466,8 @@ func (s *Sharing) updateFileMetadata(inst *instance.Instance, target *FileDocWit
 }
 
 // HandleFileUpload is used to receive a file upload when synchronizing just
-// the metadata was not enough.
-func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.ReadCloser) error {
+// the metadata was not enough. The member is the one who has sent the file.
+func (s *Sharing) HandleFileUpload(inst *instance.Instance, m *Member, key string, body io.ReadCloser) error {
 	defer body.Close()
 	target, err := getStore().Get(inst, key)
 	inst.Logger().WithField("nspace", "upload").Debugf("HandleFileUpload %#v %#v", target.FileDoc, target.Revisions)
@@ -479,10 +490,18 @@ func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.
 		return err
 	}
 
+	action := ActivityUpdate
 	if current == nil {
-		return s.UploadNewFile(inst, target, body)
+		action = ActivityAdd
+		err = s.UploadNewFile(inst, target, body)
+	} else {
+		err = s.UploadExistingFile(inst, target, current, body)
+	}
+	if err != nil {
+		return err
 	}
-	return s.UploadExistingFile(inst, target, current, body)
+	s.recordFileActivity(inst, m, action, target.FileDoc)
+	return nil
 }
 
 // UploadNewFile is used to receive a new file.
@@ -513,7 +532,7 @@ func (s *Sharing) UploadNewFile(inst *instance.Instance, target *FileDocWithRevi
 			inst.Logger().WithField("nspace", "upload").
 				Debugf("Conflict for parent on file upload: %s", err)
 		}
-	} else if target.DocID == rule.Values[0] {
+	} else if !rule.hasSeveralRoots() && target.DocID == rule.Values[0] {
 		parent, err = EnsureSharedWithMeDir(inst)
 	} else {
 		parent, err = s.GetSharingDir(inst)
@@ -645,15 +664,43 @@ func (s *Sharing) UploadExistingFile(inst *instance.Instance, target *FileDocWit
 
 	chain := revsStructToChain(target.Revisions)
 	conflict := detectConflict(newdoc.DocRev, chain)
-	switch conflict {
-	case LostConflict:
-		return s.uploadLostConflict(inst, target, newdoc, body)
-	case WonConflict:
+	policy := rule.conflictPolicy()
+	switch {
+	case conflict == NoConflict:
+		// Nothing to do
+	case policy == ConflictPolicyKeepBoth && conflict == LostConflict:
+		copyID := conflictID(newdoc.DocID, target.Rev())
+		c := s.newContentConflict(infos.Rule, olddoc, olddoc.DocRev, target.Rev(), copyID)
+		if err = s.uploadLostConflict(inst, target, newdoc, body); err != nil {
+			return err
+		}
+		s.recordConflict(inst, c)
+		return nil
+	case policy == ConflictPolicyKeepBoth:
 		if err = s.uploadWonConflict(inst, olddoc); err != nil {
 			return err
 		}
-	case NoConflict:
-		// Nothing to do
+		copyID := conflictID(olddoc.DocID, olddoc.DocRev)
+		c := s.newContentConflict(infos.Rule, olddoc, target.Rev(), olddoc.DocRev, copyID)
+		s.recordConflict(inst, c)
+	case s.incomingWinsConflict(policy, target.FileDoc, olddoc):
+		c := s.newContentConflict(infos.Rule, olddoc, target.Rev(), olddoc.DocRev, "")
+		if conflict == LostConflict {
+			if err = s.uploadLostConflictAsNewRevision(inst, newdoc, olddoc, body); err != nil {
+				return err
+			}
+			s.recordConflict(inst, c)
+			return nil
+		}
+		s.recordConflict(inst, c)
+	default:
+		// The local version wins: the incoming version is dropped, and if it
+		// has won the conflict on the revisions, the other cozy will send a
+		// new revision with the content of the local version.
+		c := s.newContentConflict(infos.Rule, olddoc, olddoc.DocRev, target.Rev(), "")
+		s.recordConflict(inst, c)
+		body.Close()
+		return nil
 	}
 	indexer.WillResolveConflict(newdoc.DocRev, chain)
 
@@ -682,6 +729,7 @@ func (s *Sharing) UploadExistingFile(inst *instance.Instance, target *FileDocWit
 	indexer.UnstashRevision(stash)
 	newdoc.DocRev = tmpdoc.DocRev
 	newdoc.InternalID = tmpdoc.InternalID
+	newdoc.EncryptionKeyID = tmpdoc.EncryptionKeyID
 	err = fs.UpdateFileDoc(tmpdoc, newdoc)
 	if err == os.ErrExist {
 		pth, errp := newdoc.Path(fs)
@@ -730,6 +778,60 @@ func (s *Sharing) uploadLostConflict(inst *instance.Instance, target *FileDocWit
 	return copyFileContent(inst, file, body)
 }
 
+// incomingWinsConflict returns true if the incoming version of a file should
+// win a conflict on its content according to the policy of the rule. The
+// result is the same on both sides of the conflict, as the two cozy instances
+// must converge on the same version.
+func (s *Sharing) incomingWinsConflict(policy string, incoming, local *vfs.FileDoc) bool {
+	if policy == ConflictPolicyOwnerWins {
+		return !s.Owner
+	}
+	if incoming.UpdatedAt.Equal(local.UpdatedAt) {
+		return compareRevisions(incoming.DocRev, local.DocRev) > 0
+	}
+	return incoming.UpdatedAt.After(local.UpdatedAt)
+}
+
+// compareRevisions compares two revisions like CouchDB does to pick the
+// winner of a conflict: the generations are compared as numbers (10-xxx is
+// after 9-xxx), and then the hashes. It returns -1, 0 or 1.
+func compareRevisions(a, b string) int {
+	if genA, genB := RevGeneration(a), RevGeneration(b); genA != genB {
+		if genA < genB {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(revHash(a), revHash(b))
+}
+
+// revHash returns the part after the hyphen of a revision.
+func revHash(rev string) string {
+	parts := strings.SplitN(rev, "-", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// uploadLostConflictAsNewRevision manages an upload where a file is in
+// conflict, the uploaded version has lost the conflict on the revisions, but
+// it wins for the conflict policy of the rule. Its content is written as a new
+// revision of the local file, that will be replicated to the other cozy
+// instances (with the same content, it is just a change of metadata for them).
+func (s *Sharing) uploadLostConflictAsNewRevision(inst *instance.Instance, newdoc, olddoc *vfs.FileDoc, body io.ReadCloser) error {
+	inst.Logger().WithField("nspace", "upload").
+		Debugf("uploadLostConflictAsNewRevision %s", olddoc.DocRev)
+	newdoc.DocName = olddoc.DocName
+	newdoc.DirID = olddoc.DirID
+	newdoc.ResetFullpath()
+	file, err := inst.VFS().CreateFile(newdoc, olddoc)
+	if err != nil {
+		return err
+	}
+	return copyFileContent(inst, file, body)
+}
+
 // uploadWonConflict manages an upload where a file is in conflict, and the
 // existing file is copied to a new file to let the upload succeed.
 func (s *Sharing) uploadWonConflict(inst *instance.Instance, src *vfs.FileDoc) error {
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/session"
 	"github.com/cozy/cozy-stack/pkg/assets/dynamic"
 	build "github.com/cozy/cozy-stack/pkg/config"
@@ -101,11 +102,13 @@ security features. Please do not use this binary as your production server.
 	}
 
 	sessionSweeper := session.SweepLoginRegistrations()
+	auditFlusher := permission.StartAuditFlusher()
 
 	// Global shutdowner that composes all the running processes of the stack
 	processes = utils.NewGroupShutdown(
 		job.System(),
 		sessionSweeper,
+		auditFlusher,
 		gopAgent{},
 	)
 	return
@@ -0,0 +1,271 @@
+package vfs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/utils"
+)
+
+// BlobsDirName is the path of the directory where the binaries are stored,
+// keyed by their checksum, when the content-addressed storage is enabled.
+const BlobsDirName = "/.cozy_blobs"
+
+// blobInternalIDPrefix is the prefix of the internal_vfs_id of the files and
+// versions whose content is stored as a blob.
+const blobInternalIDPrefix = "blob-"
+
+// maxBlobRetries is the number of times we retry to update the reference
+// counter of a blob when there is a conflict in CouchDB.
+const maxBlobRetries = 5
+
+// Blob is used by the content-addressed storage to count the number of files
+// and versions that share the same binary. Its identifier is the hexadecimal
+// representation of the MD5 checksum of the content.
+type Blob struct {
+	DocID    string `json:"_id,omitempty"`
+	DocRev   string `json:"_rev,omitempty"`
+	ByteSize int64  `json:"size,string"`
+	Refs     int    `json:"refs"`
+	// EncryptionKeyID is the identifier of the key used for the encryption
+	// at rest of the content of the blob, or 0 if it is stored in clear.
+	EncryptionKeyID uint32 `json:"encryption_key_id,omitempty"`
+}
+
+// ID returns the blob qualified identifier
+func (b *Blob) ID() string { return b.DocID }
+
+// Rev returns the blob revision
+func (b *Blob) Rev() string { return b.DocRev }
+
+// DocType returns the blob document type
+func (b *Blob) DocType() string { return consts.FilesBlobs }
+
+// Clone implements couchdb.Doc
+func (b *Blob) Clone() couchdb.Doc { cloned := *b; return &cloned }
+
+// SetID changes the blob qualified identifier
+func (b *Blob) SetID(id string) { b.DocID = id }
+
+// SetRev changes the blob revision
+func (b *Blob) SetRev(rev string) { b.DocRev = rev }
+
+// ContentAddressed returns true if the content-addressed storage is enabled,
+// ie the new binaries are deduplicated by their checksum.
+func ContentAddressed() bool {
+	return config.GetConfig().Fs.ContentAddressed
+}
+
+// NewBlobInternalID returns a new internal_vfs_id for a file whose content is
+// stored as a blob. It has the same length as the internal IDs of the swift
+// layout v3.
+func NewBlobInternalID() string {
+	return blobInternalIDPrefix + utils.RandomString(11)
+}
+
+// IsBlobInternalID returns true if the given internal_vfs_id is the one of a
+// file whose content is stored as a blob.
+func IsBlobInternalID(internalID string) bool {
+	return strings.HasPrefix(internalID, blobInternalIDPrefix)
+}
+
+// IsBlobVersion returns true if the content of the given version is stored as
+// a blob.
+func IsBlobVersion(v *Version) bool {
+	parts := strings.SplitN(v.DocID, "/", 2)
+	return len(parts) == 2 && IsBlobInternalID(parts[1])
+}
+
+// BlobKey returns the key of the blob for the given checksum.
+func BlobKey(md5sum []byte) string {
+	return hex.EncodeToString(md5sum)
+}
+
+// AcquireBlob adds a reference to the blob with the given checksum. If the
+// blob doesn't exist yet, the store function is called to put its content in
+// place, and the blob document is created only after this function has
+// succeeded: a concurrent writer can't reference a blob whose content is not
+// stored yet. The keyID is the identifier of the key used to encrypt this
+// content. It returns the blob, and true if it has been created by this call.
+func AcquireBlob(db prefixer.Prefixer, md5sum []byte, size int64, keyID uint32, store func() error) (*Blob, bool, error) {
+	key := BlobKey(md5sum)
+	stored := false
+	var err error
+	for i := 0; i < maxBlobRetries; i++ {
+		blob := &Blob{}
+		err = couchdb.GetDoc(db, consts.FilesBlobs, key, blob)
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			if !stored {
+				if err = store(); err != nil {
+					return nil, false, err
+				}
+				stored = true
+			}
+			blob = &Blob{DocID: key, ByteSize: size, Refs: 1, EncryptionKeyID: keyID}
+			err = couchdb.CreateNamedDocWithDB(db, blob)
+			if err == nil {
+				return blob, true, nil
+			}
+		} else if err == nil {
+			blob.Refs++
+			err = couchdb.UpdateDoc(db, blob)
+			if err == nil {
+				return blob, false, nil
+			}
+		}
+		if !couchdb.IsConflictError(err) {
+			return nil, false, err
+		}
+	}
+	return nil, false, err
+}
+
+// GetBlob returns the blob with the given checksum.
+func GetBlob(db prefixer.Prefixer, md5sum []byte) (*Blob, error) {
+	blob := &Blob{}
+	if err := couchdb.GetDoc(db, consts.FilesBlobs, BlobKey(md5sum), blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// SetBlobEncryptionKey records that the content of the blob with the given
+// checksum has been encrypted again with the given key.
+func SetBlobEncryptionKey(db prefixer.Prefixer, md5sum []byte, keyID uint32) error {
+	var err error
+	for i := 0; i < maxBlobRetries; i++ {
+		var blob *Blob
+		blob, err = GetBlob(db, md5sum)
+		if err != nil {
+			return err
+		}
+		blob.EncryptionKeyID = keyID
+		err = couchdb.UpdateDoc(db, blob)
+		if !couchdb.IsConflictError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// ReleaseBlob removes a reference to the blob with the given checksum. It
+// returns true if it was the last reference, and the content of the blob can
+// then be removed from the storage.
+func ReleaseBlob(db prefixer.Prefixer, md5sum []byte) (bool, error) {
+	key := BlobKey(md5sum)
+	var err error
+	for i := 0; i < maxBlobRetries; i++ {
+		blob := &Blob{}
+		err = couchdb.GetDoc(db, consts.FilesBlobs, key, blob)
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return false, nil
+		}
+		if err == nil {
+			blob.Refs--
+			if blob.Refs <= 0 {
+				err = couchdb.DeleteDoc(db, blob)
+				if err == nil {
+					return true, nil
+				}
+			} else {
+				err = couchdb.UpdateDoc(db, blob)
+				if err == nil {
+					return false, nil
+				}
+			}
+		}
+		if !couchdb.IsConflictError(err) {
+			return false, err
+		}
+	}
+	return false, err
+}
+
+// BlobRefs counts the references to the blobs from the files and versions.
+type BlobRefs map[string]int
+
+// AddFile counts the reference of the file to its blob, if any.
+func (r BlobRefs) AddFile(internalID string, md5sum []byte) {
+	if IsBlobInternalID(internalID) {
+		r[BlobKey(md5sum)]++
+	}
+}
+
+// AddVersion counts the reference of the version to its blob, if any.
+func (r BlobRefs) AddVersion(v *Version) {
+	if IsBlobVersion(v) {
+		r[BlobKey(v.MD5Sum)]++
+	}
+}
+
+// CheckBlobsConsistency compares the references to the blobs from the index
+// with the reference counters of the blobs, and with the blobs present in the
+// storage (the stored map contains their size indexed by their key). It
+// accumulates the dangling blobs (referenced but missing in the storage), the
+// leaked blobs (stored but not referenced), and the blobs with a bad counter.
+func CheckBlobsConsistency(db prefixer.Prefixer, refs BlobRefs, stored map[string]int64, accumulate func(log *FsckLog), failFast bool) error {
+	blobs := make(map[string]*Blob, len(refs))
+	err := couchdb.ForeachDocs(db, consts.FilesBlobs, func(_ string, data json.RawMessage) error {
+		b := &Blob{}
+		if erru := json.Unmarshal(data, b); erru != nil {
+			return erru
+		}
+		blobs[b.DocID] = b
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+
+	for key, nb := range refs {
+		b, ok := blobs[key]
+		if !ok {
+			b = &Blob{DocID: key}
+		}
+		if _, ok := stored[key]; !ok {
+			accumulate(&FsckLog{Type: BlobMissing, BlobDoc: b, ExpectedRefs: nb})
+			if failFast {
+				return nil
+			}
+		} else if b.Refs != nb {
+			accumulate(&FsckLog{Type: BlobRefsMismatch, BlobDoc: b, ExpectedRefs: nb})
+			if failFast {
+				return nil
+			}
+		}
+	}
+
+	for key, size := range stored {
+		if refs[key] > 0 {
+			continue
+		}
+		b, ok := blobs[key]
+		if !ok {
+			b = &Blob{DocID: key, ByteSize: size}
+		}
+		accumulate(&FsckLog{Type: BlobLeaked, BlobDoc: b})
+		if failFast {
+			return nil
+		}
+	}
+
+	for key, b := range blobs {
+		if _, ok := refs[key]; ok {
+			continue
+		}
+		if _, ok := stored[key]; ok {
+			continue
+		}
+		accumulate(&FsckLog{Type: BlobRefsMismatch, BlobDoc: b})
+		if failFast {
+			return nil
+		}
+	}
+
+	return nil
+}
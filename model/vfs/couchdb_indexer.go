@@ -565,6 +565,10 @@ func (c *couchdbIndexer) CreateVersion(v *Version) error {
 	return couchdb.CreateNamedDocWithDB(c.db, v)
 }
 
+func (c *couchdbIndexer) UpdateVersion(v *Version) error {
+	return couchdb.UpdateDoc(c.db, v)
+}
+
 func (c *couchdbIndexer) DeleteVersion(v *Version) error {
 	return couchdb.DeleteDoc(c.db, v)
 }
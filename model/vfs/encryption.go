@@ -0,0 +1,223 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+var (
+	// ErrUnknownFilesKey is used when some content has been encrypted with a
+	// key that is not in the keyring of the instance.
+	ErrUnknownFilesKey = errors.New("vfs: unknown key for the encrypted content")
+	// ErrNotEncrypted is used when the document of a file says that its
+	// content is encrypted, but the content is in clear.
+	ErrNotEncrypted = errors.New("vfs: the content is not encrypted")
+)
+
+// Keyring gives the keys used for encrypting the binaries (files, versions
+// and thumbnails) of an instance.
+type Keyring interface {
+	// CurrentFilesKey returns the key, and its identifier, that must be used
+	// to encrypt the new content. The key is nil if the content must be
+	// stored in clear.
+	CurrentFilesKey() (uint32, []byte, error)
+	// FilesKey returns the key with the given identifier.
+	FilesKey(id uint32) ([]byte, error)
+	// CheckFilesKey returns ErrUnknownFilesKey if the key with the given
+	// identifier has been removed since the keyring was loaded. It is called
+	// before the document of a new content is saved, as the old keys are
+	// removed when no document references them.
+	CheckFilesKey(id uint32) error
+}
+
+// Reencrypter is implemented by the VFS that support the encryption at rest.
+// It is used for the rotation of the keys.
+type Reencrypter interface {
+	// Reencrypt rewrites the content of the file, and of its old versions,
+	// that has not been encrypted with the current key.
+	Reencrypt(doc *FileDoc) error
+}
+
+// ThumbsReencrypter is the same as Reencrypter, but for the thumbnails.
+type ThumbsReencrypter interface {
+	// ReencryptThumbs rewrites the thumbnails of the given formats for an
+	// image that have not been encrypted with the current key.
+	ReencryptThumbs(img *FileDoc, formats []string) error
+}
+
+// EncryptionEnabled returns true if the new binaries must be encrypted.
+func EncryptionEnabled() bool {
+	return config.GetConfig().Fs.Encryption
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// WillEncrypt returns true if the new content must be encrypted with a key
+// of the given keyring.
+func WillEncrypt(keys Keyring) bool {
+	if keys == nil || !EncryptionEnabled() {
+		return false
+	}
+	_, key, err := keys.CurrentFilesKey()
+	return err != nil || key != nil
+}
+
+// EncryptWriter returns a writer that encrypts the content with the current
+// key of the keyring before writing it to w, and the identifier of this key.
+// If the content must be stored in clear, w is returned as is, with 0 for the
+// key identifier. In both cases, the returned writer must be closed before w.
+func EncryptWriter(w io.Writer, keys Keyring) (io.WriteCloser, uint32, error) {
+	if keys == nil || !EncryptionEnabled() {
+		return nopWriteCloser{w}, 0, nil
+	}
+	id, key, err := keys.CurrentFilesKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	if key == nil {
+		return nopWriteCloser{w}, 0, nil
+	}
+	enc, err := crypto.NewSegmentWriter(w, key, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return enc, id, nil
+}
+
+// CheckEncryptionKey returns an error if the content has been encrypted with
+// a key that has been removed from the keyring since the content was written.
+func CheckEncryptionKey(keys Keyring, keyID uint32) error {
+	if keys == nil || keyID == 0 {
+		return nil
+	}
+	return keys.CheckFilesKey(keyID)
+}
+
+// NeedsReencryption returns true if a content encrypted with the given key
+// (0 for a content in clear) must be encrypted again with the current key of
+// the keyring.
+func NeedsReencryption(keyID uint32, keys Keyring) (bool, error) {
+	if keys == nil || !EncryptionEnabled() {
+		return false, nil
+	}
+	current, key, err := keys.CurrentFilesKey()
+	if err != nil || key == nil {
+		return false, err
+	}
+	return keyID != current, nil
+}
+
+// DecryptFile returns a File for reading the decrypted content of f, which
+// has the given size on the storage and has been encrypted with the given key
+// (as recorded in the document of the file or version). The reader must be at
+// the start of the content. If the key identifier is 0, the content is stored
+// in clear and nil is returned with no error.
+func DecryptFile(f io.ReadSeeker, closer io.Closer, size int64, keyID uint32, keys Keyring) (File, error) {
+	if keyID == 0 {
+		return nil, nil
+	}
+	if keys == nil {
+		return nil, ErrUnknownFilesKey
+	}
+	r, err := crypto.NewSegmentReader(f, size, keys.FilesKey)
+	if err == crypto.ErrNotSegmented {
+		return nil, ErrNotEncrypted
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &decryptedFile{r, closer}, nil
+}
+
+// ThumbEncryptionKey reads the header of the content of a thumbnail, and
+// returns the identifier of the key used to encrypt it, or 0 if it is in
+// clear. The thumbnails have no document where their encryption state can be
+// recorded, so it is read from their header.
+func ThumbEncryptionKey(r io.Reader) (uint32, error) {
+	id, encrypted, err := crypto.ReadSegmentHeader(r)
+	if err != nil || !encrypted {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DecryptThumb is the same as DecryptFile, but for a thumbnail: the key is
+// given by the header of the content. If the content is not encrypted, nil is
+// returned with no error, and the position of the reader must be reset by the
+// caller. A thumbnail encrypted with a key that has been removed is reported
+// as missing, as it can be generated again from the image.
+func DecryptThumb(f io.ReadSeeker, closer io.Closer, size int64, keys Keyring) (File, error) {
+	if keys == nil {
+		return nil, nil
+	}
+	// Without any key, the content cannot have been encrypted, and we can
+	// avoid reading its header.
+	if _, key, err := keys.CurrentFilesKey(); err != nil || key == nil {
+		return nil, err
+	}
+	r, err := crypto.NewSegmentReader(f, size, keys.FilesKey)
+	if err == crypto.ErrNotSegmented {
+		return nil, nil
+	}
+	if err == ErrUnknownFilesKey {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &decryptedFile{r, closer}, nil
+}
+
+// FilesKeyInUse returns true if some files, versions or blobs have their
+// content encrypted with the key of the given identifier. It is used to know
+// if an old key can be removed from the keyring.
+func FilesKeyInUse(db prefixer.Prefixer, keyID uint32) (bool, error) {
+	for _, doctype := range []string{consts.Files, consts.FilesVersions, consts.FilesBlobs} {
+		var docs []couchdb.JSONDoc
+		req := &couchdb.FindRequest{
+			UseIndex: "by-encryption-key-id",
+			Selector: mango.Equal("encryption_key_id", keyID),
+			Fields:   []string{"_id"},
+			Limit:    1,
+		}
+		err := couchdb.FindDocs(db, doctype, req, &docs)
+		if couchdb.IsNoDatabaseError(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if len(docs) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// decryptedFile is a File for reading an encrypted content.
+type decryptedFile struct {
+	*crypto.SegmentReader
+	c io.Closer
+}
+
+func (f *decryptedFile) Write(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (f *decryptedFile) Close() error {
+	return f.c.Close()
+}
+
+var _ File = &decryptedFile{}
@@ -50,6 +50,11 @@ type FileDoc struct {
 	// Swift of a file.
 	InternalID string `json:"internal_vfs_id,omitempty"`
 
+	// EncryptionKeyID is the identifier of the key used for the encryption
+	// at rest of the content, or 0 if the content is stored in clear. It must
+	// no be used by clients.
+	EncryptionKeyID uint32 `json:"encryption_key_id,omitempty"`
+
 	// Cache of the fullpath of the file. Should not have to be invalidated
 	// since we use FileDoc as immutable data-structures.
 	fullpath string
@@ -301,6 +306,7 @@ func ModifyFileMetadata(fs VFS, olddoc *FileDoc, patch *DocPatch) (*FileDoc, err
 	newdoc.ReferencedBy = olddoc.ReferencedBy
 	newdoc.CozyMetadata = olddoc.CozyMetadata
 	newdoc.InternalID = olddoc.InternalID
+	newdoc.EncryptionKeyID = olddoc.EncryptionKeyID
 
 	if patch.MD5Sum != nil {
 		newdoc.MD5Sum = *patch.MD5Sum
@@ -38,6 +38,15 @@ const (
 	// IndexDuplicateName is used when two files or directories have the same
 	// name inside the same folder (ie they have the same path).
 	IndexDuplicateName = "index_duplicate_name"
+	// BlobMissing is used when a file or a version references a blob that is
+	// missing from the content-addressed storage (dangling blob).
+	BlobMissing FsckLogType = "blob_missing"
+	// BlobLeaked is used when a blob is present in the content-addressed
+	// storage, but no file or version references it.
+	BlobLeaked FsckLogType = "blob_leaked"
+	// BlobRefsMismatch is used when the reference counter of a blob does not
+	// match the number of files and versions that reference it.
+	BlobRefsMismatch FsckLogType = "blob_refs_mismatch"
 )
 
 // FsckLog is a struct for an inconsistency in the VFS
@@ -50,6 +59,8 @@ type FsckLog struct {
 	IsVersion        bool                 `json:"is_version"`
 	ContentMismatch  *FsckContentMismatch `json:"content_mismatch,omitempty"`
 	ExpectedFullpath string               `json:"expected_fullpath,omitempty"`
+	BlobDoc          *Blob                `json:"blob_doc,omitempty"`
+	ExpectedRefs     int                  `json:"expected_refs,omitempty"`
 }
 
 // String returns a string describing the FsckLog
@@ -82,6 +93,12 @@ func (f *FsckLog) String() string {
 		return "the document content does not match the store content checksum"
 	case FileMissing:
 		return "the document is a version whose file is not present in the index"
+	case BlobMissing:
+		return "the blob is referenced by a file or a version but is missing from the storage"
+	case BlobLeaked:
+		return "the blob is present in the storage but no file or version references it"
+	case BlobRefsMismatch:
+		return "the reference counter of the blob does not match the number of files and versions"
 	}
 	panic("bad FsckLog type")
 }
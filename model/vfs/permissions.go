@@ -3,7 +3,9 @@ package vfs
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -33,6 +35,13 @@ func Allows(fs VFS, pset permission.Set, v permission.Verb, fd Fetcher) error {
 			continue
 		}
 
+		// the conditions are checked on the file or folder itself, not on
+		// its ancestors
+		if !r.ConditionsMatch(fd) {
+			continue
+		}
+		r.Conditions = nil
+
 		// permission on whole io.cozy.files doctype
 		if len(r.Values) == 0 {
 			return nil
@@ -144,6 +153,16 @@ func (f *FileDoc) Fetch(field string) []string {
 		return []string{f.Class}
 	case "tags":
 		return f.Tags
+	case "size":
+		return []string{strconv.FormatInt(f.ByteSize, 10)}
+	case "created_at":
+		return []string{f.CreatedAt.Format(time.RFC3339)}
+	case "updated_at":
+		return []string{f.UpdatedAt.Format(time.RFC3339)}
+	case permission.CreatorField:
+		if f.CozyMetadata != nil && f.CozyMetadata.CreatedByApp != "" {
+			return []string{f.CozyMetadata.CreatedByApp}
+		}
 	case "referenced_by":
 		if f != nil {
 			var values []string
@@ -168,6 +187,14 @@ func (d *DirDoc) Fetch(field string) []string {
 		return []string{d.DocName}
 	case "tags":
 		return d.Tags
+	case "created_at":
+		return []string{d.CreatedAt.Format(time.RFC3339)}
+	case "updated_at":
+		return []string{d.UpdatedAt.Format(time.RFC3339)}
+	case permission.CreatorField:
+		if d.CozyMetadata != nil && d.CozyMetadata.CreatedByApp != "" {
+			return []string{d.CozyMetadata.CreatedByApp}
+		}
 	case "referenced_by":
 		var values []string
 		for _, ref := range d.ReferencedBy {
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
@@ -17,15 +18,27 @@ import (
 // archive, or a metadata object for an upcoming upload.
 type Store interface {
 	AddFile(db prefixer.Prefixer, filePath string) (string, error)
-	AddThumb(db prefixer.Prefixer, fileID string) (string, error)
+	AddThumb(db prefixer.Prefixer, thumb *Thumb) (string, error)
 	AddVersion(db prefixer.Prefixer, versionID string) (string, error)
 	AddArchive(db prefixer.Prefixer, archive *Archive) (string, error)
 	AddMetadata(db prefixer.Prefixer, metadata *Metadata) (string, error)
+	AddUploadSession(db prefixer.Prefixer, session *UploadSession) (string, error)
 	GetFile(db prefixer.Prefixer, key string) (string, error)
-	GetThumb(db prefixer.Prefixer, key string) (string, error)
+	GetThumb(db prefixer.Prefixer, key string) (*Thumb, error)
 	GetVersion(db prefixer.Prefixer, key string) (string, error)
 	GetArchive(db prefixer.Prefixer, key string) (*Archive, error)
 	GetMetadata(db prefixer.Prefixer, key string) (*Metadata, error)
+	GetUploadSession(db prefixer.Prefixer, key string) (*UploadSession, error)
+	SaveUploadSession(db prefixer.Prefixer, key string, session *UploadSession) error
+	DeleteUploadSession(db prefixer.Prefixer, key string) error
+}
+
+// Thumb is kept in the store for the secret of the thumbnails of a file: the
+// permissions of the requester who has been given the links are kept, to
+// check them again when the thumbnails are served.
+type Thumb struct {
+	FileID      string         `json:"file_id"`
+	Permissions permission.Set `json:"permissions"`
 }
 
 // storeTTL is time after which the data in the store will be considered stale.
@@ -91,12 +104,12 @@ func (s *memStore) AddFile(db prefixer.Prefixer, filePath string) (string, error
 	return key, nil
 }
 
-func (s *memStore) AddThumb(db prefixer.Prefixer, fileID string) (string, error) {
+func (s *memStore) AddThumb(db prefixer.Prefixer, thumb *Thumb) (string, error) {
 	key := makeSecret()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.vals[db.DBPrefix()+":"+key] = &memRef{
-		val: fileID,
+		val: thumb,
 		exp: time.Now().Add(storeTTL),
 	}
 	return key, nil
@@ -135,6 +148,14 @@ func (s *memStore) AddMetadata(db prefixer.Prefixer, metadata *Metadata) (string
 	return key, nil
 }
 
+func (s *memStore) AddUploadSession(db prefixer.Prefixer, session *UploadSession) (string, error) {
+	key := makeSecret()
+	if err := s.SaveUploadSession(db, key, session); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
 func (s *memStore) GetFile(db prefixer.Prefixer, key string) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -154,23 +175,23 @@ func (s *memStore) GetFile(db prefixer.Prefixer, key string) (string, error) {
 	return f, nil
 }
 
-func (s *memStore) GetThumb(db prefixer.Prefixer, key string) (string, error) {
+func (s *memStore) GetThumb(db prefixer.Prefixer, key string) (*Thumb, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	key = db.DBPrefix() + ":" + key
 	ref, ok := s.vals[key]
 	if !ok {
-		return "", nil
+		return nil, nil
 	}
 	if time.Now().After(ref.exp) {
 		delete(s.vals, key)
-		return "", nil
+		return nil, nil
 	}
-	f, ok := ref.val.(string)
+	t, ok := ref.val.(*Thumb)
 	if !ok {
-		return "", nil
+		return nil, nil
 	}
-	return f, nil
+	return t, nil
 }
 
 func (s *memStore) GetVersion(db prefixer.Prefixer, key string) (string, error) {
@@ -230,6 +251,43 @@ func (s *memStore) GetMetadata(db prefixer.Prefixer, key string) (*Metadata, err
 	return m, nil
 }
 
+func (s *memStore) GetUploadSession(db prefixer.Prefixer, key string) (*UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key = db.DBPrefix() + ":" + key
+	ref, ok := s.vals[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(ref.exp) {
+		delete(s.vals, key)
+		return nil, nil
+	}
+	u, ok := ref.val.(*UploadSession)
+	if !ok {
+		return nil, nil
+	}
+	return u, nil
+}
+
+func (s *memStore) SaveUploadSession(db prefixer.Prefixer, key string, session *UploadSession) error {
+	session.ExpiresAt = time.Now().Add(UploadSessionTTL)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vals[db.DBPrefix()+":"+key] = &memRef{
+		val: session,
+		exp: session.ExpiresAt,
+	}
+	return nil
+}
+
+func (s *memStore) DeleteUploadSession(db prefixer.Prefixer, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.vals, db.DBPrefix()+":"+key)
+	return nil
+}
+
 type redisStore struct {
 	c redis.UniversalClient
 }
@@ -246,9 +304,13 @@ func (s *redisStore) AddFile(db prefixer.Prefixer, filePath string) (string, err
 	return key, nil
 }
 
-func (s *redisStore) AddThumb(db prefixer.Prefixer, fileID string) (string, error) {
+func (s *redisStore) AddThumb(db prefixer.Prefixer, thumb *Thumb) (string, error) {
+	v, err := json.Marshal(thumb)
+	if err != nil {
+		return "", err
+	}
 	key := makeSecret()
-	if err := s.c.Set(db.DBPrefix()+":"+key, fileID, storeTTL).Err(); err != nil {
+	if err = s.c.Set(db.DBPrefix()+":"+key, v, storeTTL).Err(); err != nil {
 		return "", err
 	}
 	return key, nil
@@ -286,6 +348,14 @@ func (s *redisStore) AddMetadata(db prefixer.Prefixer, metadata *Metadata) (stri
 	return key, nil
 }
 
+func (s *redisStore) AddUploadSession(db prefixer.Prefixer, session *UploadSession) (string, error) {
+	key := makeSecret()
+	if err := s.SaveUploadSession(db, key, session); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
 func (s *redisStore) GetFile(db prefixer.Prefixer, key string) (string, error) {
 	f, err := s.c.Get(db.DBPrefix() + ":" + key).Result()
 	if err == redis.Nil {
@@ -297,15 +367,19 @@ func (s *redisStore) GetFile(db prefixer.Prefixer, key string) (string, error) {
 	return f, nil
 }
 
-func (s *redisStore) GetThumb(db prefixer.Prefixer, key string) (string, error) {
-	f, err := s.c.Get(db.DBPrefix() + ":" + key).Result()
+func (s *redisStore) GetThumb(db prefixer.Prefixer, key string) (*Thumb, error) {
+	b, err := s.c.Get(db.DBPrefix() + ":" + key).Bytes()
 	if err == redis.Nil {
-		return "", nil
+		return nil, nil
 	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return f, nil
+	thumb := &Thumb{}
+	if err = json.Unmarshal(b, thumb); err != nil {
+		return nil, err
+	}
+	return thumb, nil
 }
 
 func (s *redisStore) GetVersion(db prefixer.Prefixer, key string) (string, error) {
@@ -349,6 +423,34 @@ func (s *redisStore) GetMetadata(db prefixer.Prefixer, key string) (*Metadata, e
 	return meta, nil
 }
 
+func (s *redisStore) GetUploadSession(db prefixer.Prefixer, key string) (*UploadSession, error) {
+	b, err := s.c.Get(db.DBPrefix() + ":" + key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &UploadSession{}
+	if err = json.Unmarshal(b, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *redisStore) SaveUploadSession(db prefixer.Prefixer, key string, session *UploadSession) error {
+	session.ExpiresAt = time.Now().Add(UploadSessionTTL)
+	v, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.c.Set(db.DBPrefix()+":"+key, v, UploadSessionTTL).Err()
+}
+
+func (s *redisStore) DeleteUploadSession(db prefixer.Prefixer, key string) error {
+	return s.c.Del(db.DBPrefix() + ":" + key).Err()
+}
+
 func makeSecret() string {
 	return hex.EncodeToString(crypto.GenerateRandomBytes(8))
 }
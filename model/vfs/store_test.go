@@ -69,6 +69,32 @@ func TestStoreInMemory(t *testing.T) {
 	m3, err := store.GetArchive(dbA, key3)
 	assert.NoError(t, err)
 	assert.Nil(t, m3, "no expiration")
+
+	doc := &FileDoc{DocName: "video.mp4", DirID: "io.cozy.files.root-dir", ByteSize: 42}
+	u := &UploadSession{Doc: doc}
+	key4, err := store.AddUploadSession(dbA, u)
+	assert.NoError(t, err)
+
+	u2, err := store.GetUploadSession(dbB, key4)
+	assert.NoError(t, err)
+	assert.Nil(t, u2, "Inter-instances store leaking")
+
+	u.Offset = 10
+	u.Chunks = []int64{0}
+	assert.NoError(t, store.SaveUploadSession(dbA, key4, u))
+	u3, err := store.GetUploadSession(dbA, key4)
+	assert.NoError(t, err)
+	if assert.NotNil(t, u3) {
+		assert.Equal(t, int64(10), u3.Offset)
+		assert.Equal(t, []int64{0}, u3.Chunks)
+		assert.Equal(t, "video.mp4", u3.Doc.DocName)
+		assert.Equal(t, int64(42), u3.Size())
+	}
+
+	assert.NoError(t, store.DeleteUploadSession(dbA, key4))
+	u4, err := store.GetUploadSession(dbA, key4)
+	assert.NoError(t, err)
+	assert.Nil(t, u4)
 }
 
 func TestStoreInRedis(t *testing.T) {
@@ -134,4 +160,30 @@ func TestStoreInRedis(t *testing.T) {
 	m3, err := store.GetArchive(dbA, key3)
 	assert.NoError(t, err)
 	assert.Nil(t, m3, "no expiration")
+
+	doc := &FileDoc{DocName: "video.mp4", DirID: "io.cozy.files.root-dir", ByteSize: 42}
+	u := &UploadSession{Doc: doc}
+	key4, err := store.AddUploadSession(dbA, u)
+	assert.NoError(t, err)
+
+	u2, err := store.GetUploadSession(dbB, key4)
+	assert.NoError(t, err)
+	assert.Nil(t, u2, "Inter-instances store leaking")
+
+	u.Offset = 10
+	u.Chunks = []int64{0}
+	assert.NoError(t, store.SaveUploadSession(dbA, key4, u))
+	u3, err := store.GetUploadSession(dbA, key4)
+	assert.NoError(t, err)
+	if assert.NotNil(t, u3) {
+		assert.Equal(t, int64(10), u3.Offset)
+		assert.Equal(t, []int64{0}, u3.Chunks)
+		assert.Equal(t, "video.mp4", u3.Doc.DocName)
+		assert.Equal(t, int64(42), u3.Size())
+	}
+
+	assert.NoError(t, store.DeleteUploadSession(dbA, key4))
+	u4, err := store.GetUploadSession(dbA, key4)
+	assert.NoError(t, err)
+	assert.Nil(t, u4)
 }
@@ -6,4 +6,8 @@ package vfs
 type TrashJournal struct {
 	FileIDs     []string `json:"ids"`
 	ObjectNames []string `json:"objects"`
+	// Blobs is the list of the keys of the blobs (content-addressed storage)
+	// that were referenced by the deleted files. The references are released
+	// by the worker, and the blobs no longer referenced are removed.
+	Blobs []string `json:"blobs,omitempty"`
 }
@@ -0,0 +1,144 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// UploadsDirName is the name of the hidden directory where the chunks of the
+// resumable uploads are kept, before the upload is completed.
+const UploadsDirName = "/.uploads"
+
+// UploadSessionTTL is the duration after which an upload session with no
+// activity is considered as abandoned.
+var UploadSessionTTL = 24 * time.Hour
+
+var (
+	// ErrUploadSessionNotFound is used when the upload session does not exist
+	// or has expired
+	ErrUploadSessionNotFound = errors.New("Upload session not found or expired")
+	// ErrUploadOffsetMismatch is used when a chunk is sent for an offset that
+	// is not the current offset of the upload session
+	ErrUploadOffsetMismatch = errors.New("Upload offset does not match")
+	// ErrUploadChunkTooBig is used when a chunk goes beyond the size declared
+	// for the upload session
+	ErrUploadChunkTooBig = errors.New("Chunk exceeds the declared upload length")
+)
+
+// UploadSession is the state of a resumable upload. It is persisted in the
+// store between the HTTP requests that send the chunks of the file.
+type UploadSession struct {
+	// Doc is the document of the file that will be created when the upload is
+	// completed.
+	Doc *FileDoc `json:"doc"`
+	// OldRev is the revision of the file that will be overwritten by the
+	// upload, if any.
+	OldRev string `json:"old_rev,omitempty"`
+	// Overwrite is true when the upload replaces the content of an existing
+	// file (its identifier is given by Doc).
+	Overwrite bool `json:"overwrite,omitempty"`
+	// Offset is the number of bytes already received.
+	Offset int64 `json:"offset"`
+	// Chunks is the list of the offsets of the chunks already received.
+	Chunks    []int64   `json:"chunks"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Size returns the total number of bytes expected for this upload.
+func (u *UploadSession) Size() int64 {
+	return u.Doc.ByteSize
+}
+
+// Completed returns true when all the bytes of the file have been received.
+func (u *UploadSession) Completed() bool {
+	return u.Offset >= u.Doc.ByteSize
+}
+
+// Chunker defines an interface for the hidden filesystem where the chunks of
+// the resumable uploads are stored.
+type Chunker interface {
+	CreateChunk(sessionID string, offset int64) (ChunkFiler, error)
+	OpenChunk(sessionID string, offset int64) (io.ReadCloser, error)
+	RemoveChunks(sessionID string, offsets []int64) error
+	// ListSessions returns the identifiers of the sessions that have at
+	// least one chunk (or an empty directory) in the hidden filesystem.
+	ListSessions() ([]string, error)
+	// RemoveSession removes all the chunks of the given session.
+	RemoveSession(sessionID string) error
+}
+
+// ChunkFiler defines an interface to write a chunk of a resumable upload. It
+// is an io.Writer that can be aborted in case of error (a partial chunk is
+// discarded), or committed in case of success.
+type ChunkFiler interface {
+	io.Writer
+	Abort() error
+	Commit() error
+}
+
+// CheckAvailableDiskSpace returns ErrFileTooBig if a file of the given size
+// would exceed the disk quota of the instance.
+func CheckAvailableDiskSpace(fs VFS, size int64) error {
+	diskQuota := fs.DiskQuota()
+	if diskQuota <= 0 {
+		return nil
+	}
+	diskUsage, err := fs.DiskUsage()
+	if err != nil {
+		return err
+	}
+	if size > diskQuota-diskUsage {
+		return ErrFileTooBig
+	}
+	return nil
+}
+
+// CommitUpload copies the chunks of a completed upload session to a new file
+// (or a new version of an existing file) in the VFS. The MD5 checksum and the
+// disk quota are verified by the VFS when the file is closed. The chunks are
+// removed if the commit has succeeded.
+func CommitUpload(fs VFS, chunks Chunker, sessionID string, session *UploadSession) (newdoc *FileDoc, err error) {
+	newdoc = session.Doc.Clone().(*FileDoc)
+	var olddoc *FileDoc
+	if session.Overwrite {
+		olddoc, err = fs.FileByID(newdoc.ID())
+		if err != nil {
+			return nil, err
+		}
+		if session.OldRev != "" && session.OldRev != olddoc.Rev() {
+			return nil, ErrConflict
+		}
+		newdoc.ReferencedBy = olddoc.ReferencedBy
+	}
+
+	file, err := fs.CreateFile(newdoc, olddoc)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err == nil {
+			_ = chunks.RemoveChunks(sessionID, session.Chunks)
+		}
+	}()
+
+	for _, offset := range session.Chunks {
+		var r io.ReadCloser
+		r, err = chunks.OpenChunk(sessionID, offset)
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(file, r)
+		errc := r.Close()
+		if err == nil {
+			err = errc
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
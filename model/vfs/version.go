@@ -23,7 +23,10 @@ type Version struct {
 	Tags         []string          `json:"tags"`
 	Metadata     Metadata          `json:"metadata,omitempty"`
 	CozyMetadata FilesCozyMetadata `json:"cozyMetadata,omitempty"`
-	Rels         struct {
+	// EncryptionKeyID is the identifier of the key used for the encryption
+	// at rest of the content, or 0 if the content is stored in clear.
+	EncryptionKeyID uint32 `json:"encryption_key_id,omitempty"`
+	Rels            struct {
 		File struct {
 			Data struct {
 				ID   string `json:"_id"`
@@ -95,6 +98,8 @@ func NewVersion(file *FileDoc) *Version {
 		Tags:         file.Tags,
 		Metadata:     file.Metadata,
 		CozyMetadata: *fcm,
+
+		EncryptionKeyID: file.EncryptionKeyID,
 	}
 	v.Rels.File.Data.ID = file.ID()
 	v.Rels.File.Data.Type = consts.Files
@@ -117,6 +122,7 @@ func SetMetaFromVersion(file *FileDoc, version *Version) {
 	file.UpdatedAt = version.UpdatedAt
 	file.ByteSize = version.ByteSize
 	file.MD5Sum = version.MD5Sum
+	file.EncryptionKeyID = version.EncryptionKeyID
 	file.Tags = version.Tags
 	file.Metadata = version.Metadata
 	if file.CozyMetadata == nil {
@@ -86,3 +86,23 @@ func TestDetectVersionsToClean(t *testing.T) {
 	assert.Equal(t, &v4, toClean[2])
 	assert.Equal(t, &v5, toClean[3])
 }
+
+func TestBlobVersions(t *testing.T) {
+	fileID := uuidv4()
+	internalID := NewBlobInternalID()
+	assert.Len(t, internalID, 16)
+	assert.True(t, IsBlobInternalID(internalID))
+	assert.False(t, IsBlobInternalID(utils.RandomString(16)))
+
+	file := &FileDoc{DocID: fileID, DocRev: "1-abc", InternalID: internalID}
+	assert.True(t, IsBlobVersion(NewVersion(file)))
+	file.InternalID = ""
+	assert.False(t, IsBlobVersion(NewVersion(file)))
+
+	refs := make(BlobRefs)
+	md5sum := []byte{0xca, 0xfe, 0xba, 0xbe}
+	refs.AddFile(internalID, md5sum)
+	refs.AddFile("", md5sum)
+	refs.AddVersion(&Version{DocID: fileID + "/" + internalID, MD5Sum: md5sum})
+	assert.Equal(t, 2, refs["cafebabe"])
+}
@@ -196,6 +196,8 @@ type Indexer interface {
 
 	// CreateVersion adds a version to the CouchDB index.
 	CreateVersion(*Version) error
+	// UpdateVersion updates a version in the CouchDB index.
+	UpdateVersion(*Version) error
 	// DeleteVersion removes a version from the CouchDB index.
 	DeleteVersion(*Version) error
 	BatchDeleteVersions([]*Version) error
@@ -287,6 +289,8 @@ type DirOrFileDoc struct {
 	Trashed    bool     `json:"trashed,omitempty"`
 	Metadata   Metadata `json:"metadata,omitempty"`
 	InternalID string   `json:"internal_vfs_id,omitempty"`
+
+	EncryptionKeyID uint32 `json:"encryption_key_id,omitempty"`
 }
 
 // Clone is part of the couchdb.Doc interface
@@ -321,6 +325,8 @@ func (fd *DirOrFileDoc) Refine() (*DirDoc, *FileDoc) {
 			ReferencedBy: fd.ReferencedBy,
 			CozyMetadata: fd.CozyMetadata,
 			InternalID:   fd.InternalID,
+
+			EncryptionKeyID: fd.EncryptionKeyID,
 		}
 	}
 	return nil, nil
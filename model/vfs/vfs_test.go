@@ -31,6 +31,7 @@ import (
 var fs vfs.VFS
 var mutex lock.ErrorRWLocker
 var diskQuota int64
+var keyring = &keyringImpl{keys: make(map[uint32][]byte)}
 
 type diskImpl struct{}
 
@@ -38,6 +39,27 @@ func (d *diskImpl) DiskQuota() int64 {
 	return diskQuota
 }
 
+type keyringImpl struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (k *keyringImpl) CurrentFilesKey() (uint32, []byte, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *keyringImpl) FilesKey(id uint32) ([]byte, error) {
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+	return nil, vfs.ErrUnknownFilesKey
+}
+
+func (k *keyringImpl) CheckFilesKey(id uint32) error {
+	_, err := k.FilesKey(id)
+	return err
+}
+
 type H map[string]H
 
 func (h H) String() string {
@@ -746,6 +768,110 @@ func TestCreateFileTooBig(t *testing.T) {
 	assert.NoError(t, fs.DestroyDirContent(root, fs.EnsureErased))
 }
 
+func TestEncryption(t *testing.T) {
+	if _, ok := fs.(vfs.Reencrypter); !ok {
+		t.Skip("No encryption for this storage")
+	}
+	readContent := func(doc *vfs.FileDoc) ([]byte, error) {
+		f, err := fs.OpenFile(doc)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ioutil.ReadAll(f)
+	}
+	writeContent := func(doc *vfs.FileDoc, olddoc *vfs.FileDoc, content []byte) {
+		f, err := fs.CreateFile(doc, olddoc)
+		assert.NoError(t, err)
+		_, err = f.Write(content)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	// A file written before the encryption is enabled stays readable
+	clear := []byte("stored in clear")
+	doc, err := vfs.NewFileDoc("encrypted", consts.RootDirID, -1, nil,
+		"text/plain", "text", time.Now(), false, false, nil)
+	assert.NoError(t, err)
+	writeContent(doc, nil, clear)
+
+	conf := config.GetConfig()
+	conf.Fs.Encryption = true
+	defer func() {
+		conf.Fs.Encryption = false
+		keyring.current = 0
+		keyring.keys = make(map[uint32][]byte)
+	}()
+	keyring.current = 1
+	keyring.keys[1] = crypto.GenerateRandomBytes(32)
+
+	buf, err := readContent(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, clear, buf)
+
+	// A new version is encrypted, and the content can be read from any offset
+	content := crypto.GenerateRandomBytes(200 * 1024)
+	olddoc := doc
+	doc = olddoc.Clone().(*vfs.FileDoc)
+	doc.ByteSize = int64(len(content))
+	doc.MD5Sum = nil
+	writeContent(doc, olddoc, content)
+	doc, err = fs.FileByID(doc.ID())
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(content), doc.ByteSize)
+	assert.EqualValues(t, 1, doc.EncryptionKeyID)
+	buf, err = readContent(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf)
+
+	f, err := fs.OpenFile(doc)
+	assert.NoError(t, err)
+	part := make([]byte, 100)
+	n, err := f.ReadAt(part, 150*1024)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, content[150*1024:150*1024+100], part)
+	end, err := f.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(content), end)
+	assert.NoError(t, f.Close())
+
+	// Without the key, the content cannot be read
+	key1 := keyring.keys[1]
+	delete(keyring.keys, 1)
+	_, err = readContent(doc)
+	assert.Equal(t, vfs.ErrUnknownFilesKey, err)
+	keyring.keys[1] = key1
+
+	// Rotation of the key
+	keyring.current = 2
+	keyring.keys[2] = crypto.GenerateRandomBytes(32)
+	err = fs.(vfs.Reencrypter).Reencrypt(doc)
+	assert.NoError(t, err)
+	inUse, err := vfs.FilesKeyInUse(fs, 1)
+	assert.NoError(t, err)
+	assert.False(t, inUse)
+	delete(keyring.keys, 1)
+	doc, err = fs.FileByID(doc.ID())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, doc.EncryptionKeyID)
+	buf, err = readContent(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf)
+	versions, err := vfs.VersionsFor(fs, doc.ID())
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 1) {
+		v, err := fs.OpenFileVersion(doc, versions[0])
+		assert.NoError(t, err)
+		buf, err = ioutil.ReadAll(v)
+		assert.NoError(t, err)
+		assert.Equal(t, clear, buf)
+		assert.NoError(t, v.Close())
+	}
+
+	assert.NoError(t, fs.DestroyFile(doc))
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 
@@ -800,7 +926,7 @@ func makeAferoFS() (vfs.VFS, func(), error) {
 	db := prefixer.NewPrefixer("io.cozy.vfs.test", "io.cozy.vfs.test")
 	index := vfs.NewCouchdbIndexer(db)
 	mutex = lock.ReadWrite(db, "vfs-afero-test")
-	aferoFs, err := vfsafero.New(db, index, &diskImpl{}, mutex,
+	aferoFs, err := vfsafero.New(db, index, &diskImpl{}, keyring, mutex,
 		&url.URL{Scheme: "file", Host: "localhost", Path: tempdir}, "io.cozy.vfs.test")
 	if err != nil {
 		return nil, nil, err
@@ -860,7 +986,7 @@ func makeSwiftFS(layout int) (vfs.VFS, func(), error) {
 		swiftFs, err = vfsswift.NewV2(db, index, &diskImpl{}, mutex)
 	case 2:
 		mutex = lock.ReadWrite(db, "vfs-swiftv3-test")
-		swiftFs, err = vfsswift.NewV3(db, index, &diskImpl{}, mutex)
+		swiftFs, err = vfsswift.NewV3(db, index, &diskImpl{}, keyring, mutex)
 	}
 	if err != nil {
 		return nil, nil, err
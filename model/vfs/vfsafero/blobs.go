@@ -0,0 +1,132 @@
+package vfsafero
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/spf13/afero"
+)
+
+// The content-addressed storage for afero uses hard links: the files and
+// versions keep their usual paths, but the ones with the same content are
+// hard links to a blob in the BlobsDirName directory. As a consequence, it
+// works only on a local filesystem (file:// scheme), not in memory.
+func (afs *aferoVFS) contentAddressed() bool {
+	return afs.osFS && vfs.ContentAddressed()
+}
+
+func (afs *aferoVFS) realPath(name string) string {
+	return filepath.Join(afs.pth, filepath.FromSlash(name))
+}
+
+// errDanglingBlob is used when the blob document exists, but its content is
+// missing from the disk.
+var errDanglingBlob = errors.New("the blob is dangling, fsck will report it")
+
+// linkBlob acquires the blob for the content of the given file, and replaces
+// the file at the given path by a hard link to this blob. If the blob did not
+// exist, it is created as a hard link to the file before being referenced.
+// The encryption key of the document is the one of the blob. If the blob is
+// dangling, the file is left untouched and errDanglingBlob is returned.
+func (afs *aferoVFS) linkBlob(doc *vfs.FileDoc, name string) error {
+	blobpath := pathForBlob(doc.MD5Sum)
+	blob, created, err := vfs.AcquireBlob(afs, doc.MD5Sum, doc.ByteSize, doc.EncryptionKeyID, func() error {
+		return afs.storeBlob(blobpath, name)
+	})
+	if err != nil || created {
+		return err
+	}
+	if _, err = afs.fs.Stat(blobpath); err != nil {
+		// The blob is dangling: the file keeps its own content and key, and
+		// its reference is counted, so that fsck reports the missing blob
+		// with the right number of references. The blob is not repaired with
+		// this content, as the blob document may be referenced by files
+		// whose content has been encrypted with another key.
+		return errDanglingBlob
+	}
+	if err = afs.relinkBlob(blobpath, name); err == nil {
+		doc.EncryptionKeyID = blob.EncryptionKeyID
+	}
+	return err
+}
+
+// storeBlob creates the blob at the given path as a hard link to the file. A
+// file left at this path by a previous attempt is replaced, as its content
+// may have been encrypted with another key.
+func (afs *aferoVFS) storeBlob(blobpath, name string) error {
+	if err := afs.fs.MkdirAll(path.Dir(blobpath), 0755); err != nil {
+		return err
+	}
+	tmppath := path.Join(path.Dir(blobpath), ".cozy-blob-"+utils.RandomString(8))
+	if err := os.Link(afs.realPath(name), afs.realPath(tmppath)); err != nil {
+		return err
+	}
+	err := afs.fs.Rename(tmppath, blobpath)
+	if err != nil {
+		_ = afs.fs.Remove(tmppath)
+	}
+	return err
+}
+
+// relinkBlob replaces the file at the given path by a hard link to the blob.
+func (afs *aferoVFS) relinkBlob(blobpath, name string) error {
+	tmppath := path.Join(path.Dir(name), ".cozy-blob-"+utils.RandomString(8))
+	if err := os.Link(afs.realPath(blobpath), afs.realPath(tmppath)); err != nil {
+		return err
+	}
+	err := afs.fs.Rename(tmppath, name)
+	if err != nil {
+		_ = afs.fs.Remove(tmppath)
+	}
+	return err
+}
+
+// releaseBlob removes a reference to the blob of the given checksum, and
+// removes the blob from the disk if it was the last reference.
+func (afs *aferoVFS) releaseBlob(md5sum []byte) {
+	if freed, err := vfs.ReleaseBlob(afs, md5sum); err == nil && freed {
+		_ = afs.fs.Remove(pathForBlob(md5sum))
+	}
+}
+
+func (afs *aferoVFS) releaseFileBlob(doc *vfs.FileDoc) {
+	if vfs.IsBlobInternalID(doc.InternalID) {
+		afs.releaseBlob(doc.MD5Sum)
+	}
+}
+
+func (afs *aferoVFS) releaseVersionBlobs(versions []*vfs.Version) {
+	for _, v := range versions {
+		if vfs.IsBlobVersion(v) {
+			afs.releaseBlob(v.MD5Sum)
+		}
+	}
+}
+
+// storedBlobs returns the size of the blobs present on the disk, indexed by
+// their key.
+func (afs *aferoVFS) storedBlobs() (map[string]int64, error) {
+	stored := make(map[string]int64)
+	err := afero.Walk(afs.fs, vfs.BlobsDirName, func(_ string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			stored[info.Name()] = info.Size()
+		}
+		return nil
+	})
+	return stored, err
+}
+
+func pathForBlob(md5sum []byte) string {
+	key := vfs.BlobKey(md5sum)
+	return path.Join(vfs.BlobsDirName, key[:2], key)
+}
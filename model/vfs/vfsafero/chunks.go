@@ -0,0 +1,107 @@
+package vfsafero
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+)
+
+// NewChunksFs creates a new filesystem for the chunks of the resumable
+// uploads, based on a afero.Fs.
+func NewChunksFs(fs afero.Fs) vfs.Chunker {
+	return &chunks{fs}
+}
+
+type chunks struct {
+	fs afero.Fs
+}
+
+type chunk struct {
+	afero.File
+	fs      afero.Fs
+	tmpname string
+	newname string
+}
+
+func (c *chunk) Abort() error {
+	errc := c.File.Close()
+	errr := c.fs.Remove(c.tmpname)
+	if errc != nil {
+		return errc
+	}
+	return errr
+}
+
+func (c *chunk) Commit() error {
+	if err := c.File.Close(); err != nil {
+		_ = c.fs.Remove(c.tmpname)
+		return err
+	}
+	return c.fs.Rename(c.tmpname, c.newname)
+}
+
+func (c *chunks) CreateChunk(sessionID string, offset int64) (vfs.ChunkFiler, error) {
+	newname := c.makeName(sessionID, offset)
+	dir := path.Dir(newname)
+	if err := c.fs.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := afero.TempFile(c.fs, dir, "cozy-chunk")
+	if err != nil {
+		return nil, err
+	}
+	ch := &chunk{
+		File:    f,
+		fs:      c.fs,
+		tmpname: f.Name(),
+		newname: newname,
+	}
+	return ch, nil
+}
+
+func (c *chunks) OpenChunk(sessionID string, offset int64) (io.ReadCloser, error) {
+	return c.fs.Open(c.makeName(sessionID, offset))
+}
+
+func (c *chunks) RemoveChunks(sessionID string, offsets []int64) error {
+	var errm error
+	for _, offset := range offsets {
+		if err := c.fs.Remove(c.makeName(sessionID, offset)); err != nil && !os.IsNotExist(err) {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	if err := c.fs.RemoveAll(path.Join("/", sessionID)); err != nil {
+		errm = multierror.Append(errm, err)
+	}
+	return errm
+}
+
+func (c *chunks) ListSessions() ([]string, error) {
+	infos, err := afero.ReadDir(c.fs, "/")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sessions := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			sessions = append(sessions, info.Name())
+		}
+	}
+	return sessions, nil
+}
+
+func (c *chunks) RemoveSession(sessionID string) error {
+	return c.fs.RemoveAll(path.Join("/", sessionID))
+}
+
+func (c *chunks) makeName(sessionID string, offset int64) string {
+	return path.Join("/", sessionID, fmt.Sprintf("%020d", offset))
+}
@@ -0,0 +1,165 @@
+package vfsafero
+
+import (
+	"io"
+	"os"
+	"path"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/spf13/afero"
+)
+
+// Reencrypt rewrites the content of the file and of its old versions with the
+// current key of the instance, and records the new key in their documents.
+// With the content-addressed storage, the blob is rewritten and the file is
+// linked again to it.
+func (afs *aferoVFS) Reencrypt(doc *vfs.FileDoc) error {
+	if lockerr := afs.mu.Lock(); lockerr != nil {
+		return lockerr
+	}
+	defer afs.mu.Unlock()
+
+	// The document may have been modified since the walk has listed it.
+	doc, err := afs.Indexer.FileByID(doc.DocID)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	name, err := afs.Indexer.FilePath(doc)
+	if err != nil {
+		return err
+	}
+	blob := afs.contentAddressed() && vfs.IsBlobInternalID(doc.InternalID)
+	keyID, err := afs.reencryptContent(name, blob, doc.MD5Sum, doc.EncryptionKeyID)
+	if err != nil {
+		return err
+	}
+	if keyID != doc.EncryptionKeyID {
+		newdoc := doc.Clone().(*vfs.FileDoc)
+		newdoc.EncryptionKeyID = keyID
+		if err = afs.Indexer.UpdateFileDoc(doc, newdoc); err != nil {
+			return err
+		}
+	}
+
+	versions, err := vfs.VersionsFor(afs, doc.DocID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		blob := afs.contentAddressed() && vfs.IsBlobVersion(v)
+		keyID, err := afs.reencryptContent(pathForVersion(v), blob, v.MD5Sum, v.EncryptionKeyID)
+		if err != nil {
+			return err
+		}
+		if keyID != v.EncryptionKeyID {
+			v.EncryptionKeyID = keyID
+			if err = afs.Indexer.UpdateVersion(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reencryptContent rewrites the content at the given path, encrypted with the
+// given key, if it has not been encrypted with the current key. It returns
+// the identifier of the key of the content after that.
+func (afs *aferoVFS) reencryptContent(name string, blob bool, md5sum []byte, keyID uint32) (uint32, error) {
+	needed, err := vfs.NeedsReencryption(keyID, afs.keys)
+	if err != nil || !needed {
+		return keyID, err
+	}
+	if !blob {
+		return reencrypt(afs.fs, afs.keys, name, keyID)
+	}
+	// The blob may have already been rewritten for another file with the
+	// same content.
+	blobpath := pathForBlob(md5sum)
+	b, err := vfs.GetBlob(afs, md5sum)
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return keyID, err
+	}
+	if _, errs := afs.fs.Stat(blobpath); b == nil || os.IsNotExist(errs) {
+		// The blob is dangling, fsck will report it
+		return reencrypt(afs.fs, afs.keys, name, keyID)
+	}
+	blobKeyID := b.EncryptionKeyID
+	needed, err = vfs.NeedsReencryption(blobKeyID, afs.keys)
+	if err != nil {
+		return keyID, err
+	}
+	if needed {
+		if blobKeyID, err = reencrypt(afs.fs, afs.keys, blobpath, blobKeyID); err != nil {
+			return keyID, err
+		}
+		if err = vfs.SetBlobEncryptionKey(afs, md5sum, blobKeyID); err != nil {
+			return keyID, err
+		}
+	}
+	if err = afs.relinkBlob(blobpath, name); err != nil {
+		return keyID, err
+	}
+	return blobKeyID, nil
+}
+
+// reencrypt rewrites the content at the given path, that has been encrypted
+// with the given key (0 for a content in clear), with the current key. The
+// new content is written to a temporary file that replaces the old one, so
+// the hard links to the old file still point to the old content. It returns
+// the identifier of the key used for the new content.
+func reencrypt(fs afero.Fs, keys vfs.Keyring, name string, keyID uint32) (uint32, error) {
+	needed, err := vfs.NeedsReencryption(keyID, keys)
+	if err != nil || !needed {
+		return keyID, err
+	}
+	f, err := fs.Open(name)
+	if os.IsNotExist(err) {
+		return keyID, nil
+	}
+	if err != nil {
+		return keyID, err
+	}
+	defer f.Close()
+
+	infos, err := f.Stat()
+	if err != nil {
+		return keyID, err
+	}
+	var in io.Reader = f
+	dec, err := vfs.DecryptFile(f, f, infos.Size(), keyID, keys)
+	if err != nil {
+		return keyID, err
+	}
+	if dec != nil {
+		in = dec
+	}
+
+	tmp, err := afero.TempFile(fs, path.Dir(name), ".cozy-reencrypt")
+	if err != nil {
+		return keyID, err
+	}
+	tmpname := tmp.Name()
+	enc, newKeyID, err := vfs.EncryptWriter(tmp, keys)
+	if err == nil {
+		_, err = io.Copy(enc, in)
+		if errc := enc.Close(); err == nil {
+			err = errc
+		}
+	}
+	if errc := tmp.Close(); err == nil {
+		err = errc
+	}
+	if err == nil {
+		err = fs.Rename(tmpname, name)
+	}
+	if err != nil {
+		_ = fs.Remove(tmpname)
+		return keyID, err
+	}
+	return newKeyID, nil
+}
@@ -68,6 +68,16 @@ func (afs *aferoVFS) checkFiles(
 		return err
 	}
 
+	refs := make(vfs.BlobRefs)
+	for _, f := range entries {
+		if !f.IsDir {
+			refs.AddFile(f.InternalID, f.MD5Sum)
+		}
+	}
+	for _, v := range versions {
+		refs.AddVersion(v)
+	}
+
 	err = afero.Walk(afs.fs, "/", func(fullpath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -75,7 +85,9 @@ func (afs *aferoVFS) checkFiles(
 
 		if fullpath == vfs.WebappsDirName ||
 			fullpath == vfs.KonnectorsDirName ||
-			fullpath == vfs.ThumbsDirName {
+			fullpath == vfs.ThumbsDirName ||
+			fullpath == vfs.UploadsDirName ||
+			fullpath == vfs.BlobsDirName {
 			return filepath.SkipDir
 		}
 
@@ -125,13 +137,16 @@ func (afs *aferoVFS) checkFiles(
 				return errFailFast
 			}
 		} else if !f.IsDir {
-			var fd afero.File
-			fd, err = afs.fs.Open(fullpath)
+			// The content is read via open to check the decrypted content
+			// when the file is encrypted.
+			var fd vfs.File
+			fd, err = afs.open(fullpath, f.EncryptionKeyID)
 			if err != nil {
 				return err
 			}
 			h := md5.New()
-			if _, err = io.Copy(h, fd); err != nil {
+			var size int64
+			if size, err = io.Copy(h, fd); err != nil {
 				fd.Close()
 				return err
 			}
@@ -139,13 +154,13 @@ func (afs *aferoVFS) checkFiles(
 				return err
 			}
 			md5sum := h.Sum(nil)
-			if !bytes.Equal(md5sum, f.MD5Sum) || f.ByteSize != info.Size() {
+			if !bytes.Equal(md5sum, f.MD5Sum) || f.ByteSize != size {
 				accumulate(&vfs.FsckLog{
 					Type:    vfs.ContentMismatch,
 					IsFile:  true,
 					FileDoc: f,
 					ContentMismatch: &vfs.FsckContentMismatch{
-						SizeFile:    info.Size(),
+						SizeFile:    size,
 						SizeIndex:   f.ByteSize,
 						MD5SumFile:  md5sum,
 						MD5SumIndex: f.MD5Sum,
@@ -196,7 +211,14 @@ func (afs *aferoVFS) checkFiles(
 		}
 	}
 
-	return nil
+	stored, err := afs.storedBlobs()
+	if err != nil {
+		return err
+	}
+	if !afs.contentAddressed() && len(refs) == 0 && len(stored) == 0 {
+		return nil
+	}
+	return vfs.CheckBlobsConsistency(afs, refs, stored, accumulate, failFast)
 }
 
 func fileInfosToDirDoc(fullpath string, fileinfo os.FileInfo) *vfs.TreeFile {
@@ -18,6 +18,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/filetype"
 	"github.com/cozy/cozy-stack/pkg/lock"
+	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 
 	"github.com/spf13/afero"
@@ -37,6 +38,7 @@ type aferoVFS struct {
 	fs     afero.Fs
 	mu     lock.ErrorRWLocker
 	pth    string
+	keys   vfs.Keyring
 
 	// whether or not the localfilesystem requires an initialisation of its root
 	// directory
@@ -57,7 +59,10 @@ func GetMemFS(key string) afero.Fs {
 //
 // The supported scheme of the storage url are file://, for an OS-FS store, and
 // mem:// for an in-memory store. The backend used is the afero package.
-func New(db prefixer.Prefixer, index vfs.Indexer, disk vfs.DiskThresholder, mu lock.ErrorRWLocker, fsURL *url.URL, pathSegment string) (vfs.VFS, error) {
+//
+// The keyring is used for the encryption at rest of the files. It can be nil
+// if the files are stored in clear.
+func New(db prefixer.Prefixer, index vfs.Indexer, disk vfs.DiskThresholder, keys vfs.Keyring, mu lock.ErrorRWLocker, fsURL *url.URL, pathSegment string) (vfs.VFS, error) {
 	if fsURL.Scheme != "mem" && fsURL.Path == "" {
 		return nil, fmt.Errorf("vfsafero: please check the supplied fs url: %s",
 			fsURL.String())
@@ -84,6 +89,7 @@ func New(db prefixer.Prefixer, index vfs.Indexer, disk vfs.DiskThresholder, mu l
 		fs:     fs,
 		mu:     mu,
 		pth:    pth,
+		keys:   keys,
 		// for now, only the file:// scheme needs a specific initialisation of its
 		// root directory.
 		osFS: fsURL.Scheme == "file",
@@ -106,6 +112,7 @@ func (afs *aferoVFS) UseSharingIndexer(index vfs.Indexer) vfs.VFS {
 		fs:              afs.fs,
 		mu:              afs.mu,
 		pth:             afs.pth,
+		keys:            afs.keys,
 		osFS:            afs.osFS,
 	}
 }
@@ -214,11 +221,24 @@ func (afs *aferoVFS) CreateFile(newdoc, olddoc *vfs.FileDoc) (vfs.File, error) {
 		}
 	}
 
+	if afs.contentAddressed() {
+		newdoc.InternalID = vfs.NewBlobInternalID()
+	} else if vfs.IsBlobInternalID(newdoc.InternalID) {
+		newdoc.InternalID = ""
+	}
+
 	f, err := afero.TempFile(afs.fs, "/", newdoc.DocName)
 	if err != nil {
 		return nil, err
 	}
 	tmppath := path.Join("/", f.Name())
+	enc, keyID, err := vfs.EncryptWriter(f, afs.keys)
+	if err != nil {
+		_ = f.Close()
+		_ = afs.fs.Remove(tmppath)
+		return nil, err
+	}
+	newdoc.EncryptionKeyID = keyID
 
 	hash := md5.New()
 	extractor := vfs.NewMetaExtractor(newdoc)
@@ -226,6 +246,7 @@ func (afs *aferoVFS) CreateFile(newdoc, olddoc *vfs.FileDoc) (vfs.File, error) {
 	return &aferoFileCreation{
 		afs:     afs,
 		f:       f,
+		enc:     enc,
 		newdoc:  newdoc,
 		olddoc:  olddoc,
 		tmppath: tmppath,
@@ -266,11 +287,13 @@ func (afs *aferoVFS) DestroyDirContent(doc *vfs.DirDoc, push func(vfs.TrashJourn
 	}
 	var allVersions []*vfs.Version
 	for _, file := range files {
+		afs.releaseFileBlob(file)
 		_ = afs.fs.RemoveAll(pathForVersions(file.DocID))
 		if versions, err := vfs.VersionsFor(afs, file.DocID); err == nil {
 			allVersions = append(allVersions, versions...)
 		}
 	}
+	afs.releaseVersionBlobs(allVersions)
 	return afs.Indexer.BatchDeleteVersions(allVersions)
 }
 
@@ -290,11 +313,13 @@ func (afs *aferoVFS) DestroyDirAndContent(doc *vfs.DirDoc, push func(vfs.TrashJo
 	}
 	var allVersions []*vfs.Version
 	for _, file := range files {
+		afs.releaseFileBlob(file)
 		_ = afs.fs.RemoveAll(pathForVersions(file.DocID))
 		if versions, err := vfs.VersionsFor(afs, file.DocID); err == nil {
 			allVersions = append(allVersions, versions...)
 		}
 	}
+	afs.releaseVersionBlobs(allVersions)
 	return afs.Indexer.BatchDeleteVersions(allVersions)
 }
 
@@ -316,11 +341,13 @@ func (afs *aferoVFS) DestroyFile(doc *vfs.FileDoc) error {
 	if err = afs.Indexer.DeleteFileDoc(doc); err != nil {
 		return err
 	}
+	afs.releaseFileBlob(doc)
 	versions, err := vfs.VersionsFor(afs, doc.DocID)
 	if err != nil {
 		return err
 	}
 	_ = afs.fs.RemoveAll(pathForVersions(doc.DocID))
+	afs.releaseVersionBlobs(versions)
 	return afs.Indexer.BatchDeleteVersions(versions)
 }
 
@@ -333,11 +360,7 @@ func (afs *aferoVFS) OpenFile(doc *vfs.FileDoc) (vfs.File, error) {
 	if err != nil {
 		return nil, err
 	}
-	f, err := afs.fs.Open(name)
-	if err != nil {
-		return nil, err
-	}
-	return &aferoFileOpen{f}, nil
+	return afs.open(name, doc.EncryptionKeyID)
 }
 
 func (afs *aferoVFS) EnsureErased(journal vfs.TrashJournal) error {
@@ -349,11 +372,31 @@ func (afs *aferoVFS) OpenFileVersion(doc *vfs.FileDoc, version *vfs.Version) (vf
 		return nil, lockerr
 	}
 	defer afs.mu.RUnlock()
-	f, err := afs.fs.Open(pathForVersion(version))
+	return afs.open(pathForVersion(version), version.EncryptionKeyID)
+}
+
+// open returns a file handle for reading the content at the given path. The
+// content is decrypted if it has been encrypted with the given key (0 for a
+// content in clear).
+func (afs *aferoVFS) open(name string, keyID uint32) (vfs.File, error) {
+	f, err := afs.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == 0 {
+		return &aferoFileOpen{f}, nil
+	}
+	infos, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	dec, err := vfs.DecryptFile(f, f, infos.Size(), keyID, afs.keys)
 	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
-	return &aferoFileOpen{f}, nil
+	return dec, nil
 }
 
 func (afs *aferoVFS) RevertFileVersion(doc *vfs.FileDoc, version *vfs.Version) error {
@@ -382,6 +425,12 @@ func (afs *aferoVFS) RevertFileVersion(doc *vfs.FileDoc, version *vfs.Version) e
 
 	newdoc := doc.Clone().(*vfs.FileDoc)
 	vfs.SetMetaFromVersion(newdoc, version)
+	// The internal ID of the saved version must not be reused, and the file
+	// must keep the blob of the version if it has one.
+	newdoc.InternalID = ""
+	if vfs.IsBlobVersion(version) {
+		newdoc.InternalID = strings.SplitN(version.DocID, "/", 2)[1]
+	}
 	if err = afs.Indexer.UpdateFileDoc(doc, newdoc); err != nil {
 		_ = afs.fs.Rename(mainpath, frompath)
 		_ = afs.fs.Rename(savepath, mainpath)
@@ -540,6 +589,7 @@ func (f *aferoFileOpen) Close() error {
 type aferoFileCreation struct {
 	afs     *aferoVFS          // parent vfs
 	f       afero.File         // file handle
+	enc     io.WriteCloser     // encrypts the content written to f
 	newdoc  *vfs.FileDoc       // new document
 	olddoc  *vfs.FileDoc       // old document
 	tmppath string             // temporary file path for uploading a new version of this file
@@ -572,7 +622,7 @@ func (f *aferoFileCreation) Write(p []byte) (int, error) {
 		}
 	}
 
-	n, err := f.f.Write(p)
+	n, err := f.enc.Write(p)
 	if err != nil {
 		f.err = err
 		return n, err
@@ -608,6 +658,10 @@ func (f *aferoFileCreation) Close() (err error) {
 		}
 	}()
 
+	if errc := f.enc.Close(); errc != nil && f.err == nil {
+		f.err = errc
+	}
+
 	if err = f.f.Close(); err != nil {
 		if f.meta != nil {
 			(*f.meta).Abort(err)
@@ -661,6 +715,25 @@ func (f *aferoFileCreation) Close() (err error) {
 		return vfs.ErrParentInTrash
 	}
 
+	// The old keys are removed when no document references them, so we must
+	// check that the key is still there before saving the document, under
+	// the same lock as the removal of the keys.
+	if err = vfs.CheckEncryptionKey(f.afs.keys, newdoc.EncryptionKeyID); err != nil {
+		return err
+	}
+
+	// With the content-addressed storage, the temporary file is replaced by
+	// a hard link to the blob with the same content (the hard link survives
+	// the rename below). If it fails, the file is kept as is, and will be
+	// reported by fsck.
+	isBlob := vfs.IsBlobInternalID(newdoc.InternalID)
+	if isBlob {
+		if errb := f.afs.linkBlob(newdoc, f.tmppath); errb != nil {
+			logger.WithDomain(f.afs.domain).WithField("nspace", "vfsafero").
+				Warnf("Cannot link the blob for %s: %s", newdoc.DocID, errb)
+		}
+	}
+
 	var v *vfs.Version
 	if olddoc != nil {
 		v = vfs.NewVersion(olddoc)
@@ -671,6 +744,9 @@ func (f *aferoFileCreation) Close() (err error) {
 		err = f.afs.Indexer.CreateNamedFileDoc(newdoc)
 	}
 	if err != nil {
+		if isBlob {
+			f.afs.releaseFileBlob(newdoc)
+		}
 		return err
 	}
 
@@ -703,6 +779,7 @@ func (f *aferoFileCreation) Close() (err error) {
 		if cleanV {
 			vPath := pathForVersion(v)
 			_ = f.afs.fs.Remove(vPath)
+			f.afs.releaseVersionBlobs([]*vfs.Version{v})
 		}
 		for _, old := range toClean {
 			cleanOldVersion(f.afs, old)
@@ -778,6 +855,7 @@ func cleanOldVersion(afs *aferoVFS, v *vfs.Version) {
 	if err := afs.Indexer.DeleteVersion(v); err == nil {
 		vPath := pathForVersion(v)
 		_ = afs.fs.Remove(vPath)
+		afs.releaseVersionBlobs([]*vfs.Version{v})
 	}
 }
 
@@ -2,6 +2,7 @@ package vfsafero
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -11,27 +12,43 @@ import (
 	"github.com/spf13/afero"
 )
 
-// NewThumbsFs creates a new thumb filesystem base on a afero.Fs.
-func NewThumbsFs(fs afero.Fs) vfs.Thumbser {
-	return &thumbs{fs}
+// NewThumbsFs creates a new thumb filesystem base on a afero.Fs. The keyring
+// is used for the encryption at rest of the thumbnails, and can be nil.
+func NewThumbsFs(fs afero.Fs, keys vfs.Keyring) vfs.Thumbser {
+	return &thumbs{fs, keys}
 }
 
 type thumbs struct {
-	fs afero.Fs
+	fs   afero.Fs
+	keys vfs.Keyring
 }
 
 type thumb struct {
 	afero.File
+	enc     io.WriteCloser
 	fs      afero.Fs
 	tmpname string
 	newname string
 }
 
+func (t *thumb) Write(p []byte) (int, error) {
+	return t.enc.Write(p)
+}
+
 func (t *thumb) Abort() error {
+	_ = t.File.Close()
 	return t.fs.Remove(t.tmpname)
 }
 
 func (t *thumb) Commit() error {
+	if err := t.enc.Close(); err != nil {
+		_ = t.Abort()
+		return err
+	}
+	if err := t.File.Close(); err != nil {
+		_ = t.fs.Remove(t.tmpname)
+		return err
+	}
 	return t.fs.Rename(t.tmpname, t.newname)
 }
 
@@ -48,8 +65,15 @@ func (t *thumbs) CreateThumb(img *vfs.FileDoc, format string) (vfs.ThumbFiler, e
 		return nil, err
 	}
 	tmpname := f.Name()
+	enc, _, err := vfs.EncryptWriter(f, t.keys)
+	if err != nil {
+		_ = f.Close()
+		_ = t.fs.Remove(tmpname)
+		return nil, err
+	}
 	th := &thumb{
 		File:    f,
+		enc:     enc,
 		fs:      t.fs,
 		tmpname: tmpname,
 		newname: newname,
@@ -91,10 +115,50 @@ func (t *thumbs) ServeThumbContent(w http.ResponseWriter, req *http.Request,
 		return err
 	}
 	defer f.Close()
+	dec, err := vfs.DecryptThumb(f, f, s.Size(), t.keys)
+	if err != nil {
+		return err
+	}
+	if dec != nil {
+		http.ServeContent(w, req, name, s.ModTime(), dec)
+		return nil
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
 	http.ServeContent(w, req, name, s.ModTime(), f)
 	return nil
 }
 
+func (t *thumbs) ReencryptThumbs(img *vfs.FileDoc, formats []string) error {
+	for _, format := range formats {
+		if err := t.reencryptThumb(t.makeName(img, format)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reencryptThumb rewrites the thumbnail at the given path if it has not been
+// encrypted with the current key. The key of a thumbnail is given by the
+// header of its content.
+func (t *thumbs) reencryptThumb(name string) error {
+	f, err := t.fs.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keyID, err := vfs.ThumbEncryptionKey(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	_, err = reencrypt(t.fs, t.keys, name, keyID)
+	return err
+}
+
 func (t *thumbs) makeName(img *vfs.FileDoc, format string) string {
 	dir := img.ID()[:4]
 	name := fmt.Sprintf("%s-%s.jpg", img.ID(), format)
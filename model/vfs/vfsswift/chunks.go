@@ -0,0 +1,162 @@
+package vfsswift
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/ncw/swift"
+)
+
+// NewChunksFs creates a new filesystem for the chunks of the resumable
+// uploads, based on swift. The chunks are stored in the same container as the
+// thumbnails.
+func NewChunksFs(c *swift.Connection, domain string) vfs.Chunker {
+	return &chunks{c: c, container: swiftV1DataContainerPrefix + domain}
+}
+
+// NewChunksFsV2 creates a new filesystem for the chunks of the resumable
+// uploads, based on swift. The chunks are stored in the same container as the
+// thumbnails.
+func NewChunksFsV2(c *swift.Connection, db prefixer.Prefixer) vfs.Chunker {
+	return &chunks{c: c, container: swiftV2ContainerPrefixData + db.DBPrefix()}
+}
+
+// NewChunksFsV3 creates a new filesystem for the chunks of the resumable
+// uploads, based on swift. The chunks are stored in the same container as the
+// data, with an "uploads/" prefix.
+func NewChunksFsV3(c *swift.Connection, db prefixer.Prefixer) vfs.Chunker {
+	return &chunks{c: c, container: swiftV3ContainerPrefix + db.DBPrefix()}
+}
+
+type chunks struct {
+	c         *swift.Connection
+	container string
+}
+
+type chunk struct {
+	io.WriteCloser
+	c         *swift.Connection
+	container string
+	name      string
+}
+
+func (ch *chunk) Abort() error {
+	errc := ch.WriteCloser.Close()
+	errd := ch.c.ObjectDelete(ch.container, ch.name)
+	if errc != nil {
+		return errc
+	}
+	if errd != nil && errd != swift.ObjectNotFound {
+		return errd
+	}
+	return nil
+}
+
+func (ch *chunk) Commit() error {
+	return ch.WriteCloser.Close()
+}
+
+func (c *chunks) CreateChunk(sessionID string, offset int64) (vfs.ChunkFiler, error) {
+	name := c.makeName(sessionID, offset)
+	obj, err := c.c.ObjectCreate(c.container, name, true, "", "application/octet-stream", nil)
+	if err != nil {
+		if _, _, errc := c.c.Container(c.container); errc == swift.ContainerNotFound {
+			if errc = c.c.ContainerCreate(c.container, nil); errc != nil {
+				return nil, err
+			}
+			obj, err = c.c.ObjectCreate(c.container, name, true, "", "application/octet-stream", nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	ch := &chunk{
+		WriteCloser: obj,
+		c:           c.c,
+		container:   c.container,
+		name:        name,
+	}
+	return ch, nil
+}
+
+func (c *chunks) OpenChunk(sessionID string, offset int64) (io.ReadCloser, error) {
+	f, _, err := c.c.ObjectOpen(c.container, c.makeName(sessionID, offset), false, nil)
+	if err != nil {
+		return nil, wrapSwiftErr(err)
+	}
+	return f, nil
+}
+
+func (c *chunks) RemoveChunks(sessionID string, offsets []int64) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	objNames := make([]string, len(offsets))
+	for i, offset := range offsets {
+		objNames[i] = c.makeName(sessionID, offset)
+	}
+	_, err := c.c.BulkDelete(c.container, objNames)
+	if err == swift.Forbidden {
+		err = nil
+		for _, objName := range objNames {
+			errd := c.c.ObjectDelete(c.container, objName)
+			if err == nil && errd != nil && errd != swift.ObjectNotFound {
+				err = errd
+			}
+		}
+	}
+	return err
+}
+
+func (c *chunks) ListSessions() ([]string, error) {
+	names, err := c.c.ObjectNamesAll(c.container, &swift.ObjectsOpts{
+		Prefix:    chunksPrefix,
+		Delimiter: '/',
+	})
+	if err == swift.ContainerNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]string, 0, len(names))
+	for _, name := range names {
+		sessionID := strings.TrimSuffix(strings.TrimPrefix(name, chunksPrefix), "/")
+		if sessionID != "" {
+			sessions = append(sessions, sessionID)
+		}
+	}
+	return sessions, nil
+}
+
+func (c *chunks) RemoveSession(sessionID string) error {
+	objNames, err := c.c.ObjectNamesAll(c.container, &swift.ObjectsOpts{
+		Prefix: chunksPrefix + sessionID + "/",
+	})
+	if err == swift.ContainerNotFound || len(objNames) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.c.BulkDelete(c.container, objNames)
+	if err == swift.Forbidden {
+		err = nil
+		for _, objName := range objNames {
+			errd := c.c.ObjectDelete(c.container, objName)
+			if err == nil && errd != nil && errd != swift.ObjectNotFound {
+				err = errd
+			}
+		}
+	}
+	return err
+}
+
+const chunksPrefix = "uploads/"
+
+func (c *chunks) makeName(sessionID string, offset int64) string {
+	return fmt.Sprintf("%s%s/%020d", chunksPrefix, sessionID, offset)
+}
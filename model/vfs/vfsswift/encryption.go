@@ -0,0 +1,183 @@
+package vfsswift
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/ncw/swift"
+)
+
+// Reencrypt rewrites the content of the file and of its old versions with the
+// current key of the instance, and records the new key in their documents.
+// With the content-addressed storage, the blob is rewritten, and it is done
+// only once for all the files that share it.
+func (sfs *swiftVFSV3) Reencrypt(doc *vfs.FileDoc) error {
+	if lockerr := sfs.mu.Lock(); lockerr != nil {
+		return lockerr
+	}
+	defer sfs.mu.Unlock()
+
+	// The document may have been modified since the walk has listed it.
+	doc, err := sfs.Indexer.FileByID(doc.DocID)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	keyID, err := sfs.reencryptContent(doc.DocID, doc.InternalID, doc.MD5Sum, doc.EncryptionKeyID)
+	if err != nil {
+		return err
+	}
+	if keyID != doc.EncryptionKeyID {
+		newdoc := doc.Clone().(*vfs.FileDoc)
+		newdoc.EncryptionKeyID = keyID
+		if err = sfs.Indexer.UpdateFileDoc(doc, newdoc); err != nil {
+			return err
+		}
+	}
+
+	versions, err := vfs.VersionsFor(sfs, doc.DocID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		internalID := v.DocID
+		if parts := strings.SplitN(v.DocID, "/", 2); len(parts) > 1 {
+			internalID = parts[1]
+		}
+		keyID, err := sfs.reencryptContent(doc.DocID, internalID, v.MD5Sum, v.EncryptionKeyID)
+		if err != nil {
+			return err
+		}
+		if keyID != v.EncryptionKeyID {
+			v.EncryptionKeyID = keyID
+			if err = sfs.Indexer.UpdateVersion(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reencryptContent rewrites the object of a file or version, encrypted with
+// the given key, if it has not been encrypted with the current key. It
+// returns the identifier of the key of the content after that.
+func (sfs *swiftVFSV3) reencryptContent(docID, internalID string, md5sum []byte, keyID uint32) (uint32, error) {
+	objName := objectNameV3(docID, internalID, md5sum)
+	if !vfs.IsBlobInternalID(internalID) {
+		return reencrypt(sfs.c, sfs.container, objName, sfs.keys, keyID)
+	}
+	// The blob may have already been rewritten for another file with the
+	// same content.
+	blob, err := vfs.GetBlob(sfs, md5sum)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		// The blob is dangling, fsck will report it
+		return reencrypt(sfs.c, sfs.container, objName, sfs.keys, keyID)
+	}
+	if err != nil {
+		return keyID, err
+	}
+	blobKeyID, err := reencrypt(sfs.c, sfs.container, objName, sfs.keys, blob.EncryptionKeyID)
+	if err != nil {
+		return keyID, err
+	}
+	if blobKeyID != blob.EncryptionKeyID {
+		if err = vfs.SetBlobEncryptionKey(sfs, md5sum, blobKeyID); err != nil {
+			return keyID, err
+		}
+	}
+	return blobKeyID, nil
+}
+
+func (t *thumbsV2) ReencryptThumbs(img *vfs.FileDoc, formats []string) error {
+	for _, format := range formats {
+		if err := t.reencryptThumb(t.makeName(img, format)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reencryptThumb rewrites the given thumbnail if it has not been encrypted
+// with the current key. The key of a thumbnail is given by the header of its
+// content.
+func (t *thumbsV2) reencryptThumb(objName string) error {
+	f, _, err := t.c.ObjectOpen(t.container, objName, false, nil)
+	if err == swift.ObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keyID, err := vfs.ThumbEncryptionKey(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	_, err = reencrypt(t.c, t.container, objName, t.keys, keyID)
+	return err
+}
+
+// reencrypt rewrites the content of the given object, that has been encrypted
+// with the given key (0 for a content in clear), with the current key. The
+// new content is uploaded to a temporary object, with the same metadata, that
+// is then moved in place of the old object. It returns the identifier of the
+// key used for the new content.
+func reencrypt(c *swift.Connection, container, objName string, keys vfs.Keyring, keyID uint32) (uint32, error) {
+	needed, err := vfs.NeedsReencryption(keyID, keys)
+	if err != nil || !needed {
+		return keyID, err
+	}
+	f, headers, err := c.ObjectOpen(container, objName, false, nil)
+	if err == swift.ObjectNotFound {
+		return keyID, nil
+	}
+	if err != nil {
+		return keyID, err
+	}
+	defer f.Close()
+
+	size, err := f.Length()
+	if err != nil {
+		return keyID, err
+	}
+	var in io.Reader = f
+	dec, err := vfs.DecryptFile(f, f, size, keyID, keys)
+	if err != nil {
+		return keyID, err
+	}
+	if dec != nil {
+		in = dec
+	}
+
+	tmpName := objName + ".reencrypt-" + utils.RandomString(8)
+	out, err := c.ObjectCreate(container, tmpName, true, "",
+		headers["Content-Type"], headers.ObjectMetadata().ObjectHeaders())
+	if err != nil {
+		return keyID, err
+	}
+	enc, newKeyID, err := vfs.EncryptWriter(out, keys)
+	if err == nil {
+		_, err = io.Copy(enc, in)
+		if errc := enc.Close(); err == nil {
+			err = errc
+		}
+	}
+	if errc := out.Close(); err == nil {
+		err = errc
+	}
+	if err == nil {
+		err = c.ObjectMove(container, tmpName, container, objName)
+	}
+	if err != nil {
+		_ = c.ObjectDelete(container, tmpName)
+		return keyID, err
+	}
+	return newKeyID, nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/ncw/swift"
 )
 
@@ -63,13 +64,35 @@ func (sfs *swiftVFSV3) checkFiles(
 		return err
 	}
 
+	// The files and versions stored as blobs are checked via the blobs
+	// reference counters.
+	refs := make(vfs.BlobRefs)
+	for key, f := range entries {
+		if vfs.IsBlobInternalID(f.InternalID) {
+			refs.AddFile(f.InternalID, f.MD5Sum)
+			delete(entries, key)
+		}
+	}
+	for key, v := range versions {
+		if vfs.IsBlobVersion(v) {
+			refs.AddVersion(v)
+			delete(versions, key)
+		}
+	}
+	stored := make(map[string]int64)
+
 	err = sfs.c.ObjectsWalk(sfs.container, nil, func(opts *swift.ObjectsOpts) (interface{}, error) {
 		objs, err := sfs.c.Objects(sfs.container, opts)
 		if err != nil {
 			return nil, err
 		}
 		for _, obj := range objs {
-			if strings.HasPrefix(obj.Name, "thumbs/") {
+			if strings.HasPrefix(obj.Name, blobsPrefixV3) {
+				stored[strings.TrimPrefix(obj.Name, blobsPrefixV3)] = obj.Bytes
+				continue
+			}
+			if strings.HasPrefix(obj.Name, "thumbs/") ||
+				strings.HasPrefix(obj.Name, "uploads/") {
 				continue
 			}
 			docID, internalID := makeDocIDV3(obj.Name)
@@ -79,7 +102,7 @@ func (sfs *swiftVFSV3) checkFiles(
 				if err != nil {
 					return nil, err
 				}
-				if !bytes.Equal(md5sum, v.MD5Sum) || v.ByteSize != obj.Bytes {
+				if !sameContentV3(obj, md5sum, v.MD5Sum, v.ByteSize) {
 					accumulate(&vfs.FsckLog{
 						Type:       vfs.ContentMismatch,
 						IsVersion:  true,
@@ -114,7 +137,7 @@ func (sfs *swiftVFSV3) checkFiles(
 				if err != nil {
 					return nil, err
 				}
-				if !bytes.Equal(md5sum, f.MD5Sum) || f.ByteSize != obj.Bytes {
+				if !sameContentV3(obj, md5sum, f.MD5Sum, f.ByteSize) {
 					accumulate(&vfs.FsckLog{
 						Type:    vfs.ContentMismatch,
 						IsFile:  true,
@@ -166,7 +189,10 @@ func (sfs *swiftVFSV3) checkFiles(
 		}
 	}
 
-	return nil
+	if !vfs.ContentAddressed() && len(refs) == 0 && len(stored) == 0 {
+		return nil
+	}
+	return vfs.CheckBlobsConsistency(sfs, refs, stored, accumulate, failFast)
 }
 
 func objectToFileDocV3(container string, object swift.Object) *vfs.TreeFile {
@@ -193,3 +219,13 @@ func objectToFileDocV3(container string, object swift.Object) *vfs.TreeFile {
 		},
 	}
 }
+
+// sameContentV3 returns true if the object has the expected checksum and
+// size. For an encrypted content, only its size can be checked, as the
+// checksum computed by Swift is the one of the encrypted content.
+func sameContentV3(obj swift.Object, md5sum, expectedMD5 []byte, expectedSize int64) bool {
+	if bytes.Equal(md5sum, expectedMD5) && obj.Bytes == expectedSize {
+		return true
+	}
+	return obj.Bytes == crypto.EncryptedSize(expectedSize)
+}
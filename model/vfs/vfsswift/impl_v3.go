@@ -2,7 +2,9 @@ package vfsswift
 
 import (
 	"bytes"
+	"crypto/md5"
 	"encoding/hex"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
@@ -30,6 +32,7 @@ type swiftVFSV3 struct {
 	container string
 	mu        lock.ErrorRWLocker
 	log       *logrus.Entry
+	keys      vfs.Keyring
 }
 
 const swiftV3ContainerPrefix = "cozy-v3-"
@@ -44,7 +47,10 @@ const swiftV3ContainerPrefix = "cozy-v3-"
 // in the name), and it is poor in features (for example, we want to swap an
 // old version with the current version without having to download/upload
 // contents, and it is not supported).
-func NewV3(db prefixer.Prefixer, index vfs.Indexer, disk vfs.DiskThresholder, mu lock.ErrorRWLocker) (vfs.VFS, error) {
+//
+// The keyring is used for the encryption at rest of the files. It can be nil
+// if the files are stored in clear.
+func NewV3(db prefixer.Prefixer, index vfs.Indexer, disk vfs.DiskThresholder, keys vfs.Keyring, mu lock.ErrorRWLocker) (vfs.VFS, error) {
 	return &swiftVFSV3{
 		Indexer:         index,
 		DiskThresholder: disk,
@@ -55,6 +61,7 @@ func NewV3(db prefixer.Prefixer, index vfs.Indexer, disk vfs.DiskThresholder, mu
 		container: swiftV3ContainerPrefix + db.DBPrefix(),
 		mu:        mu,
 		log:       logger.WithDomain(db.DomainName()).WithField("nspace", "vfsswift"),
+		keys:      keys,
 	}, nil
 }
 
@@ -75,6 +82,48 @@ func MakeObjectNameV3(docID, internalID string) string {
 	return docID[:22] + "/" + docID[22:27] + "/" + docID[27:] + "/" + internalID
 }
 
+// blobsPrefixV3 is the prefix of the swift objects used by the
+// content-addressed storage. They are named by the hexadecimal representation
+// of the MD5 checksum of their content.
+const blobsPrefixV3 = "blobs/"
+
+func blobObjectNameV3(md5sum []byte) string {
+	return blobsPrefixV3 + vfs.BlobKey(md5sum)
+}
+
+// objectNameV3 returns the name of the swift object with the content of a
+// file or version: the shared blob if the content is deduplicated, or an
+// object specific to this file or version otherwise.
+func objectNameV3(docID, internalID string, md5sum []byte) string {
+	if vfs.IsBlobInternalID(internalID) {
+		return blobObjectNameV3(md5sum)
+	}
+	return MakeObjectNameV3(docID, internalID)
+}
+
+// releaseObjectV3 returns the name of the swift object that can be deleted
+// when a file or version is destroyed, or an empty string if the content is
+// a blob still referenced by other files or versions.
+func (sfs *swiftVFSV3) releaseObjectV3(docID, internalID string, md5sum []byte) string {
+	if !vfs.IsBlobInternalID(internalID) {
+		return MakeObjectNameV3(docID, internalID)
+	}
+	return sfs.releaseBlobV3(md5sum)
+}
+
+// releaseBlobV3 removes a reference to the blob with the given checksum, and
+// returns the name of its swift object if it can be deleted.
+func (sfs *swiftVFSV3) releaseBlobV3(md5sum []byte) string {
+	freed, err := vfs.ReleaseBlob(sfs, md5sum)
+	if err != nil {
+		sfs.log.Warnf("Cannot release the blob %s: %s", vfs.BlobKey(md5sum), err)
+	}
+	if !freed {
+		return ""
+	}
+	return blobObjectNameV3(md5sum)
+}
+
 func makeDocIDV3(objName string) (string, string) {
 	if len(objName) != 51 {
 		parts := strings.SplitN(objName, "/", 2)
@@ -103,6 +152,7 @@ func (sfs *swiftVFSV3) UseSharingIndexer(index vfs.Indexer) vfs.VFS {
 		container:       sfs.container,
 		mu:              sfs.mu,
 		log:             sfs.log,
+		keys:            sfs.keys,
 	}
 }
 
@@ -215,7 +265,11 @@ func (sfs *swiftVFSV3) CreateFile(newdoc, olddoc *vfs.FileDoc) (vfs.File, error)
 		}
 	}
 
-	newdoc.InternalID = NewInternalID()
+	if vfs.ContentAddressed() {
+		newdoc.InternalID = vfs.NewBlobInternalID()
+	} else {
+		newdoc.InternalID = NewInternalID()
+	}
 	objName := MakeObjectNameV3(newdoc.DocID, newdoc.InternalID)
 	objMeta := swift.Metadata{
 		"creation-name": newdoc.Name(),
@@ -223,23 +277,40 @@ func (sfs *swiftVFSV3) CreateFile(newdoc, olddoc *vfs.FileDoc) (vfs.File, error)
 		"exec":          strconv.FormatBool(newdoc.Executable),
 	}
 
-	hash := hex.EncodeToString(newdoc.MD5Sum)
+	// When the content is encrypted, the checksum computed by Swift is the
+	// one of the encrypted content, so we compute the checksum of the content
+	// ourselves.
+	md5hex := hex.EncodeToString(newdoc.MD5Sum)
+	var contentHash hash.Hash
+	if vfs.WillEncrypt(sfs.keys) {
+		md5hex = ""
+		contentHash = md5.New()
+	}
 	f, err := sfs.c.ObjectCreate(
 		sfs.container,
 		objName,
 		true,
-		hash,
+		md5hex,
 		newdoc.Mime,
 		objMeta.ObjectHeaders(),
 	)
 	if err != nil {
 		return nil, err
 	}
+	enc, keyID, err := vfs.EncryptWriter(f, sfs.keys)
+	if err != nil {
+		_ = f.Close()
+		_ = sfs.c.ObjectDelete(sfs.container, objName)
+		return nil, err
+	}
+	newdoc.EncryptionKeyID = keyID
 	extractor := vfs.NewMetaExtractor(newdoc)
 
 	return &swiftFileCreationV3{
 		fs:      sfs,
 		f:       f,
+		enc:     enc,
+		hash:    contentHash,
 		newdoc:  newdoc,
 		olddoc:  olddoc,
 		name:    objName,
@@ -265,15 +336,23 @@ func (sfs *swiftVFSV3) destroyDir(doc *vfs.DirDoc, push func(vfs.TrashJournal) e
 		return nil
 	}
 	vfs.DiskQuotaAfterDestroy(sfs, diskUsage, destroyed)
+	// The references to the blobs are released by the trash-files worker,
+	// only if the journal has been pushed.
 	ids := make([]string, len(files))
-	objNames := make([]string, len(files))
+	objNames := make([]string, 0, len(files))
+	var blobs []string
 	for i, file := range files {
 		ids[i] = file.DocID
-		objNames[i] = MakeObjectNameV3(file.DocID, file.InternalID)
+		if vfs.IsBlobInternalID(file.InternalID) {
+			blobs = append(blobs, vfs.BlobKey(file.MD5Sum))
+		} else {
+			objNames = append(objNames, MakeObjectNameV3(file.DocID, file.InternalID))
+		}
 	}
 	err = push(vfs.TrashJournal{
 		FileIDs:     ids,
 		ObjectNames: objNames,
+		Blobs:       blobs,
 	})
 	return err
 }
@@ -292,12 +371,13 @@ func (sfs *swiftVFSV3) DestroyFile(doc *vfs.FileDoc) error {
 	}
 	defer sfs.mu.Unlock()
 	diskUsage, _ := sfs.Indexer.DiskUsage()
-	objNames := []string{
-		MakeObjectNameV3(doc.DocID, doc.InternalID),
-	}
 	if err := sfs.Indexer.DeleteFileDoc(doc); err != nil {
 		return err
 	}
+	var objNames []string
+	if objName := sfs.releaseObjectV3(doc.DocID, doc.InternalID, doc.MD5Sum); objName != "" {
+		objNames = append(objNames, objName)
+	}
 	destroyed := doc.ByteSize
 	var err error
 	if versions, err := vfs.VersionsFor(sfs, doc.DocID); err == nil {
@@ -306,7 +386,9 @@ func (sfs *swiftVFSV3) DestroyFile(doc *vfs.FileDoc) error {
 			if parts := strings.SplitN(v.DocID, "/", 2); len(parts) > 1 {
 				internalID = parts[1]
 			}
-			objNames = append(objNames, MakeObjectNameV3(doc.DocID, internalID))
+			if objName := sfs.releaseObjectV3(doc.DocID, internalID, v.MD5Sum); objName != "" {
+				objNames = append(objNames, objName)
+			}
 			destroyed += v.ByteSize
 		}
 		err = sfs.Indexer.BatchDeleteVersions(versions)
@@ -336,6 +418,15 @@ func (sfs *swiftVFSV3) EnsureErased(journal vfs.TrashJournal) error {
 	// No lock needed
 	diskUsage, _ := sfs.Indexer.DiskUsage()
 	objNames := journal.ObjectNames
+	for _, key := range journal.Blobs {
+		md5sum, err := hex.DecodeString(key)
+		if err != nil {
+			continue
+		}
+		if objName := sfs.releaseBlobV3(md5sum); objName != "" {
+			objNames = append(objNames, objName)
+		}
+	}
 	var destroyed int64
 	var allVersions []*vfs.Version
 	for _, fileID := range journal.FileIDs {
@@ -345,7 +436,9 @@ func (sfs *swiftVFSV3) EnsureErased(journal vfs.TrashJournal) error {
 				if parts := strings.SplitN(v.DocID, "/", 2); len(parts) > 1 {
 					internalID = parts[1]
 				}
-				objNames = append(objNames, MakeObjectNameV3(fileID, internalID))
+				if objName := sfs.releaseObjectV3(fileID, internalID, v.MD5Sum); objName != "" {
+					objNames = append(objNames, objName)
+				}
 				destroyed += v.ByteSize
 			}
 			allVersions = append(allVersions, versions...)
@@ -375,15 +468,11 @@ func (sfs *swiftVFSV3) OpenFile(doc *vfs.FileDoc) (vfs.File, error) {
 		return nil, lockerr
 	}
 	defer sfs.mu.RUnlock()
-	objName := MakeObjectNameV3(doc.DocID, doc.InternalID)
-	f, _, err := sfs.c.ObjectOpen(sfs.container, objName, false, nil)
-	if err == swift.ObjectNotFound {
-		return nil, os.ErrNotExist
-	}
+	keyID, err := sfs.contentKeyID(doc.InternalID, doc.MD5Sum, doc.EncryptionKeyID)
 	if err != nil {
 		return nil, err
 	}
-	return &swiftFileOpenV3{f, nil}, nil
+	return sfs.open(objectNameV3(doc.DocID, doc.InternalID, doc.MD5Sum), keyID)
 }
 
 func (sfs *swiftVFSV3) OpenFileVersion(doc *vfs.FileDoc, version *vfs.Version) (vfs.File, error) {
@@ -395,7 +484,35 @@ func (sfs *swiftVFSV3) OpenFileVersion(doc *vfs.FileDoc, version *vfs.Version) (
 	if parts := strings.SplitN(version.DocID, "/", 2); len(parts) > 1 {
 		internalID = parts[1]
 	}
-	objName := MakeObjectNameV3(doc.DocID, internalID)
+	keyID, err := sfs.contentKeyID(internalID, version.MD5Sum, version.EncryptionKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return sfs.open(objectNameV3(doc.DocID, internalID, version.MD5Sum), keyID)
+}
+
+// contentKeyID returns the identifier of the key used to encrypt the content
+// of a file or version. A blob is shared by several files and versions, and
+// it can be encrypted again for one of them: its key is recorded on the blob
+// document.
+func (sfs *swiftVFSV3) contentKeyID(internalID string, md5sum []byte, keyID uint32) (uint32, error) {
+	if !vfs.IsBlobInternalID(internalID) {
+		return keyID, nil
+	}
+	blob, err := vfs.GetBlob(sfs, md5sum)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		return keyID, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return blob.EncryptionKeyID, nil
+}
+
+// open returns a file handle for reading the content of the given object. The
+// content is decrypted if it has been encrypted with the given key (0 for a
+// content in clear).
+func (sfs *swiftVFSV3) open(objName string, keyID uint32) (vfs.File, error) {
 	f, _, err := sfs.c.ObjectOpen(sfs.container, objName, false, nil)
 	if err == swift.ObjectNotFound {
 		return nil, os.ErrNotExist
@@ -403,7 +520,20 @@ func (sfs *swiftVFSV3) OpenFileVersion(doc *vfs.FileDoc, version *vfs.Version) (
 	if err != nil {
 		return nil, err
 	}
-	return &swiftFileOpenV3{f, nil}, nil
+	if keyID == 0 {
+		return &swiftFileOpenV3{f, nil}, nil
+	}
+	size, err := f.Length()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	dec, err := vfs.DecryptFile(f, f, size, keyID, sfs.keys)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return dec, nil
 }
 
 func (sfs *swiftVFSV3) RevertFileVersion(doc *vfs.FileDoc, version *vfs.Version) error {
@@ -539,6 +669,8 @@ func (sfs *swiftVFSV3) DirOrFileByPath(name string) (*vfs.DirDoc, *vfs.FileDoc,
 type swiftFileCreationV3 struct {
 	fs      *swiftVFSV3
 	f       *swift.ObjectCreateFile
+	enc     io.WriteCloser
+	hash    hash.Hash // only when the content is encrypted
 	newdoc  *vfs.FileDoc
 	olddoc  *vfs.FileDoc
 	name    string
@@ -570,7 +702,7 @@ func (f *swiftFileCreationV3) Write(p []byte) (int, error) {
 		}
 	}
 
-	n, err := f.f.Write(p)
+	n, err := f.enc.Write(p)
 	if err != nil {
 		f.err = err
 		return n, err
@@ -587,6 +719,9 @@ func (f *swiftFileCreationV3) Write(p []byte) (int, error) {
 		return n, f.err
 	}
 
+	if f.hash != nil {
+		_, _ = f.hash.Write(p)
+	}
 	return n, nil
 }
 
@@ -604,6 +739,10 @@ func (f *swiftFileCreationV3) Close() (err error) {
 		}
 	}()
 
+	if errc := f.enc.Close(); errc != nil && f.err == nil {
+		f.err = errc
+	}
+
 	if err = f.f.Close(); err != nil {
 		if err == swift.ObjectCorrupted {
 			err = vfs.ErrInvalidHash
@@ -630,8 +769,16 @@ func (f *swiftFileCreationV3) Close() (err error) {
 	}
 
 	// The actual check of the optionally given md5 hash is handled by the swift
-	// library.
-	if newdoc.MD5Sum == nil {
+	// library, except for the encrypted content.
+	if f.hash != nil {
+		md5sum := f.hash.Sum(nil)
+		if newdoc.MD5Sum == nil {
+			newdoc.MD5Sum = md5sum
+		}
+		if !bytes.Equal(newdoc.MD5Sum, md5sum) {
+			return vfs.ErrInvalidHash
+		}
+	} else if newdoc.MD5Sum == nil {
 		var headers swift.Headers
 		var md5sum []byte
 		headers, err = f.f.Headers()
@@ -669,6 +816,37 @@ func (f *swiftFileCreationV3) Close() (err error) {
 	}
 	defer f.fs.mu.Unlock()
 
+	// The old keys are removed when no document references them, so we must
+	// check that the key is still there before saving the document, under
+	// the same lock as the removal of the keys.
+	if err = vfs.CheckEncryptionKey(f.fs.keys, newdoc.EncryptionKeyID); err != nil {
+		return err
+	}
+
+	// With the content-addressed storage, the uploaded object becomes the blob
+	// if there is no blob yet for this content, or it is just removed.
+	isBlob := vfs.IsBlobInternalID(newdoc.InternalID)
+	if isBlob {
+		// The object is moved before the reference is committed, so that
+		// another writer can't see the blob before its content is in place.
+		var blob *vfs.Blob
+		var created bool
+		blob, created, err = vfs.AcquireBlob(f.fs, newdoc.MD5Sum, newdoc.ByteSize, newdoc.EncryptionKeyID, func() error {
+			return f.fs.c.ObjectMove(f.fs.container, f.name, f.fs.container, blobObjectNameV3(newdoc.MD5Sum))
+		})
+		if err != nil {
+			return err
+		}
+		if !created {
+			errd := f.fs.c.ObjectDelete(f.fs.container, f.name)
+			if errd != nil && errd != swift.ObjectNotFound {
+				_, _ = vfs.ReleaseBlob(f.fs, newdoc.MD5Sum)
+				return errd
+			}
+			newdoc.EncryptionKeyID = blob.EncryptionKeyID
+		}
+	}
+
 	var v *vfs.Version
 	if olddoc != nil {
 		v = vfs.NewVersion(olddoc)
@@ -679,6 +857,11 @@ func (f *swiftFileCreationV3) Close() (err error) {
 		err = f.fs.Indexer.CreateNamedFileDoc(newdoc)
 	}
 	if err != nil {
+		if isBlob {
+			if objName := f.fs.releaseObjectV3(newdoc.DocID, newdoc.InternalID, newdoc.MD5Sum); objName != "" {
+				_ = f.fs.c.ObjectDelete(f.fs.container, objName)
+			}
+		}
 		return err
 	}
 
@@ -694,8 +877,9 @@ func (f *swiftFileCreationV3) Close() (err error) {
 			if parts := strings.SplitN(v.DocID, "/", 2); len(parts) > 1 {
 				internalID = parts[1]
 			}
-			objName := MakeObjectNameV3(newdoc.DocID, internalID)
-			_ = f.fs.c.ObjectDelete(f.fs.container, objName)
+			if objName := f.fs.releaseObjectV3(newdoc.DocID, internalID, v.MD5Sum); objName != "" {
+				_ = f.fs.c.ObjectDelete(f.fs.container, objName)
+			}
 		}
 		for _, old := range toClean {
 			cleanOldVersion(f.fs, newdoc.DocID, old)
@@ -715,8 +899,9 @@ func cleanOldVersion(sfs *swiftVFSV3, fileID string, v *vfs.Version) {
 		if parts := strings.SplitN(v.DocID, "/", 2); len(parts) > 1 {
 			internalID = parts[1]
 		}
-		objName := MakeObjectNameV3(fileID, internalID)
-		_ = sfs.c.ObjectDelete(sfs.container, objName)
+		if objName := sfs.releaseObjectV3(fileID, internalID, v.MD5Sum); objName != "" {
+			_ = sfs.c.ObjectDelete(sfs.container, objName)
+		}
 	}
 }
 
@@ -26,23 +26,30 @@ func NewThumbsFsV2(c *swift.Connection, db prefixer.Prefixer) vfs.Thumbser {
 // NewThumbsFsV3 creates a new thumb filesystem base on swift.
 //
 // This version stores the thumbnails in the same container as the main data
-// container.
-func NewThumbsFsV3(c *swift.Connection, db prefixer.Prefixer) vfs.Thumbser {
-	return &thumbsV2{c: c, container: swiftV3ContainerPrefix + db.DBPrefix()}
+// container. The keyring is used for the encryption at rest of the
+// thumbnails, and can be nil.
+func NewThumbsFsV3(c *swift.Connection, db prefixer.Prefixer, keys vfs.Keyring) vfs.Thumbser {
+	return &thumbsV2{c: c, container: swiftV3ContainerPrefix + db.DBPrefix(), keys: keys}
 }
 
 type thumbsV2 struct {
 	c         *swift.Connection
 	container string
+	keys      vfs.Keyring
 }
 
 type thumb struct {
 	io.WriteCloser
+	enc       io.WriteCloser
 	c         *swift.Connection
 	container string
 	name      string
 }
 
+func (t *thumb) Write(p []byte) (int, error) {
+	return t.enc.Write(p)
+}
+
 func (t *thumb) Abort() error {
 	errc := t.WriteCloser.Close()
 	errd := t.c.ObjectDelete(t.container, t.name)
@@ -56,6 +63,10 @@ func (t *thumb) Abort() error {
 }
 
 func (t *thumb) Commit() error {
+	if err := t.enc.Close(); err != nil {
+		_ = t.Abort()
+		return err
+	}
 	return t.WriteCloser.Close()
 }
 
@@ -75,8 +86,15 @@ func (t *thumbsV2) CreateThumb(img *vfs.FileDoc, format string) (vfs.ThumbFiler,
 			return nil, err
 		}
 	}
+	enc, _, err := vfs.EncryptWriter(obj, t.keys)
+	if err != nil {
+		_ = obj.Close()
+		_ = t.c.ObjectDelete(t.container, name)
+		return nil, err
+	}
 	th := &thumb{
 		WriteCloser: obj,
+		enc:         enc,
 		c:           t.c,
 		container:   t.container,
 		name:        name,
@@ -124,6 +142,23 @@ func (t *thumbsV2) ServeThumbContent(w http.ResponseWriter, req *http.Request, i
 	defer f.Close()
 
 	w.Header().Set("Etag", fmt.Sprintf(`"%s"`, o["Etag"]))
+	if t.keys != nil {
+		var size int64
+		var dec vfs.File
+		if size, err = f.Length(); err != nil {
+			return err
+		}
+		if dec, err = vfs.DecryptThumb(f, f, size, t.keys); err != nil {
+			return err
+		}
+		if dec != nil {
+			http.ServeContent(w, req, name, unixEpochZero, dec)
+			return nil
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
 	http.ServeContent(w, req, name, unixEpochZero, f)
 	return nil
 }
@@ -164,6 +164,13 @@ type Fs struct {
 	Transport     http.RoundTripper
 	DefaultLayout int
 	Versioning    FsVersioning
+	// ContentAddressed enables the deduplication of the binaries, keyed by
+	// their checksum (only for the file:// and swift layout v3 storages).
+	ContentAddressed bool
+	// Encryption enables the encryption at rest of the binaries, with a key
+	// per instance wrapped by the vault credentials key (only for the file://
+	// and swift layout v3 storages).
+	Encryption bool
 }
 
 // FsVersioning contains the configuration for the versioning of files
@@ -187,6 +194,7 @@ type Jobs struct {
 	WhiteList             bool
 	Workers               []Worker
 	ImageMagickConvertCmd string
+	PdfToTextCmd          string
 	// XXX for retro-compatibility
 	NbWorkers             int
 	DefaultDurationToKeep string
@@ -398,6 +406,7 @@ func Setup(cfgFile string) (err error) {
 func applyDefaults(v *viper.Viper) {
 	v.SetDefault("password_reset_interval", defaultPasswordResetInterval)
 	v.SetDefault("jobs.imagemagick_convert_cmd", "convert")
+	v.SetDefault("jobs.pdftotext_cmd", "pdftotext")
 	v.SetDefault("jobs.defaultDurationToKeep", "2W")
 	v.SetDefault("assets_polling_disabled", false)
 	v.SetDefault("assets_polling_interval", 2*time.Minute)
@@ -575,6 +584,7 @@ func UseViper(v *viper.Viper) error {
 	jobs := Jobs{
 		RedisConfig:           jobsRedis,
 		ImageMagickConvertCmd: v.GetString("jobs.imagemagick_convert_cmd"),
+		PdfToTextCmd:          v.GetString("jobs.pdftotext_cmd"),
 		DefaultDurationToKeep: v.GetString("jobs.defaultDurationToKeep"),
 	}
 	{
@@ -674,6 +684,8 @@ func UseViper(v *viper.Viper) error {
 				MaxNumberToKeep:            v.GetInt("fs.versioning.max_number_of_versions_to_keep"),
 				MinDelayBetweenTwoVersions: v.GetDuration("fs.versioning.min_delay_between_two_versions"),
 			},
+			ContentAddressed: v.GetBool("fs.content_addressed"),
+			Encryption:       v.GetBool("fs.encryption"),
 		},
 		CouchDB: CouchDB{
 			Auth:   couchAuth,
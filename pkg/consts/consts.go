@@ -16,6 +16,9 @@ const (
 	// referencing a directory that contains the notes with collaborative
 	// edition.
 	NotesSlug = "notes"
+	// PasswordsSlug is the slug of the passwords app, which is used by the
+	// stack for the sharings of the bitwarden organizations.
+	PasswordsSlug = "passwords"
 )
 
 const (
@@ -27,6 +27,13 @@ const (
 	Files = "io.cozy.files"
 	// FilesMetadata doc type for metadata of files
 	FilesMetadata = "io.cozy.files.metadata"
+	// FilesBlobs doc type for the reference counters of the binaries in the
+	// content-addressed storage
+	FilesBlobs = "io.cozy.files.blobs"
+	// FilesSearch doc type for the full-text search index of the files
+	FilesSearch = "io.cozy.files.search"
+	// FilesUploads doc type for the sessions of resumable uploads
+	FilesUploads = "io.cozy.files.uploads"
 	// FilesVersions doc type for versioning file contents
 	FilesVersions = "io.cozy.files.versions"
 	// Thumbnails is a synthetic doctype for thumbnails, used for realtime
@@ -38,16 +45,26 @@ const (
 	Intents = "io.cozy.intents"
 	// Jobs doc type for queued jobs
 	Jobs = "io.cozy.jobs"
+	// JobsWorkflows doc type for the workflows of jobs
+	JobsWorkflows = "io.cozy.jobs.workflows"
+	// JobsDeadLetters doc type for the jobs that have failed after all their
+	// executions
+	JobsDeadLetters = "io.cozy.jobs.dead-letters"
 	// JobEvents doc type for real time events sent by jobs
 	JobEvents = "io.cozy.jobs.events"
 	// Notifications doc type for notifications
 	Notifications = "io.cozy.notifications"
 	// OAuthAccessCodes doc type for OAuth2 access codes
 	OAuthAccessCodes = "io.cozy.oauth.access_codes"
+	// OAuthDeviceCodes doc type for the codes of the OAuth2 device
+	// authorization grant
+	OAuthDeviceCodes = "io.cozy.oauth.device_codes"
 	// OAuthClients doc type for OAuth2 clients
 	OAuthClients = "io.cozy.oauth.clients"
 	// Permissions doc type for permissions identifying a connection
 	Permissions = "io.cozy.permissions"
+	// PermissionsAudit doc type for the audit trail of the permissions
+	PermissionsAudit = "io.cozy.permissions.audit"
 	// Contacts doc type for sharing
 	Contacts = "io.cozy.contacts"
 	// RemoteRequests doc type for logging requests to remote websites
@@ -64,6 +81,11 @@ const (
 	Sharings = "io.cozy.sharings"
 	// SharingsAnswer doc type for credentials exchange for sharings
 	SharingsAnswer = "io.cozy.sharings.answer"
+	// SharingsActivities doc type for the activity journal of the sharings
+	SharingsActivities = "io.cozy.sharings.activities"
+	// SharingsConflicts doc type for the conflicts between the versions of
+	// a file in a sharing
+	SharingsConflicts = "io.cozy.sharings.conflicts"
 	// SharingsInitialSync doc type for real-time events for initial sync of a
 	// sharing
 	SharingsInitialSync = "io.cozy.sharings.initial-sync"
@@ -84,6 +106,9 @@ const (
 	// BitwardenOrganizations doc type for Bitwarden organizations (and
 	// collections inside them)
 	BitwardenOrganizations = "com.bitwarden.organizations"
+	// BitwardenSends doc type for the Bitwarden sends (ephemeral texts and
+	// files shared via a link)
+	BitwardenSends = "io.cozy.bitwarden.sends"
 	// NotesDocuments doc type is used for manipulating the documents that
 	// represents a note before they are persisted to a file.
 	NotesDocuments = "io.cozy.notes.documents"
@@ -95,4 +120,9 @@ const (
 	// NotesEvents doc type is used for realtime events related to a note, like
 	// a change of title.
 	NotesEvents = "io.cozy.notes.events"
+	// NotesComments doc type is used for the threads of comments and the
+	// suggestions on a note.
+	NotesComments = "io.cozy.notes.comments"
+	// NotesSnapshots doc type is used for the named versions of a note.
+	NotesSnapshots = "io.cozy.notes.snapshots"
 )
@@ -222,6 +222,22 @@ func (j *JSONDoc) Fetch(field string) []string {
 		return values
 	}
 
+	// A dotted field is a path in the nested objects, like
+	// cozyMetadata.createdByApp
+	if strings.Contains(field, ".") {
+		var value interface{} = j.M
+		for _, part := range strings.Split(field, ".") {
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			if value, ok = obj[part]; !ok {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%v", value)}
+	}
+
 	return []string{fmt.Sprintf("%v", j.Get(field))}
 }
 
@@ -910,11 +926,15 @@ type IndexCreationResponse struct {
 	Name   string `json:"name,omitempty"`
 }
 
-// UpdateResponse is the response from couchdb when updating documents
+// UpdateResponse is the response from couchdb when updating documents. For a
+// bulk request, Error and Reason are filled for the documents that have not
+// been written.
 type UpdateResponse struct {
-	ID  string `json:"id"`
-	Rev string `json:"rev"`
-	Ok  bool   `json:"ok"`
+	ID     string `json:"id"`
+	Rev    string `json:"rev"`
+	Ok     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
 }
 
 // FindResponse is the response from couchdb on a find request
@@ -7,7 +7,7 @@ import (
 
 // IndexViewsVersion is the version of current definition of views & indexes.
 // This number should be incremented when this file changes.
-const IndexViewsVersion int = 25
+const IndexViewsVersion int = 39
 
 // Indexes is the index list required by an instance to run properly.
 var Indexes = []*mango.Index{
@@ -19,14 +19,27 @@ var Indexes = []*mango.Index{
 	// Used to lookup a directory given its path
 	mango.IndexOnFields(consts.Files, "dir-by-path", []string{"path"}),
 
+	// Used to know if an old key for the encryption at rest of the binaries
+	// is still used by some files, versions or blobs
+	mango.IndexOnFields(consts.Files, "by-encryption-key-id", []string{"encryption_key_id"}),
+	mango.IndexOnFields(consts.FilesVersions, "by-encryption-key-id", []string{"encryption_key_id"}),
+	mango.IndexOnFields(consts.FilesBlobs, "by-encryption-key-id", []string{"encryption_key_id"}),
+
 	// Used to lookup a queued and running jobs
 	mango.IndexOnFields(consts.Jobs, "by-worker-and-state", []string{"worker", "state"}),
 	mango.IndexOnFields(consts.Jobs, "by-trigger-id", []string{"trigger_id", "queued_at"}),
 	mango.IndexOnFields(consts.Jobs, "by-queued-at", []string{"queued_at"}),
+	// Used to lookup the jobs of a workflow
+	mango.IndexOnFields(consts.Jobs, "by-workflow-id", []string{"workflow_id", "queued_at"}),
+	// Used to list the dead letters of the jobs
+	mango.IndexOnFields(consts.JobsDeadLetters, "by-worker-and-failed-at", []string{"worker", "failed_at"}),
+	// Used to purge the old dead letters
+	mango.IndexOnFields(consts.JobsDeadLetters, "by-failed-at", []string{"failed_at"}),
 
 	// Used to lookup oauth clients by name
 	mango.IndexOnFields(consts.OAuthClients, "by-client-name", []string{"client_name"}),
 	mango.IndexOnFields(consts.OAuthClients, "by-notification-platform", []string{"notification_platform"}),
+	mango.IndexOnFields(consts.OAuthDeviceCodes, "by-user-code", []string{"user_code"}),
 
 	// Used to lookup login history by OS, browser, and IP
 	mango.IndexOnFields(consts.SessionsLogins, "by-os-browser-ip", []string{"os", "browser", "ip"}),
@@ -40,6 +53,29 @@ var Indexes = []*mango.Index{
 
 	// Used to lookup the bitwarden ciphers in a folder
 	mango.IndexOnFields(consts.BitwardenCiphers, "by-folder-id", []string{"folder_id"}),
+	// Used to lookup the bitwarden ciphers to purge from the trash
+	mango.IndexOnFields(consts.BitwardenCiphers, "by-deleted-date", []string{"deleted_date"}),
+	// Used to lookup the bitwarden ciphers in an organization
+	mango.IndexOnFields(consts.BitwardenCiphers, "by-organization-id", []string{"organization_id"}),
+	// Used to lookup the bitwarden sends to delete
+	mango.IndexOnFields(consts.BitwardenSends, "by-deletion-date", []string{"deletion_date"}),
+
+	// Used to lookup the threads of comments on a note
+	mango.IndexOnFields(consts.NotesComments, "by-note-id", []string{"note_id", "created_at"}),
+
+	// Used to lookup the snapshots of a note
+	mango.IndexOnFields(consts.NotesSnapshots, "by-note-id", []string{"note_id", "version"}),
+
+	// Used to lookup the conflicts of a sharing
+	mango.IndexOnFields(consts.SharingsConflicts, "by-sharing-id", []string{"sharing_id", "created_at"}),
+
+	// Used to lookup the activity journal of a sharing
+	mango.IndexOnFields(consts.SharingsActivities, "by-sharing-id", []string{"sharing_id", "created_at"}),
+
+	// Used to export and purge the audit trail of the permissions
+	mango.IndexOnFields(consts.PermissionsAudit, "by-created-at", []string{"created_at"}),
+	mango.IndexOnFields(consts.PermissionsAudit, "by-permission-id", []string{"permission_id", "created_at"}),
+	mango.IndexOnFields(consts.PermissionsAudit, "by-source-id", []string{"source_id", "created_at"}),
 }
 
 // DiskUsageView is the view used for computing the disk usage for files
@@ -226,6 +262,44 @@ function(doc) {
 `,
 }
 
+// SearchTermsView is the view used for the full-text search on files: it
+// emits the terms of the search index, with their weights.
+var SearchTermsView = &View{
+	Name:    "search-terms",
+	Doctype: consts.FilesSearch,
+	Map: `
+function(doc) {
+  var t;
+  if (doc.terms) {
+    for (t in doc.terms) {
+      emit(t, doc.terms[t]);
+    }
+  }
+  if (doc.content) {
+    for (t in doc.content) {
+      emit(t, doc.content[t]);
+    }
+  }
+}
+`,
+}
+
+// JobsWaitingForView is the view used for finding the jobs that are waiting
+// for a given job to be done.
+var JobsWaitingForView = &View{
+	Name:    "waiting-for",
+	Doctype: consts.Jobs,
+	Map: `
+function(doc) {
+  if (doc.state === 'waiting' && isArray(doc.wait_for)) {
+    for (var i = 0; i < doc.wait_for.length; i++) {
+      emit(doc.wait_for[i]);
+    }
+  }
+}
+`,
+}
+
 // Views is the list of all views that are created by the stack.
 var Views = []*View{
 	DiskUsageView,
@@ -240,6 +314,8 @@ var Views = []*View{
 	SharedDocsBySharingID,
 	SharingsByDocTypeView,
 	ContactByEmail,
+	SearchTermsView,
+	JobsWaitingForView,
 }
 
 // ViewsByDoctype returns the list of views for a specified doc type.
@@ -0,0 +1,349 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// The content encrypted with a SegmentWriter starts with a header: a magic
+// string, the identifier of the key, and a random salt. The salt is used to
+// derive a key specific to this content from the given key (HKDF-SHA256).
+// Then, the content is split in segments of a fixed size, and each segment is
+// encrypted with AES-256-GCM. The nonce of a segment is its index, and the
+// last segment is flagged in the additional data to detect a truncation. As
+// the segments have a fixed size, the content can be decrypted from any
+// offset, without reading the previous segments.
+const (
+	segmentMagic    = "CZE1"
+	segmentSaltSize = 16
+	segmentSize     = 64 * 1024
+	segmentOverhead = 16 // the GCM tag
+
+	// SegmentHeaderSize is the size of the header of an encrypted content.
+	SegmentHeaderSize = len(segmentMagic) + 4 + segmentSaltSize
+)
+
+var (
+	// ErrSegmentCorrupted is used when an encrypted content cannot be
+	// decrypted.
+	ErrSegmentCorrupted = errors.New("crypto: the encrypted content is corrupted")
+	// ErrNotSegmented is used when a content has not been encrypted.
+	ErrNotSegmented = errors.New("crypto: the content is not encrypted")
+	// ErrSegmentBadKey is used when the key is not a 256 bits key.
+	ErrSegmentBadKey = errors.New("crypto: the key must be 32 bytes long")
+)
+
+var (
+	notLastSegment = []byte{0}
+	lastSegment    = []byte{1}
+)
+
+func newSegmentAEAD(key, salt []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrSegmentBadKey
+	}
+	derived := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, key, salt, []byte(segmentMagic))
+	if _, err := io.ReadFull(kdf, derived); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(aead cipher.AEAD, index uint32) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], index)
+	return nonce
+}
+
+// EncryptedSize returns the size of the encrypted content for a content of
+// the given size.
+func EncryptedSize(size int64) int64 {
+	segments := size/segmentSize + 1
+	if size > 0 && size%segmentSize == 0 {
+		segments--
+	}
+	return int64(SegmentHeaderSize) + size + segments*segmentOverhead
+}
+
+// DecryptedSize returns the size of the content for an encrypted content of
+// the given size.
+func DecryptedSize(size int64) (int64, error) {
+	size -= int64(SegmentHeaderSize)
+	if size < segmentOverhead {
+		return 0, ErrSegmentCorrupted
+	}
+	full := int64(segmentSize + segmentOverhead)
+	segments := (size + full - 1) / full
+	if size%full != 0 && size%full < segmentOverhead {
+		return 0, ErrSegmentCorrupted
+	}
+	return size - segments*segmentOverhead, nil
+}
+
+// ReadSegmentHeader reads the header of an encrypted content, and returns the
+// identifier of the key used for encrypting it. The boolean is false if the
+// content has not been encrypted with a SegmentWriter.
+func ReadSegmentHeader(r io.Reader) (uint32, bool, error) {
+	header := make([]byte, SegmentHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if string(header[:len(segmentMagic)]) != segmentMagic {
+		return 0, false, nil
+	}
+	keyID := binary.BigEndian.Uint32(header[len(segmentMagic):])
+	return keyID, true, nil
+}
+
+// SegmentWriter is an io.WriteCloser that encrypts the content written to it,
+// by segments. Close must be called to write the last segment, but it does
+// not close the underlying writer.
+type SegmentWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint32
+	err   error
+}
+
+// NewSegmentWriter returns a SegmentWriter that writes the content encrypted
+// with the given key to w. The key identifier is put in the header, to find
+// the key when decrypting the content.
+func NewSegmentWriter(w io.Writer, key []byte, keyID uint32) (*SegmentWriter, error) {
+	salt := GenerateRandomBytes(segmentSaltSize)
+	aead, err := newSegmentAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, SegmentHeaderSize)
+	header = append(header, segmentMagic...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[len(segmentMagic):], keyID)
+	header = append(header, salt...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &SegmentWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, segmentSize+segmentOverhead),
+	}, nil
+}
+
+// Write implements the io.Writer interface.
+func (s *SegmentWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		// A full segment is flushed only when we know that it is not the
+		// last one.
+		if len(s.buf) == segmentSize {
+			if s.err = s.flush(notLastSegment); s.err != nil {
+				return 0, s.err
+			}
+		}
+		l := segmentSize - len(s.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		s.buf = append(s.buf, p[:l]...)
+		p = p[l:]
+	}
+	return n, nil
+}
+
+func (s *SegmentWriter) flush(flag []byte) error {
+	nonce := segmentNonce(s.aead, s.index)
+	sealed := s.aead.Seal(s.buf[:0], nonce, s.buf, flag)
+	if _, err := s.w.Write(sealed); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	s.index++
+	return nil
+}
+
+// Close writes the last segment.
+func (s *SegmentWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.err = s.flush(lastSegment)
+	if s.err == nil {
+		s.err = errors.New("crypto: the segment writer is closed")
+		return nil
+	}
+	return s.err
+}
+
+// SegmentReader decrypts a content encrypted by a SegmentWriter. It
+// implements io.Reader, io.ReaderAt and io.Seeker, by decrypting only the
+// segments where the data is read. It is not safe for a concurrent use.
+type SegmentReader struct {
+	r      io.ReadSeeker
+	rpos   int64
+	aead   cipher.AEAD
+	size   int64
+	pos    int64
+	count  uint32
+	index  uint32
+	cached []byte
+	loaded bool
+}
+
+// NewSegmentReader returns a SegmentReader for the encrypted content of r,
+// which has the given (encrypted) size. The reader must be at the start of
+// the content. The keys function is called with the key identifier from the
+// header to get the key for decrypting the content. ErrNotSegmented is
+// returned if the content has not been encrypted with a SegmentWriter: in
+// this case, some bytes may have been read from r.
+func NewSegmentReader(r io.ReadSeeker, size int64, keys func(keyID uint32) ([]byte, error)) (*SegmentReader, error) {
+	if size < int64(SegmentHeaderSize) {
+		return nil, ErrNotSegmented
+	}
+	header := make([]byte, SegmentHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(segmentMagic)]) != segmentMagic {
+		return nil, ErrNotSegmented
+	}
+	plainSize, err := DecryptedSize(size)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys(binary.BigEndian.Uint32(header[len(segmentMagic):]))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newSegmentAEAD(key, header[len(segmentMagic)+4:])
+	if err != nil {
+		return nil, err
+	}
+	count := plainSize/segmentSize + 1
+	if plainSize > 0 && plainSize%segmentSize == 0 {
+		count--
+	}
+	return &SegmentReader{
+		r:      r,
+		rpos:   int64(SegmentHeaderSize),
+		aead:   aead,
+		size:   plainSize,
+		count:  uint32(count),
+		cached: make([]byte, 0, segmentSize+segmentOverhead),
+	}, nil
+}
+
+// Size returns the size of the decrypted content.
+func (s *SegmentReader) Size() int64 {
+	return s.size
+}
+
+func (s *SegmentReader) load(index uint32) error {
+	if s.loaded && s.index == index {
+		return nil
+	}
+	s.loaded = false
+	offset := int64(SegmentHeaderSize) + int64(index)*(segmentSize+segmentOverhead)
+	if s.rpos != offset {
+		if _, err := s.r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		s.rpos = offset
+	}
+	length := segmentSize + segmentOverhead
+	flag := notLastSegment
+	if index == s.count-1 {
+		length = int(s.size-int64(index)*segmentSize) + segmentOverhead
+		flag = lastSegment
+	}
+	buf := s.cached[:length]
+	n, err := io.ReadFull(s.r, buf)
+	s.rpos += int64(n)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrSegmentCorrupted
+		}
+		return err
+	}
+	nonce := segmentNonce(s.aead, index)
+	plain, err := s.aead.Open(buf[:0], nonce, buf, flag)
+	if err != nil {
+		return ErrSegmentCorrupted
+	}
+	s.cached = plain
+	s.index = index
+	s.loaded = true
+	return nil
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (s *SegmentReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("crypto: negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if off >= s.size {
+			return n, io.EOF
+		}
+		index := uint32(off / segmentSize)
+		if err := s.load(index); err != nil {
+			return n, err
+		}
+		start := int(off - int64(index)*segmentSize)
+		copied := copy(p[n:], s.cached[start:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// Read implements the io.Reader interface.
+func (s *SegmentReader) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	n, err := s.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements the io.Seeker interface.
+func (s *SegmentReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = s.pos + offset
+	case io.SeekEnd:
+		pos = s.size + offset
+	default:
+		return 0, errors.New("crypto: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("crypto: negative position")
+	}
+	s.pos = pos
+	return pos, nil
+}
@@ -0,0 +1,110 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptSegments(t *testing.T, key, plain []byte, keyID uint32) []byte {
+	var buf bytes.Buffer
+	w, err := NewSegmentWriter(&buf, key, keyID)
+	assert.NoError(t, err)
+	// Write in small chunks to check the buffering
+	for i := 0; i < len(plain); i += 1000 {
+		end := i + 1000
+		if end > len(plain) {
+			end = len(plain)
+		}
+		_, err = w.Write(plain[i:end])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestSegments(t *testing.T) {
+	key := GenerateRandomBytes(32)
+	keys := func(id uint32) ([]byte, error) {
+		assert.Equal(t, uint32(42), id)
+		return key, nil
+	}
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		plain := GenerateRandomBytes(size)
+		encrypted := encryptSegments(t, key, plain, 42)
+		assert.EqualValues(t, EncryptedSize(int64(size)), len(encrypted))
+		decSize, err := DecryptedSize(int64(len(encrypted)))
+		assert.NoError(t, err)
+		assert.EqualValues(t, size, decSize)
+
+		keyID, ok, err := ReadSegmentHeader(bytes.NewReader(encrypted))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(42), keyID)
+
+		r, err := NewSegmentReader(bytes.NewReader(encrypted), int64(len(encrypted)), keys)
+		assert.NoError(t, err)
+		assert.EqualValues(t, size, r.Size())
+		decrypted, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(plain, decrypted))
+
+		if size > 10 {
+			off := int64(size / 2)
+			_, err = r.Seek(off, io.SeekStart)
+			assert.NoError(t, err)
+			rest, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(plain[off:], rest))
+
+			part := make([]byte, 10)
+			n, err := r.ReadAt(part, int64(size-10))
+			assert.NoError(t, err)
+			assert.Equal(t, 10, n)
+			assert.Equal(t, plain[size-10:], part)
+		}
+	}
+}
+
+func TestSegmentsCorrupted(t *testing.T) {
+	key := GenerateRandomBytes(32)
+	keys := func(id uint32) ([]byte, error) { return key, nil }
+	plain := GenerateRandomBytes(2*segmentSize + 100)
+	encrypted := encryptSegments(t, key, plain, 1)
+
+	// Truncated after the first segment
+	truncated := encrypted[:SegmentHeaderSize+segmentSize+segmentOverhead]
+	r, err := NewSegmentReader(bytes.NewReader(truncated), int64(len(truncated)), keys)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrSegmentCorrupted, err)
+
+	// Modified byte
+	modified := make([]byte, len(encrypted))
+	copy(modified, encrypted)
+	modified[SegmentHeaderSize+segmentSize+100] ^= 1
+	r, err = NewSegmentReader(bytes.NewReader(modified), int64(len(modified)), keys)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrSegmentCorrupted, err)
+
+	// Bad key
+	other := func(id uint32) ([]byte, error) { return GenerateRandomBytes(32), nil }
+	r, err = NewSegmentReader(bytes.NewReader(encrypted), int64(len(encrypted)), other)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrSegmentCorrupted, err)
+
+	// Not encrypted
+	_, ok, err := ReadSegmentHeader(bytes.NewReader(plain))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = NewSegmentReader(bytes.NewReader(plain), int64(len(plain)), keys)
+	assert.Equal(t, ErrNotSegmented, err)
+	_, err = NewSegmentReader(bytes.NewReader(plain[:3]), 3, keys)
+	assert.Equal(t, ErrNotSegmented, err)
+}
@@ -0,0 +1,40 @@
+package keymgmt
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+const wrapNonceLen = 24
+
+var errBadWrappedKey = errors.New("keymgmt: bad wrapped key")
+
+// WrapKey encrypts a secret key with the given encryptor keypair, so that it
+// can be persisted. The nonce is put before the encrypted key.
+func WrapKey(encryptorKey *NACLKey, key []byte) ([]byte, error) {
+	var nonce [wrapNonceLen]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	out := make([]byte, wrapNonceLen, wrapNonceLen+len(key)+box.Overhead)
+	copy(out, nonce[:])
+	return box.Seal(out, key, &nonce, encryptorKey.PublicKey(), encryptorKey.PrivateKey()), nil
+}
+
+// UnwrapKey decrypts a secret key that has been wrapped with WrapKey, by
+// using the decryptor keypair.
+func UnwrapKey(decryptorKey *NACLKey, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < wrapNonceLen+box.Overhead {
+		return nil, errBadWrappedKey
+	}
+	var nonce [wrapNonceLen]byte
+	copy(nonce[:], wrapped[:wrapNonceLen])
+	key, ok := box.Open(nil, wrapped[wrapNonceLen:], &nonce, decryptorKey.PublicKey(), decryptorKey.PrivateKey())
+	if !ok {
+		return nil, errBadWrappedKey
+	}
+	return key, nil
+}
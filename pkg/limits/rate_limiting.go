@@ -59,6 +59,9 @@ const (
 	SendHintByMail
 	// JobNotesPersistType is used for saving notes to the VFS
 	JobNotesPersistType
+	// BitwardenSendAccessType is used for counting the number of accesses to
+	// a bitwarden send by its recipients
+	BitwardenSendAccessType
 )
 
 type counterConfig struct {
@@ -170,6 +173,12 @@ var configs = []counterConfig{
 		Limit:  100,
 		Period: 1 * time.Hour,
 	},
+	// BitwardenSendAccessType
+	{
+		Prefix: "bitwarden-send-access",
+		Limit:  100,
+		Period: 1 * time.Hour,
+	},
 }
 
 // Counter is an interface for counting number of attempts that can be used to
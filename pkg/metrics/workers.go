@@ -106,6 +106,21 @@ a label by slug.`,
 	[]string{"slug", "result"},
 )
 
+// WorkerQueueDepth is a gauge metric of the number of jobs in the queues of
+// the workers, labelled by worker type and instance domain.
+var WorkerQueueDepth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "workers",
+		Subsystem: "queues",
+		Name:      "depth",
+
+		Help: `Number of jobs in the queues of the workers, labelled by worker type and
+instance domain. The instances without jobs in the queue of a worker are not
+listed.`,
+	},
+	[]string{"worker_type", "domain"},
+)
+
 func init() {
 	prometheus.MustRegister(
 		WorkerExecDurations,
@@ -113,6 +128,7 @@ func init() {
 		WorkerExecRetries,
 		WorkerExecTimeoutsCounter,
 		WorkerKonnectorExecDeleteCounter,
+		WorkerQueueDepth,
 
 		WorkersKonnectorsExecDurations,
 	)
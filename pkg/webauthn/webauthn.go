@@ -0,0 +1,340 @@
+// Package webauthn implements the server side of the Web Authentication API
+// (https://www.w3.org/TR/webauthn/) that is needed to register security keys
+// and to verify the assertions made with them. The attestation statements are
+// not verified: the stack doesn't check the provenance of the authenticators,
+// like with the "none" conveyance preference.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+var (
+	// ErrInvalidClientData is used when the client data are not the expected
+	// ones (wrong type, challenge or origin)
+	ErrInvalidClientData = errors.New("webauthn: invalid client data")
+	// ErrInvalidAuthData is used when the authenticator data can't be parsed
+	// or are for another relying party
+	ErrInvalidAuthData = errors.New("webauthn: invalid authenticator data")
+	// ErrInvalidAttestation is used when the attestation object can't be
+	// parsed
+	ErrInvalidAttestation = errors.New("webauthn: invalid attestation")
+	// ErrUserNotPresent is used when the authenticator has not checked the
+	// presence of the user
+	ErrUserNotPresent = errors.New("webauthn: the user was not present")
+	// ErrUnsupportedKey is used when the public key of the credential has an
+	// algorithm that is not supported
+	ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+	// ErrInvalidSignature is used when the signature of an assertion is not
+	// valid
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+	// ErrClonedAuthenticator is used when the signature counter of an
+	// assertion has not increased, which can be the sign of a cloned
+	// authenticator
+	ErrClonedAuthenticator = errors.New("webauthn: the signature counter has not increased")
+)
+
+// Flags of the authenticator data
+const (
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+)
+
+// COSE key parameters and algorithms
+const (
+	coseKeyType   = 1
+	coseAlg       = 3
+	coseEC2Curve  = -1
+	coseEC2X      = -2
+	coseEC2Y      = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+	coseKtyEC2    = 2
+	coseKtyRSA    = 3
+	coseAlgES256  = -7
+	coseAlgRS256  = -257
+	coseCurveP256 = 1
+)
+
+// Credential is a public key credential registered by an authenticator.
+type Credential struct {
+	ID        []byte `json:"id"`
+	PublicKey []byte `json:"public_key"` // A COSE_Key, encoded in CBOR
+	SignCount uint32 `json:"sign_count"`
+}
+
+// CreationOptions returns the options to give to navigator.credentials.create
+// on the client side for registering a new credential. The binary values are
+// encoded in base64url.
+func CreationOptions(rpID, rpName string, userID []byte, userName string, challenge []byte, exclude []Credential) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge": encode(challenge),
+		"rp": map[string]interface{}{
+			"id":   rpID,
+			"name": rpName,
+		},
+		"user": map[string]interface{}{
+			"id":          encode(userID),
+			"name":        userName,
+			"displayName": userName,
+		},
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": coseAlgES256},
+			{"type": "public-key", "alg": coseAlgRS256},
+		},
+		"excludeCredentials": descriptors(exclude),
+		"attestation":        "none",
+		"timeout":            60000,
+	}
+}
+
+// RequestOptions returns the options to give to navigator.credentials.get on
+// the client side for making an assertion with one of the given credentials.
+// The binary values are encoded in base64url.
+func RequestOptions(rpID string, challenge []byte, allowed []Credential) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge":        encode(challenge),
+		"rpId":             rpID,
+		"allowCredentials": descriptors(allowed),
+		"userVerification": "discouraged",
+		"timeout":          60000,
+	}
+}
+
+func descriptors(creds []Credential) []map[string]interface{} {
+	list := make([]map[string]interface{}, len(creds))
+	for i, cred := range creds {
+		list[i] = map[string]interface{}{
+			"type": "public-key",
+			"id":   encode(cred.ID),
+		}
+	}
+	return list
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decode decodes a binary value sent by the client, in base64url (with or
+// without padding).
+func Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// VerifyRegistration checks the response of an authenticator to
+// navigator.credentials.create, and returns the new credential.
+func VerifyRegistration(rpID, origin string, challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := checkClientData(clientDataJSON, "webauthn.create", challenge, origin); err != nil {
+		return nil, err
+	}
+	var att struct {
+		Fmt      string `codec:"fmt"`
+		AuthData []byte `codec:"authData"`
+	}
+	if err := codec.NewDecoderBytes(attestationObject, &codec.CborHandle{}).Decode(&att); err != nil {
+		return nil, ErrInvalidAttestation
+	}
+	ad, err := parseAuthData(rpID, att.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if len(ad.credentialID) == 0 {
+		return nil, ErrInvalidAttestation
+	}
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response of an authenticator to
+// navigator.credentials.get for the given credential. It returns the new
+// value of the signature counter.
+func VerifyAssertion(rpID, origin string, challenge []byte, cred *Credential, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	if err := checkClientData(clientDataJSON, "webauthn.get", challenge, origin); err != nil {
+		return 0, err
+	}
+	ad, err := parseAuthData(rpID, authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	pub, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	hash := sha256.Sum256(clientDataJSON)
+	signed := make([]byte, 0, len(authenticatorData)+len(hash))
+	signed = append(signed, authenticatorData...)
+	signed = append(signed, hash[:]...)
+	if !pub.verify(signed, signature) {
+		return 0, ErrInvalidSignature
+	}
+	// Some authenticators don't implement the counter, and always send 0
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrClonedAuthenticator
+	}
+	return ad.signCount, nil
+}
+
+func checkClientData(raw []byte, typ string, challenge []byte, origin string) error {
+	var data struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return ErrInvalidClientData
+	}
+	if data.Type != typ || data.Origin != origin {
+		return ErrInvalidClientData
+	}
+	sent, err := Decode(data.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(sent, challenge) != 1 {
+		return ErrInvalidClientData
+	}
+	return nil
+}
+
+type authData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData parses the authenticator data, and checks that they are for
+// the given relying party, and that the user was present.
+func parseAuthData(rpID string, raw []byte) (*authData, error) {
+	if len(raw) < 37 {
+		return nil, ErrInvalidAuthData
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return nil, ErrInvalidAuthData
+	}
+	ad := &authData{
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+	if ad.flags&flagAttestedData == 0 {
+		return ad, nil
+	}
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidAuthData
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, ErrInvalidAuthData
+	}
+	ad.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+	// The public key is followed by the extensions, so we need to know where
+	// the CBOR value ends.
+	var key map[int]interface{}
+	dec := codec.NewDecoderBytes(rest, &codec.CborHandle{})
+	if err := dec.Decode(&key); err != nil {
+		return nil, ErrInvalidAuthData
+	}
+	ad.publicKey = rest[:dec.NumBytesRead()]
+	return ad, nil
+}
+
+type publicKey struct {
+	alg int
+	ec  *ecdsa.PublicKey
+	rsa *rsa.PublicKey
+}
+
+func parsePublicKey(raw []byte) (*publicKey, error) {
+	var key map[int]interface{}
+	if err := codec.NewDecoderBytes(raw, &codec.CborHandle{}).Decode(&key); err != nil {
+		return nil, ErrUnsupportedKey
+	}
+	kty, _ := toInt(key[coseKeyType])
+	alg, _ := toInt(key[coseAlg])
+	switch {
+	case kty == coseKtyEC2 && alg == coseAlgES256:
+		crv, _ := toInt(key[coseEC2Curve])
+		x, okX := key[coseEC2X].([]byte)
+		y, okY := key[coseEC2Y].([]byte)
+		if crv != coseCurveP256 || !okX || !okY {
+			return nil, ErrUnsupportedKey
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrUnsupportedKey
+		}
+		return &publicKey{alg: alg, ec: pub}, nil
+	case kty == coseKtyRSA && alg == coseAlgRS256:
+		n, okN := key[coseRSAN].([]byte)
+		e, okE := key[coseRSAE].([]byte)
+		if !okN || !okE || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		return &publicKey{alg: alg, rsa: pub}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+func (k *publicKey) verify(signed, signature []byte) bool {
+	hash := sha256.Sum256(signed)
+	switch k.alg {
+	case coseAlgES256:
+		var sig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+			return false
+		}
+		return ecdsa.Verify(k.ec, hash[:], sig.R, sig.S)
+	case coseAlgRS256:
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, hash[:], signature) == nil
+	}
+	return false
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	testRPID   = "alice.cozy.example"
+	testOrigin = "https://alice.cozy.example"
+)
+
+func encodeCBOR(t *testing.T, v interface{}) []byte {
+	var out []byte
+	err := codec.NewEncoderBytes(&out, &codec.CborHandle{}).Encode(v)
+	assert.NoError(t, err)
+	return out
+}
+
+func clientData(t *testing.T, typ string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": encode(challenge),
+		"origin":    testOrigin,
+	})
+	assert.NoError(t, err)
+	return data
+}
+
+func authenticatorData(flags byte, counter uint32, attested []byte) []byte {
+	hash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, hash[:]...)
+	data = append(data, flags)
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, counter)
+	data = append(data, count...)
+	return append(data, attested...)
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	coseKey := encodeCBOR(t, map[int]interface{}{
+		coseKeyType:  coseKtyEC2,
+		coseAlg:      coseAlgES256,
+		coseEC2Curve: coseCurveP256,
+		coseEC2X:     priv.X.Bytes(),
+		coseEC2Y:     priv.Y.Bytes(),
+	})
+	credID := []byte("my-security-key")
+	attested := make([]byte, 16) // AAGUID
+	attested = append(attested, 0, byte(len(credID)))
+	attested = append(attested, credID...)
+	attested = append(attested, coseKey...)
+	attestation := encodeCBOR(t, map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authenticatorData(flagUserPresent|flagAttestedData, 0, attested),
+	})
+
+	challenge := []byte("registration-challenge")
+	_, err = VerifyRegistration(testRPID, testOrigin, []byte("other"),
+		clientData(t, "webauthn.create", challenge), attestation)
+	assert.Equal(t, ErrInvalidClientData, err)
+	_, err = VerifyRegistration("bob.cozy.example", testOrigin, challenge,
+		clientData(t, "webauthn.create", challenge), attestation)
+	assert.Equal(t, ErrInvalidAuthData, err)
+	cred, err := VerifyRegistration(testRPID, testOrigin, challenge,
+		clientData(t, "webauthn.create", challenge), attestation)
+	assert.NoError(t, err)
+	assert.Equal(t, credID, cred.ID)
+	assert.Equal(t, coseKey, cred.PublicKey)
+
+	sign := func(authData, clientDataJSON []byte) []byte {
+		hash := sha256.Sum256(clientDataJSON)
+		signed := append(append([]byte{}, authData...), hash[:]...)
+		digest := sha256.Sum256(signed)
+		sig, err := priv.Sign(rand.Reader, digest[:], nil)
+		assert.NoError(t, err)
+		return sig
+	}
+
+	challenge = []byte("login-challenge")
+	data := clientData(t, "webauthn.get", challenge)
+	authData := authenticatorData(flagUserPresent, 1, nil)
+	count, err := VerifyAssertion(testRPID, testOrigin, challenge, cred, data, authData, sign(authData, data))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), count)
+
+	// Wrong type
+	created := clientData(t, "webauthn.create", challenge)
+	_, err = VerifyAssertion(testRPID, testOrigin, challenge, cred, created, authData, sign(authData, created))
+	assert.Equal(t, ErrInvalidClientData, err)
+
+	// User not present
+	absent := authenticatorData(0, 2, nil)
+	_, err = VerifyAssertion(testRPID, testOrigin, challenge, cred, data, absent, sign(absent, data))
+	assert.Equal(t, ErrUserNotPresent, err)
+
+	// Bad signature
+	_, err = VerifyAssertion(testRPID, testOrigin, challenge, cred, data, authData, sign(authData, created))
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	// Counter not increased
+	cred.SignCount = count
+	_, err = VerifyAssertion(testRPID, testOrigin, challenge, cred, data, authData, sign(authData, data))
+	assert.Equal(t, ErrClonedAuthenticator, err)
+}
+
+func TestOptions(t *testing.T) {
+	creds := []Credential{{ID: []byte("key1")}}
+	opts := CreationOptions(testRPID, "Cozy", []byte("user"), testRPID, []byte("challenge"), creds)
+	assert.Equal(t, encode([]byte("challenge")), opts["challenge"])
+	assert.Len(t, opts["excludeCredentials"], 1)
+	opts = RequestOptions(testRPID, []byte("challenge"), creds)
+	assert.Equal(t, testRPID, opts["rpId"])
+	allowed := opts["allowCredentials"].([]map[string]interface{})
+	assert.Equal(t, encode([]byte("key1")), allowed[0]["id"])
+
+	decoded, err := Decode(encode([]byte("key1")) + "=")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("key1"), decoded)
+}
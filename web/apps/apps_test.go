@@ -257,7 +257,7 @@ func TestServeAppsWithACode(t *testing.T) {
 	assert.NotEmpty(t, location.Query().Get("redirect"))
 
 	longRunSession := true
-	sess, _ := session.New(testInstance, longRunSession)
+	sess, _ := session.New(testInstance, longRunSession, nil)
 	code := session.BuildCode(sess.ID(), appHost)
 
 	req, _ = http.NewRequest("GET", ts.URL+"/foo?code="+code.Value, nil)
@@ -320,7 +320,7 @@ func TestFaviconWithContext(t *testing.T) {
 	c := &http.Client{Jar: ja, CheckRedirect: noRedirect}
 
 	longRunSession := true
-	sess, _ := session.New(testInstance, longRunSession)
+	sess, _ := session.New(testInstance, longRunSession, nil)
 	code := session.BuildCode(sess.ID(), appHost)
 
 	req, _ := http.NewRequest("GET", ts.URL+"/foo?code="+code.Value, nil)
@@ -571,7 +571,7 @@ func TestMain(m *testing.M) {
 	ts = setup.GetTestServer("/apps", webApps.WebappsRoutes, func(r *echo.Echo) *echo.Echo {
 		r.POST("/login", func(c echo.Context) error {
 			longRunSession := true
-			sess, _ := session.New(testInstance, longRunSession)
+			sess, _ := session.New(testInstance, longRunSession, nil)
 			cookie, _ := sess.ToCookie()
 			c.SetCookie(cookie)
 			return c.HTML(http.StatusOK, "OK")
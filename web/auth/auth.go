@@ -112,7 +112,7 @@ func AddCodeToRedirect(redirect *url.URL, domain, sessionID string) *url.URL {
 // SetCookieForNewSession creates a new session and sets the cookie on echo context
 func SetCookieForNewSession(c echo.Context, longRunSession bool) (string, error) {
 	instance := middlewares.GetInstance(c)
-	session, err := session.New(instance, longRunSession)
+	session, err := session.New(instance, longRunSession, c.Request())
 	if err != nil {
 		return "", err
 	}
@@ -337,8 +337,8 @@ func login(c echo.Context) error {
 		// check that the mail has been confirmed. If not, 2FA is not
 		// activated.
 		// If device is trusted, skip the 2FA.
-		if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
-			twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+		if inst.HasTwoFactor() && !isTrustedDevice(c, inst) {
+			twoFactorToken, err := lifecycle.StartTwoFactor(inst, "")
 			if err != nil {
 				return err
 			}
@@ -538,6 +538,12 @@ func Routes(router *echo.Group) {
 	authorizeGroup.POST("/sharing", authorizeSharing)
 
 	router.POST("/access_token", accessToken)
+
+	// Device authorization grant
+	router.POST("/device/code", deviceAuthorization)
+	deviceGroup := router.Group("/device", noCSRF)
+	deviceGroup.GET("", deviceForm)
+	deviceGroup.POST("", deviceAnswer)
 	router.POST("/secret_exchange", secretExchange)
 
 	// 2FA
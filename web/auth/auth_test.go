@@ -1190,6 +1190,45 @@ func TestAccessTokenNoClientSecret(t *testing.T) {
 	assertJSONError(t, res, "the client_secret parameter is mandatory")
 }
 
+func TestAccessTokenPKCEWithoutClientSecret(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	ac, err := oauth.CreateAccessCode(testInstance, clientID, "files:read", challenge, oauth.PKCEMethodS256)
+	assert.NoError(t, err)
+
+	res, err := postForm("/auth/access_token", &url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"code":          {ac.Code},
+		"code_verifier": {"not-the-right-verifier-for-this-access-code"},
+	})
+	assert.NoError(t, err)
+	assertJSONError(t, res, "invalid code_verifier")
+
+	res, err = postForm("/auth/access_token", &url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {clientID},
+		"refresh_token": {"foo"},
+	})
+	assert.NoError(t, err)
+	assertJSONError(t, res, "the client_secret parameter is mandatory")
+
+	res, err = postForm("/auth/access_token", &url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"code":          {ac.Code},
+		"code_verifier": {verifier},
+	})
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "200 OK", res.Status)
+	var response map[string]string
+	err = json.NewDecoder(res.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, "files:read", response["scope"])
+	assertValidToken(t, response["access_token"], "access", clientID, "files:read")
+}
+
 func TestAccessTokenInvalidClientSecret(t *testing.T) {
 	res, err := postForm("/auth/access_token", &url.Values{
 		"grant_type":    {"authorization_code"},
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// DeviceAuthorizationResponse is the struct used for serializing to JSON the
+// response of the device authorization endpoint (RFC 8628).
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// deviceError sends an error response for the device authorization grant,
+// with one of the error codes of RFC 6749 and RFC 8628.
+func deviceError(c echo.Context, code, description string) error {
+	res := echo.Map{"error": code}
+	if description != "" {
+		res["error_description"] = description
+	}
+	return c.JSON(http.StatusBadRequest, res)
+}
+
+// deviceAuthorization is the device authorization endpoint: a client that
+// can't open a browser asks for a device code, and the user code that the
+// user will type on another device.
+func deviceAuthorization(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	clientID := c.FormValue("client_id")
+	clientSecret := c.FormValue("client_secret")
+	scope := c.FormValue("scope")
+
+	if clientID == "" || clientSecret == "" {
+		return deviceError(c, "invalid_request", "the client_id and client_secret parameters are mandatory")
+	}
+	client, err := oauth.FindClient(inst, clientID)
+	if err != nil {
+		if couchErr, isCouchErr := couchdb.IsCouchError(err); isCouchErr && couchErr.StatusCode >= 500 {
+			return err
+		}
+		return deviceError(c, "invalid_client", "the client must be registered")
+	}
+	if subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 0 {
+		return deviceError(c, "invalid_client", "invalid client_secret")
+	}
+	if oauth.IsLinkedApp(client.SoftwareID) {
+		return deviceError(c, "unauthorized_client", "a linked app must use the authorization code grant")
+	}
+	if scope == "" || scope == oauth.ScopeLogin {
+		return deviceError(c, "invalid_scope", "the scope parameter is mandatory")
+	}
+	if _, err := permission.UnmarshalScopeString(scope); err != nil {
+		return deviceError(c, "invalid_scope", err.Error())
+	}
+
+	dc, err := oauth.CreateDeviceCode(inst, client.ClientID, scope)
+	if err != nil {
+		return err
+	}
+	userCode := dc.FormattedUserCode()
+	return c.JSON(http.StatusOK, DeviceAuthorizationResponse{
+		DeviceCode:              dc.Code,
+		UserCode:                userCode,
+		VerificationURI:         inst.PageURL("/auth/device", nil),
+		VerificationURIComplete: inst.PageURL("/auth/device", url.Values{"user_code": {userCode}}),
+		ExpiresIn:               int(oauth.DeviceCodeTTL.Seconds()),
+		Interval:                int(oauth.DeviceCodeInterval.Seconds()),
+	})
+}
+
+// checkDeviceCode is used by the token endpoint for the device code grant. It
+// returns the device code if the user has given the permissions to the
+// client, or else the error code to send to the client.
+func checkDeviceCode(inst *instance.Instance, client *oauth.Client, code string) (*oauth.DeviceCode, string, error) {
+	if code == "" {
+		return nil, "invalid_request", nil
+	}
+	dc, err := oauth.FindDeviceCode(inst, code)
+	if err == oauth.ErrDeviceCodeNotFound {
+		return nil, "invalid_grant", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	if dc.ClientID != client.ClientID {
+		return nil, "invalid_grant", nil
+	}
+	if dc.Expired() {
+		deleteDeviceCode(inst, dc)
+		return nil, "expired_token", nil
+	}
+
+	switch dc.State {
+	case oauth.DeviceCodeApproved:
+		// It can be used only once: the tokens are given only to the request
+		// that has consumed it
+		if err := dc.Consume(inst); err == oauth.ErrDeviceCodeNotFound {
+			return nil, "invalid_grant", nil
+		} else if err != nil {
+			return nil, "", err
+		}
+		return dc, "", nil
+	case oauth.DeviceCodeDenied:
+		deleteDeviceCode(inst, dc)
+		return nil, "access_denied", nil
+	}
+	if err := dc.Poll(inst); err == oauth.ErrSlowDown {
+		return nil, "slow_down", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	return nil, "authorization_pending", nil
+}
+
+func deleteDeviceCode(inst *instance.Instance, dc *oauth.DeviceCode) {
+	if err := couchdb.DeleteDoc(inst, dc); err != nil {
+		inst.Logger().WithField("nspace", "oauth").
+			Errorf("Failed to delete the device code: %s", err)
+	}
+}
+
+// findPendingDeviceCode returns the device code for the user code typed by
+// the user, and its client, if the user has not already answered.
+func findPendingDeviceCode(inst *instance.Instance, userCode string) (*oauth.DeviceCode, *oauth.Client, error) {
+	dc, err := oauth.FindDeviceCodeByUserCode(inst, userCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dc.State != oauth.DeviceCodePending {
+		return nil, nil, oauth.ErrDeviceCodeNotFound
+	}
+	client, err := oauth.FindClient(inst, dc.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dc, client, nil
+}
+
+func renderDevice(c echo.Context, code int, params echo.Map) error {
+	inst := middlewares.GetInstance(c)
+	params["Title"] = inst.TemplateTitle()
+	params["CozyUI"] = middlewares.CozyUI(inst)
+	params["ThemeCSS"] = middlewares.ThemeCSS(inst)
+	params["Domain"] = inst.ContextualDomain()
+	params["ContextName"] = inst.ContextName
+	params["Locale"] = inst.Locale
+	params["Favicon"] = middlewares.Favicon(inst)
+	params["CSRF"] = c.Get("csrf")
+	return c.Render(code, "device.html", params)
+}
+
+// renderDeviceConfirmation shows the permissions asked by the client, for the
+// user to approve or deny them.
+func renderDeviceConfirmation(c echo.Context, dc *oauth.DeviceCode, client *oauth.Client) error {
+	permissions, err := permission.UnmarshalScopeString(dc.Scope)
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Error Invalid scope")
+	}
+	return renderDevice(c, http.StatusOK, echo.Map{
+		"UserCode":    dc.FormattedUserCode(),
+		"Client":      client,
+		"Permissions": permissions,
+	})
+}
+
+func deviceForm(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !middlewares.IsLoggedIn(c) {
+		u := inst.PageURL("/auth/login", url.Values{
+			"redirect": {inst.FromURL(c.Request().URL)},
+		})
+		return c.Redirect(http.StatusSeeOther, u)
+	}
+
+	userCode := c.QueryParam("user_code")
+	if userCode == "" {
+		return renderDevice(c, http.StatusOK, echo.Map{})
+	}
+	dc, client, err := findPendingDeviceCode(inst, userCode)
+	if err != nil {
+		return renderDevice(c, http.StatusBadRequest, echo.Map{
+			"UserCode": userCode,
+			"Error":    "Device Invalid code",
+		})
+	}
+	return renderDeviceConfirmation(c, dc, client)
+}
+
+func deviceAnswer(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !middlewares.IsLoggedIn(c) {
+		return renderError(c, http.StatusUnauthorized, "Error Must be authenticated")
+	}
+
+	userCode := c.FormValue("user_code")
+	dc, _, err := findPendingDeviceCode(inst, userCode)
+	if err != nil {
+		return renderDevice(c, http.StatusBadRequest, echo.Map{
+			"UserCode": userCode,
+			"Error":    "Device Invalid code",
+		})
+	}
+	approved := c.FormValue("answer") == "approve"
+	if err := dc.Answer(inst, approved); err != nil {
+		return err
+	}
+	return renderDevice(c, http.StatusOK, echo.Map{
+		"Done":     true,
+		"Approved": approved,
+	})
+}
@@ -30,14 +30,16 @@ type webappParams struct {
 }
 
 type authorizeParams struct {
-	instance    *instance.Instance
-	state       string
-	clientID    string
-	redirectURI string
-	scope       string
-	resType     string
-	client      *oauth.Client
-	webapp      *webappParams
+	instance        *instance.Instance
+	state           string
+	clientID        string
+	redirectURI     string
+	scope           string
+	resType         string
+	challenge       string
+	challengeMethod string
+	client          *oauth.Client
+	webapp          *webappParams
 }
 
 func checkAuthorizeParams(c echo.Context, params *authorizeParams) (bool, error) {
@@ -53,6 +55,11 @@ func checkAuthorizeParams(c echo.Context, params *authorizeParams) (bool, error)
 	if params.resType != "code" {
 		return true, renderError(c, http.StatusBadRequest, "Error Invalid response type")
 	}
+	method, err := oauth.CheckCodeChallenge(params.challenge, params.challengeMethod)
+	if err != nil {
+		return true, renderError(c, http.StatusBadRequest, "Error Invalid code_challenge")
+	}
+	params.challengeMethod = method
 
 	params.client = new(oauth.Client)
 	if err := couchdb.GetDoc(params.instance, consts.OAuthClients, params.clientID, params.client); err != nil {
@@ -100,12 +107,14 @@ func checkAuthorizeParams(c echo.Context, params *authorizeParams) (bool, error)
 func authorizeForm(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	params := authorizeParams{
-		instance:    instance,
-		state:       c.QueryParam("state"),
-		clientID:    c.QueryParam("client_id"),
-		redirectURI: c.QueryParam("redirect_uri"),
-		scope:       c.QueryParam("scope"),
-		resType:     c.QueryParam("response_type"),
+		instance:        instance,
+		state:           c.QueryParam("state"),
+		clientID:        c.QueryParam("client_id"),
+		redirectURI:     c.QueryParam("redirect_uri"),
+		scope:           c.QueryParam("scope"),
+		resType:         c.QueryParam("response_type"),
+		challenge:       c.QueryParam("code_challenge"),
+		challengeMethod: c.QueryParam("code_challenge_method"),
 	}
 
 	if hasError, err := checkAuthorizeParams(c, &params); hasError {
@@ -123,7 +132,8 @@ func authorizeForm(c echo.Context) error {
 	// for the manager. It does not require any authorization from the user, and
 	// generate a code without asking any permission.
 	if params.scope == oauth.ScopeLogin {
-		access, err := oauth.CreateAccessCode(params.instance, params.clientID, "" /* = scope */)
+		access, err := oauth.CreateAccessCode(params.instance, params.clientID, "", /* = scope */
+			params.challenge, params.challengeMethod)
 		if err != nil {
 			return err
 		}
@@ -201,6 +211,8 @@ func authorizeForm(c echo.Context) error {
 		"State":            params.state,
 		"RedirectURI":      params.redirectURI,
 		"Scope":            params.scope,
+		"Challenge":        params.challenge,
+		"ChallengeMethod":  params.challengeMethod,
 		"Permissions":      permissions,
 		"ReadOnly":         readOnly,
 		"CSRF":             c.Get("csrf"),
@@ -213,12 +225,14 @@ func authorizeForm(c echo.Context) error {
 func authorize(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	params := authorizeParams{
-		instance:    instance,
-		state:       c.FormValue("state"),
-		clientID:    c.FormValue("client_id"),
-		redirectURI: c.FormValue("redirect_uri"),
-		scope:       c.FormValue("scope"),
-		resType:     c.FormValue("response_type"),
+		instance:        instance,
+		state:           c.FormValue("state"),
+		clientID:        c.FormValue("client_id"),
+		redirectURI:     c.FormValue("redirect_uri"),
+		scope:           c.FormValue("scope"),
+		resType:         c.FormValue("response_type"),
+		challenge:       c.FormValue("code_challenge"),
+		challengeMethod: c.FormValue("code_challenge_method"),
 	}
 
 	if hasError, err := checkAuthorizeParams(c, &params); hasError {
@@ -267,7 +281,8 @@ func authorize(c echo.Context) error {
 		}
 	}
 
-	access, err := oauth.CreateAccessCode(params.instance, params.clientID, params.scope)
+	access, err := oauth.CreateAccessCode(params.instance, params.clientID, params.scope,
+		params.challenge, params.challengeMethod)
 	if err != nil {
 		return err
 	}
@@ -409,7 +424,10 @@ func accessToken(c echo.Context) error {
 			"error": "the client_id parameter is mandatory",
 		})
 	}
-	if clientSecret == "" {
+	// The client_secret can be omitted when exchanging an access code that
+	// was created with a PKCE challenge, for the public clients that can't
+	// keep a secret (native apps)
+	if clientSecret == "" && grant != "authorization_code" {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"error": "the client_secret parameter is mandatory",
 		})
@@ -424,7 +442,7 @@ func accessToken(c echo.Context) error {
 			"error": "the client must be registered",
 		})
 	}
-	if subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 0 {
+	if clientSecret != "" && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 0 {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"error": "invalid client_secret",
 		})
@@ -454,6 +472,21 @@ func accessToken(c echo.Context) error {
 				"error": "invalid code",
 			})
 		}
+		if accessCode.ClientID != client.ClientID {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "invalid code",
+			})
+		}
+		if clientSecret == "" && accessCode.ChallengeMethod != oauth.PKCEMethodS256 {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "the client_secret parameter is mandatory",
+			})
+		}
+		if !accessCode.ValidateCodeVerifier(c.FormValue("code_verifier")) {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "invalid code_verifier",
+			})
+		}
 		out.Scope = accessCode.Scope
 		out.Refresh, err = client.CreateJWT(instance, consts.RefreshTokenAudience, out.Scope)
 		if err != nil {
@@ -468,6 +501,24 @@ func accessToken(c echo.Context) error {
 				"[oauth] Failed to delete the access code: %s", err)
 		}
 
+	case oauth.DeviceCodeGrantType:
+		dc, errCode, err := checkDeviceCode(instance, client, c.FormValue("device_code"))
+		if err != nil {
+			return err
+		}
+		if errCode != "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": errCode,
+			})
+		}
+		out.Scope = dc.Scope
+		out.Refresh, err = client.CreateJWT(instance, consts.RefreshTokenAudience, out.Scope)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": "Can't generate refresh token",
+			})
+		}
+
 	case "refresh_token":
 		claims, ok := client.ValidToken(instance, consts.RefreshTokenAudience, c.FormValue("refresh_token"))
 		if !ok {
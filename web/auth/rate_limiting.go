@@ -17,7 +17,8 @@ func LoginRateExceeded(i *instance.Instance) error {
 }
 
 // TwoFactorRateExceeded regenerates a new 2FA passcode after too many failed
-// attempts to login
+// attempts to login. For the other methods, the counter is just reset, as the
+// generations are also limited.
 func TwoFactorRateExceeded(i *instance.Instance) error {
 	err := limits.CheckRateLimit(i, limits.TwoFactorGenerationType)
 	if limits.IsLimitReachedOrExceeded(err) {
@@ -25,6 +26,9 @@ func TwoFactorRateExceeded(i *instance.Instance) error {
 	}
 	// Reset the key and send a new passcode to the user
 	limits.ResetCounter(i, limits.TwoFactorType)
+	if lifecycle.DefaultTwoFactorMethod(i) != lifecycle.TwoFactorMethodMail {
+		return nil
+	}
 	_, err = lifecycle.SendTwoFactorPasscode(i)
 	return err
 }
@@ -1,20 +1,35 @@
 package auth
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
 
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
 )
 
-func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsError string, redirect *url.URL, twoFactorToken []byte, longRunSession bool, trustedDeviceCheckBox bool) error {
+func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsError string, redirect *url.URL, twoFactorToken []byte, method string, longRunSession bool, trustedDeviceCheckBox bool) error {
 	title := i.Translate("Login Two factor title")
 
+	var webauthnOptions string
+	if method == lifecycle.TwoFactorMethodWebAuthn {
+		opts, err := i.WebAuthnRequestOptions(twoFactorToken)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid twoFactorToken")
+		}
+		data, err := json.Marshal(opts)
+		if err != nil {
+			return err
+		}
+		webauthnOptions = string(data)
+	}
+
 	redirectQuery := redirect.Query()
 	var clientScope string
 	if clientScopes := redirectQuery["scope"]; len(clientScopes) > 0 {
@@ -35,6 +50,10 @@ func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsEr
 		"Redirect":              redirect.String(),
 		"LongRunSession":        longRunSession,
 		"TwoFactorToken":        string(twoFactorToken),
+		"TwoFactorMethod":       method,
+		"DefaultMethod":         lifecycle.DefaultTwoFactorMethod(i),
+		"WebAuthnOptions":       webauthnOptions,
+		"HasRecoveryCodes":      len(i.RecoveryCodes) > 0,
 		"Favicon":               middlewares.Favicon(i),
 		"TrustedDeviceCheckBox": trustedCheckbox,
 	})
@@ -59,6 +78,37 @@ func twoFactorForm(c echo.Context) error {
 
 	twoFactorToken := []byte(twoFactorTokenParam)
 
+	// The user can choose another method than the default one: the mail as a
+	// fallback, or a recovery code.
+	method := c.QueryParam("method")
+	switch method {
+	case "":
+		method = lifecycle.DefaultTwoFactorMethod(inst)
+	case lifecycle.TwoFactorMethodMail:
+		if lifecycle.DefaultTwoFactorMethod(inst) != lifecycle.TwoFactorMethodMail {
+			if !inst.ValidateTwoFactorToken(twoFactorToken) {
+				return c.JSON(http.StatusBadRequest, "Invalid twoFactorToken")
+			}
+			err = limits.CheckRateLimit(inst, limits.TwoFactorGenerationType)
+			if limits.IsLimitReachedOrExceeded(err) {
+				if err = TwoFactorGenerationExceeded(inst); err != nil {
+					inst.Logger().WithField("nspace", "auth").Warning(err)
+				}
+				return renderError(c, http.StatusTooManyRequests, inst.Translate(TwoFactorExceededErrorKey))
+			}
+			twoFactorToken, err = lifecycle.StartTwoFactor(inst, method)
+			if err != nil {
+				return err
+			}
+		}
+	case lifecycle.TwoFactorMethodRecovery:
+		// The token is kept, there is nothing to send
+	default:
+		if method != lifecycle.DefaultTwoFactorMethod(inst) {
+			return c.JSON(http.StatusBadRequest, "Invalid method")
+		}
+	}
+
 	longRunSession := false
 	if longRunParam := c.QueryParam("long-run-session"); longRunParam != "" {
 		longRunSession, err = strconv.ParseBool(longRunParam)
@@ -74,7 +124,7 @@ func twoFactorForm(c echo.Context) error {
 		}
 	}
 
-	return renderTwoFactorForm(c, inst, http.StatusOK, credsError, redirect, twoFactorToken, longRunSession, trustedDeviceCheckBox)
+	return renderTwoFactorForm(c, inst, http.StatusOK, credsError, redirect, twoFactorToken, method, longRunSession, trustedDeviceCheckBox)
 }
 
 // twoFactor handles a the twoFactor POST request
@@ -96,6 +146,10 @@ func twoFactor(c echo.Context) error {
 	// Retreiving data from request
 	token := []byte(c.FormValue("two-factor-token"))
 	passcode := c.FormValue("two-factor-passcode")
+	method := c.FormValue("two-factor-method")
+	if method == "" {
+		method = lifecycle.DefaultTwoFactorMethod(inst)
+	}
 	generateTrustedDeviceToken, _ := strconv.ParseBool(c.FormValue("two-factor-generate-trusted-device-token"))
 
 	longRunSession := false
@@ -107,9 +161,9 @@ func twoFactor(c echo.Context) error {
 	}
 
 	// Handle 2FA failed
-	correctPasscode := inst.ValidateTwoFactorPasscode(token, passcode)
+	correctPasscode := lifecycle.CheckTwoFactor(inst, token, method, passcode)
 	if !correctPasscode {
-		return twoFactorFailed(c, inst, redirect, token, longRunSession, trustedDeviceCheckBox)
+		return twoFactorFailed(c, inst, redirect, token, method, longRunSession, trustedDeviceCheckBox)
 	}
 
 	// Generate a new session
@@ -135,7 +189,7 @@ func twoFactor(c echo.Context) error {
 }
 
 // twoFactorFailed returns the 2FA form with an error message
-func twoFactorFailed(c echo.Context, inst *instance.Instance, redirect *url.URL, token []byte, longRunSession bool, trustedDeviceCheckBox bool) error {
+func twoFactorFailed(c echo.Context, inst *instance.Instance, redirect *url.URL, token []byte, method string, longRunSession bool, trustedDeviceCheckBox bool) error {
 	errorMessage := inst.Translate(TwoFactorErrorKey)
 
 	errCheckRateLimit := limits.CheckRateLimit(inst, limits.TwoFactorType)
@@ -152,5 +206,5 @@ func twoFactorFailed(c echo.Context, inst *instance.Instance, redirect *url.URL,
 		})
 	}
 
-	return renderTwoFactorForm(c, inst, http.StatusUnauthorized, errorMessage, redirect, token, longRunSession, trustedDeviceCheckBox)
+	return renderTwoFactorForm(c, inst, http.StatusUnauthorized, errorMessage, redirect, token, method, longRunSession, trustedDeviceCheckBox)
 }
@@ -0,0 +1,200 @@
+package bitwarden
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/jslib/blob/master/src/models/response/attachmentResponse.ts
+type attachmentResponse struct {
+	ID       string `json:"Id"`
+	URL      string `json:"Url"`
+	FileName string `json:"FileName"`
+	Key      string `json:"Key"`
+	Size     string `json:"Size"`
+	SizeName string `json:"SizeName"`
+	Object   string `json:"Object"`
+}
+
+func newAttachmentResponse(inst *instance.Instance, c *bitwarden.Cipher, att *bitwarden.Attachment) *attachmentResponse {
+	r := attachmentResponse{
+		ID:       att.ID,
+		FileName: att.FileName,
+		Key:      att.Key,
+		Size:     strconv.FormatInt(att.Size, 10),
+		SizeName: humanize.Bytes(uint64(att.Size)),
+		Object:   "attachment",
+	}
+	if token, err := bitwarden.AttachmentToken(inst, c.CouchID, att.ID); err == nil {
+		r.URL = inst.PageURL("/bitwarden/attachments/"+c.CouchID+"/"+att.ID, url.Values{
+			"token": {token},
+		})
+	}
+	return &r
+}
+
+// PostAttachment is the handler for uploading a new attachment for a cipher.
+// The body is a multipart form, with the encrypted key of the attachment in
+// the key field, and its encrypted content in the data field (the filename of
+// this part is the encrypted name of the attachment).
+func PostAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+
+	cipher := &bitwarden.Cipher{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenCiphers, id, cipher); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	key := c.FormValue("key")
+	header, err := c.FormFile("data")
+	if err != nil || key == "" || header.Filename == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid multipart form",
+		})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	defer file.Close()
+
+	_, err = bitwarden.AddAttachment(inst, cipher, header.Filename, key, header.Size, file)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == vfs.ErrFileTooBig {
+			status = http.StatusRequestEntityTooLarge
+		}
+		return c.JSON(status, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	settings.UpdateRevisionDate(inst, setting)
+	res := newCipherResponse(inst, cipher, setting)
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetAttachment is the handler for downloading the encrypted content of an
+// attachment. The clients don't send their bearer token for this request, so
+// the access is checked with the token in the URL from the sync response.
+func GetAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipherID := c.Param("cipherID")
+	attachmentID := c.Param("attachmentID")
+	token := c.QueryParam("token")
+	if !bitwarden.CheckAttachmentToken(inst, cipherID, attachmentID, token) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	cipher := &bitwarden.Cipher{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenCiphers, cipherID, cipher); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	att, err := cipher.FindAttachment(attachmentID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+	doc, err := bitwarden.OpenAttachment(inst, cipher, att)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+
+	return vfs.ServeFileContent(inst.VFS(), doc, nil, "", "attachment", c.Request(), c.Response())
+}
+
+// DeleteAttachment is the handler for the route to delete an attachment of a
+// cipher.
+func DeleteAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+
+	cipher := &bitwarden.Cipher{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenCiphers, id, cipher); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := bitwarden.DeleteAttachment(inst, cipher, c.Param("attachmentID")); err != nil {
+		if err == bitwarden.ErrAttachmentNotFound {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
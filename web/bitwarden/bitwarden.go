@@ -214,7 +214,7 @@ func getInitialCredentials(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		if !checkTwoFactor(c, inst) {
 			return nil
 		}
@@ -275,7 +275,14 @@ func checkTwoFactor(c echo.Context, inst *instance.Instance) bool {
 	cache := config.GetConfig().CacheStorage
 	key := "bw-2fa:" + inst.Domain
 
-	if passcode := c.FormValue("twoFactorToken"); passcode != "" {
+	// The bitwarden clients don't support the security keys, so they use the
+	// mail as a fallback.
+	useTOTP := inst.HasAuthMode(instance.TwoFactorTOTP)
+	if passcode := c.FormValue("twoFactorToken"); passcode != "" && useTOTP {
+		if lifecycle.CheckTOTPPasscode(inst, passcode) {
+			return true
+		}
+	} else if passcode != "" {
 		if token, ok := cache.Get(key); ok {
 			if inst.ValidateTwoFactorPasscode(token, passcode) {
 				return true
@@ -290,6 +297,17 @@ func checkTwoFactor(c echo.Context, inst *instance.Instance) bool {
 		return true
 	}
 
+	if useTOTP {
+		// 0 means authenticator app
+		_ = c.JSON(http.StatusBadRequest, echo.Map{
+			"error":               "invalid_grant",
+			"error_description":   "Two factor required.",
+			"TwoFactorProviders":  []int{0},
+			"TwoFactorProviders2": map[string]interface{}{"0": nil},
+		})
+		return false
+	}
+
 	email, err := inst.SettingsEMail()
 	if err != nil {
 		_ = c.JSON(http.StatusInternalServerError, echo.Map{
@@ -427,13 +445,27 @@ func Routes(router *echo.Group) {
 	ciphers.GET("", ListCiphers)
 	ciphers.POST("", CreateCipher)
 	ciphers.POST("/create", CreateSharedCipher)
+	ciphers.POST("/import", ImportCiphers)
+	ciphers.DELETE("", BulkDeleteCiphers)
+	ciphers.POST("/delete", BulkDeleteCiphers)
+	ciphers.PUT("/delete", BulkSoftDeleteCiphers)
+	ciphers.PUT("/restore", BulkRestoreCiphers)
+	ciphers.POST("/move", MoveCiphers)
+	ciphers.PUT("/move", MoveCiphers)
+	ciphers.POST("/share", BulkShareCiphers)
+	ciphers.PUT("/share", BulkShareCiphers)
 	ciphers.GET("/:id", GetCipher)
 	ciphers.POST("/:id", UpdateCipher)
 	ciphers.PUT("/:id", UpdateCipher)
 	ciphers.DELETE("/:id", DeleteCipher)
 	ciphers.POST("/:id/delete", DeleteCipher)
+	ciphers.PUT("/:id/delete", SoftDeleteCipher)
+	ciphers.PUT("/:id/restore", RestoreCipher)
 	ciphers.POST("/:id/share", ShareCipher)
 	ciphers.PUT("/:id/share", ShareCipher)
+	ciphers.POST("/:id/attachment", PostAttachment)
+	ciphers.DELETE("/:id/attachment/:attachmentID", DeleteAttachment)
+	ciphers.POST("/:id/attachment/:attachmentID/delete", DeleteAttachment)
 
 	folders := api.Group("/folders")
 	folders.GET("", ListFolders)
@@ -444,6 +476,44 @@ func Routes(router *echo.Group) {
 	folders.DELETE("/:id", DeleteFolder)
 	folders.POST("/:id/delete", DeleteFolder)
 
+	organizations := api.Group("/organizations")
+	organizations.POST("", CreateOrganization)
+	organizations.GET("/:id", GetOrganization)
+	organizations.DELETE("/:id", DeleteOrganization)
+	organizations.POST("/:id/delete", DeleteOrganization)
+	organizations.POST("/:id/leave", LeaveOrganization)
+	organizations.GET("/:id/users", ListOrganizationUsers)
+	organizations.POST("/:id/users/invite", InviteOrganizationUsers)
+	organizations.POST("/:id/users/:userID/confirm", ConfirmOrganizationUser)
+	organizations.DELETE("/:id/users/:userID", RemoveOrganizationUser)
+	organizations.POST("/:id/users/:userID/delete", RemoveOrganizationUser)
+	organizations.GET("/:id/collections", ListOrganizationCollections)
+	organizations.POST("/:id/collections", CreateCollection)
+	organizations.PUT("/:id/collections/:collectionID", UpdateCollection)
+	organizations.POST("/:id/collections/:collectionID", UpdateCollection)
+	organizations.DELETE("/:id/collections/:collectionID", DeleteCollection)
+	organizations.POST("/:id/collections/:collectionID/delete", DeleteCollection)
+
+	sends := api.Group("/sends")
+	sends.GET("", ListSends)
+	sends.POST("", CreateSend)
+	sends.POST("/file", CreateFileSend)
+	sends.POST("/access/:accessID", AccessSend)
+	sends.GET("/:id", GetSend)
+	sends.PUT("/:id", UpdateSend)
+	sends.DELETE("/:id", DeleteSend)
+	sends.PUT("/:id/remove-password", RemoveSendPassword)
+	sends.POST("/:id/access/file/:fileID", AccessSendFile)
+
+	api.GET("/collections", ListCollections)
+	api.GET("/users/:id/public-key", GetPublicKey)
+
+	attachments := router.Group("/attachments")
+	attachments.GET("/:cipherID/:attachmentID", GetAttachment)
+
+	sendFiles := router.Group("/sends")
+	sendFiles.GET("/:id/:fileID", DownloadSendFile)
+
 	hub := router.Group("/notifications/hub")
 	hub.GET("", WebsocketHub)
 	hub.POST("/negotiate", NegotiateHub)
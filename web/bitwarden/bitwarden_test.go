@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/bitwarden"
 	"github.com/cozy/cozy-stack/model/instance"
@@ -446,6 +449,148 @@ func TestDeleteCipher(t *testing.T) {
 	assert.Equal(t, 200, res.StatusCode)
 }
 
+func TestCipherAttachment(t *testing.T) {
+	body := `
+{
+	"type": 2,
+	"name": "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+	"secureNote": {
+		"type": 0
+	}
+}`
+	req, _ := http.NewRequest("POST", ts.URL+"/bitwarden/api/ciphers", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	id := result["Id"].(string)
+
+	content := "2.encrypted-content-of-the-attachment"
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	assert.NoError(t, w.WriteField("key", "2.encrypted-key"))
+	part, err := w.CreateFormFile("data", "2.encrypted-filename")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	req, _ = http.NewRequest("POST", ts.URL+"/bitwarden/api/ciphers/"+id+"/attachment", buf)
+	req.Header.Add("Content-Type", w.FormDataContentType())
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	attachments := result["Attachments"].([]interface{})
+	assert.Len(t, attachments, 1)
+	att := attachments[0].(map[string]interface{})
+	assert.Equal(t, "attachment", att["Object"])
+	assert.Equal(t, "2.encrypted-filename", att["FileName"])
+	assert.Equal(t, "2.encrypted-key", att["Key"])
+	assert.Equal(t, "37", att["Size"])
+	attID := att["Id"].(string)
+
+	res, err = http.Get(att["Url"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	downloaded, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(downloaded))
+
+	res, err = http.Get(ts.URL + "/bitwarden/attachments/" + id + "/" + attID + "?token=invalid")
+	assert.NoError(t, err)
+	assert.Equal(t, 401, res.StatusCode)
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/bitwarden/api/ciphers/"+id+"/attachment/"+attID, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	res, err = http.Get(att["Url"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/bitwarden/api/ciphers/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func TestTrashCiphers(t *testing.T) {
+	body := `
+{
+	"type": 2,
+	"name": "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+	"secureNote": {
+		"type": 0
+	}
+}`
+	req, _ := http.NewRequest("POST", ts.URL+"/bitwarden/api/ciphers", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	id := result["Id"].(string)
+	assert.Nil(t, result["DeletedDate"])
+
+	req, _ = http.NewRequest("PUT", ts.URL+"/bitwarden/api/ciphers/"+id+"/delete", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/bitwarden/api/ciphers/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result["DeletedDate"])
+
+	body = `{"ids": ["` + id + `"]}`
+	req, _ = http.NewRequest("PUT", ts.URL+"/bitwarden/api/ciphers/restore", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data := result["Data"].([]interface{})
+	assert.Len(t, data, 1)
+	restored := data[0].(map[string]interface{})
+	assert.Equal(t, id, restored["Id"])
+	assert.Nil(t, restored["DeletedDate"])
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/bitwarden/api/ciphers", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/bitwarden/api/ciphers/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+}
+
 func TestSync(t *testing.T) {
 	req, _ := http.NewRequest("GET", ts.URL+"/bitwarden/api/sync", nil)
 	req.Header.Add("Authorization", "Bearer "+token)
@@ -556,6 +701,203 @@ func TestSharedCipher(t *testing.T) {
 	assert.Equal(t, orgID, orgaID)
 }
 
+func TestImportCiphers(t *testing.T) {
+	body := `
+{
+	"ciphers": [
+		{
+			"type": 2,
+			"name": "2.G38TIU3t1pGOfkzjCQE7OQ==|Xa1RupttU7zrWdzIT6a7jA==|J1C5nn2XSIRhXfXHlN6Ce70Q6d/Ee/QkpLbCQxSUjoI=",
+			"secureNote": {
+				"type": 0
+			}
+		},
+		{
+			"type": 2,
+			"name": "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+			"secureNote": {
+				"type": 0
+			}
+		}
+	],
+	"folders": [
+		{
+			"name": "2.FQAwIBaDbczEGnEJw4g4hw==|7KreXaC0duAj0ulzZJ8ncA==|nu2sEvotjd4zusvGF8YZJPnS9SiJPDqc1VIfCrfve/o="
+		}
+	],
+	"folderRelationships": [
+		{
+			"key": 1,
+			"value": 0
+		}
+	]
+}`
+	req, _ := http.NewRequest("POST", ts.URL+"/bitwarden/api/ciphers/import", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/bitwarden/api/folders", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	var importedFolderID string
+	for _, f := range result["Data"].([]interface{}) {
+		folder := f.(map[string]interface{})
+		if folder["Name"] == "2.FQAwIBaDbczEGnEJw4g4hw==|7KreXaC0duAj0ulzZJ8ncA==|nu2sEvotjd4zusvGF8YZJPnS9SiJPDqc1VIfCrfve/o=" {
+			importedFolderID = folder["Id"].(string)
+		}
+	}
+	assert.NotEmpty(t, importedFolderID)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/bitwarden/api/ciphers", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	found := 0
+	for _, c := range result["Data"].([]interface{}) {
+		cipher := c.(map[string]interface{})
+		switch cipher["Name"] {
+		case "2.G38TIU3t1pGOfkzjCQE7OQ==|Xa1RupttU7zrWdzIT6a7jA==|J1C5nn2XSIRhXfXHlN6Ce70Q6d/Ee/QkpLbCQxSUjoI=":
+			assert.Nil(t, cipher["FolderId"])
+			found++
+		case "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=":
+			if cipher["FolderId"] == importedFolderID {
+				found++
+			}
+		}
+	}
+	assert.Equal(t, 2, found)
+
+	body = `{"ciphers": [{"type": 2}], "folders": []}`
+	req, _ = http.NewRequest("POST", ts.URL+"/bitwarden/api/ciphers/import", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+}
+
+func TestSends(t *testing.T) {
+	deletion := time.Now().Add(72 * time.Hour).UTC().Format(time.RFC3339)
+	body := `
+{
+	"type": 0,
+	"name": "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+	"key": "2.FQAwIBaDbczEGnEJw4g4hw==|7KreXaC0duAj0ulzZJ8ncA==|nu2sEvotjd4zusvGF8YZJPnS9SiJPDqc1VIfCrfve/o=",
+	"maxAccessCount": 1,
+	"deletionDate": "` + deletion + `",
+	"text": {
+		"text": "2.T57BwAuV8ubIn/sZPbQC+A==|EhUSSpJWSzSYOdJ/AQzfXuUXxwzcs/6C4tOXqhWAqcM=|OWV2VIqLfoWPs9DiouXGUOtTEkVeklbtJQHkQFIXkC8=",
+		"hidden": false
+	},
+	"password": "ZkPuY5S0d3Gsu6JoUR3L4WIupWuX1rwvL6kqoTGv9Jo="
+}`
+	req, _ := http.NewRequest("POST", ts.URL+"/bitwarden/api/sends", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, "send", result["Object"])
+	assert.Equal(t, float64(0), result["Type"])
+	assert.Equal(t, float64(0), result["AccessCount"])
+	assert.NotEmpty(t, result["Password"])
+	assert.NotEqual(t, "ZkPuY5S0d3Gsu6JoUR3L4WIupWuX1rwvL6kqoTGv9Jo=", result["Password"])
+	id := result["Id"].(string)
+	accessID := result["AccessId"].(string)
+	assert.NotEmpty(t, accessID)
+
+	req, _ = http.NewRequest("POST", ts.URL+"/bitwarden/api/sends/access/"+accessID, bytes.NewBufferString(`{}`))
+	req.Header.Add("Content-Type", "application/json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 401, res.StatusCode)
+
+	req, _ = http.NewRequest("POST", ts.URL+"/bitwarden/api/sends/access/"+accessID, bytes.NewBufferString(`{"password": "wrong"}`))
+	req.Header.Add("Content-Type", "application/json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+
+	body = `{"password": "ZkPuY5S0d3Gsu6JoUR3L4WIupWuX1rwvL6kqoTGv9Jo="}`
+	req, _ = http.NewRequest("POST", ts.URL+"/bitwarden/api/sends/access/"+accessID, bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, "send-access", result["Object"])
+	assert.Equal(t, accessID, result["Id"])
+	assert.Equal(t, "me@bitwarden.example.net", result["CreatorIdentifier"])
+	text := result["Text"].(map[string]interface{})
+	assert.Equal(t, "2.T57BwAuV8ubIn/sZPbQC+A==|EhUSSpJWSzSYOdJ/AQzfXuUXxwzcs/6C4tOXqhWAqcM=|OWV2VIqLfoWPs9DiouXGUOtTEkVeklbtJQHkQFIXkC8=", text["Text"])
+
+	// The maximal number of accesses has been reached
+	req, _ = http.NewRequest("POST", ts.URL+"/bitwarden/api/sends/access/"+accessID, bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/bitwarden/api/sends/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), result["AccessCount"])
+
+	req, _ = http.NewRequest("PUT", ts.URL+"/bitwarden/api/sends/"+id+"/remove-password", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Nil(t, result["Password"])
+
+	req, _ = http.NewRequest("GET", ts.URL+"/bitwarden/api/sends", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Len(t, result["Data"], 1)
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/bitwarden/api/sends/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/bitwarden/api/sends/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+}
+
 func TestSetKeyPair(t *testing.T) {
 	body, _ := json.Marshal(map[string]string{
 		"encryptedPrivateKey": "2.demXNYbv8o47sG+fhYYvhg==|jXpxet7AApeIzrC3Yr752LwmjBdCZn6HJl6SjEOVP3rrOpGu5qV2rN0dBH5yXXWHusfxM7IvXkdC/fzBUAmFFOU5ubTp9kHFBqIn51tiJG6BRs5aTm7kF6TYSHVDIP5kUdX4O7DcmD23dqtq/8211DSAFR/DK1QDm5Da77Clh7NHxQE9Z9RTW1PBGV56DfzrY3N06H6vI+V6fTZ6HJRD2pdPczR2ZNC0ziQP7qCUYNlSjEv70O4VoYMSUsdb4UUE1YetcSdZ+dIAy+V2KHfoHmTFYI4DtMCW6WpDzp0ufPvszFjt1EwaMr78hujMrQr1gFWxgN8kOLJyYCrd1F5aIxWXHghBH/t+QU31gyQOxCdj18f10ssfuY/y7vocSJQ9pTRRPNh4beGAijV1AETaXWLK1L6oMnkbdhr9ZA2I6cZaHNCaHIynHQH7NUqKKQUJL/FyZ8rBv4YNnxCMRi9p88IoTb0oPsUCoNCaIZ2cvzXz+0VpU6zxj4ke7H6Bu7H46MSB1P+YHzGLtFNzZJVsUBEkz7dotUDeTeqlYKnq7oldWJ4HlqODevzCev+FRnYgrYpoXmYC/dxa1R5IlKCu6rEmP05A7Nw4h9cymnTwRMEoZRSppJ2O5FlSx/Go9Jz12g2Tfiaf+RvO7nkIb2qKiz7Jo2aJgakL5lMOlEdBA2+dsYSyX4Tvu8Ua4p0GcYaGOgSjXH27lQ73ZpHSicf4Q1kAooVl+6zTOPAqgMXOnyyVSRqBPse28HuDwGtmD8BAeVDIfkMW+a+PlWa+yoEWKfDHRduoxNod7Pc9xlNFt6eOeGoBQTEIiF7ccBDtNiSU1yfvqBZEgI8QF0QiGUo9eP7+59so5eu9/DuzjdqFMmGPtG3zHifMxuMzO5+E9UxTyHuCwvxuH93F4vmPC8zzXXn8/ErhEeqmYl1lxZbfJDm1qcjTkJibNKJ9+CXUeP0hq8yi07SEN1xJSZpupf90EUjrdFd3impz3gZKummEjTvzr3J1JX4gC/wD0mGkROHQwb0jCTDJNC18cX4usPYtNr3FxLZmxCGgPmZhkzFjF0qppN1aXTxQskdorEejQUwLL5EnWJySd9/W2P6PmjkJTAwKYUNHsmVUAfbMA7y7QBIjVFFWS4xYy0GJcc8NaLKkFMkGv/oluw552prWAJZ4aM2asoNgyv/JARrAF+JbOPSpax+CtUMO+LCFlBITHopbkHz0TwI1UMj/vIOh9wxDiMqe3YBiviymudX+B7awCaUPTLubWW1jwC4kBnXmRGAKyyIvzgOvwkdcKfQRxoTxq7JFTL/hWk7x4HlQqviSWGY166CLIp6SydCT+cqHMf3MHhe8AQZVC+nIDVNQZWfpFbOFb3nNDwlT+laWrtsiuX7hHiL0VLaCU4xzup5m4zvi59/Qxj0+d8n6M/3GP3/Tvp/bKY9m7CHoeimtGF9Ai2QFJFMOEQw3S1SUBL62ZsezKgBap6y1RqmMzdz/h3f5mhHxRMoQ0kgzZwMNWJvi2acGoIttcmBU7Cn6fqxYNi11dg17M7cFJAQCMicvd4pEwl8IBrm7uFrzbLvuLeolyiDx8GX3jfIo//Ceqa6P/RIqN8jKzH3nTSePuVqkXYiIdxhlAeF//EYW0CwOjd3GEoc=|aUt6NKqrLW4HeprkbwjuBzSQbR84imTujhUPxK17eX4=",
@@ -0,0 +1,347 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/jslib/blob/master/src/models/request/cipherBulkDeleteRequest.ts
+// https://github.com/bitwarden/jslib/blob/master/src/models/request/cipherBulkMoveRequest.ts
+type bulkRequest struct {
+	IDs      []string `json:"ids"`
+	FolderID string   `json:"folderId"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/src/models/request/cipherWithIdRequest.ts
+type cipherWithIDRequest struct {
+	cipherRequest
+	ID string `json:"id"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/src/models/request/cipherBulkShareRequest.ts
+type bulkShareRequest struct {
+	Ciphers       []cipherWithIDRequest `json:"ciphers"`
+	CollectionIDs []string              `json:"collectionIds"`
+}
+
+// findCiphers returns the ciphers with the given identifiers. The unknown
+// identifiers are ignored.
+func findCiphers(inst *instance.Instance, ids []string) ([]*bitwarden.Cipher, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var ciphers []*bitwarden.Cipher
+	req := &couchdb.AllDocsRequest{Keys: ids}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenCiphers, req, &ciphers); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	found := ciphers[:0]
+	for _, c := range ciphers {
+		if c != nil {
+			found = append(found, c)
+		}
+	}
+	return found, nil
+}
+
+// updateCiphers applies the change function on each cipher, and persists them
+// in a bulk.
+func updateCiphers(inst *instance.Instance, ciphers []*bitwarden.Cipher, change func(c *bitwarden.Cipher)) error {
+	docs := make([]interface{}, len(ciphers))
+	olds := make([]interface{}, len(ciphers))
+	for i, old := range ciphers {
+		cipher := old.Clone().(*bitwarden.Cipher)
+		change(cipher)
+		docs[i] = cipher
+		olds[i] = old
+	}
+	if err := couchdb.BulkUpdateDocs(inst, consts.BitwardenCiphers, docs, olds); err != nil {
+		return err
+	}
+	for i := range ciphers {
+		ciphers[i] = docs[i].(*bitwarden.Cipher)
+	}
+	return nil
+}
+
+// BulkDeleteCiphers is the handler for the route to delete several ciphers.
+// They are not put in the trash, but deleted for good.
+func BulkDeleteCiphers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req bulkRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	ciphers, err := findCiphers(inst, req.IDs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	docs := make([]couchdb.Doc, len(ciphers))
+	for i, cipher := range ciphers {
+		if err := bitwarden.DeleteAttachments(inst, cipher); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": err.Error(),
+			})
+		}
+		docs[i] = cipher
+	}
+	if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, docs); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// BulkSoftDeleteCiphers is the handler for the route to put several ciphers
+// in the trash.
+func BulkSoftDeleteCiphers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req bulkRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	ciphers, err := findCiphers(inst, req.IDs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	err = updateCiphers(inst, ciphers, func(cipher *bitwarden.Cipher) {
+		cipher.SoftDelete()
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ensureTrashTrigger(inst, setting)
+	settings.UpdateRevisionDate(inst, setting)
+	return c.NoContent(http.StatusOK)
+}
+
+// BulkRestoreCiphers is the handler for the route to restore several ciphers
+// from the trash.
+func BulkRestoreCiphers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req bulkRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	ciphers, err := findCiphers(inst, req.IDs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	err = updateCiphers(inst, ciphers, func(cipher *bitwarden.Cipher) {
+		cipher.Restore()
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	settings.UpdateRevisionDate(inst, setting)
+	res := &ciphersList{Object: "list"}
+	for _, cipher := range ciphers {
+		res.Data = append(res.Data, newCipherResponse(inst, cipher, setting))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// MoveCiphers is the handler for the route to move several ciphers to a
+// folder (or out of their folder if the folderId is empty).
+func MoveCiphers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req bulkRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	if req.FolderID != "" {
+		folder := &bitwarden.Folder{}
+		if err := couchdb.GetDoc(inst, consts.BitwardenFolders, req.FolderID, folder); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "folder not found",
+			})
+		}
+	}
+
+	ciphers, err := findCiphers(inst, req.IDs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	err = updateCiphers(inst, ciphers, func(cipher *bitwarden.Cipher) {
+		cipher.FolderID = req.FolderID
+		if cipher.Metadata != nil {
+			cipher.Metadata.ChangeUpdatedAt()
+		}
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// BulkShareCiphers is the handler for the route to share several ciphers with
+// an organization. The fields must be encrypted with the organization key.
+func BulkShareCiphers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req bulkShareRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	ids := make([]string, len(req.Ciphers))
+	for i, r := range req.Ciphers {
+		if r.OrganizationID == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "organizationId not provided",
+			})
+		}
+		ids[i] = r.ID
+	}
+	olds, err := findCiphers(inst, ids)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if len(olds) != len(ids) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+
+	docs := make([]interface{}, len(olds))
+	oldDocs := make([]interface{}, len(olds))
+	for i, old := range olds {
+		cipher, err := req.Ciphers[i].toCipher()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": err.Error(),
+			})
+		}
+		err = bitwarden.SetCollection(inst, setting, cipher, req.Ciphers[i].OrganizationID, req.CollectionIDs)
+		if err != nil {
+			inst.Logger().WithField("nspace", "bitwarden").
+				Infof("Bad collection: %v (%s)", req.CollectionIDs, err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": err.Error(),
+			})
+		}
+		cipher.Attachments = old.Attachments
+		cipher.DeletedDate = old.DeletedDate
+		if old.Metadata != nil {
+			cipher.Metadata = old.Metadata.Clone()
+		}
+		cipher.Metadata.ChangeUpdatedAt()
+		cipher.SetID(old.ID())
+		cipher.SetRev(old.Rev())
+		docs[i] = cipher
+		oldDocs[i] = old
+	}
+	if err := couchdb.BulkUpdateDocs(inst, consts.BitwardenCiphers, docs, oldDocs); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	for _, doc := range docs {
+		cipher := doc.(*bitwarden.Cipher)
+		if err := bitwarden.MoveAttachments(inst, cipher); err != nil {
+			inst.Logger().WithField("nspace", "bitwarden").
+				Warnf("Cannot move the attachments of %s: %s", cipher.ID(), err)
+		}
+	}
+
+	settings.UpdateRevisionDate(inst, setting)
+	return c.NoContent(http.StatusOK)
+}
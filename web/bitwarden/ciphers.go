@@ -9,6 +9,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/bitwarden"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -107,12 +108,13 @@ type cipherResponse struct {
 	OrganizationID *string                `json:"OrganizationId"`
 	CollectionIDs  []string               `json:"CollectionIds"`
 	Fields         interface{}            `json:"Fields"`
-	Attachments    *string                `json:"Attachments"`
+	Attachments    []*attachmentResponse  `json:"Attachments"`
 	Login          *loginResponse         `json:"Login,omitempty"`
 	SecureNote     map[string]interface{} `json:"SecureNote,omitempty"`
 	Card           map[string]interface{} `json:"Card,omitempty"`
 	Identity       map[string]interface{} `json:"Identity,omitempty"`
 	Date           time.Time              `json:"RevisionDate"`
+	DeletedDate    *time.Time             `json:"DeletedDate"`
 	Edit           bool                   `json:"Edit"`
 	UseOTP         bool                   `json:"OrganizationUseTotp"`
 }
@@ -129,7 +131,7 @@ func titleizeKeys(data bitwarden.MapData) map[string]interface{} {
 	return res
 }
 
-func newCipherResponse(c *bitwarden.Cipher, setting *settings.Settings) *cipherResponse {
+func newCipherResponse(inst *instance.Instance, c *bitwarden.Cipher, setting *settings.Settings) *cipherResponse {
 	r := cipherResponse{
 		Object:   "cipher",
 		ID:       c.CouchID,
@@ -148,9 +150,18 @@ func newCipherResponse(c *bitwarden.Cipher, setting *settings.Settings) *cipherR
 	if c.Metadata != nil {
 		r.Date = c.Metadata.UpdatedAt.UTC()
 	}
+	if c.DeletedDate != nil {
+		deleted := c.DeletedDate.UTC()
+		r.DeletedDate = &deleted
+	}
 	if c.SharedWithCozy {
 		r.OrganizationID = &setting.OrganizationID
 		r.CollectionIDs = append(r.CollectionIDs, setting.CollectionID)
+	} else if c.OrganizationID != "" {
+		r.OrganizationID = &c.OrganizationID
+		if c.CollectionID != "" {
+			r.CollectionIDs = append(r.CollectionIDs, c.CollectionID)
+		}
 	}
 
 	if len(c.Fields) > 0 {
@@ -165,6 +176,11 @@ func newCipherResponse(c *bitwarden.Cipher, setting *settings.Settings) *cipherR
 		r.Fields = fields
 	}
 
+	for i := range c.Attachments {
+		att := newAttachmentResponse(inst, c, &c.Attachments[i])
+		r.Attachments = append(r.Attachments, att)
+	}
+
 	switch c.Type {
 	case bitwarden.LoginType:
 		if c.Login != nil {
@@ -237,7 +253,7 @@ func ListCiphers(c echo.Context) error {
 
 	res := &ciphersList{Object: "list"}
 	for _, f := range ciphers {
-		res.Data = append(res.Data, newCipherResponse(f, setting))
+		res.Data = append(res.Data, newCipherResponse(inst, f, setting))
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -288,7 +304,7 @@ func CreateCipher(c echo.Context) error {
 	}
 
 	settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -333,23 +349,13 @@ func CreateSharedCipher(c echo.Context) error {
 			"error": err.Error(),
 		})
 	}
-	if len(req.CollectionIDs) != 1 {
+	if err := bitwarden.SetCollection(inst, setting, cipher, req.Cipher.OrganizationID, req.CollectionIDs); err != nil {
 		inst.Logger().WithField("nspace", "bitwarden").
-			Infof("Bad collection: %v", req.CollectionIDs)
+			Infof("Bad collection: %v (%s)", req.CollectionIDs, err)
 		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "generic collectionIds is not supported",
+			"error": err.Error(),
 		})
 	}
-	for _, id := range req.CollectionIDs {
-		if id != setting.CollectionID {
-			inst.Logger().WithField("nspace", "bitwarden").
-				Infof("Bad collection: %s vs %s", id, setting.CollectionID)
-			return c.JSON(http.StatusBadRequest, echo.Map{
-				"error": "generic collectionIds is not supported",
-			})
-		}
-		cipher.SharedWithCozy = true
-	}
 
 	if err := couchdb.CreateDoc(inst, cipher); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
@@ -358,7 +364,7 @@ func CreateSharedCipher(c echo.Context) error {
 	}
 
 	settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -397,7 +403,7 @@ func GetCipher(c echo.Context) error {
 		})
 	}
 
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -456,7 +462,13 @@ func UpdateCipher(c echo.Context) error {
 	if req.OrganizationID != "" && old.SharedWithCozy {
 		cipher.SharedWithCozy = true
 	}
+	if req.OrganizationID != "" && req.OrganizationID == old.OrganizationID {
+		cipher.OrganizationID = old.OrganizationID
+		cipher.CollectionID = old.CollectionID
+	}
 
+	cipher.Attachments = old.Attachments
+	cipher.DeletedDate = old.DeletedDate
 	if old.Metadata != nil {
 		cipher.Metadata = old.Metadata.Clone()
 	}
@@ -477,7 +489,7 @@ func UpdateCipher(c echo.Context) error {
 	}
 
 	settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -509,6 +521,11 @@ func DeleteCipher(c echo.Context) error {
 		})
 	}
 
+	if err := bitwarden.DeleteAttachments(inst, cipher); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
 	if err := couchdb.DeleteDoc(inst, cipher); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
 			"error": err.Error(),
@@ -519,6 +536,112 @@ func DeleteCipher(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+// SoftDeleteCipher is the handler for the route to put a cipher in the trash.
+func SoftDeleteCipher(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+
+	old := &bitwarden.Cipher{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenCiphers, id, old); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	cipher := old.Clone().(*bitwarden.Cipher)
+	cipher.SoftDelete()
+	if err := couchdb.UpdateDocWithOld(inst, cipher, old); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ensureTrashTrigger(inst, setting)
+	settings.UpdateRevisionDate(inst, setting)
+	return c.NoContent(http.StatusOK)
+}
+
+// RestoreCipher is the handler for the route to restore a cipher from the
+// trash.
+func RestoreCipher(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+
+	old := &bitwarden.Cipher{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenCiphers, id, old); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	cipher := old.Clone().(*bitwarden.Cipher)
+	cipher.Restore()
+	if err := couchdb.UpdateDocWithOld(inst, cipher, old); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	settings.UpdateRevisionDate(inst, setting)
+	res := newCipherResponse(inst, cipher, setting)
+	return c.JSON(http.StatusOK, res)
+}
+
+// ensureTrashTrigger adds the trigger that purges the trash if needed. An
+// error is only logged, as the ciphers can still be purged later.
+func ensureTrashTrigger(inst *instance.Instance, setting *settings.Settings) {
+	if err := bitwarden.EnsureTrashTrigger(inst, setting); err != nil {
+		inst.Logger().WithField("nspace", "bitwarden").
+			Warnf("Cannot add the trigger for purging the trash: %s", err)
+	}
+}
+
 type shareCipherRequest struct {
 	Cipher        cipherRequest `json:"cipher"`
 	CollectionIDs []string      `json:"collectionIds"`
@@ -583,24 +706,16 @@ func ShareCipher(c echo.Context) error {
 		})
 	}
 
-	if len(req.CollectionIDs) != 1 {
+	if err := bitwarden.SetCollection(inst, setting, cipher, req.Cipher.OrganizationID, req.CollectionIDs); err != nil {
 		inst.Logger().WithField("nspace", "bitwarden").
-			Infof("Bad collection: %v", req.CollectionIDs)
+			Infof("Bad collection: %v (%s)", req.CollectionIDs, err)
 		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "generic collectionIds is not supported",
+			"error": err.Error(),
 		})
 	}
-	for _, id := range req.CollectionIDs {
-		if id != setting.CollectionID {
-			inst.Logger().WithField("nspace", "bitwarden").
-				Infof("Bad collection: %s vs %s", id, setting.CollectionID)
-			return c.JSON(http.StatusBadRequest, echo.Map{
-				"error": "generic collectionIds is not supported",
-			})
-		}
-		cipher.SharedWithCozy = true
-	}
 
+	cipher.Attachments = old.Attachments
+	cipher.DeletedDate = old.DeletedDate
 	if old.Metadata != nil {
 		cipher.Metadata = old.Metadata.Clone()
 	}
@@ -612,8 +727,12 @@ func ShareCipher(c echo.Context) error {
 			"error": err.Error(),
 		})
 	}
+	if err := bitwarden.MoveAttachments(inst, cipher); err != nil {
+		inst.Logger().WithField("nspace", "bitwarden").
+			Warnf("Cannot move the attachments of %s: %s", cipher.ID(), err)
+	}
 
 	settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
@@ -158,6 +158,16 @@ func readPump(notifier *wsNotifier) {
 			Infof("Subscribe error: %s", err)
 		return
 	}
+	if err := ds.Subscribe(consts.BitwardenSends); err != nil {
+		logger.WithDomain(ds.DomainName()).WithField("nspace", "bitwarden").
+			Infof("Subscribe error: %s", err)
+		return
+	}
+	if err := ds.Subscribe(consts.BitwardenProfiles); err != nil {
+		logger.WithDomain(ds.DomainName()).WithField("nspace", "bitwarden").
+			Infof("Subscribe error: %s", err)
+		return
+	}
 	notifier.Responses <- initialResponse
 
 	// Just send back the pings from the client
@@ -239,13 +249,16 @@ const (
 	// hubLoginDelete  = 2
 	hubFolderDelete = 3
 	// hubCiphers      = 4
-	// hubVault        = 5
+	hubVault = 5
 	// hubOrgKeys      = 6
 	hubFolderCreate = 7
 	hubFolderUpdate = 8
 	hubCipherDelete = 9
 	// hubSettings     = 10
 	// hubLogOut       = 11
+	hubSendCreate = 12
+	hubSendUpdate = 13
+	hubSendDelete = 14
 )
 
 func buildNotification(e *realtime.Event, userID string, setting *settings.Settings) *notification {
@@ -276,6 +289,25 @@ func buildNotification(e *realtime.Event, userID string, setting *settings.Setti
 		case realtime.EventDelete:
 			t = hubCipherDelete
 		}
+	} else if doctype == consts.BitwardenSends {
+		// The payload for a send has the same fields as for a folder
+		payload = buildFolderPayload(e, userID)
+		switch e.Verb {
+		case realtime.EventCreate:
+			t = hubSendCreate
+		case realtime.EventUpdate:
+			t = hubSendUpdate
+		case realtime.EventDelete:
+			t = hubSendDelete
+		}
+	} else if doctype == consts.BitwardenProfiles {
+		// This event is sent after an import, to ask the clients to do a
+		// full sync.
+		payload = map[string]interface{}{
+			"UserId": userID,
+			"Date":   time.Now(),
+		}
+		t = hubVault
 	}
 	if t < 0 {
 		return nil
@@ -309,6 +341,10 @@ func buildFolderPayload(e *realtime.Event, userID string) map[string]interface{}
 		if doc.Metadata != nil {
 			updatedAt = doc.Metadata.UpdatedAt
 		}
+	} else if doc, ok := e.Doc.(*bitwarden.Send); ok {
+		if doc.Metadata != nil {
+			updatedAt = doc.Metadata.UpdatedAt
+		}
 	}
 	if date != "" {
 		if t, err := time.Parse(time.RFC3339, date); err == nil {
@@ -327,18 +363,25 @@ func buildFolderPayload(e *realtime.Event, userID string) map[string]interface{}
 
 func buildCipherPayload(e *realtime.Event, userID string, setting *settings.Settings) map[string]interface{} {
 	var sharedWithCozy bool
+	var organizationID, collectionID string
 	var updatedAt interface{}
 	var date string
 	if doc, ok := e.Doc.(*couchdb.JSONDoc); ok {
 		sharedWithCozy, _ = doc.M["sharedWithCozy"].(bool)
+		organizationID, _ = doc.M["organization_id"].(string)
+		collectionID, _ = doc.M["collection_id"].(string)
 		meta, _ := doc.M["cozyMetadata"].(map[string]interface{})
 		date, _ = meta["updatedAt"].(string)
 	} else if doc, ok := e.Doc.(*realtime.JSONDoc); ok {
 		sharedWithCozy, _ = doc.M["sharedWithCozy"].(bool)
+		organizationID, _ = doc.M["organization_id"].(string)
+		collectionID, _ = doc.M["collection_id"].(string)
 		meta, _ := doc.M["cozyMetadata"].(map[string]interface{})
 		date, _ = meta["updatedAt"].(string)
 	} else if doc, ok := e.Doc.(*bitwarden.Cipher); ok {
 		sharedWithCozy = doc.SharedWithCozy
+		organizationID = doc.OrganizationID
+		collectionID = doc.CollectionID
 		if doc.Metadata != nil {
 			updatedAt = doc.Metadata.UpdatedAt
 		}
@@ -355,6 +398,9 @@ func buildCipherPayload(e *realtime.Event, userID string, setting *settings.Sett
 	if sharedWithCozy {
 		orgID = setting.OrganizationID
 		collIDs = []string{setting.CollectionID}
+	} else if organizationID != "" {
+		orgID = organizationID
+		collIDs = []string{collectionID}
 	}
 	return map[string]interface{}{
 		"Id":             e.Doc.ID(),
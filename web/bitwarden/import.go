@@ -0,0 +1,104 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/jslib/blob/master/src/models/request/kvpRequest.ts
+type kvpRequest struct {
+	Key   int `json:"key"`
+	Value int `json:"value"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/src/models/request/importCiphersRequest.ts
+type importRequest struct {
+	Ciphers             []cipherRequest `json:"ciphers"`
+	Folders             []folderRequest `json:"folders"`
+	FolderRelationships []kvpRequest    `json:"folderRelationships"`
+}
+
+// ImportCiphers is the handler for the route used to import a vault. The
+// client parses the export of the other password manager (Bitwarden JSON,
+// 1Password, LastPass, KeePass, etc.), encrypts the ciphers and the folders,
+// and sends them in a single request. The folder relationships associate the
+// index of a cipher (key) to the index of a folder (value).
+func ImportCiphers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenFolders); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req importRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	folders := make([]*bitwarden.Folder, len(req.Folders))
+	for i := range req.Folders {
+		if req.Folders[i].Name == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": fmt.Sprintf("folder %d: missing name", i),
+			})
+		}
+		folder := req.Folders[i].toFolder()
+		id, err := couchdb.UUID(inst)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": err.Error(),
+			})
+		}
+		folder.SetID(id)
+		folders[i] = folder
+	}
+
+	ciphers := make([]*bitwarden.Cipher, len(req.Ciphers))
+	for i := range req.Ciphers {
+		cipher, err := req.Ciphers[i].toCipher()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": fmt.Sprintf("cipher %d: %s", i, err),
+			})
+		}
+		// The folders of the vault are given by the folder relationships
+		cipher.FolderID = ""
+		ciphers[i] = cipher
+	}
+
+	for _, kvp := range req.FolderRelationships {
+		if kvp.Key < 0 || kvp.Key >= len(ciphers) ||
+			kvp.Value < 0 || kvp.Value >= len(folders) {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "invalid folder relationship",
+			})
+		}
+		ciphers[kvp.Key].FolderID = folders[kvp.Value].ID()
+	}
+
+	if err := bitwarden.ImportVault(inst, folders, ciphers); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
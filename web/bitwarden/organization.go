@@ -1,12 +1,18 @@
 package bitwarden
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http"
 
+	"github.com/cozy/cozy-stack/model/bitwarden"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
 )
 
 // https://github.com/bitwarden/jslib/blob/master/src/models/response/profileOrganizationResponse.ts
@@ -101,3 +107,577 @@ func getCozyCollectionResponse(setting *settings.Settings) (*collectionResponse,
 		Object:         "collection",
 	}, nil
 }
+
+// maxCollections is the maximal number of collections announced to the
+// clients for the organizations created by the users.
+const maxCollections = 100
+
+func newOrganizationResponse(inst *instance.Instance, org *bitwarden.Organization, self *bitwarden.OrgMember) *organizationResponse {
+	var email string
+	for _, m := range org.Members {
+		if m.Type == bitwarden.OrgMemberOwner {
+			email = m.Email
+			break
+		}
+	}
+	return &organizationResponse{
+		ID:             org.CouchID,
+		Name:           org.Name,
+		Key:            self.Key,
+		Email:          email,
+		Plan:           "TeamsAnnually",
+		PlanType:       5, // TeamsAnnually plan
+		Seats:          len(org.Members),
+		MaxCollections: maxCollections,
+		MaxStorage:     1,
+		SelfHost:       true,
+		Use2fa:         true,
+		UseDirectory:   false,
+		UseEvents:      false,
+		UseGroups:      false,
+		UseTotp:        true,
+		Premium:        true,
+		Enabled:        true,
+		Status:         int(self.Status),
+		Type:           int(self.Type),
+		Object:         "profileOrganization",
+	}
+}
+
+func newCollectionResponse(org *bitwarden.Organization, coll *bitwarden.Collection) *collectionResponse {
+	return &collectionResponse{
+		ID:             coll.ID,
+		OrganizationID: org.CouchID,
+		Name:           coll.Name,
+		Object:         "collection",
+	}
+}
+
+// https://github.com/bitwarden/jslib/blob/master/src/models/response/organizationUserResponse.ts
+type orgUserResponse struct {
+	ID        string  `json:"Id"`
+	UserID    *string `json:"UserId"`
+	Name      string  `json:"Name"`
+	Email     string  `json:"Email"`
+	Type      int     `json:"Type"`
+	Status    int     `json:"Status"`
+	AccessAll bool    `json:"AccessAll"`
+	Object    string  `json:"Object"`
+}
+
+func newOrgUserResponse(m *bitwarden.OrgMember) *orgUserResponse {
+	r := orgUserResponse{
+		ID:        m.ID,
+		Name:      m.Name,
+		Email:     m.Email,
+		Type:      int(m.Type),
+		Status:    int(m.Status),
+		AccessAll: true,
+		Object:    "organizationUserUserDetails",
+	}
+	if m.UserID != "" {
+		r.UserID = &m.UserID
+	}
+	return &r
+}
+
+func orgErrorResponse(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch err {
+	case bitwarden.ErrOrganizationNotFound, bitwarden.ErrOrgMemberNotFound,
+		bitwarden.ErrCollectionNotFound:
+		status = http.StatusNotFound
+	case bitwarden.ErrNotOrganizationAdmin, bitwarden.ErrNotOrganizationSharer:
+		status = http.StatusForbidden
+	case bitwarden.ErrOrgMemberNotAccepted:
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{
+		"error": err.Error(),
+	})
+}
+
+// findManagedOrganization returns the organization from the id parameter of
+// the URL, if the user is an owner or an admin of this organization.
+func findManagedOrganization(c echo.Context) (*bitwarden.Organization, error) {
+	inst := middlewares.GetInstance(c)
+	org, err := bitwarden.FindOrganization(inst, c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+	if !org.CanManage(inst) {
+		return nil, bitwarden.ErrNotOrganizationAdmin
+	}
+	return org, nil
+}
+
+// CreateOrganization is the route used to create an organization, with a
+// first collection. The key is the organization key encrypted with the
+// public key of the user.
+func CreateOrganization(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req struct {
+		Name           string `json:"name"`
+		Key            string `json:"key"`
+		CollectionName string `json:"collectionName"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Name == "" || req.Key == "" || req.CollectionName == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "name, key and collectionName are mandatory",
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if setting.PublicKey == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "no public key",
+		})
+	}
+
+	org, err := bitwarden.CreateOrganization(inst, setting, req.Name, req.Key, req.CollectionName)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, setting)
+	res := newOrganizationResponse(inst, org, org.Self(inst))
+	res.Object = "organization"
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetOrganization returns information about an organization.
+func GetOrganization(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := bitwarden.FindOrganization(inst, c.Param("id"))
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	res := newOrganizationResponse(inst, org, org.Self(inst))
+	res.Object = "organization"
+	return c.JSON(http.StatusOK, res)
+}
+
+// DeleteOrganization is the route used by an owner to delete an
+// organization, with its ciphers.
+func DeleteOrganization(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := bitwarden.FindOrganization(inst, c.Param("id"))
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if self := org.Self(inst); self.Type != bitwarden.OrgMemberOwner {
+		return orgErrorResponse(c, bitwarden.ErrNotOrganizationAdmin)
+	}
+	if err := bitwarden.DeleteOrganization(inst, org); err != nil {
+		return orgErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// LeaveOrganization is the route used by a member to leave an organization.
+// The sharing of the organization is revoked on the Cozy of this member.
+func LeaveOrganization(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := bitwarden.FindOrganization(inst, c.Param("id"))
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	self := org.Self(inst)
+	if self.Type == bitwarden.OrgMemberOwner {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "the owner cannot leave the organization",
+		})
+	}
+	if err := org.RemoveMember(self.ID); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.Save(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.LeaveSharing(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// ListOrganizationUsers returns the members of an organization.
+func ListOrganizationUsers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := bitwarden.FindOrganization(inst, c.Param("id"))
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	users := make([]*orgUserResponse, len(org.Members))
+	for i := range org.Members {
+		users[i] = newOrgUserResponse(&org.Members[i])
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"Data":   users,
+		"Object": "list",
+	})
+}
+
+// InviteOrganizationUsers is the route used to invite some users in an
+// organization by their email addresses. The organization is shared with
+// their Cozy instances via a Cozy sharing: when the Cozy of an invited member
+// receives the organization, the invitation is accepted with the public key
+// of this member.
+func InviteOrganizationUsers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req struct {
+		Emails []string                `json:"emails"`
+		Type   bitwarden.OrgMemberType `json:"type"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	org, err := findManagedOrganization(c)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	for _, email := range req.Emails {
+		if _, err := org.Invite(inst, email, req.Type); err != nil {
+			return orgErrorResponse(c, err)
+		}
+	}
+	if err := org.ShareWithMembers(inst, req.Emails); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.Save(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ConfirmOrganizationUser is the route used to send the organization key,
+// encrypted with the public key of a member who has accepted the invitation.
+func ConfirmOrganizationUser(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.Key == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	org, err := findManagedOrganization(c)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.Confirm(c.Param("userID"), req.Key); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.Save(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// RemoveOrganizationUser is the route used to remove a member from an
+// organization. The access of the member to the sharing of the organization
+// is revoked.
+func RemoveOrganizationUser(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := findManagedOrganization(c)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	m, err := org.FindMember(c.Param("userID"))
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if m.Type == bitwarden.OrgMemberOwner {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "the owner cannot be removed",
+		})
+	}
+	if err := org.RevokeMemberSharing(inst, m); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.RemoveMember(m.ID); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.Save(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ListOrganizationCollections returns the collections of an organization.
+func ListOrganizationCollections(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := bitwarden.FindOrganization(inst, c.Param("id"))
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	colls := make([]*collectionResponse, len(org.Collections))
+	for i := range org.Collections {
+		colls[i] = newCollectionResponse(org, &org.Collections[i])
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"Data":   colls,
+		"Object": "list",
+	})
+}
+
+// CreateCollection is the route used to add a collection to an organization.
+// The name must be encrypted with the organization key.
+func CreateCollection(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.Name == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	org, err := findManagedOrganization(c)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	coll, err := org.AddCollection(inst, req.Name)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.Save(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.JSON(http.StatusOK, newCollectionResponse(org, coll))
+}
+
+// UpdateCollection is the route used to rename a collection.
+func UpdateCollection(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.Name == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+
+	org, err := findManagedOrganization(c)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	coll, err := org.FindCollection(c.Param("collectionID"))
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	coll.Name = req.Name
+	if err := org.Save(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.JSON(http.StatusOK, newCollectionResponse(org, coll))
+}
+
+// DeleteCollection is the route used to remove a collection from an
+// organization.
+func DeleteCollection(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := findManagedOrganization(c)
+	if err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.RemoveCollection(inst, c.Param("collectionID")); err != nil {
+		return orgErrorResponse(c, err)
+	}
+	if err := org.Save(inst); err != nil {
+		return orgErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// ListCollections returns the collections that the user can use, in all the
+// organizations.
+func ListCollections(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	orgs, err := bitwarden.ListOrganizations(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"Data":   listCollections(inst, setting, orgs),
+		"Object": "list",
+	})
+}
+
+// listCollections returns the collection of the Cozy organization, and the
+// collections of the organizations where the user is a confirmed member.
+func listCollections(inst *instance.Instance, setting *settings.Settings, orgs []*bitwarden.Organization) []*collectionResponse {
+	var colls []*collectionResponse
+	if coll, err := getCozyCollectionResponse(setting); err == nil {
+		colls = append(colls, coll)
+	}
+	for _, org := range orgs {
+		if !org.IsConfirmed(inst) {
+			continue
+		}
+		for i := range org.Collections {
+			colls = append(colls, newCollectionResponse(org, &org.Collections[i]))
+		}
+	}
+	return colls
+}
+
+// GetPublicKey returns the public key of a user. It is used by an admin of an
+// organization to encrypt the organization key for a member.
+func GetPublicKey(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	userID := c.Param("id")
+	var publicKey string
+	if userID == inst.ID() {
+		setting, err := settings.Get(inst)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": err.Error(),
+			})
+		}
+		publicKey = setting.PublicKey
+	} else {
+		orgs, err := bitwarden.ListOrganizations(inst)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": err.Error(),
+			})
+		}
+		for _, org := range orgs {
+			for _, m := range org.Members {
+				if m.UserID == userID && m.PublicKey != "" {
+					publicKey = m.PublicKey
+				}
+			}
+		}
+	}
+	if publicKey == "" {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"UserId":    userID,
+		"PublicKey": publicKey,
+		"Object":    "userKey",
+	})
+}
@@ -0,0 +1,615 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/request/sendRequest.ts
+type sendRequest struct {
+	Type           bitwarden.SendType  `json:"type"`
+	FileLength     *int64              `json:"fileLength"`
+	Name           string              `json:"name"`
+	Notes          string              `json:"notes"`
+	Key            string              `json:"key"`
+	MaxAccessCount *int                `json:"maxAccessCount"`
+	ExpirationDate *time.Time          `json:"expirationDate"`
+	DeletionDate   *time.Time          `json:"deletionDate"`
+	Text           *bitwarden.SendText `json:"text"`
+	Password       string              `json:"password"`
+	Disabled       bool                `json:"disabled"`
+	HideEmail      bool                `json:"hideEmail"`
+}
+
+func (r *sendRequest) validate() error {
+	if r.Name == "" || r.Key == "" {
+		return errors.New("name and key are mandatory")
+	}
+	switch r.Type {
+	case bitwarden.SendTextType:
+		if r.Text == nil {
+			return errors.New("text is mandatory")
+		}
+	case bitwarden.SendFileType:
+	default:
+		return errors.New("type has an unknown value")
+	}
+	now := time.Now()
+	if r.DeletionDate == nil || r.DeletionDate.Before(now) {
+		return errors.New("deletionDate must be in the future")
+	}
+	if r.DeletionDate.After(now.Add(bitwarden.MaxSendLifetime)) {
+		return errors.New("deletionDate must be less than 31 days from now")
+	}
+	if r.ExpirationDate != nil && r.ExpirationDate.After(*r.DeletionDate) {
+		return errors.New("expirationDate must be before deletionDate")
+	}
+	if r.MaxAccessCount != nil && *r.MaxAccessCount < 0 {
+		return errors.New("maxAccessCount must be positive")
+	}
+	return nil
+}
+
+// applyTo copies the fields of the request to the send. The type, the file
+// and the password of the send are not changed by this method.
+func (r *sendRequest) applyTo(s *bitwarden.Send) {
+	s.Name = r.Name
+	s.Notes = r.Notes
+	s.Key = r.Key
+	s.MaxAccessCount = r.MaxAccessCount
+	s.ExpirationDate = r.ExpirationDate
+	s.DeletionDate = *r.DeletionDate
+	s.Disabled = r.Disabled
+	s.HideEmail = r.HideEmail
+	if s.Type == bitwarden.SendTextType {
+		s.Text = r.Text
+	}
+}
+
+func (r *sendRequest) toSend() (*bitwarden.Send, error) {
+	s := bitwarden.Send{Type: r.Type}
+	r.applyTo(&s)
+	if err := s.SetPassword(r.Password); err != nil {
+		return nil, err
+	}
+	md := metadata.New()
+	md.DocTypeVersion = bitwarden.DocTypeVersion
+	s.Metadata = md
+	return &s, nil
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendTextResponse.ts
+type sendTextResponse struct {
+	Text   string `json:"Text"`
+	Hidden bool   `json:"Hidden"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendFileResponse.ts
+type sendFileResponse struct {
+	ID       string `json:"Id"`
+	FileName string `json:"FileName"`
+	Size     string `json:"Size"`
+	SizeName string `json:"SizeName"`
+}
+
+func newSendTextResponse(s *bitwarden.Send) *sendTextResponse {
+	if s.Text == nil {
+		return nil
+	}
+	return &sendTextResponse{
+		Text:   s.Text.Text,
+		Hidden: s.Text.Hidden,
+	}
+}
+
+func newSendFileResponse(s *bitwarden.Send) *sendFileResponse {
+	if s.File == nil {
+		return nil
+	}
+	return &sendFileResponse{
+		ID:       s.File.ID,
+		FileName: s.File.FileName,
+		Size:     strconv.FormatInt(s.File.Size, 10),
+		SizeName: humanize.Bytes(uint64(s.File.Size)),
+	}
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendResponse.ts
+type sendResponse struct {
+	ID             string            `json:"Id"`
+	AccessID       string            `json:"AccessId"`
+	Type           int               `json:"Type"`
+	Name           string            `json:"Name"`
+	Notes          *string           `json:"Notes"`
+	File           *sendFileResponse `json:"File"`
+	Text           *sendTextResponse `json:"Text"`
+	Key            string            `json:"Key"`
+	MaxAccessCount *int              `json:"MaxAccessCount"`
+	AccessCount    int               `json:"AccessCount"`
+	Password       *string           `json:"Password"`
+	Disabled       bool              `json:"Disabled"`
+	RevisionDate   time.Time         `json:"RevisionDate"`
+	ExpirationDate *time.Time        `json:"ExpirationDate"`
+	DeletionDate   time.Time         `json:"DeletionDate"`
+	HideEmail      bool              `json:"HideEmail"`
+	Object         string            `json:"Object"`
+}
+
+func newSendResponse(s *bitwarden.Send) *sendResponse {
+	r := sendResponse{
+		ID:             s.CouchID,
+		AccessID:       s.AccessID(),
+		Type:           int(s.Type),
+		Name:           s.Name,
+		File:           newSendFileResponse(s),
+		Text:           newSendTextResponse(s),
+		Key:            s.Key,
+		MaxAccessCount: s.MaxAccessCount,
+		AccessCount:    s.AccessCount,
+		Disabled:       s.Disabled,
+		ExpirationDate: s.ExpirationDate,
+		DeletionDate:   s.DeletionDate,
+		HideEmail:      s.HideEmail,
+		Object:         "send",
+	}
+	if s.Notes != "" {
+		r.Notes = &s.Notes
+	}
+	if s.Password != "" {
+		r.Password = &s.Password
+	}
+	if md := s.Metadata; md != nil {
+		r.RevisionDate = md.UpdatedAt.UTC()
+	}
+	return &r
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendAccessResponse.ts
+type sendAccessResponse struct {
+	ID                string            `json:"Id"`
+	Type              int               `json:"Type"`
+	Name              string            `json:"Name"`
+	File              *sendFileResponse `json:"File"`
+	Text              *sendTextResponse `json:"Text"`
+	ExpirationDate    *time.Time        `json:"ExpirationDate"`
+	CreatorIdentifier *string           `json:"CreatorIdentifier"`
+	Object            string            `json:"Object"`
+}
+
+func newSendAccessResponse(inst *instance.Instance, s *bitwarden.Send) *sendAccessResponse {
+	r := sendAccessResponse{
+		ID:             s.AccessID(),
+		Type:           int(s.Type),
+		Name:           s.Name,
+		File:           newSendFileResponse(s),
+		Text:           newSendTextResponse(s),
+		ExpirationDate: s.ExpirationDate,
+		Object:         "send-access",
+	}
+	if !s.HideEmail {
+		email := string(inst.PassphraseSalt())
+		r.CreatorIdentifier = &email
+	}
+	return &r
+}
+
+type sendsList struct {
+	Data   []*sendResponse `json:"Data"`
+	Object string          `json:"Object"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/request/sendAccessRequest.ts
+type sendAccessRequest struct {
+	Password string `json:"password"`
+}
+
+func sendErrorResponse(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch err {
+	case bitwarden.ErrSendNotFound:
+		status = http.StatusNotFound
+	case bitwarden.ErrSendPasswordRequired:
+		status = http.StatusUnauthorized
+	case bitwarden.ErrSendInvalidPassword:
+		status = http.StatusBadRequest
+	case vfs.ErrFileTooBig:
+		status = http.StatusRequestEntityTooLarge
+	case limits.ErrRateLimitReached, limits.ErrRateLimitExceeded:
+		status = http.StatusTooManyRequests
+	}
+	return c.JSON(status, echo.Map{
+		"error": err.Error(),
+	})
+}
+
+// checkSendRateLimit returns an error if the send has been accessed too many
+// times recently, to slow down the brute-force attacks on its password.
+func checkSendRateLimit(inst *instance.Instance, sendID string) error {
+	err := limits.CheckRateLimitKey(inst.Domain+"/"+sendID, limits.BitwardenSendAccessType)
+	if limits.IsLimitReachedOrExceeded(err) {
+		return err
+	}
+	return nil
+}
+
+func ensureSendsTrigger(inst *instance.Instance, setting *settings.Settings) {
+	if err := bitwarden.EnsureSendsTrigger(inst, setting); err != nil {
+		inst.Logger().WithField("nspace", "bitwarden").
+			Warnf("Cannot add the trigger for deleting the sends: %s", err)
+	}
+}
+
+// createSend persists a new send, and returns the response for it. The file
+// of the send is destroyed if the send can't be persisted.
+func createSend(c echo.Context, inst *instance.Instance, send *bitwarden.Send) error {
+	if err := couchdb.CreateDoc(inst, send); err != nil {
+		if errd := bitwarden.DestroySendFile(inst, send); errd != nil {
+			inst.Logger().WithField("nspace", "bitwarden").
+				Warnf("Cannot destroy the file of a send: %s", errd)
+		}
+		return sendErrorResponse(c, err)
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	ensureSendsTrigger(inst, setting)
+	settings.UpdateRevisionDate(inst, setting)
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// ListSends is the route to get the list of the sends of the user.
+func ListSends(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	sends, err := listSends(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	res := &sendsList{Object: "list"}
+	for _, s := range sends {
+		res.Data = append(res.Data, newSendResponse(s))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func listSends(inst *instance.Instance) ([]*bitwarden.Send, error) {
+	var sends []*bitwarden.Send
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenSends, req, &sends); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return sends, nil
+}
+
+// CreateSend is the route to create a text send. The text, the name and the
+// notes are encrypted with the key of the send, and this key is encrypted
+// with the key of the user.
+func CreateSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Type != bitwarden.SendTextType {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "file sends must be created with /bitwarden/api/sends/file",
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	send, err := req.toSend()
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	return createSend(c, inst, send)
+}
+
+// CreateFileSend is the route to create a file send. The body is a multipart
+// form, with the send request in the model field, and the encrypted content
+// of the file in the data field (the filename of this part is the encrypted
+// name of the file).
+func CreateFileSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req sendRequest
+	if err := json.Unmarshal([]byte(c.FormValue("model")), &req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Type != bitwarden.SendFileType {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "text sends must be created with /bitwarden/api/sends",
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	header, err := c.FormFile("data")
+	if err != nil || header.Filename == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid multipart form",
+		})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	defer file.Close()
+
+	send, err := req.toSend()
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	size := header.Size
+	if req.FileLength != nil {
+		size = *req.FileLength
+	}
+	if err := bitwarden.AddSendFile(inst, send, header.Filename, size, file); err != nil {
+		return sendErrorResponse(c, err)
+	}
+	return createSend(c, inst, send)
+}
+
+// GetSend returns information about a send.
+func GetSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := bitwarden.FindSend(inst, c.Param("id"))
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// UpdateSend is the route to change a send. Its type and its file can't be
+// changed, and its password is changed only if a new one is given.
+func UpdateSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := bitwarden.FindSend(inst, c.Param("id"))
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Type != send.Type {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "the type of a send can't be changed",
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	req.applyTo(send)
+	if req.Password != "" {
+		if err := send.SetPassword(req.Password); err != nil {
+			return sendErrorResponse(c, err)
+		}
+	}
+	if send.Metadata != nil {
+		send.Metadata.ChangeUpdatedAt()
+	}
+	if err := couchdb.UpdateDoc(inst, send); err != nil {
+		return sendErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// RemoveSendPassword is the route to remove the password that protects a
+// send.
+func RemoveSendPassword(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := bitwarden.FindSend(inst, c.Param("id"))
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	_ = send.SetPassword("")
+	if send.Metadata != nil {
+		send.Metadata.ChangeUpdatedAt()
+	}
+	if err := couchdb.UpdateDoc(inst, send); err != nil {
+		return sendErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// DeleteSend is the route to delete a send, with its file.
+func DeleteSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := bitwarden.FindSend(inst, c.Param("id"))
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	if err := bitwarden.DeleteSend(inst, send); err != nil {
+		return sendErrorResponse(c, err)
+	}
+
+	settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// AccessSend is the public route used by the recipients of a send to get it.
+// The access counter is incremented for a text send. For a file send, it is
+// incremented when the file is downloaded.
+func AccessSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	id := bitwarden.SendIDFromAccessID(c.Param("accessID"))
+	send, err := bitwarden.FindSend(inst, id)
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	if err := checkSendRateLimit(inst, send.CouchID); err != nil {
+		return sendErrorResponse(c, err)
+	}
+
+	var req sendAccessRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil && err != io.EOF {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if send.Type == bitwarden.SendFileType {
+		err = send.CheckAccess(req.Password)
+	} else {
+		err = send.Access(inst, req.Password)
+	}
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, newSendAccessResponse(inst, send))
+}
+
+// AccessSendFile is the public route used by the recipients of a file send to
+// get the URL for downloading the encrypted content of the file.
+func AccessSendFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	id := bitwarden.SendIDFromAccessID(c.Param("id"))
+	send, err := bitwarden.FindSend(inst, id)
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	if err := checkSendRateLimit(inst, send.CouchID); err != nil {
+		return sendErrorResponse(c, err)
+	}
+	if send.File == nil || send.File.ID != c.Param("fileID") {
+		return sendErrorResponse(c, bitwarden.ErrSendNotFound)
+	}
+
+	var req sendAccessRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil && err != io.EOF {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if err := send.Access(inst, req.Password); err != nil {
+		return sendErrorResponse(c, err)
+	}
+
+	token, err := bitwarden.SendFileToken(inst, send.CouchID, send.File.ID)
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	u := inst.PageURL("/bitwarden/sends/"+send.CouchID+"/"+send.File.ID, url.Values{
+		"token": {token},
+	})
+	return c.JSON(http.StatusOK, echo.Map{
+		"Id":     send.File.ID,
+		"Url":    u,
+		"Object": "send-fileDownload",
+	})
+}
+
+// DownloadSendFile is the handler for downloading the encrypted content of
+// the file of a send. The access is checked with the short-lived token in the
+// URL given by AccessSendFile.
+func DownloadSendFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sendID := c.Param("id")
+	fileID := c.Param("fileID")
+	if !bitwarden.CheckSendFileToken(inst, sendID, fileID, c.QueryParam("token")) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := bitwarden.FindSend(inst, sendID)
+	if err != nil {
+		return sendErrorResponse(c, err)
+	}
+	if !send.Downloadable() {
+		return sendErrorResponse(c, bitwarden.ErrSendNotFound)
+	}
+	doc, err := bitwarden.OpenSendFile(inst, send)
+	if err != nil {
+		return sendErrorResponse(c, bitwarden.ErrSendNotFound)
+	}
+	return vfs.ServeFileContent(inst.VFS(), doc, nil, "", "attachment", c.Request(), c.Response())
+}
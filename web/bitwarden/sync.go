@@ -41,6 +41,15 @@ func newProfileResponse(inst *instance.Instance, setting *settings.Settings) (*p
 	if orga, err := getCozyOrganizationResponse(inst, setting); err == nil {
 		organizations = append(organizations, orga)
 	}
+	orgs, err := bitwarden.ListOrganizations(inst)
+	if err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		if org.IsConfirmed(inst) {
+			organizations = append(organizations, newOrganizationResponse(inst, org, org.Self(inst)))
+		}
+	}
 	p := &profileResponse{
 		ID:            inst.ID(),
 		Name:          name,
@@ -70,14 +79,18 @@ type syncResponse struct {
 	Folders     []*folderResponse     `json:"Folders"`
 	Ciphers     []*cipherResponse     `json:"Ciphers"`
 	Collections []*collectionResponse `json:"Collections"`
+	Sends       []*sendResponse       `json:"Sends"`
 	Domains     *domainsResponse      `json:"Domains"`
 	Object      string                `json:"Object"`
 }
 
-func newSyncResponse(setting *settings.Settings,
+func newSyncResponse(inst *instance.Instance,
+	setting *settings.Settings,
 	profile *profileResponse,
 	ciphers []*bitwarden.Cipher,
 	folders []*bitwarden.Folder,
+	orgs []*bitwarden.Organization,
+	sends []*bitwarden.Send,
 	domains *domainsResponse,
 ) *syncResponse {
 	foldersResponse := make([]*folderResponse, len(folders))
@@ -86,17 +99,18 @@ func newSyncResponse(setting *settings.Settings,
 	}
 	ciphersResponse := make([]*cipherResponse, len(ciphers))
 	for i, c := range ciphers {
-		ciphersResponse[i] = newCipherResponse(c, setting)
+		ciphersResponse[i] = newCipherResponse(inst, c, setting)
 	}
-	var collections []*collectionResponse
-	if coll, err := getCozyCollectionResponse(setting); err == nil {
-		collections = append(collections, coll)
+	sendsResponse := make([]*sendResponse, len(sends))
+	for i, s := range sends {
+		sendsResponse[i] = newSendResponse(s)
 	}
 	return &syncResponse{
 		Profile:     profile,
 		Folders:     foldersResponse,
 		Ciphers:     ciphersResponse,
-		Collections: collections,
+		Collections: listCollections(inst, setting, orgs),
+		Sends:       sendsResponse,
 		Domains:     domains,
 		Object:      "sync",
 	}
@@ -117,6 +131,13 @@ func Sync(c echo.Context) error {
 		return err
 	}
 
+	// The organizations received via a Cozy sharing since the last sync are
+	// accepted, so that an admin can confirm the user.
+	if err := bitwarden.AcceptOrganizations(inst, setting); err != nil {
+		inst.Logger().WithField("nspace", "bitwarden").
+			Infof("Cannot accept the organizations: %s", err)
+	}
+
 	profile, err := newProfileResponse(inst, setting)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
@@ -144,11 +165,45 @@ func Sync(c echo.Context) error {
 		}
 	}
 
+	orgs, err := bitwarden.ListOrganizations(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	ciphers = filterCiphersByOrganizations(inst, ciphers, orgs)
+
+	sends, err := listSends(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
 	var domains *domainsResponse
 	if c.QueryParam("excludeDomains") == "" {
 		domains = newDomainsResponse(setting)
 	}
 
-	res := newSyncResponse(setting, profile, ciphers, folders, domains)
+	res := newSyncResponse(inst, setting, profile, ciphers, folders, orgs, sends, domains)
 	return c.JSON(http.StatusOK, res)
 }
+
+// filterCiphersByOrganizations removes the ciphers of the organizations where
+// the user is not a confirmed member: the client doesn't have the key to
+// decrypt them.
+func filterCiphersByOrganizations(inst *instance.Instance, ciphers []*bitwarden.Cipher, orgs []*bitwarden.Organization) []*bitwarden.Cipher {
+	confirmed := make(map[string]bool)
+	for _, org := range orgs {
+		if org.IsConfirmed(inst) {
+			confirmed[org.CouchID] = true
+		}
+	}
+	filtered := ciphers[:0]
+	for _, c := range ciphers {
+		if c.OrganizationID == "" || confirmed[c.OrganizationID] {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
@@ -121,6 +121,7 @@ func createFileHandler(c echo.Context, fs vfs.VFS) (f *file, err error) {
 		return
 	}
 	f = newFile(doc, instance)
+	f.preview = previewChecker(c)(doc)
 	return
 }
 
@@ -645,7 +646,7 @@ func ReadFileContentFromIDHandler(c echo.Context) error {
 		return WrapVfsError(err)
 	}
 
-	err = checkPerm(c, permission.GET, nil, doc)
+	err = checkPerm(c, permission.DOWNLOAD, nil, doc)
 	if err != nil {
 		return err
 	}
@@ -672,7 +673,7 @@ func ReadFileContentFromVersion(c echo.Context) error {
 		return WrapVfsError(err)
 	}
 
-	err = checkPerm(c, permission.GET, nil, doc)
+	err = checkPerm(c, permission.DOWNLOAD, nil, doc)
 	if err != nil {
 		return err
 	}
@@ -746,19 +747,24 @@ func ThumbnailHandler(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 
 	secret := c.Param("secret")
-	fileID, err := vfs.GetStore().GetThumb(instance, secret)
+	thumb, err := vfs.GetStore().GetThumb(instance, secret)
 	if err != nil {
 		return WrapVfsError(err)
 	}
-	if fileID == "" || c.Param("file-id") != fileID {
+	if thumb == nil || c.Param("file-id") != thumb.FileID {
 		return jsonapi.NewError(http.StatusBadRequest, "Wrong download token")
 	}
 
-	doc, err := instance.VFS().FileByID(fileID)
+	doc, err := instance.VFS().FileByID(thumb.FileID)
 	if err != nil {
 		return WrapVfsError(err)
 	}
 
+	// The file may have been moved since the links have been given
+	if err := vfs.Allows(instance.VFS(), thumb.Permissions, permission.PREVIEW, doc); err != nil {
+		return jsonapi.Forbidden(err)
+	}
+
 	fs := lifecycle.ThumbsFS(instance)
 	format := c.Param("format")
 	err = fs.ServeThumbContent(c.Response(), c.Request(), doc, format)
@@ -794,7 +800,7 @@ func sendFileFromPath(c echo.Context, path string, checkPermission bool) error {
 	}
 
 	if checkPermission {
-		err = middlewares.Allow(c, permission.GET, doc)
+		err = middlewares.Allow(c, permission.DOWNLOAD, doc)
 		if err != nil {
 			return err
 		}
@@ -852,7 +858,7 @@ func ArchiveDownloadCreateHandler(c echo.Context) error {
 	}
 
 	for _, e := range entries {
-		err = checkPerm(c, permission.GET, e.Dir, e.File)
+		err = checkPerm(c, permission.DOWNLOAD, e.Dir, e.File)
 		if err != nil {
 			return err
 		}
@@ -905,7 +911,7 @@ func FileDownloadCreateHandler(c echo.Context) error {
 		}
 	}
 
-	err = checkPerm(c, "GET", nil, doc)
+	err = checkPerm(c, permission.DOWNLOAD, nil, doc)
 	if err != nil {
 		return err
 	}
@@ -1204,12 +1210,15 @@ func FindFilesMango(c echo.Context) error {
 	}
 
 	out := make([]jsonapi.Object, len(results))
+	canPreview := previewChecker(c)
 	for i, dof := range results {
 		d, f := dof.Refine()
 		if d != nil {
 			out[i] = newDir(d)
 		} else {
-			out[i] = newFile(f, instance)
+			file := newFile(f, instance)
+			file.preview = canPreview(f)
+			out[i] = file
 		}
 	}
 
@@ -1270,6 +1279,7 @@ func Routes(router *echo.Group) {
 	router.POST("/:file-id/versions", CopyVersionHandler)
 
 	router.POST("/_find", FindFilesMango)
+	router.GET("/_search", SearchFilesHandler)
 
 	router.HEAD("/:file-id", HeadDirOrFile)
 
@@ -1285,6 +1295,11 @@ func Routes(router *echo.Group) {
 	router.POST("/:file-id", CreationHandler)
 	router.PUT("/:file-id", OverwriteFileContentHandler)
 	router.POST("/upload/metadata", UploadMetadataHandler)
+	router.POST("/upload/sessions", CreateUploadSessionHandler)
+	router.HEAD("/upload/sessions/:session-id", GetUploadSessionHandler)
+	router.GET("/upload/sessions/:session-id", GetUploadSessionHandler)
+	router.PATCH("/upload/sessions/:session-id", UploadChunkHandler)
+	router.DELETE("/upload/sessions/:session-id", CancelUploadSessionHandler)
 
 	router.GET("/:file-id/thumbnails/:secret/:format", ThumbnailHandler)
 
@@ -1347,8 +1362,12 @@ func wrapVfsError(err error) *jsonapi.Error {
 	case vfs.ErrFileInTrash, vfs.ErrNonAbsolutePath,
 		vfs.ErrDirNotEmpty:
 		return jsonapi.BadRequest(err)
-	case vfs.ErrFileTooBig:
+	case vfs.ErrFileTooBig, vfs.ErrUploadChunkTooBig:
 		return jsonapi.Errorf(http.StatusRequestEntityTooLarge, "%s", err)
+	case vfs.ErrUploadSessionNotFound:
+		return jsonapi.NotFound(err)
+	case vfs.ErrUploadOffsetMismatch:
+		return jsonapi.Conflict(err)
 	}
 	return nil
 }
@@ -1465,6 +1484,32 @@ func checkPerm(c echo.Context, v permission.Verb, d *vfs.DirDoc, f *vfs.FileDoc)
 	return middlewares.AllowVFS(c, v, f)
 }
 
+// previewChecker returns a function that tells if the thumbnails of a file
+// can be given to the requester: it returns the permissions of the requester
+// if they allow the PREVIEW verb on the file, or nil. When the permissions on
+// io.cozy.files don't use the domain verbs, the PREVIEW verb is implied by
+// GET, and there is no need to check it for each file. The permissions are
+// kept with the secret of the thumbnails, and ThumbnailHandler checks them.
+func previewChecker(c echo.Context) func(f *vfs.FileDoc) permission.Set {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return func(f *vfs.FileDoc) permission.Set { return nil }
+	}
+	withDomainVerbs := pdoc.Permissions.Some(func(r permission.Rule) bool {
+		return r.Type == consts.Files && r.Verbs.HasDomainVerbs()
+	})
+	if !withDomainVerbs {
+		return func(f *vfs.FileDoc) permission.Set { return pdoc.Permissions }
+	}
+	fs := middlewares.GetInstance(c).VFS()
+	return func(f *vfs.FileDoc) permission.Set {
+		if vfs.Allows(fs, pdoc.Permissions, permission.PREVIEW, f) != nil {
+			return nil
+		}
+		return pdoc.Permissions
+	}
+}
+
 func parseMD5Hash(md5B64 string) ([]byte, error) {
 	// Encoded md5 hash in base64 should at least have 22 caracters in
 	// base64: 16*3/4 = 21+1/3
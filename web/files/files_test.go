@@ -16,10 +16,12 @@ import (
 	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
 	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/tests/testutils"
 	"github.com/cozy/cozy-stack/web/errors"
@@ -556,6 +558,146 @@ func TestUploadWithSourceAccount(t *testing.T) {
 	assert.Equal(t, identifier, fcm["sourceAccountIdentifier"])
 }
 
+func TestResumableUpload(t *testing.T) {
+	u := "/files/upload/sessions?Name=resumable.txt&DirID=" + consts.RootDirID + "&Size=11"
+	req, err := http.NewRequest("POST", ts.URL+u, nil)
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww==")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var obj map[string]interface{}
+	assert.NoError(t, extractJSONRes(res, &obj))
+	data := obj["data"].(map[string]interface{})
+	assert.Equal(t, consts.FilesUploads, data["type"])
+	secret := data["id"].(string)
+	attrs := data["attributes"].(map[string]interface{})
+	assert.Equal(t, "11", attrs["size"])
+	assert.Equal(t, "0", attrs["offset"])
+
+	sendChunk := func(offset, body string) *http.Response {
+		req, err := http.NewRequest("PATCH", ts.URL+"/files/upload/sessions/"+secret, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+		req.Header.Add("Upload-Offset", offset)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return res
+	}
+
+	res = sendChunk("0", "hello ")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "6", res.Header.Get("Upload-Offset"))
+	res.Body.Close()
+
+	res = sendChunk("3", "lo world")
+	assert.Equal(t, 409, res.StatusCode)
+	assert.Equal(t, "6", res.Header.Get("Upload-Offset"))
+	res.Body.Close()
+
+	req, err = http.NewRequest("HEAD", ts.URL+"/files/upload/sessions/"+secret, nil)
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "6", res.Header.Get("Upload-Offset"))
+	assert.Equal(t, "11", res.Header.Get("Upload-Length"))
+
+	res = sendChunk("6", "world")
+	assert.Equal(t, 201, res.StatusCode)
+	assert.NoError(t, extractJSONRes(res, &obj))
+	data = obj["data"].(map[string]interface{})
+	assert.Equal(t, consts.Files, data["type"])
+	attrs = data["attributes"].(map[string]interface{})
+	assert.Equal(t, "resumable.txt", attrs["name"])
+	assert.Equal(t, "11", attrs["size"])
+
+	buf, err := readFile(testInstance.VFS(), "/resumable.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(buf))
+
+	res = sendChunk("11", "")
+	assert.Equal(t, 404, res.StatusCode)
+	res.Body.Close()
+}
+
+func TestResumableUploadBadHash(t *testing.T) {
+	u := "/files/upload/sessions?Name=resumable-badhash.txt&DirID=" + consts.RootDirID + "&Size=3"
+	req, err := http.NewRequest("POST", ts.URL+u, nil)
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+	req.Header.Add("Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww==")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var obj map[string]interface{}
+	assert.NoError(t, extractJSONRes(res, &obj))
+	secret := obj["data"].(map[string]interface{})["id"].(string)
+
+	req, err = http.NewRequest("PATCH", ts.URL+"/files/upload/sessions/"+secret, strings.NewReader("foobar"))
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+	req.Header.Add("Upload-Offset", "0")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 413, res.StatusCode)
+	res.Body.Close()
+
+	req, err = http.NewRequest("PATCH", ts.URL+"/files/upload/sessions/"+secret, strings.NewReader("foo"))
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+	req.Header.Add("Upload-Offset", "0")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 412, res.StatusCode)
+	res.Body.Close()
+
+	_, err = testInstance.VFS().FileByPath("/resumable-badhash.txt")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCleanAbandonedUploads(t *testing.T) {
+	u := "/files/upload/sessions?Name=resumable-abandoned.txt&DirID=" + consts.RootDirID + "&Size=6"
+	req, err := http.NewRequest("POST", ts.URL+u, nil)
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var obj map[string]interface{}
+	assert.NoError(t, extractJSONRes(res, &obj))
+	secret := obj["data"].(map[string]interface{})["id"].(string)
+
+	req, err = http.NewRequest("PATCH", ts.URL+"/files/upload/sessions/"+secret, strings.NewReader("foo"))
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token)
+	req.Header.Add("Upload-Offset", "0")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	res.Body.Close()
+
+	// The session is still alive: its chunks are kept
+	chunks := lifecycle.UploadsFS(testInstance)
+	_, err = lifecycle.CleanUploads(testInstance)
+	assert.NoError(t, err)
+	sessions, err := chunks.ListSessions()
+	assert.NoError(t, err)
+	assert.Contains(t, sessions, secret)
+
+	// The session has expired: its chunks are removed
+	assert.NoError(t, vfs.GetStore().DeleteUploadSession(testInstance, secret))
+	removed, err := lifecycle.CleanUploads(testInstance)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	sessions, err = chunks.ListSessions()
+	assert.NoError(t, err)
+	assert.NotContains(t, sessions, secret)
+}
+
 func TestModifyMetadataByPath(t *testing.T) {
 	body := "foo"
 	res1, data1 := upload(t, "/files/?Type=file&Name=file-move-me-by-path", "text/plain", body, "rL0Y20zC+Fzt72VPzMSk2A==")
@@ -2105,6 +2247,45 @@ func TestThumbnail(t *testing.T) {
 	assert.True(t, strings.HasPrefix(res4.Header.Get("Content-Type"), "image/jpeg"))
 }
 
+func TestThumbnailHandlerChecksPreview(t *testing.T) {
+	callHandler := func(perms permission.Set) (*httptest.ResponseRecorder, error) {
+		secret, err := vfs.GetStore().AddThumb(testInstance, &vfs.Thumb{
+			FileID:      imgID,
+			Permissions: perms,
+		})
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/files/"+imgID+"/thumbnails/"+secret+"/small", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.Set("instance", testInstance)
+		c.SetParamNames("file-id", "secret", "format")
+		c.SetParamValues(imgID, secret, "small")
+		return rec, ThumbnailHandler(c)
+	}
+
+	_, err := callHandler(permission.Set{
+		permission.Rule{
+			Type:  consts.Files,
+			Verbs: permission.Verbs(permission.DOWNLOAD),
+		},
+	})
+	if assert.Error(t, err) {
+		jsonErr, ok := err.(*jsonapi.Error)
+		if assert.True(t, ok) {
+			assert.Equal(t, http.StatusForbidden, jsonErr.Status)
+		}
+	}
+
+	rec, err := callHandler(permission.Set{
+		permission.Rule{
+			Type:  consts.Files,
+			Verbs: permission.Verbs(permission.DOWNLOAD, permission.PREVIEW),
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
 func TestGetFileByPublicLink(t *testing.T) {
 	var err error
 	body := "foo"
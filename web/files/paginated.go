@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -27,6 +28,9 @@ type file struct {
 	doc      *vfs.FileDoc
 	instance *instance.Instance
 	versions []*vfs.Version
+	// preview is the permissions of the requester if they can see the
+	// thumbnails of the file, or nil
+	preview permission.Set
 	// XXX Hide the internal_vfs_id
 	InternalID *interface{} `json:"internal_vfs_id,omitempty"`
 }
@@ -107,6 +111,7 @@ func dirData(c echo.Context, statusCode int, doc *vfs.DirDoc) error {
 
 	relsData := make([]couchdb.DocReference, 0)
 	included := make([]jsonapi.Object, 0)
+	canPreview := previewChecker(c)
 
 	for _, child := range children {
 		if child.ID() == consts.TrashDirID {
@@ -117,7 +122,9 @@ func dirData(c echo.Context, statusCode int, doc *vfs.DirDoc) error {
 		if d != nil {
 			included = append(included, newDir(d))
 		} else {
-			included = append(included, newFile(f, instance))
+			file := newFile(f, instance)
+			file.preview = canPreview(f)
+			included = append(included, file)
 		}
 	}
 
@@ -177,6 +184,7 @@ func dirDataList(c echo.Context, statusCode int, doc *vfs.DirDoc) error {
 	}
 
 	included := make([]jsonapi.Object, 0)
+	canPreview := previewChecker(c)
 	for _, child := range children {
 		if child.ID() == consts.TrashDirID {
 			continue
@@ -185,7 +193,9 @@ func dirDataList(c echo.Context, statusCode int, doc *vfs.DirDoc) error {
 		if d != nil {
 			included = append(included, newDir(d))
 		} else {
-			included = append(included, newFile(f, instance))
+			file := newFile(f, instance)
+			file.preview = canPreview(f)
+			included = append(included, file)
 		}
 	}
 
@@ -204,13 +214,14 @@ func dirDataList(c echo.Context, statusCode int, doc *vfs.DirDoc) error {
 
 // newFile creates an instance of file struct from a vfs.FileDoc document.
 func newFile(doc *vfs.FileDoc, i *instance.Instance) *file {
-	return &file{doc, i, nil, nil}
+	return &file{doc: doc, instance: i}
 }
 
 // FileData returns a jsonapi representation of the given file.
 func FileData(c echo.Context, statusCode int, doc *vfs.FileDoc, withVersions bool, links *jsonapi.LinksList) error {
 	instance := middlewares.GetInstance(c)
 	f := newFile(doc, instance)
+	f.preview = previewChecker(c)(doc)
 	if withVersions {
 		if versions, err := vfs.VersionsFor(instance, doc.ID()); err == nil {
 			f.versions = versions
@@ -311,8 +322,9 @@ func (f *file) MarshalJSON() ([]byte, error) {
 }
 func (f *file) Links() *jsonapi.LinksList {
 	links := jsonapi.LinksList{Self: "/files/" + f.doc.DocID}
-	if f.doc.Class == "image" {
-		if secret, err := vfs.GetStore().AddThumb(f.instance, f.doc.DocID); err == nil {
+	if f.doc.Class == "image" && f.preview != nil {
+		thumb := &vfs.Thumb{FileID: f.doc.DocID, Permissions: f.preview}
+		if secret, err := vfs.GetStore().AddThumb(f.instance, thumb); err == nil {
 			links.Small = "/files/" + f.doc.DocID + "/thumbnails/" + secret + "/small"
 			links.Medium = "/files/" + f.doc.DocID + "/thumbnails/" + secret + "/medium"
 			links.Large = "/files/" + f.doc.DocID + "/thumbnails/" + secret + "/large"
@@ -23,7 +23,7 @@ const (
 	maxRefsPerPage     = 1000
 )
 
-func rawMessageToObject(i *instance.Instance, bb json.RawMessage) (jsonapi.Object, error) {
+func rawMessageToObject(i *instance.Instance, bb json.RawMessage, canPreview func(f *vfs.FileDoc) permission.Set) (jsonapi.Object, error) {
 	var dof vfs.DirOrFileDoc
 	err := json.Unmarshal(bb, &dof)
 	if err != nil {
@@ -34,7 +34,9 @@ func rawMessageToObject(i *instance.Instance, bb json.RawMessage) (jsonapi.Objec
 		return newDir(d), nil
 	}
 
-	return newFile(f, i), nil
+	file := newFile(f, i)
+	file.preview = canPreview(f)
+	return file, nil
 }
 
 // ListReferencesHandler list all files referenced by a doc
@@ -133,6 +135,7 @@ func ListReferencesHandler(c echo.Context) error {
 		docs = make([]jsonapi.Object, len(res.Rows))
 	}
 
+	canPreview := previewChecker(c)
 	for i, row := range res.Rows {
 		refs[i] = couchdb.DocReference{
 			ID:   row.ID,
@@ -140,7 +143,7 @@ func ListReferencesHandler(c echo.Context) error {
 		}
 
 		if includeDocs {
-			docs[i], err = rawMessageToObject(instance, row.Doc)
+			docs[i], err = rawMessageToObject(instance, row.Doc, canPreview)
 			if err != nil {
 				return err
 			}
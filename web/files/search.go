@@ -0,0 +1,107 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/search"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+const maxSearchPerPage = 100
+
+// SearchFilesHandler handles GET requests on /files/_search to make a
+// full-text search on the names, paths, tags and content of the files. The
+// results are sorted by relevance, and only the files that can be read with
+// the permissions of the request are returned.
+func SearchFilesHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	q := c.QueryParam("q")
+	if q == "" {
+		return jsonapi.InvalidParameter("q", errors.New("q is missing"))
+	}
+
+	limit := defPerPage
+	if l := c.QueryParam("page[limit]"); l != "" {
+		reqLimit, err := strconv.Atoi(l)
+		if err != nil || reqLimit <= 0 {
+			return jsonapi.InvalidParameter("page[limit]", errors.New("page limit is not a positive number"))
+		}
+		limit = reqLimit
+	}
+	if limit > maxSearchPerPage {
+		limit = maxSearchPerPage
+	}
+	skip := 0
+	if s := c.QueryParam("page[skip]"); s != "" {
+		reqSkip, err := strconv.Atoi(s)
+		if err != nil || reqSkip < 0 {
+			return jsonapi.InvalidParameter("page[skip]", errors.New("page skip is not a positive number"))
+		}
+		skip = reqSkip
+	}
+
+	// With a permission on the whole doctype, we can skip the permission
+	// checks for each file.
+	wholeType := middlewares.AllowWholeType(c, permission.GET, consts.Files) == nil
+	if !wholeType {
+		if _, err := middlewares.GetPermission(c); err != nil {
+			return err
+		}
+	}
+
+	results, err := search.Query(inst, q)
+	if err != nil {
+		return err
+	}
+
+	fs := inst.VFS()
+	canPreview := previewChecker(c)
+	out := make([]jsonapi.Object, 0, limit)
+	matched := 0
+	hasMore := false
+	for _, result := range results {
+		doc, err := fs.FileByID(result.ID)
+		if err == os.ErrNotExist {
+			// The index can be a bit late, or have a stale entry
+			continue
+		}
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		if !search.Indexable(doc) {
+			continue
+		}
+		if !wholeType && checkPerm(c, permission.GET, nil, doc) != nil {
+			continue
+		}
+		matched++
+		if matched <= skip {
+			continue
+		}
+		if len(out) == limit {
+			hasMore = true
+			break
+		}
+		file := newFile(doc, inst)
+		file.preview = canPreview(doc)
+		out = append(out, file)
+	}
+
+	var links *jsonapi.LinksList
+	if hasMore {
+		params := url.Values{}
+		params.Set("q", q)
+		params.Set("page[limit]", strconv.Itoa(limit))
+		params.Set("page[skip]", strconv.Itoa(skip+limit))
+		links = &jsonapi.LinksList{Next: "/files/_search?" + params.Encode()}
+	}
+	return jsonapi.DataList(c, http.StatusOK, out, links)
+}
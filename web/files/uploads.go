@@ -0,0 +1,269 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/lock"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiUploadSession struct {
+	session *vfs.UploadSession
+	secret  string
+}
+
+func (u *apiUploadSession) ID() string                             { return u.secret }
+func (u *apiUploadSession) Rev() string                            { return "" }
+func (u *apiUploadSession) SetID(id string)                        { u.secret = id }
+func (u *apiUploadSession) SetRev(rev string)                      {}
+func (u *apiUploadSession) DocType() string                        { return consts.FilesUploads }
+func (u *apiUploadSession) Clone() couchdb.Doc                     { cloned := *u; return &cloned }
+func (u *apiUploadSession) Relationships() jsonapi.RelationshipMap { return nil }
+func (u *apiUploadSession) Included() []jsonapi.Object             { return nil }
+func (u *apiUploadSession) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/files/upload/sessions/" + u.secret}
+}
+func (u *apiUploadSession) MarshalJSON() ([]byte, error) {
+	attrs := map[string]interface{}{
+		"name":       u.session.Doc.DocName,
+		"dir_id":     u.session.Doc.DirID,
+		"size":       strconv.FormatInt(u.session.Size(), 10),
+		"offset":     strconv.FormatInt(u.session.Offset, 10),
+		"expires_at": u.session.ExpiresAt,
+	}
+	if u.session.Overwrite {
+		attrs["file_id"] = u.session.Doc.ID()
+	}
+	return json.Marshal(attrs)
+}
+
+var _ jsonapi.Object = (*apiUploadSession)(nil)
+
+// CreateUploadSessionHandler handles POST requests on /files/upload/sessions
+// to start a resumable upload. The content of the file is then sent in one or
+// several chunks via PATCH requests on the session.
+func CreateUploadSessionHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fs := inst.VFS()
+
+	size, err := strconv.ParseInt(c.QueryParam("Size"), 10, 64)
+	if err != nil || size < 0 {
+		return jsonapi.InvalidParameter("Size", errors.New("Size must be a positive integer"))
+	}
+
+	session := &vfs.UploadSession{}
+	if fileID := c.QueryParam("FileID"); fileID != "" {
+		olddoc, err := fs.FileByID(fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		if err = CheckIfMatch(c, olddoc.Rev()); err != nil {
+			return WrapVfsError(err)
+		}
+		if err = checkPerm(c, permission.PUT, nil, olddoc); err != nil {
+			return err
+		}
+		newdoc, err := FileDocFromReq(c, olddoc.DocName, olddoc.DirID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		if olddoc.CozyMetadata != nil {
+			newdoc.CozyMetadata = olddoc.CozyMetadata.Clone()
+		}
+		updateFileCozyMetadata(c, newdoc, true)
+		newdoc.SetID(olddoc.ID())
+		session.Doc = newdoc
+		session.OldRev = olddoc.Rev()
+		session.Overwrite = true
+	} else {
+		newdoc, err := FileDocFromReq(c, c.QueryParam("Name"), c.QueryParam("DirID"))
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		if created := c.QueryParam("CreatedAt"); created != "" {
+			if at, err2 := time.Parse(time.RFC3339, created); err2 == nil {
+				newdoc.CreatedAt = at
+			}
+		}
+		newdoc.CozyMetadata, _ = cozyMetadataFromClaims(c, true)
+		session.Doc = newdoc
+	}
+	session.Doc.ByteSize = size
+
+	if err = checkUploadSessionPerm(c, session); err != nil {
+		return err
+	}
+	if err = vfs.CheckAvailableDiskSpace(fs, size); err != nil {
+		return WrapVfsError(err)
+	}
+
+	secret, err := vfs.GetStore().AddUploadSession(inst, session)
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if err = lifecycle.EnsureUploadsCleanupTrigger(inst); err != nil {
+		inst.Logger().WithField("nspace", "files").
+			Warnf("Cannot add the trigger for cleaning the uploads: %s", err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiUploadSession{session, secret}, nil)
+}
+
+// GetUploadSessionHandler handles GET and HEAD requests on
+// /files/upload/sessions/:session-id to know how many bytes have already been
+// received, and so where the upload must be resumed.
+func GetUploadSessionHandler(c echo.Context) error {
+	secret := c.Param("session-id")
+	session, err := getUploadSession(c, secret)
+	if err != nil {
+		return err
+	}
+	setUploadHeaders(c, session)
+	if c.Request().Method == http.MethodHead {
+		return c.NoContent(http.StatusOK)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiUploadSession{session, secret}, nil)
+}
+
+// UploadChunkHandler handles PATCH requests on /files/upload/sessions/:session-id
+// to send a chunk of the file. The Upload-Offset header must be the current
+// offset of the session. When the last chunk has been received, the file is
+// created in the VFS and its JSON-API representation is returned.
+func UploadChunkHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	secret := c.Param("session-id")
+
+	mu := lock.ReadWrite(inst, "uploads/"+secret)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	session, err := getUploadSession(c, secret)
+	if err != nil {
+		return err
+	}
+
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != session.Offset {
+		setUploadHeaders(c, session)
+		return WrapVfsError(vfs.ErrUploadOffsetMismatch)
+	}
+
+	chunks := lifecycle.UploadsFS(inst)
+	if !session.Completed() {
+		remaining := session.Size() - session.Offset
+		chunk, err := chunks.CreateChunk(secret, offset)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		n, err := io.Copy(chunk, io.LimitReader(c.Request().Body, remaining+1))
+		if err == nil && n > remaining {
+			err = vfs.ErrUploadChunkTooBig
+		}
+		if err != nil {
+			_ = chunk.Abort()
+			inst.Logger().WithField("nspace", "files").
+				Warnf("Error on uploading chunk: %s (%d bytes written at offset %d)", err, n, offset)
+			return WrapVfsError(err)
+		}
+		if n == 0 {
+			_ = chunk.Abort()
+		} else {
+			if err = chunk.Commit(); err != nil {
+				return WrapVfsError(err)
+			}
+			session.Chunks = append(session.Chunks, offset)
+			session.Offset += n
+			if err = vfs.GetStore().SaveUploadSession(inst, secret, session); err != nil {
+				return WrapVfsError(err)
+			}
+		}
+	}
+
+	if !session.Completed() {
+		setUploadHeaders(c, session)
+		return jsonapi.Data(c, http.StatusOK, &apiUploadSession{session, secret}, nil)
+	}
+
+	newdoc, err := vfs.CommitUpload(inst.VFS(), chunks, secret, session)
+	if err != nil {
+		if err == vfs.ErrInvalidHash || err == vfs.ErrContentLengthMismatch {
+			_ = chunks.RemoveChunks(secret, session.Chunks)
+			_ = vfs.GetStore().DeleteUploadSession(inst, secret)
+		}
+		return WrapVfsError(err)
+	}
+	_ = vfs.GetStore().DeleteUploadSession(inst, secret)
+
+	status := http.StatusCreated
+	if session.Overwrite {
+		status = http.StatusOK
+	}
+	return FileData(c, status, newdoc, session.Overwrite, nil)
+}
+
+// CancelUploadSessionHandler handles DELETE requests on
+// /files/upload/sessions/:session-id to abort a resumable upload and release
+// the chunks already received.
+func CancelUploadSessionHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	secret := c.Param("session-id")
+
+	mu := lock.ReadWrite(inst, "uploads/"+secret)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	session, err := getUploadSession(c, secret)
+	if err != nil {
+		return err
+	}
+	if err = lifecycle.UploadsFS(inst).RemoveChunks(secret, session.Chunks); err != nil {
+		return WrapVfsError(err)
+	}
+	if err = vfs.GetStore().DeleteUploadSession(inst, secret); err != nil {
+		return WrapVfsError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getUploadSession(c echo.Context, secret string) (*vfs.UploadSession, error) {
+	inst := middlewares.GetInstance(c)
+	session, err := vfs.GetStore().GetUploadSession(inst, secret)
+	if err != nil {
+		return nil, WrapVfsError(err)
+	}
+	if session == nil {
+		return nil, WrapVfsError(vfs.ErrUploadSessionNotFound)
+	}
+	if err = checkUploadSessionPerm(c, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func checkUploadSessionPerm(c echo.Context, session *vfs.UploadSession) error {
+	if session.Overwrite {
+		return checkPerm(c, permission.PUT, nil, session.Doc)
+	}
+	return checkPerm(c, permission.POST, nil, session.Doc)
+}
+
+func setUploadHeaders(c echo.Context, session *vfs.UploadSession) {
+	h := c.Response().Header()
+	h.Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(session.Size(), 10))
+}
@@ -284,6 +284,40 @@ func updatesHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, j)
 }
 
+func rebuildSearchIndex(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	j, err := job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "search-rebuild",
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusAccepted, j)
+}
+
+func rotateFilesKey(c echo.Context) error {
+	if !vfs.EncryptionEnabled() {
+		return jsonapi.BadRequest(errors.New("The encryption of the files is disabled"))
+	}
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if !inst.SupportsEncryption() {
+		return wrapError(instance.ErrEncryptionNotSupported)
+	}
+	j, err := job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "rotate-files-key",
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusAccepted, j)
+}
+
 func setAuthMode(c echo.Context) error {
 	domain := c.Param("domain")
 	inst, err := lifecycle.GetInstance(domain)
@@ -436,6 +470,8 @@ func wrapError(err error) error {
 		return jsonapi.BadRequest(err)
 	case instance.ErrBadTOSVersion:
 		return jsonapi.BadRequest(err)
+	case instance.ErrEncryptionNotSupported:
+		return jsonapi.BadRequest(err)
 	}
 	return err
 }
@@ -476,6 +512,8 @@ func Routes(router *echo.Group) {
 	router.GET("/:domain/prefix", showPrefix)
 	router.GET("/:domain/swift-prefix", getSwiftBucketName)
 	router.POST("/:domain/auth-mode", setAuthMode)
+	router.POST("/:domain/search/rebuild", rebuildSearchIndex)
+	router.POST("/:domain/files-key/rotate", rotateFilesKey)
 
 	// Config
 	router.POST("/redis", rebuildRedis)
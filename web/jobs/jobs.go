@@ -2,6 +2,7 @@ package jobs
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -26,17 +27,22 @@ import (
 
 	// import workers
 	_ "github.com/cozy/cozy-stack/worker/archive"
+	_ "github.com/cozy/cozy-stack/worker/bitwarden"
+	_ "github.com/cozy/cozy-stack/worker/encryption"
 	"github.com/cozy/cozy-stack/worker/exec"
 	_ "github.com/cozy/cozy-stack/worker/log"
 	_ "github.com/cozy/cozy-stack/worker/mails"
 	_ "github.com/cozy/cozy-stack/worker/migrations"
 	_ "github.com/cozy/cozy-stack/worker/move"
 	_ "github.com/cozy/cozy-stack/worker/notes"
+	_ "github.com/cozy/cozy-stack/worker/permissions"
 	_ "github.com/cozy/cozy-stack/worker/push"
+	_ "github.com/cozy/cozy-stack/worker/search"
 	_ "github.com/cozy/cozy-stack/worker/share"
 	_ "github.com/cozy/cozy-stack/worker/thumbnail"
 	_ "github.com/cozy/cozy-stack/worker/trash"
 	_ "github.com/cozy/cozy-stack/worker/updates"
+	_ "github.com/cozy/cozy-stack/worker/uploads"
 )
 
 type (
@@ -47,6 +53,19 @@ type (
 		Arguments   json.RawMessage `json:"arguments"`
 		ForwardLogs bool            `json:"forward_logs"`
 		Options     *job.JobOptions `json:"options"`
+		WaitFor     []string        `json:"wait_for"`
+	}
+	apiWorkflow struct {
+		w *job.Workflow
+	}
+	apiWorkflowRequest struct {
+		Steps []job.WorkflowStep `json:"steps"`
+	}
+	apiDeadLetter struct {
+		l *job.DeadLetter
+	}
+	apiDeadLetterRequest struct {
+		Message json.RawMessage `json:"message"`
 	}
 	apiQueue struct {
 		workerType string
@@ -84,6 +103,36 @@ func (j apiJob) MarshalJSON() ([]byte, error) {
 	return json.Marshal(j.j)
 }
 
+func (w apiWorkflow) ID() string                             { return w.w.WorkflowID }
+func (w apiWorkflow) Rev() string                            { return "" }
+func (w apiWorkflow) DocType() string                        { return consts.JobsWorkflows }
+func (w apiWorkflow) Clone() couchdb.Doc                     { return w }
+func (w apiWorkflow) SetID(_ string)                         {}
+func (w apiWorkflow) SetRev(_ string)                        {}
+func (w apiWorkflow) Relationships() jsonapi.RelationshipMap { return nil }
+func (w apiWorkflow) Included() []jsonapi.Object             { return nil }
+func (w apiWorkflow) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/jobs/workflows/" + w.ID()}
+}
+func (w apiWorkflow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.w)
+}
+
+func (l apiDeadLetter) ID() string                             { return l.l.ID() }
+func (l apiDeadLetter) Rev() string                            { return l.l.Rev() }
+func (l apiDeadLetter) DocType() string                        { return consts.JobsDeadLetters }
+func (l apiDeadLetter) Clone() couchdb.Doc                     { return l }
+func (l apiDeadLetter) SetID(_ string)                         {}
+func (l apiDeadLetter) SetRev(_ string)                        {}
+func (l apiDeadLetter) Relationships() jsonapi.RelationshipMap { return nil }
+func (l apiDeadLetter) Included() []jsonapi.Object             { return nil }
+func (l apiDeadLetter) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/jobs/dead-letters/" + l.ID()}
+}
+func (l apiDeadLetter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.l)
+}
+
 func (q apiQueue) ID() string      { return q.workerType }
 func (q apiQueue) DocType() string { return consts.Jobs }
 func (q apiQueue) Fetch(field string) []string {
@@ -163,6 +212,7 @@ func pushJob(c echo.Context) error {
 		Options:     req.Options,
 		ForwardLogs: req.ForwardLogs,
 		Message:     job.Message(req.Arguments),
+		WaitFor:     req.WaitFor,
 	}
 
 	// TODO: uncomment to restric jobs permissions.
@@ -194,6 +244,190 @@ func pushJob(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusAccepted, apiJob{j}, nil)
 }
 
+func pushWorkflow(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+
+	req := apiWorkflowRequest{}
+	if _, err := jsonapi.Bind(c.Request().Body, &req); err != nil {
+		return wrapJobsError(err)
+	}
+
+	permd, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	for _, step := range req.Steps {
+		jr := &job.JobRequest{WorkerType: step.WorkerType}
+		if err := middlewares.Allow(c, permission.POST, jr); err != nil {
+			return err
+		}
+		if permd.Type != permission.TypeCLI {
+			if err := checkReservedWorker(jr.WorkerType); err != nil {
+				return err
+			}
+		}
+	}
+
+	w, err := job.PushWorkflow(job.System(), instance, req.Steps)
+	if err != nil {
+		return wrapJobsError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusAccepted, apiWorkflow{w}, nil)
+}
+
+func getWorkflow(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	w, err := job.GetWorkflow(instance, c.Param("workflow-id"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	for _, j := range w.Jobs {
+		if err := middlewares.Allow(c, permission.GET, j); err != nil {
+			return err
+		}
+	}
+	return jsonapi.Data(c, http.StatusOK, apiWorkflow{w}, nil)
+}
+
+// allowDeadLetters checks the permission on the dead letters of a worker
+// type: it is the same as for the jobs of this worker type.
+func allowDeadLetters(c echo.Context, verb permission.Verb, workerType string) error {
+	if workerType == "" {
+		return middlewares.AllowWholeType(c, verb, consts.Jobs)
+	}
+	jr := &job.JobRequest{WorkerType: workerType}
+	return middlewares.Allow(c, verb, jr)
+}
+
+func getDeadLetterAndAllow(c echo.Context, verb permission.Verb) (*job.DeadLetter, error) {
+	instance := middlewares.GetInstance(c)
+	l, err := job.GetDeadLetter(instance, c.Param("letter-id"))
+	if err != nil {
+		return nil, wrapJobsError(err)
+	}
+	if err := allowDeadLetters(c, verb, l.WorkerType); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func listDeadLetters(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	workerType := c.QueryParam("Worker")
+	if err := allowDeadLetters(c, permission.GET, workerType); err != nil {
+		return err
+	}
+
+	var limit int
+	if queryLimit := c.QueryParam("Limit"); queryLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(queryLimit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+	}
+
+	letters, err := job.ListDeadLetters(instance, workerType, c.QueryParam("Error"), limit)
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	objs := make([]jsonapi.Object, len(letters))
+	for i, l := range letters {
+		objs[i] = apiDeadLetter{l}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func getDeadLetter(c echo.Context) error {
+	l, err := getDeadLetterAndAllow(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, apiDeadLetter{l}, nil)
+}
+
+func patchDeadLetter(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	l, err := getDeadLetterAndAllow(c, permission.PATCH)
+	if err != nil {
+		return err
+	}
+
+	req := apiDeadLetterRequest{}
+	if _, err := jsonapi.Bind(c.Request().Body, &req); err != nil {
+		return wrapJobsError(err)
+	}
+	if len(req.Message) == 0 {
+		return jsonapi.BadRequest(errors.New("The message is missing"))
+	}
+	if err := l.UpdateMessage(instance, job.Message(req.Message)); err != nil {
+		return wrapJobsError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, apiDeadLetter{l}, nil)
+}
+
+func deleteDeadLetter(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	l, err := getDeadLetterAndAllow(c, permission.DELETE)
+	if err != nil {
+		return err
+	}
+	if err := l.Discard(instance); err != nil {
+		return wrapJobsError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func replayDeadLetter(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	l, err := getDeadLetterAndAllow(c, permission.POST)
+	if err != nil {
+		return err
+	}
+	permd, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if permd.Type != permission.TypeCLI {
+		if err := checkReservedWorker(l.WorkerType); err != nil {
+			return err
+		}
+	}
+
+	j, err := l.Replay(job.System(), instance)
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	return jsonapi.Data(c, http.StatusAccepted, apiJob{j}, nil)
+}
+
+func replayDeadLetters(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	workerType := c.QueryParam("Worker")
+	if err := allowDeadLetters(c, permission.POST, workerType); err != nil {
+		return err
+	}
+	permd, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if permd.Type != permission.TypeCLI {
+		if workerType == "" {
+			return echo.NewHTTPError(http.StatusForbidden)
+		}
+		if err := checkReservedWorker(workerType); err != nil {
+			return err
+		}
+	}
+
+	n, err := job.ReplayDeadLetters(job.System(), instance, workerType, c.QueryParam("Error"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]int{"replayed": n})
+}
+
 func newTrigger(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	sched := job.System()
@@ -592,7 +826,24 @@ func purgeJobs(c echo.Context) error {
 		}
 	}
 
-	return c.JSON(http.StatusOK, map[string]int{"deleted": len(jobsToDelete)})
+	// The dead letters expire, so that they don't pile up
+	lettersDeleted := 0
+	before := time.Now().Add(-job.DeadLettersMaxAge)
+	for {
+		n, err := job.PurgeDeadLetters(instance, before)
+		if err != nil {
+			return err
+		}
+		lettersDeleted += n
+		if n < job.MaxDeadLettersListed {
+			break
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{
+		"deleted":              len(jobsToDelete),
+		"dead_letters_deleted": lettersDeleted,
+	})
 }
 
 // Routes sets the routing for the jobs service
@@ -600,6 +851,16 @@ func Routes(router *echo.Group) {
 	router.GET("/queue/:worker-type", getQueue)
 	router.POST("/queue/:worker-type", pushJob)
 
+	router.POST("/workflows", pushWorkflow)
+	router.GET("/workflows/:workflow-id", getWorkflow)
+
+	router.GET("/dead-letters", listDeadLetters)
+	router.POST("/dead-letters/replay", replayDeadLetters)
+	router.GET("/dead-letters/:letter-id", getDeadLetter)
+	router.PATCH("/dead-letters/:letter-id", patchDeadLetter)
+	router.DELETE("/dead-letters/:letter-id", deleteDeadLetter)
+	router.POST("/dead-letters/:letter-id/replay", replayDeadLetter)
+
 	router.POST("/triggers", newTrigger)
 	router.GET("/triggers", getAllTriggers)
 	router.GET("/triggers/:trigger-id", getTrigger)
@@ -617,8 +878,13 @@ func wrapJobsError(err error) error {
 	switch err {
 	case job.ErrNotFoundTrigger,
 		job.ErrNotFoundJob,
+		job.ErrNotFoundWorkflow,
+		job.ErrNotFoundDeadLetter,
 		job.ErrUnknownWorker:
 		return jsonapi.NotFound(err)
+	case job.ErrInvalidWorkflow,
+		job.ErrUnknownDependency:
+		return jsonapi.BadRequest(err)
 	case job.ErrUnknownTrigger:
 		return jsonapi.InvalidAttribute("Type", err)
 	case limits.ErrRateLimitReached,
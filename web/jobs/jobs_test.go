@@ -375,8 +375,9 @@ func TestGetAllJobs(t *testing.T) {
 		return
 	}
 
-	// The instance already has a trigger for thumbnails
-	assert.Len(t, v.Data, 1)
+	// The instance already has a trigger for thumbnails and another one for
+	// the search index
+	assert.Len(t, v.Data, 2)
 
 	body, _ := json.Marshal(&jsonapiReq{
 		Data: &jsonapiData{
@@ -413,12 +414,12 @@ func TestGetAllJobs(t *testing.T) {
 		return
 	}
 
-	if assert.Len(t, v.Data, 2) {
+	if assert.Len(t, v.Data, 3) {
 		var index int
-		if v.Data[1].Attributes.Type == "@in" {
-			index = 1
-		} else {
-			index = 0
+		for i, data := range v.Data {
+			if data.Attributes.Type == "@in" {
+				index = i
+			}
 		}
 		assert.Equal(t, consts.Triggers, v.Data[index].Type)
 		assert.Equal(t, "@in", v.Data[index].Attributes.Type)
@@ -205,7 +205,8 @@ func ParseJWT(c echo.Context, instance *instance.Instance, token string) (*permi
 			return nil, err
 		}
 
-		// A share token is only valid if the user has not been revoked
+		// A share token is only valid if the user has not been revoked, and
+		// if the access has not expired
 		if pdoc.Type == permission.TypeSharePreview {
 			sharingID := strings.Split(pdoc.SourceID, "/")
 			sharingDoc, err := sharing.FindSharing(instance, sharingID[1])
@@ -218,7 +219,7 @@ func ParseJWT(c echo.Context, instance *instance.Instance, token string) (*permi
 				return nil, err
 			}
 
-			if member.Status == sharing.MemberStatusRevoked {
+			if member.Status == sharing.MemberStatusRevoked || sharingDoc.AccessExpired(member) {
 				return nil, permission.ErrInvalidToken
 			}
 		}
@@ -265,7 +266,9 @@ func AllowWholeType(c echo.Context, v permission.Verb, doctype string) error {
 	if err != nil {
 		return err
 	}
-	if !pdoc.Permissions.AllowWholeType(v, doctype) {
+	allowed := pdoc.Permissions.AllowWholeType(v, doctype)
+	auditAccess(c, pdoc, v, doctype, "", allowed)
+	if !allowed {
 		return ErrForbidden
 	}
 	return nil
@@ -277,7 +280,9 @@ func Allow(c echo.Context, v permission.Verb, o permission.Fetcher) error {
 	if err != nil {
 		return err
 	}
-	if !pdoc.Permissions.Allow(v, o) {
+	allowed := pdoc.Permissions.Allow(v, o)
+	auditAccess(c, pdoc, v, o.DocType(), o.ID(), allowed)
+	if !allowed {
 		return ErrForbidden
 	}
 	return nil
@@ -290,7 +295,9 @@ func AllowOnFields(c echo.Context, v permission.Verb, o permission.Fetcher, fiel
 	if err != nil {
 		return err
 	}
-	if !pdoc.Permissions.AllowOnFields(v, o, fields...) {
+	allowed := pdoc.Permissions.AllowOnFields(v, o, fields...)
+	auditAccess(c, pdoc, v, o.DocType(), o.ID(), allowed)
+	if !allowed {
 		return ErrForbidden
 	}
 	return nil
@@ -302,7 +309,9 @@ func AllowTypeAndID(c echo.Context, v permission.Verb, doctype, id string) error
 	if err != nil {
 		return err
 	}
-	if !pdoc.Permissions.AllowID(v, doctype, id) {
+	allowed := pdoc.Permissions.AllowID(v, doctype, id)
+	auditAccess(c, pdoc, v, doctype, id, allowed)
+	if !allowed {
 		return ErrForbidden
 	}
 	return nil
@@ -316,6 +325,7 @@ func AllowVFS(c echo.Context, v permission.Verb, o vfs.Fetcher) error {
 		return err
 	}
 	err = vfs.Allows(instance.VFS(), pdoc.Permissions, v, o)
+	auditAccess(c, pdoc, v, o.DocType(), o.ID(), err == nil)
 	if err != nil {
 		return ErrForbidden
 	}
@@ -388,12 +398,28 @@ func AllowForApp(c echo.Context, v permission.Verb, o permission.Fetcher) (slug
 	if pdoc.Type != permission.TypeWebapp && pdoc.Type != permission.TypeKonnector {
 		return "", ErrForbidden
 	}
-	if !pdoc.Permissions.Allow(v, o) {
+	allowed := pdoc.Permissions.Allow(v, o)
+	auditAccess(c, pdoc, v, o.DocType(), o.ID(), allowed)
+	if !allowed {
 		return "", ErrForbidden
 	}
 	return pdoc.SourceID, nil
 }
 
+// auditAccess records the access decision in the audit trail of the
+// permissions, if it has been enabled for the instance.
+func auditAccess(c echo.Context, pdoc *permission.Permission, v permission.Verb, doctype, id string, allowed bool) {
+	inst := GetInstance(c)
+	if !inst.PermissionsAuditEnabled() {
+		return
+	}
+	code := ""
+	if claims, ok := c.Get("claims").(permission.Claims); ok && claims.Audience == consts.ShareAudience {
+		code = claims.Subject
+	}
+	permission.AuditAccessDecision(inst, pdoc, code, v, doctype, id, allowed)
+}
+
 // GetSourceID returns the sourceID of the permissions associated with the
 // given context.
 func GetSourceID(c echo.Context) (slug string, err error) {
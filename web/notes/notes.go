@@ -5,12 +5,17 @@ package notes
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
+	"strings"
 
+	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/sharing"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
@@ -195,14 +200,435 @@ func PutTelepointer(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// ExportNote is the API handler for GET /notes/:id/export?Format=xxx. It
+// returns the content of the note converted to markdown, HTML or DOCX.
+func ExportNote(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.DOWNLOAD, file); err != nil {
+		return err
+	}
+
+	format := c.QueryParam("Format")
+	if format == "" {
+		format = note.MarkdownFormat
+	}
+	exported, err := note.Export(inst, file, format)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	disposition := vfs.ContentDisposition("attachment", exported.Filename)
+	c.Response().Header().Set(echo.HeaderContentDisposition, disposition)
+	return c.Blob(http.StatusOK, exported.Mime, exported.Content)
+}
+
+// importAttributes are the attributes for importing a file as a note: the
+// identifier of the file, its format, and the attributes of a new note.
+type importAttributes struct {
+	note.Document
+	FileID string `json:"file_id"`
+	Format string `json:"format"`
+}
+
+// ImportNote is the API handler for POST /notes/import. It creates a note
+// from an existing markdown or HTML file.
+func ImportNote(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Files); err != nil {
+		return err
+	}
+
+	attrs := importAttributes{}
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return err
+	}
+
+	inst := middlewares.GetInstance(c)
+	src, err := inst.VFS().FileByID(attrs.FileID)
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.GET, src); err != nil {
+		return err
+	}
+
+	format := attrs.Format
+	if format == "" {
+		format = guessFormat(src)
+	}
+	doc := &attrs.Document
+	if doc.Title == "" {
+		doc.Title = strings.TrimSuffix(src.DocName, path.Ext(src.DocName))
+	}
+	doc.CreatedBy = getCreatedBy(c)
+
+	content, err := inst.VFS().OpenFile(src)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer content.Close()
+
+	file, err := note.Import(inst, doc, format, content)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return files.FileData(c, http.StatusCreated, file, false, nil)
+}
+
+func guessFormat(file *vfs.FileDoc) string {
+	switch strings.ToLower(path.Ext(file.DocName)) {
+	case ".md", ".markdown":
+		return note.MarkdownFormat
+	case ".html", ".htm":
+		return note.HTMLFormat
+	}
+	switch file.Mime {
+	case "text/markdown", "text/x-markdown":
+		return note.MarkdownFormat
+	case "text/html":
+		return note.HTMLFormat
+	}
+	return ""
+}
+
+// threadAttributes are the attributes sent by the client for the actions on
+// the threads of comments.
+type threadAttributes struct {
+	SessionID  string                 `json:"sessionID"`
+	Kind       string                 `json:"kind"`
+	Version    *int64                 `json:"version"`
+	From       int                    `json:"from"`
+	To         int                    `json:"to"`
+	Suggestion map[string]interface{} `json:"suggestion"`
+	Body       string                 `json:"body"`
+}
+
+// ListThreads is the API handler for GET /notes/:id/comments. It returns the
+// threads of comments and the suggestions for the note.
+func ListThreads(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	threads, err := note.ListThreads(inst, file)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(threads))
+	for i, thread := range threads {
+		objs[i] = thread
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// CreateThread is the API handler for POST /notes/:id/comments. It creates a
+// thread of comments, or a suggestion, anchored to a range of the note.
+func CreateThread(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.COMMENT, file); err != nil {
+		return err
+	}
+
+	attrs := threadAttributes{}
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return err
+	}
+	if attrs.Version == nil {
+		return jsonapi.InvalidAttribute("version", errors.New("The version is missing"))
+	}
+
+	thread := &note.Thread{
+		Kind:       attrs.Kind,
+		Suggestion: attrs.Suggestion,
+		Anchor:     &note.Anchor{From: attrs.From, To: attrs.To},
+	}
+	if attrs.Body != "" || attrs.Kind == note.CommentThread {
+		thread.Comments = []note.Comment{{Author: getCommentAuthor(c, inst), Body: attrs.Body}}
+	}
+	if err := note.CreateThread(inst, file, thread, *attrs.Version, attrs.SessionID); err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusCreated, thread, nil)
+}
+
+// AddComment is the API handler for POST /notes/:id/comments/:thread-id/replies.
+// It adds a comment to a thread.
+func AddComment(c echo.Context) error {
+	return updateThread(c, permission.COMMENT, func(inst *instance.Instance, file *vfs.FileDoc, threadID string, attrs threadAttributes) (*note.Thread, error) {
+		comment := note.Comment{Author: getCommentAuthor(c, inst), Body: attrs.Body}
+		return note.AddComment(inst, file, threadID, comment, attrs.SessionID)
+	})
+}
+
+// ResolveThread is the API handler for POST
+// /notes/:id/comments/:thread-id/resolve. It marks the thread as resolved.
+func ResolveThread(c echo.Context) error {
+	return updateThread(c, permission.PATCH, func(inst *instance.Instance, file *vfs.FileDoc, threadID string, attrs threadAttributes) (*note.Thread, error) {
+		return note.ResolveThread(inst, file, threadID, attrs.SessionID)
+	})
+}
+
+// ReopenThread is the API handler for POST
+// /notes/:id/comments/:thread-id/reopen. It reopens a resolved thread.
+func ReopenThread(c echo.Context) error {
+	return updateThread(c, permission.PATCH, func(inst *instance.Instance, file *vfs.FileDoc, threadID string, attrs threadAttributes) (*note.Thread, error) {
+		return note.ReopenThread(inst, file, threadID, attrs.SessionID)
+	})
+}
+
+// AcceptSuggestion is the API handler for POST
+// /notes/:id/comments/:thread-id/accept. It applies the suggested change on
+// the note.
+func AcceptSuggestion(c echo.Context) error {
+	return updateThread(c, permission.PATCH, func(inst *instance.Instance, file *vfs.FileDoc, threadID string, attrs threadAttributes) (*note.Thread, error) {
+		return note.AcceptSuggestion(inst, file, threadID, attrs.SessionID)
+	})
+}
+
+// RejectSuggestion is the API handler for POST
+// /notes/:id/comments/:thread-id/reject. It closes the suggestion without
+// changing the note.
+func RejectSuggestion(c echo.Context) error {
+	return updateThread(c, permission.PATCH, func(inst *instance.Instance, file *vfs.FileDoc, threadID string, attrs threadAttributes) (*note.Thread, error) {
+		return note.RejectSuggestion(inst, file, threadID, attrs.SessionID)
+	})
+}
+
+func updateThread(
+	c echo.Context,
+	verb permission.Verb,
+	fn func(*instance.Instance, *vfs.FileDoc, string, threadAttributes) (*note.Thread, error),
+) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, verb, file); err != nil {
+		return err
+	}
+
+	attrs := threadAttributes{}
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return err
+	}
+
+	thread, err := fn(inst, file, c.Param("thread-id"), attrs)
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, thread, nil)
+}
+
+// HistoryNote is the API handler for GET /notes/:id/history. It returns the
+// timeline of the changes on the note.
+func HistoryNote(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	entries, err := note.History(inst, file)
+	if err != nil {
+		return wrapError(err)
+	}
+	if entries == nil {
+		entries = []note.HistoryEntry{}
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// snapshotAttributes are the attributes sent by the client for creating a
+// snapshot of a note, or for restoring a version of a note.
+type snapshotAttributes struct {
+	SessionID string `json:"sessionID"`
+	Name      string `json:"name"`
+	Version   *int64 `json:"version"`
+}
+
+// ListSnapshots is the API handler for GET /notes/:id/snapshots. It returns
+// the named versions of the note.
+func ListSnapshots(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	snapshots, err := note.ListSnapshots(inst, file)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(snapshots))
+	for i, snapshot := range snapshots {
+		objs[i] = snapshot
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// CreateSnapshot is the API handler for POST /notes/:id/snapshots. It saves a
+// version of the note with a name.
+func CreateSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.POST, file); err != nil {
+		return err
+	}
+
+	attrs := snapshotAttributes{}
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return err
+	}
+
+	snapshot, err := note.CreateSnapshot(inst, file, attrs.Name, attrs.Version, getCreatedBy(c))
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, snapshot, nil)
+}
+
+// DeleteSnapshot is the API handler for DELETE
+// /notes/:id/snapshots/:snapshot-id. It removes a snapshot of the note.
+func DeleteSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.DELETE, file); err != nil {
+		return err
+	}
+
+	if err := note.DeleteSnapshot(inst, file, c.Param("snapshot-id")); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DiffNote is the API handler for GET /notes/:id/diff?From=xxx&To=yyy. It
+// returns the changes between two versions of the note.
+func DiffNote(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	from, err := strconv.ParseInt(c.QueryParam("From"), 10, 64)
+	if err != nil {
+		return jsonapi.InvalidParameter("From", err)
+	}
+	to, err := strconv.ParseInt(c.QueryParam("To"), 10, 64)
+	if err != nil {
+		return jsonapi.InvalidParameter("To", err)
+	}
+
+	diff, err := note.GetDiff(inst, file, from, to)
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusOK, diff)
+}
+
+// RestoreNote is the API handler for POST /notes/:id/restore. It brings back
+// a past version of the note, by applying new steps on its last version.
+func RestoreNote(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PATCH, file); err != nil {
+		return err
+	}
+
+	attrs := snapshotAttributes{}
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return err
+	}
+	if attrs.Version == nil {
+		return jsonapi.InvalidAttribute("version", errors.New("The version is missing"))
+	}
+
+	file, err = note.Restore(inst, file, *attrs.Version, attrs.SessionID)
+	if err != nil {
+		return wrapError(err)
+	}
+	return files.FileData(c, http.StatusOK, file, false, nil)
+}
+
 // Routes sets the routing for the collaborative edition of notes.
 func Routes(router *echo.Group) {
 	router.POST("", CreateNote)
+	router.POST("/import", ImportNote)
 	router.GET("/:id", GetNote)
 	router.GET("/:id/steps", GetSteps)
 	router.PATCH("/:id", PatchNote)
 	router.PUT("/:id/title", ChangeTitle)
 	router.PUT("/:id/telepointer", PutTelepointer)
+	router.GET("/:id/export", ExportNote)
+	router.GET("/:id/comments", ListThreads)
+	router.POST("/:id/comments", CreateThread)
+	router.POST("/:id/comments/:thread-id/replies", AddComment)
+	router.POST("/:id/comments/:thread-id/resolve", ResolveThread)
+	router.POST("/:id/comments/:thread-id/reopen", ReopenThread)
+	router.POST("/:id/comments/:thread-id/accept", AcceptSuggestion)
+	router.POST("/:id/comments/:thread-id/reject", RejectSuggestion)
+	router.GET("/:id/history", HistoryNote)
+	router.GET("/:id/snapshots", ListSnapshots)
+	router.POST("/:id/snapshots", CreateSnapshot)
+	router.DELETE("/:id/snapshots/:snapshot-id", DeleteSnapshot)
+	router.GET("/:id/diff", DiffNote)
+	router.POST("/:id/restore", RestoreNote)
 }
 
 func wrapError(err error) *jsonapi.Error {
@@ -213,8 +639,30 @@ func wrapError(err error) *jsonapi.Error {
 		return jsonapi.NotFound(err)
 	case note.ErrNoSteps, note.ErrInvalidSteps:
 		return jsonapi.BadRequest(err)
-	case note.ErrCannotApply:
+	case note.ErrCannotApply, note.ErrThreadClosed:
 		return jsonapi.Conflict(err)
+	case note.ErrUnknownFormat:
+		return jsonapi.InvalidParameter("Format", err)
+	case note.ErrInvalidImport:
+		return jsonapi.InvalidAttribute("file_id", err)
+	case note.ErrTooOld:
+		return jsonapi.PreconditionFailed("version", err)
+	case note.ErrNotFoundThread:
+		return jsonapi.NotFound(err)
+	case note.ErrInvalidThreadKind:
+		return jsonapi.InvalidAttribute("kind", err)
+	case note.ErrInvalidAnchor:
+		return jsonapi.InvalidAttribute("from", err)
+	case note.ErrEmptyComment:
+		return jsonapi.InvalidAttribute("body", err)
+	case note.ErrInvalidSuggestion:
+		return jsonapi.InvalidAttribute("suggestion", err)
+	case note.ErrNotSuggestion:
+		return jsonapi.BadRequest(err)
+	case note.ErrVersionNotFound, note.ErrNotFoundSnapshot:
+		return jsonapi.NotFound(err)
+	case note.ErrEmptySnapshotName:
+		return jsonapi.InvalidAttribute("name", err)
 	case note.ErrInvalidSchema:
 		return jsonapi.InvalidAttribute("id", err)
 	case os.ErrNotExist, vfs.ErrParentDoesNotExist, vfs.ErrParentInTrash:
@@ -225,6 +673,37 @@ func wrapError(err error) *jsonapi.Error {
 	return jsonapi.InternalServerError(err)
 }
 
+// getCommentAuthor returns the name of the author of a comment. It is derived
+// from the permission of the request, and not sent by the client: it is the
+// name of the member for a sharecode of a sharing, and the public name of the
+// instance for its owner. It is empty for an anonymous share by link.
+func getCommentAuthor(c echo.Context, inst *instance.Instance) string {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return ""
+	}
+	switch pdoc.Type {
+	case permission.TypeSharePreview:
+		parts := strings.SplitN(pdoc.SourceID, "/", 2)
+		if len(parts) != 2 || parts[0] != consts.Sharings {
+			return ""
+		}
+		s, err := sharing.FindSharing(inst, parts[1])
+		if err != nil {
+			return ""
+		}
+		m, err := s.FindMemberBySharecode(inst, middlewares.GetRequestToken(c))
+		if err != nil {
+			return ""
+		}
+		return m.PrimaryName()
+	case permission.TypeShareByLink:
+		return ""
+	}
+	name, _ := inst.PublicName()
+	return name
+}
+
 func getCreatedBy(c echo.Context) string {
 	if claims, ok := c.Get("claims").(permission.Claims); ok {
 		switch claims.Audience {
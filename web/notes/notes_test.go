@@ -11,9 +11,11 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/realtime"
@@ -526,6 +528,333 @@ func TestNoteRealtime(t *testing.T) {
 	assert.EqualValues(t, file.Metadata["version"], v5)
 }
 
+func threadRequest(t *testing.T, method, path, body string) (int, map[string]interface{}) {
+	req, _ := http.NewRequest(method, ts.URL+"/notes/"+noteID+path, bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer res.Body.Close()
+	var result map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&result)
+	data, _ := result["data"].(map[string]interface{})
+	attrs, _ := data["attributes"].(map[string]interface{})
+	if attrs != nil {
+		attrs["id"] = data["id"]
+	}
+	return res.StatusCode, attrs
+}
+
+func TestComments(t *testing.T) {
+	file, err := inst.VFS().FileByID(noteID)
+	assert.NoError(t, err)
+	file, err = note.GetFile(inst, file)
+	assert.NoError(t, err)
+	// The content is "HXXello world"
+	v := file.Metadata["version"]
+
+	body := fmt.Sprintf(`{
+  "data": {
+    "type": "io.cozy.notes.comments",
+    "attributes": {
+      "sessionID": "543781490137",
+      "kind": "comment",
+      "version": %v,
+      "from": 9,
+      "to": 14,
+      "author": "Alice",
+      "body": "Maybe with an uppercase?"
+    }
+  }
+}`, v)
+	status, comment := threadRequest(t, "POST", "/comments", body)
+	assert.Equal(t, 201, status)
+	commentID, _ := comment["id"].(string)
+	assert.NotEmpty(t, commentID)
+	assert.Equal(t, "comment", comment["kind"])
+	assert.Equal(t, "open", comment["state"])
+	anchor, _ := comment["anchor"].(map[string]interface{})
+	assert.EqualValues(t, 9, anchor["from"])
+	assert.EqualValues(t, 14, anchor["to"])
+	comments, _ := comment["comments"].([]interface{})
+	if assert.Len(t, comments, 1) {
+		// The author is given by the server, not by the client
+		first, _ := comments[0].(map[string]interface{})
+		publicName, _ := inst.PublicName()
+		assert.Equal(t, publicName, first["author"])
+	}
+
+	body = fmt.Sprintf(`{
+  "data": {
+    "type": "io.cozy.notes.comments",
+    "attributes": {
+      "sessionID": "543781490137",
+      "kind": "suggestion",
+      "version": %v,
+      "from": 2,
+      "to": 4,
+      "author": "Bob"
+    }
+  }
+}`, v)
+	status, suggestion := threadRequest(t, "POST", "/comments", body)
+	assert.Equal(t, 201, status)
+	suggestionID, _ := suggestion["id"].(string)
+	assert.Equal(t, "suggestion", suggestion["kind"])
+
+	body = `{
+  "data": {
+    "type": "io.cozy.notes.comments",
+    "attributes": { "sessionID": "543781490137" }
+  }
+}`
+	status, _ = threadRequest(t, "POST", "/comments/"+commentID+"/accept", body)
+	assert.Equal(t, 400, status)
+	status, suggestion = threadRequest(t, "POST", "/comments/"+suggestionID+"/accept", body)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "accepted", suggestion["state"])
+	assert.Nil(t, suggestion["anchor"])
+	status, _ = threadRequest(t, "POST", "/comments/"+suggestionID+"/reject", body)
+	assert.Equal(t, 409, status)
+
+	reply := `{
+  "data": {
+    "type": "io.cozy.notes.comments",
+    "attributes": {
+      "sessionID": "543781490137",
+      "author": "Bob",
+      "body": "Yes, why not"
+    }
+  }
+}`
+	status, comment = threadRequest(t, "POST", "/comments/"+commentID+"/replies", reply)
+	assert.Equal(t, 200, status)
+	comments, _ = comment["comments"].([]interface{})
+	assert.Len(t, comments, 2)
+	status, comment = threadRequest(t, "POST", "/comments/"+commentID+"/resolve", body)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "resolved", comment["state"])
+
+	req, _ := http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/comments", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].([]interface{})
+	if !assert.Len(t, data, 2) {
+		return
+	}
+	first, _ := data[0].(map[string]interface{})
+	assert.Equal(t, commentID, first["id"])
+	attrs, _ := first["attributes"].(map[string]interface{})
+	assert.Equal(t, "resolved", attrs["state"])
+	// The anchor has been remapped after the deletion of "XX"
+	anchor, _ = attrs["anchor"].(map[string]interface{})
+	assert.EqualValues(t, 7, anchor["from"])
+	assert.EqualValues(t, 12, anchor["to"])
+
+	err = note.Update(inst, noteID)
+	assert.NoError(t, err)
+	doc, err := inst.VFS().FileByID(noteID)
+	assert.NoError(t, err)
+	f, err := inst.VFS().OpenFile(doc)
+	assert.NoError(t, err)
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello world", string(buf))
+}
+
+func TestExportNote(t *testing.T) {
+	req, _ := http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/export?Format=html", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "text/html", res.Header.Get("Content-Type"))
+	assert.Contains(t, res.Header.Get("Content-Disposition"), `filename="A very new title.html"`)
+	buf, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), "<title>A very new title</title>")
+	assert.Contains(t, string(buf), "<p>Hello world</p>")
+
+	req2, _ := http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/export?Format=docx", nil)
+	req2.Header.Add("Authorization", "Bearer "+token)
+	res2, err := http.DefaultClient.Do(req2)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res2.StatusCode)
+	buf2, err := ioutil.ReadAll(res2.Body)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(buf2, []byte("PK")))
+
+	req3, _ := http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/export?Format=pdf", nil)
+	req3.Header.Add("Authorization", "Bearer "+token)
+	res3, err := http.DefaultClient.Do(req3)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res3.StatusCode)
+}
+
+func TestImportNote(t *testing.T) {
+	md := "# Imported\n\nSome **bold** text\n\n- one\n- two\n"
+	src, err := vfs.NewFileDoc("imported.md", consts.RootDirID, -1, nil, "text/markdown", "text", time.Now(), false, false, nil)
+	assert.NoError(t, err)
+	f, err := inst.VFS().CreateFile(src, nil)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(md))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	existing, err := inst.VFS().FileByID(noteID)
+	assert.NoError(t, err)
+	schema, _ := json.Marshal(existing.Metadata["schema"])
+	body := fmt.Sprintf(`{
+  "data": {
+    "type": "io.cozy.notes.documents",
+    "attributes": {
+      "file_id": "%s",
+      "schema": %s
+    }
+  }
+}`, src.ID(), schema)
+	req, _ := http.NewRequest("POST", ts.URL+"/notes/import", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	attrs, _ := data["attributes"].(map[string]interface{})
+	assert.Equal(t, "imported.cozy-note", attrs["name"])
+	meta, _ := attrs["metadata"].(map[string]interface{})
+	assert.Equal(t, "imported", meta["title"])
+	assert.EqualValues(t, 0, meta["version"])
+
+	id, _ := data["id"].(string)
+	doc, err := inst.VFS().FileByID(id)
+	assert.NoError(t, err)
+	file, err := inst.VFS().OpenFile(doc)
+	assert.NoError(t, err)
+	defer file.Close()
+	buf, err := ioutil.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "# Imported\n\nSome **bold** text\n\n* one\n\n* two", string(buf))
+}
+
+func TestHistory(t *testing.T) {
+	file, err := inst.VFS().FileByID(noteID)
+	assert.NoError(t, err)
+	file, err = note.GetFile(inst, file)
+	assert.NoError(t, err)
+	// The content is "Hello world"
+	v, _ := file.Metadata["version"].(int64)
+
+	body := `{
+  "data": {
+    "type": "io.cozy.notes.snapshots",
+    "attributes": { "name": "Before the exclamation" }
+  }
+}`
+	status, snapshot := threadRequest(t, "POST", "/snapshots", body)
+	assert.Equal(t, 201, status)
+	snapshotID, _ := snapshot["id"].(string)
+	assert.NotEmpty(t, snapshotID)
+	assert.Equal(t, "Before the exclamation", snapshot["name"])
+	assert.EqualValues(t, v, snapshot["version"])
+
+	body = `{
+  "data": [{
+    "type": "io.cozy.notes.steps",
+    "attributes": {
+      "sessionID": "543781490137",
+      "stepType": "replace",
+      "from": 12,
+      "to": 12,
+      "slice": {
+        "content": [{ "type": "text", "text": "!" }]
+      }
+    }
+  }]
+}`
+	req, _ := http.NewRequest("PATCH", ts.URL+"/notes/"+noteID, bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("If-Match", fmt.Sprintf("%d", v))
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("%s/notes/%s/diff?From=%d&To=%d", ts.URL, noteID, v, v+1), nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var diff note.Diff
+	err = json.NewDecoder(res.Body).Decode(&diff)
+	assert.NoError(t, err)
+	expected := []note.DiffChunk{
+		{Op: note.DiffDelete, Text: "Hello world"},
+		{Op: note.DiffInsert, Text: "Hello world!"},
+	}
+	assert.Equal(t, expected, diff.Changes)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/history", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var history []note.HistoryEntry
+	err = json.NewDecoder(res.Body).Decode(&history)
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, history) {
+		assert.Equal(t, note.StepsEntry, history[0].Kind)
+		assert.Equal(t, v+1, history[0].Version)
+		assert.Equal(t, "543781490137", history[0].SessionID)
+	}
+	found := false
+	for _, entry := range history {
+		if entry.Kind == note.SnapshotEntry && entry.ID == snapshotID {
+			found = true
+			assert.Equal(t, v, entry.Version)
+		}
+	}
+	assert.True(t, found)
+
+	body = fmt.Sprintf(`{
+  "data": {
+    "type": "io.cozy.notes.documents",
+    "attributes": { "sessionID": "543781490137", "version": %d }
+  }
+}`, v)
+	status, restored := threadRequest(t, "POST", "/restore", body)
+	assert.Equal(t, 200, status)
+	meta, _ := restored["metadata"].(map[string]interface{})
+	assert.EqualValues(t, v+2, meta["version"])
+
+	err = note.Update(inst, noteID)
+	assert.NoError(t, err)
+	doc, err := inst.VFS().FileByID(noteID)
+	assert.NoError(t, err)
+	f, err := inst.VFS().OpenFile(doc)
+	assert.NoError(t, err)
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello world", string(buf))
+
+	status, _ = threadRequest(t, "DELETE", "/snapshots/"+snapshotID, "")
+	assert.Equal(t, 204, status)
+	status, _ = threadRequest(t, "DELETE", "/snapshots/"+snapshotID, "")
+	assert.Equal(t, 404, status)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	testutils.NeedCouchdb()
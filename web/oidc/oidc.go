@@ -108,8 +108,8 @@ func Login(c echo.Context) error {
 		return renderError(c, inst, http.StatusBadRequest, "Sorry, the cozy was not found.")
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
-		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+	if inst.HasTwoFactor() {
+		twoFactorToken, err := lifecycle.StartTwoFactor(inst, "")
 		if err != nil {
 			return err
 		}
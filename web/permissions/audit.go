@@ -0,0 +1,149 @@
+package permissions
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+const defaultAuditEntriesListed = 100
+
+func getAuditSettings(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.PermissionsAudit); err != nil {
+		return err
+	}
+	settings := inst.PermissionsAudit
+	if settings == nil {
+		settings = &permission.AuditSettings{}
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+func updateAuditSettings(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.PermissionsAudit); err != nil {
+		return err
+	}
+	var settings permission.AuditSettings
+	if err := json.NewDecoder(c.Request().Body).Decode(&settings); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if old := inst.PermissionsAudit; old != nil {
+		settings.TriggerID = old.TriggerID
+	}
+	if err := updateAuditTrigger(inst, &settings); err != nil {
+		return err
+	}
+	if err := lifecycle.Patch(inst, &lifecycle.Options{PermissionsAudit: &settings}); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// updateAuditTrigger adds the trigger that purges the old entries of the
+// audit trail when it is enabled, and removes it when it is disabled.
+func updateAuditTrigger(inst *instance.Instance, settings *permission.AuditSettings) error {
+	sched := job.System()
+	if !settings.Enabled {
+		if settings.TriggerID != "" {
+			err := sched.DeleteTrigger(inst, settings.TriggerID)
+			if err != nil && err != job.ErrNotFoundTrigger {
+				return err
+			}
+			settings.TriggerID = ""
+		}
+		return nil
+	}
+	if settings.TriggerID != "" {
+		if _, err := sched.GetTrigger(inst, settings.TriggerID); err == nil {
+			return nil
+		}
+	}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Domain:     inst.ContextualDomain(),
+		Type:       "@every",
+		WorkerType: "permissions-audit-purge",
+		Arguments:  "24h",
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err = sched.AddTrigger(t); err != nil {
+		return err
+	}
+	settings.TriggerID = t.ID()
+	return nil
+}
+
+func exportAudit(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.PermissionsAudit); err != nil {
+		return err
+	}
+
+	filter := permission.AuditFilter{
+		PermissionID: c.QueryParam("permission_id"),
+		SourceID:     c.QueryParam("source_id"),
+	}
+	var err error
+	if filter.Since, err = parseAuditDate(c.QueryParam("since")); err != nil {
+		return jsonapi.InvalidParameter("since", err)
+	}
+	if filter.Until, err = parseAuditDate(c.QueryParam("until")); err != nil {
+		return jsonapi.InvalidParameter("until", err)
+	}
+	limit := defaultAuditEntriesListed
+	if param := c.QueryParam("page[limit]"); param != "" {
+		if limit, err = strconv.Atoi(param); err != nil {
+			return jsonapi.InvalidParameter("page[limit]", err)
+		}
+	}
+
+	entries, next, err := permission.ListAuditEntries(inst, filter, c.QueryParam("page[cursor]"), limit)
+	if err != nil {
+		return err
+	}
+
+	links := &jsonapi.LinksList{}
+	if next != "" {
+		params := c.QueryParams()
+		query := url.Values{}
+		for k, v := range params {
+			query[k] = v
+		}
+		query.Set("page[cursor]", next)
+		links.Next = "/permissions/audit?" + query.Encode()
+	}
+
+	out := make([]jsonapi.Object, len(entries))
+	for i, e := range entries {
+		out[i] = e
+	}
+	return jsonapi.DataList(c, http.StatusOK, out, links)
+}
+
+func parseAuditDate(param string) (*time.Time, error) {
+	if param == "" {
+		return nil, nil
+	}
+	date, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
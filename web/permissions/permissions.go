@@ -371,6 +371,10 @@ func Routes(router *echo.Group) {
 
 	router.GET("/doctype/:doctype/shared-by-link", listByLinkPermissionsByDoctype)
 
+	router.GET("/audit", exportAudit)
+	router.GET("/audit/settings", getAuditSettings)
+	router.PUT("/audit/settings", updateAuditSettings)
+
 	// Legacy routes, kept here for compatibility reasons
 	router.GET("/doctype/:doctype/sharedByLink", listByLinkPermissionsByDoctype)
 }
@@ -128,6 +128,7 @@ func updateInstanceAuthMode(c echo.Context) error {
 	}
 
 	args := struct {
+		twoFactorCredentials
 		AuthMode                string `json:"auth_mode"`
 		TwoFactorActivationCode string `json:"two_factor_activation_code"`
 	}{}
@@ -155,6 +156,16 @@ func updateInstanceAuthMode(c echo.Context) error {
 		if ok := inst.ValidateMailConfirmationCode(args.TwoFactorActivationCode); !ok {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
+	case instance.TwoFactorTOTP, instance.TwoFactorWebAuthn:
+		if err = allowTwoFactorChange(c); err != nil {
+			return err
+		}
+		if err = checkTwoFactorCredentials(inst, &args.twoFactorCredentials); err != nil {
+			return err
+		}
+		if err = lifecycle.CheckTwoFactorEnrolled(inst, authMode); err != nil {
+			return jsonapi.InvalidParameter("auth_mode", err)
+		}
 	}
 
 	err = lifecycle.Patch(inst, &lifecycle.Options{AuthMode: args.AuthMode})
@@ -174,16 +174,24 @@ func updatePassphrase(c echo.Context) error {
 	newPassphrase := []byte(args.Passphrase)
 	currentPassphrase := []byte(args.Current)
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && len(args.TwoFactorToken) == 0 {
+	if inst.HasTwoFactor() && len(args.TwoFactorToken) == 0 {
 		if lifecycle.CheckPassphrase(inst, currentPassphrase) == nil {
 			var twoFactorToken []byte
-			twoFactorToken, err = lifecycle.SendTwoFactorPasscode(inst)
+			twoFactorToken, err = lifecycle.StartTwoFactor(inst, "")
 			if err != nil {
 				return err
 			}
-			return c.JSON(http.StatusOK, echo.Map{
-				"two_factor_token": twoFactorToken,
-			})
+			result := echo.Map{
+				"two_factor_token":  twoFactorToken,
+				"two_factor_method": lifecycle.DefaultTwoFactorMethod(inst),
+			}
+			if inst.HasAuthMode(instance.TwoFactorWebAuthn) {
+				result["webauthn_options"], err = inst.WebAuthnRequestOptions(twoFactorToken)
+				if err != nil {
+					return err
+				}
+			}
+			return c.JSON(http.StatusOK, result)
 		}
 		return instance.ErrInvalidPassphrase
 	}
@@ -16,7 +16,8 @@ import (
 )
 
 type apiSession struct {
-	s *session.Session
+	s       *session.Session
+	current bool
 }
 
 func (s *apiSession) ID() string                             { return s.s.ID() }
@@ -28,7 +29,12 @@ func (s *apiSession) SetRev(_ string)                        {}
 func (s *apiSession) Relationships() jsonapi.RelationshipMap { return nil }
 func (s *apiSession) Included() []jsonapi.Object             { return nil }
 func (s *apiSession) Links() *jsonapi.LinksList              { return nil }
-func (s *apiSession) MarshalJSON() ([]byte, error)           { return json.Marshal(s.s) }
+func (s *apiSession) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*session.Session
+		Current bool `json:"current,omitempty"`
+	}{s.s, s.current})
+}
 
 func getSessions(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
@@ -42,14 +48,42 @@ func getSessions(c echo.Context) error {
 		return err
 	}
 
+	var currentID string
+	if current, ok := middlewares.GetSession(c); ok {
+		currentID = current.ID()
+	}
 	objs := make([]jsonapi.Object, len(sessions))
 	for i, s := range sessions {
-		objs[i] = &apiSession{s}
+		objs[i] = &apiSession{s, s.ID() == currentID}
 	}
 
 	return jsonapi.DataList(c, http.StatusOK, objs, nil)
 }
 
+func revokeSession(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.Sessions); err != nil {
+		return err
+	}
+
+	sessionID := c.Param("id")
+	if current, ok := middlewares.GetSession(c); ok && current.ID() == sessionID {
+		// Revoking the current session is the same thing as a logout
+		c.SetCookie(current.Delete(inst))
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	err := session.Revoke(inst, sessionID)
+	if err == session.ErrInvalidID {
+		return jsonapi.NotFound(err)
+	}
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func warnings(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 
@@ -90,9 +124,20 @@ func Routes(router *echo.Group) {
 	router.PUT("/instance/auth_mode", updateInstanceAuthMode)
 	router.PUT("/instance/sign_tos", updateInstanceTOS)
 
+	router.GET("/two_factor", getTwoFactor)
+	router.POST("/two_factor/challenge", twoFactorChallenge)
+	router.POST("/two_factor/totp", enrollTOTP)
+	router.PUT("/two_factor/totp", confirmTOTP)
+	router.DELETE("/two_factor/totp", removeTOTP)
+	router.POST("/two_factor/webauthn/options", webAuthnCreationOptions)
+	router.POST("/two_factor/webauthn", addWebAuthnCredential)
+	router.DELETE("/two_factor/webauthn/:id", removeWebAuthnCredential)
+	router.POST("/two_factor/recovery_codes", regenerateRecoveryCodes)
+
 	router.GET("/flags", getFlags)
 
 	router.GET("/sessions", getSessions)
+	router.DELETE("/sessions/:id", revokeSession)
 
 	router.GET("/clients", listClients)
 	router.DELETE("/clients/:id", revokeClient)
@@ -34,6 +34,8 @@ var tsB *httptest.Server
 var testInstance *instance.Instance
 var instanceRev string
 var token string
+var sessionsToken string
+var otherInstance *instance.Instance
 var oauthClientID string
 
 func TestPatchWithGoodRev(t *testing.T) {
@@ -484,6 +486,146 @@ func TestUpdatePassphraseWithTwoFactorAuth(t *testing.T) {
 	assert.Equal(t, "204 No Content", res.Status)
 }
 
+func TestRegenerateRecoveryCodes(t *testing.T) {
+	// A permission on the settings is not enough to change the second factors
+	args, _ := json.Marshal(&echo.Map{
+		"passphrase": "MyLastPassphrase",
+	})
+	req, _ := http.NewRequest("POST", ts.URL+"/settings/two_factor/recovery_codes", bytes.NewReader(args))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "403 Forbidden", res.Status)
+
+	// The passphrase and the current second factor are required
+	req, _ = http.NewRequest("POST", tsB.URL+"/settings/two_factor/recovery_codes", bytes.NewReader(args))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "403 Forbidden", res.Status)
+
+	req, _ = http.NewRequest("POST", tsB.URL+"/settings/two_factor/challenge", bytes.NewReader(args))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "200 OK", res.Status)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, "mail", result["two_factor_method"])
+	assert.NotEmpty(t, result["two_factor_token"])
+
+	twoFactorToken, twoFactorPasscode, err := testInstance.GenerateTwoFactorSecrets()
+	assert.NoError(t, err)
+	args, _ = json.Marshal(&echo.Map{
+		"passphrase":          "MyLastPassphrase",
+		"two_factor_token":    twoFactorToken,
+		"two_factor_passcode": "000000",
+	})
+	req, _ = http.NewRequest("POST", tsB.URL+"/settings/two_factor/recovery_codes", bytes.NewReader(args))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "403 Forbidden", res.Status)
+
+	args, _ = json.Marshal(&echo.Map{
+		"passphrase":          "MyLastPassphrase",
+		"two_factor_token":    twoFactorToken,
+		"two_factor_passcode": twoFactorPasscode,
+	})
+	req, _ = http.NewRequest("POST", tsB.URL+"/settings/two_factor/recovery_codes", bytes.NewReader(args))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "200 OK", res.Status)
+	var codes struct {
+		Codes []string `json:"codes"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&codes)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, codes.Codes)
+}
+
+func TestRevokeSession(t *testing.T) {
+	sess, err := session.New(testInstance, false, nil)
+	assert.NoError(t, err)
+
+	// A permission on the sessions is required
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/settings/sessions/"+sess.ID(), nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "403 Forbidden", res.Status)
+
+	// Revoke another session
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/settings/sessions/"+sess.ID(), nil)
+	req.Header.Add("Authorization", "Bearer "+sessionsToken)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "204 No Content", res.Status)
+	_, err = session.Get(testInstance, sess.ID())
+	assert.Equal(t, session.ErrInvalidID, err)
+
+	// The session no longer exists
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/settings/sessions/"+sess.ID(), nil)
+	req.Header.Add("Authorization", "Bearer "+sessionsToken)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "404 Not Found", res.Status)
+
+	// A session of another instance can't be revoked
+	other, err := session.New(otherInstance, false, nil)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/settings/sessions/"+other.ID(), nil)
+	req.Header.Add("Authorization", "Bearer "+sessionsToken)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "404 Not Found", res.Status)
+	_, err = session.Get(otherInstance, other.ID())
+	assert.NoError(t, err)
+}
+
+func TestRevokeCurrentSession(t *testing.T) {
+	sess, err := session.New(testInstance, false, nil)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodDelete, "/settings/sessions/"+sess.ID(), nil)
+	req.Header.Add("Authorization", "Bearer "+sessionsToken)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("instance", testInstance)
+	c.Set("session", sess)
+	c.SetParamNames("id")
+	c.SetParamValues(sess.ID())
+	err = revokeSession(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// The session is deleted, and the cookie is cleared like for a logout
+	_, err = session.Get(testInstance, sess.ID())
+	assert.Equal(t, session.ErrInvalidID, err)
+	cookies := rec.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, session.SessionCookieName, cookies[0].Name)
+		assert.Empty(t, cookies[0].Value)
+		assert.True(t, cookies[0].MaxAge < 0)
+	}
+}
+
 func TestListClients(t *testing.T) {
 	res, err := http.Get(ts.URL + "/settings/clients")
 	assert.NoError(t, err)
@@ -847,6 +989,14 @@ func TestMain(m *testing.M) {
 	})
 	scope := consts.Settings + " " + consts.OAuthClients
 	_, token = setup.GetTestClient(scope)
+	_, sessionsToken = setup.GetTestClient(consts.Sessions)
+
+	otherSetup := testutils.NewSetup(m, "settings_test_other")
+	otherInstance = otherSetup.GetTestInstance()
+	setup.AddCleanup(func() error {
+		otherSetup.Cleanup()
+		return nil
+	})
 
 	ts = setup.GetTestServer("/settings", Routes)
 	ts.Config.Handler.(*echo.Echo).HTTPErrorHandler = errors.ErrorHandler
@@ -870,7 +1020,7 @@ func TestMain(m *testing.M) {
 func fakeAuthentication(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		instance := c.Get("instance").(*instance.Instance)
-		sess, _ := session.New(instance, true)
+		sess, _ := session.New(instance, true, nil)
 		c.Set("session", sess)
 		return next(c)
 	}
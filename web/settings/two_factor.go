@@ -0,0 +1,353 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/webauthn"
+	"github.com/cozy/cozy-stack/web/auth"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// qrCodeSize is the size in pixels of the QR code for the TOTP enrollment.
+const qrCodeSize = 256
+
+type apiWebAuthnCredential struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAPIWebAuthnCredential(cred *instance.WebAuthnCredential) apiWebAuthnCredential {
+	return apiWebAuthnCredential{
+		ID:        base64.RawURLEncoding.EncodeToString(cred.ID),
+		Name:      cred.Name,
+		CreatedAt: cred.CreatedAt,
+	}
+}
+
+func wrapTwoFactorError(err error) error {
+	switch err {
+	case instance.ErrInvalidTwoFactor, instance.ErrTwoFactorNotEnrolled,
+		webauthn.ErrInvalidClientData, webauthn.ErrInvalidAuthData,
+		webauthn.ErrInvalidAttestation, webauthn.ErrUserNotPresent,
+		webauthn.ErrUnsupportedKey:
+		return jsonapi.NewError(http.StatusUnprocessableEntity, err.Error())
+	case instance.ErrTwoFactorInUse, instance.ErrWebAuthnCredentialExists:
+		return jsonapi.Conflict(err)
+	case instance.ErrWebAuthnCredentialNotFound:
+		return jsonapi.NotFound(err)
+	}
+	return err
+}
+
+// twoFactorCredentials are the credentials that must be sent to change the
+// second factors of the instance: the passphrase (hashed by the client), and
+// the current second factor when the authentication mode requires one.
+type twoFactorCredentials struct {
+	Passphrase        string `json:"passphrase"`
+	TwoFactorToken    []byte `json:"two_factor_token"`
+	TwoFactorMethod   string `json:"two_factor_method"`
+	TwoFactorPasscode string `json:"two_factor_passcode"`
+}
+
+// allowTwoFactorChange checks that the request can change the second factors
+// of the instance. Having a permission on the settings is not enough: the
+// request must come from the settings application, or from a logged-in
+// session.
+func allowTwoFactorChange(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	if middlewares.IsLoggedIn(c) {
+		return nil
+	}
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if pdoc.Type != permission.TypeWebapp || pdoc.SourceID != consts.Apps+"/"+consts.SettingsSlug {
+		return middlewares.ErrForbidden
+	}
+	return nil
+}
+
+// checkPassphrase checks the passphrase sent to change the second factors.
+// The failed attempts are counted like the failed logins.
+func checkPassphrase(inst *instance.Instance, passphrase string) error {
+	if lifecycle.CheckPassphrase(inst, []byte(passphrase)) == nil {
+		return nil
+	}
+	err := limits.CheckRateLimit(inst, limits.AuthType)
+	if limits.IsLimitReachedOrExceeded(err) {
+		if err = auth.LoginRateExceeded(inst); err != nil {
+			inst.Logger().WithField("nspace", "settings").Warning(err)
+		}
+	}
+	return jsonapi.Forbidden(instance.ErrInvalidPassphrase)
+}
+
+// checkTwoFactorCredentials checks the passphrase and the current second
+// factor sent to change the second factors of the instance.
+func checkTwoFactorCredentials(inst *instance.Instance, creds *twoFactorCredentials) error {
+	if err := checkPassphrase(inst, creds.Passphrase); err != nil {
+		return err
+	}
+	if !inst.HasTwoFactor() {
+		return nil
+	}
+	if lifecycle.CheckTwoFactor(inst, creds.TwoFactorToken, creds.TwoFactorMethod, creds.TwoFactorPasscode) {
+		return nil
+	}
+	err := limits.CheckRateLimit(inst, limits.TwoFactorType)
+	if limits.IsLimitReachedOrExceeded(err) {
+		if err = auth.TwoFactorRateExceeded(inst); err != nil {
+			inst.Logger().WithField("nspace", "settings").Warning(err)
+		}
+	}
+	return jsonapi.Forbidden(instance.ErrInvalidTwoFactor)
+}
+
+func getTwoFactor(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+
+	keys := make([]apiWebAuthnCredential, len(inst.WebAuthnCredentials))
+	for i := range inst.WebAuthnCredentials {
+		keys[i] = newAPIWebAuthnCredential(&inst.WebAuthnCredentials[i])
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"auth_mode":      instance.AuthModeToString(inst.AuthMode),
+		"totp":           inst.TOTPSecret != "",
+		"webauthn":       keys,
+		"recovery_codes": len(inst.RecoveryCodes),
+	})
+}
+
+func twoFactorChallenge(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	args := struct {
+		Passphrase string `json:"passphrase"`
+		Method     string `json:"two_factor_method"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkPassphrase(inst, args.Passphrase); err != nil {
+		return err
+	}
+	if !inst.HasTwoFactor() {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	method := args.Method
+	if method == "" {
+		method = lifecycle.DefaultTwoFactorMethod(inst)
+	}
+	token, err := lifecycle.StartTwoFactor(inst, method)
+	if err != nil {
+		return err
+	}
+	result := echo.Map{
+		"two_factor_token":  token,
+		"two_factor_method": method,
+	}
+	if method == lifecycle.TwoFactorMethodWebAuthn {
+		result["webauthn_options"], err = inst.WebAuthnRequestOptions(token)
+		if err != nil {
+			return wrapTwoFactorError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func enrollTOTP(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	key, err := lifecycle.EnrollTOTP(inst)
+	if err != nil {
+		return err
+	}
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return err
+	}
+	qrCode, err := encodeQRCode(img)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"secret":  key.Secret(),
+		"url":     key.URL(),
+		"qr_code": qrCode,
+	})
+}
+
+func confirmTOTP(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	args := struct {
+		twoFactorCredentials
+		Passcode string `json:"passcode"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkTwoFactorCredentials(inst, &args.twoFactorCredentials); err != nil {
+		return err
+	}
+	if err := lifecycle.ConfirmTOTP(inst, args.Passcode); err != nil {
+		return wrapTwoFactorError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func removeTOTP(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	var creds twoFactorCredentials
+	if err := c.Bind(&creds); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkTwoFactorCredentials(inst, &creds); err != nil {
+		return err
+	}
+	if err := lifecycle.RemoveTOTP(inst); err != nil {
+		return wrapTwoFactorError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func webAuthnCreationOptions(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	token, err := inst.GenerateTwoFactorToken()
+	if err != nil {
+		return err
+	}
+	options, err := inst.WebAuthnCreationOptions(token)
+	if err != nil {
+		return wrapTwoFactorError(err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"token":   token,
+		"options": options,
+	})
+}
+
+func addWebAuthnCredential(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	args := struct {
+		twoFactorCredentials
+		Token             []byte `json:"token"`
+		Name              string `json:"name"`
+		ClientDataJSON    string `json:"client_data_json"`
+		AttestationObject string `json:"attestation_object"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkTwoFactorCredentials(inst, &args.twoFactorCredentials); err != nil {
+		return err
+	}
+	clientData, err := webauthn.Decode(args.ClientDataJSON)
+	if err != nil {
+		return jsonapi.InvalidParameter("client_data_json", err)
+	}
+	attestation, err := webauthn.Decode(args.AttestationObject)
+	if err != nil {
+		return jsonapi.InvalidParameter("attestation_object", err)
+	}
+
+	cred, err := lifecycle.AddWebAuthnCredential(inst, args.Token, args.Name, clientData, attestation)
+	if err != nil {
+		return wrapTwoFactorError(err)
+	}
+	return c.JSON(http.StatusCreated, newAPIWebAuthnCredential(cred))
+}
+
+func removeWebAuthnCredential(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	id, err := webauthn.Decode(c.Param("id"))
+	if err != nil {
+		return jsonapi.InvalidParameter("id", err)
+	}
+	var creds twoFactorCredentials
+	if err := c.Bind(&creds); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkTwoFactorCredentials(inst, &creds); err != nil {
+		return err
+	}
+	if err := lifecycle.RemoveWebAuthnCredential(inst, id); err != nil {
+		return wrapTwoFactorError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func regenerateRecoveryCodes(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := allowTwoFactorChange(c); err != nil {
+		return err
+	}
+
+	var creds twoFactorCredentials
+	if err := c.Bind(&creds); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkTwoFactorCredentials(inst, &creds); err != nil {
+		return err
+	}
+	codes, err := lifecycle.RegenerateRecoveryCodes(inst)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"codes": codes})
+}
+
+// encodeQRCode returns the QR code as a data URL, to be used as the src of an
+// img element.
+func encodeQRCode(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
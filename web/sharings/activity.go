@@ -0,0 +1,53 @@
+package sharings
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type activityResponse struct {
+	Members    []*sharing.MemberSyncStatus `json:"members"`
+	Activities []*sharing.Activity         `json:"activities"`
+}
+
+// GetActivity returns the sync status of the members of the sharing, and the
+// last entries of its activity journal
+func GetActivity(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	limit := sharing.DefaultActivitiesLimit
+	if param := c.QueryParam("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil {
+			return jsonapi.InvalidParameter("limit", err)
+		}
+		if limit <= 0 {
+			return jsonapi.InvalidParameter("limit", errors.New("The limit must be positive"))
+		}
+	}
+	statuses, err := s.SyncStatuses(inst)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	activities, err := sharing.ListActivities(inst, s.SID, limit)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	return c.JSON(http.StatusOK, &activityResponse{
+		Members:    statuses,
+		Activities: activities,
+	})
+}
@@ -0,0 +1,81 @@
+package sharings
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// ListConflicts returns the conflicts that have happened on this cozy for the
+// files of the sharing
+func ListConflicts(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	conflicts, err := sharing.ListConflicts(inst, s.SID, c.QueryParam("state"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	objs := make([]jsonapi.Object, len(conflicts))
+	for i, conflict := range conflicts {
+		objs[i] = conflict
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// GetConflict returns a conflict of the sharing
+func GetConflict(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	conflict, err := sharing.FindConflict(inst, s.SID, c.Param("conflict-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, conflict, nil)
+}
+
+type resolutionAttributes struct {
+	Resolution string `json:"resolution"`
+}
+
+// ResolveConflict is used by the user to tell how a conflict on the files of
+// the sharing should be resolved
+func ResolveConflict(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = checkCreatePermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	conflict, err := sharing.FindConflict(inst, s.SID, c.Param("conflict-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	var attrs resolutionAttributes
+	if _, err = jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err = s.ResolveConflict(inst, conflict, attrs.Resolution); err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, conflict, nil)
+}
@@ -0,0 +1,69 @@
+package sharings
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type expirationAttributes struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// SetExpiration is used to change the expiration date of a sharing. A null
+// date means that the sharing will no longer expire.
+func SetExpiration(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = checkCreatePermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	var attrs expirationAttributes
+	if _, err = jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err = s.SetExpiry(inst, attrs.ExpiresAt); err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapiSharingWithDocs(c, s)
+}
+
+// SetRecipientExpiration is used to change the expiration date of the access
+// of a recipient to a sharing. A null date means that the access will no
+// longer expire.
+func SetRecipientExpiration(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = checkCreatePermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		return jsonapi.InvalidParameter("index", err)
+	}
+	if index <= 0 || index >= len(s.Members) {
+		return jsonapi.InvalidParameter("index", errors.New("Invalid index"))
+	}
+	var attrs expirationAttributes
+	if _, err = jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err = s.SetMemberExpiry(inst, index, attrs.ExpiresAt); err != nil {
+		return wrapErrors(err)
+	}
+	go s.NotifyRecipients(inst, nil)
+	return jsonapiSharingWithDocs(c, s)
+}
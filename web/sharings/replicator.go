@@ -55,7 +55,11 @@ func BulkDocs(c echo.Context) error {
 		inst.Logger().WithField("nspace", "replicator").Infof("No bulk docs")
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
-	err = s.ApplyBulkDocs(inst, docs)
+	member, err := requestMember(c, s)
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Member was not found: %s", err)
+	}
+	err = s.ApplyBulkDocs(inst, member, docs)
 	if err != nil {
 		inst.Logger().WithField("nspace", "replicator").Infof("Error on apply: %s", err)
 		return wrapErrors(err)
@@ -105,7 +109,11 @@ func SyncFile(c echo.Context) error {
 		err = errors.New("The identifiers in the URL and in the doc are not the same")
 		return jsonapi.InvalidAttribute("id", err)
 	}
-	key, err := s.SyncFile(inst, fileDoc)
+	member, err := requestMember(c, s)
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Member was not found: %s", err)
+	}
+	key, err := s.SyncFile(inst, member, fileDoc)
 	if err != nil {
 		inst.Logger().WithField("nspace", "replicator").Infof("Error on sync file: %s", err)
 		return wrapErrors(err)
@@ -125,7 +133,11 @@ func FileHandler(c echo.Context) error {
 		inst.Logger().WithField("nspace", "replicator").Infof("Sharing was not found: %s", err)
 		return wrapErrors(err)
 	}
-	if err := s.HandleFileUpload(inst, c.Param("id"), c.Request().Body); err != nil {
+	member, err := requestMember(c, s)
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Member was not found: %s", err)
+	}
+	if err := s.HandleFileUpload(inst, member, c.Param("id"), c.Request().Body); err != nil {
 		inst.Logger().WithField("nspace", "replicator").Infof("Error on file upload: %s", err)
 		return wrapErrors(err)
 	}
@@ -171,10 +183,37 @@ func checkSharingReadPermissions(next echo.HandlerFunc) echo.HandlerFunc {
 				Infof("Not allowed (%s)", sharingID)
 			return echo.NewHTTPError(http.StatusForbidden)
 		}
+		if err := checkSharingNotExpired(c, sharingID, requestPerm.SourceID); err != nil {
+			return err
+		}
 		return next(c)
 	}
 }
 
+// checkSharingNotExpired refuses the requests of a recipient, on the owner's
+// cozy, when the sharing or the access of the recipient has expired. The
+// revocation notifications are still accepted.
+func checkSharingNotExpired(c echo.Context, sharingID, clientID string) error {
+	if c.Request().Method == http.MethodDelete {
+		return nil
+	}
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil || !s.Owner {
+		return nil
+	}
+	m, err := s.FindMemberByInboundClientID(clientID)
+	if err != nil {
+		return nil
+	}
+	if s.AccessExpired(m) {
+		inst.Logger().WithField("nspace", "replicator").
+			Infof("Access has expired (%s)", sharingID)
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	return nil
+}
+
 func checkSharingWritePermissions(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if err := hasSharingWritePermissions(c); err != nil {
@@ -197,7 +236,7 @@ func hasSharingWritePermissions(c echo.Context) error {
 			Infof("Not allowed (%s)", sharingID)
 		return echo.NewHTTPError(http.StatusForbidden)
 	}
-	return nil
+	return checkSharingNotExpired(c, sharingID, requestPerm.SourceID)
 }
 
 func checkSharingPermissions(next echo.HandlerFunc) echo.HandlerFunc {
@@ -496,6 +496,8 @@ func Routes(router *echo.Group) {
 	router.POST("/:sharing-id/recipients/self/readonly", DowngradeToReadOnly, checkSharingWritePermissions)  // On the recipient
 	router.DELETE("/:sharing-id/recipients/:index/readonly", RemoveReadOnly)                                 // On the sharer
 	router.DELETE("/:sharing-id/recipients/self/readonly", UpgradeToReadWrite, checkSharingWritePermissions) // On the recipient
+	router.PUT("/:sharing-id/expiration", SetExpiration)                                                     // On the sharer
+	router.PUT("/:sharing-id/recipients/:index/expiration", SetRecipientExpiration)                          // On the sharer
 	router.DELETE("/:sharing-id", RevocationRecipientNotif, checkSharingWritePermissions)                    // On the recipient
 	router.DELETE("/:sharing-id/recipients/self", RevokeRecipientBySelf)                                     // On the recipient
 	router.DELETE("/:sharing-id/answer", RevocationOwnerNotif, checkSharingWritePermissions)                 // On the sharer
@@ -505,6 +507,14 @@ func Routes(router *echo.Group) {
 
 	router.GET("/doctype/:doctype", GetSharingsInfoByDocType)
 
+	// Activity journal and sync status
+	router.GET("/:sharing-id/activity", GetActivity)
+
+	// Conflicts on the shared files
+	router.GET("/:sharing-id/conflicts", ListConflicts)
+	router.GET("/:sharing-id/conflicts/:conflict-id", GetConflict)
+	router.POST("/:sharing-id/conflicts/:conflict-id/resolve", ResolveConflict)
+
 	// Register the URL of their Cozy for recipients
 	router.GET("/:sharing-id/discovery", GetDiscovery)
 	router.POST("/:sharing-id/discovery", PostDiscovery)
@@ -534,11 +544,15 @@ func checkCreatePermissions(c echo.Context, s *sharing.Sharing) (string, error)
 		requestPerm.Type != permission.TypeCLI {
 		return "", permission.ErrInvalidAudience
 	}
+	// The app must have all the verbs on the shared documents, and be allowed
+	// to share them further
+	verbs := permission.Verbs(permission.GET, permission.POST, permission.PUT,
+		permission.PATCH, permission.DELETE, permission.SHARE)
 	for _, r := range s.Rules {
 		pr := permission.Rule{
 			Title:    r.Title,
 			Type:     r.DocType,
-			Verbs:    permission.ALL,
+			Verbs:    verbs,
 			Selector: r.Selector,
 			Values:   r.Values,
 		}
@@ -622,6 +636,14 @@ func wrapErrors(err error) error {
 		return jsonapi.BadRequest(err)
 	case sharing.ErrAlreadyAccepted:
 		return jsonapi.Conflict(err)
+	case sharing.ErrConflictNotFound:
+		return jsonapi.NotFound(err)
+	case sharing.ErrConflictResolved:
+		return jsonapi.Conflict(err)
+	case sharing.ErrInvalidResolution:
+		return jsonapi.InvalidAttribute("resolution", err)
+	case sharing.ErrInvalidExpiry:
+		return jsonapi.InvalidAttribute("expires_at", err)
 	case vfs.ErrInvalidHash:
 		return jsonapi.InvalidParameter("md5sum", err)
 	case vfs.ErrContentLengthMismatch:
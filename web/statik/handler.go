@@ -33,6 +33,7 @@ var (
 		"authorize.html",
 		"authorize_sharing.html",
 		"compat.html",
+		"device.html",
 		"error.html",
 		"login.html",
 		"need_onboarding.html",
@@ -0,0 +1,33 @@
+package bitwarden
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "bitwarden-sends-purge",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Minute,
+		WorkerFunc:   WorkerSendsPurge,
+	})
+}
+
+// WorkerSendsPurge is a worker that deletes the bitwarden sends after their
+// deletion date.
+func WorkerSendsPurge(ctx *job.WorkerContext) error {
+	inst := ctx.Instance
+	deleted, err := bitwarden.PurgeSends(inst, time.Now())
+	if err != nil {
+		return err
+	}
+	ctx.Logger().WithField("nspace", "bitwarden").
+		Debugf("%d sends deleted", deleted)
+	return nil
+}
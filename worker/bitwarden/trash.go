@@ -0,0 +1,34 @@
+package bitwarden
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "bitwarden-trash-purge",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Minute,
+		WorkerFunc:   WorkerTrashPurge,
+	})
+}
+
+// WorkerTrashPurge is a worker that deletes the bitwarden ciphers that have
+// been in the trash for longer than the retention of the instance.
+func WorkerTrashPurge(ctx *job.WorkerContext) error {
+	inst := ctx.Instance
+	before := time.Now().Add(-bitwarden.TrashRetention(inst))
+	deleted, err := bitwarden.PurgeTrash(inst, before)
+	if err != nil {
+		return err
+	}
+	ctx.Logger().WithField("nspace", "bitwarden").
+		Debugf("%d ciphers purged from the trash", deleted)
+	return nil
+}
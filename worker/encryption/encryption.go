@@ -0,0 +1,164 @@
+package encryption
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/lock"
+	"github.com/cozy/cozy-stack/worker/thumbnail"
+)
+
+// ErrEncryptionDisabled is used when the key rotation is asked, but the
+// encryption at rest is not enabled in the configuration.
+var ErrEncryptionDisabled = errors.New("The encryption of the files is disabled")
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "rotate-files-key",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Reserved:     true,
+		Timeout:      6 * time.Hour,
+		WorkerFunc:   Worker,
+	})
+}
+
+// maxPasses is the number of times the binaries are walked for encrypting
+// them again with the new key, before giving up on removing the old keys that
+// are still referenced (by uploads that were in progress, for example).
+const maxPasses = 3
+
+// Worker is a worker that generates a new key for the encryption at rest of
+// the binaries of an instance, and encrypts again the files, versions and
+// thumbnails with this key. When it is done, the old keys that are no longer
+// referenced by a file, a version or a blob are removed. It can also be used
+// to encrypt the binaries of an instance created before the encryption was
+// enabled.
+func Worker(ctx *job.WorkerContext) error {
+	if !vfs.EncryptionEnabled() {
+		return ErrEncryptionDisabled
+	}
+	inst := ctx.Instance
+	if _, ok := inst.VFS().(vfs.Reencrypter); !ok {
+		return instance.ErrEncryptionNotSupported
+	}
+	log := ctx.Logger().WithField("nspace", "encryption")
+
+	err := updateFilesKeys(inst, func(i *instance.Instance) error {
+		return i.AddFilesKey()
+	})
+	if err != nil {
+		return err
+	}
+
+	// The uploads that were in progress when the key has been added can still
+	// use the old keys: the binaries are walked again while an old key is
+	// still referenced.
+	for pass := 1; pass <= maxPasses; pass++ {
+		if err := reencryptAll(ctx, inst); err != nil {
+			return err
+		}
+		log.Infof("Pass %d of the key rotation done", pass)
+		inUse, err := removeUnusedKeys(inst)
+		if err != nil {
+			return err
+		}
+		if len(inUse) == 0 {
+			return nil
+		}
+		log.Infof("Old keys still in use: %v", inUse)
+	}
+	log.Warnf("Some old keys are still in use and have been kept")
+	return nil
+}
+
+// removeUnusedKeys removes the old keys that are no longer referenced, and
+// returns the identifiers of the old keys that are still in use. The VFS lock
+// is held, so that no file can be saved with a key while it is removed.
+func removeUnusedKeys(inst *instance.Instance) ([]uint32, error) {
+	mu := lock.ReadWrite(inst, "vfs")
+	if err := mu.Lock(); err != nil {
+		return nil, err
+	}
+	defer mu.Unlock()
+
+	fresh, err := instance.GetFromCouch(inst.Domain)
+	if err != nil {
+		return nil, err
+	}
+	var unused, inUse []uint32
+	for _, id := range fresh.OldFilesKeysIDs() {
+		used, err := vfs.FilesKeyInUse(inst, id)
+		if err != nil {
+			return nil, err
+		}
+		if used {
+			inUse = append(inUse, id)
+		} else {
+			unused = append(unused, id)
+		}
+	}
+	if len(unused) == 0 {
+		return inUse, nil
+	}
+	err = updateFilesKeys(inst, func(i *instance.Instance) error {
+		i.RemoveFilesKeys(unused)
+		return nil
+	})
+	return inUse, err
+}
+
+func reencryptAll(ctx *job.WorkerContext, inst *instance.Instance) error {
+	fs := inst.VFS()
+	reencrypter := fs.(vfs.Reencrypter)
+	thumbs, _ := lifecycle.ThumbsFS(inst).(vfs.ThumbsReencrypter)
+	return vfs.Walk(fs, "/", func(_ string, _ *vfs.DirDoc, file *vfs.FileDoc, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := reencrypter.Reencrypt(file); err != nil {
+			return err
+		}
+		if thumbs != nil && file.Class == "image" {
+			return thumbs.ReencryptThumbs(file, thumbnail.FormatsNames)
+		}
+		return nil
+	})
+}
+
+// updateFilesKeys applies the change to the keys on a fresh copy of the
+// instance document, to avoid the conflicts, and updates the keys of the
+// given instance, that are used by its VFS.
+func updateFilesKeys(inst *instance.Instance, change func(i *instance.Instance) error) error {
+	for {
+		fresh, err := instance.GetFromCouch(inst.Domain)
+		if err != nil {
+			return err
+		}
+		if err = change(fresh); err != nil {
+			return err
+		}
+		err = couchdb.UpdateDoc(couchdb.GlobalDB, fresh)
+		if couchdb.IsConflictError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		inst.FilesKeys = fresh.FilesKeys
+		inst.SetRev(fresh.Rev())
+		return nil
+	}
+}
@@ -17,16 +17,18 @@ const templateTitleVar = "template_title"
 
 func initMailTemplates() {
 	mailTemplater = MailTemplater{
-		"passphrase_hint":              subjectEntry{"Mail Hint Subject", nil},
-		"passphrase_reset":             subjectEntry{"Mail Reset Passphrase Subject", nil},
-		"archiver":                     subjectEntry{"Mail Archive Subject", nil},
-		"two_factor":                   subjectEntry{"Mail Two Factor Subject", nil},
-		"two_factor_mail_confirmation": subjectEntry{"Mail Two Factor Mail Confirmation Subject", []string{templateTitleVar}},
-		"new_connection":               subjectEntry{"Mail New Connection Subject", []string{templateTitleVar}},
-		"new_registration":             subjectEntry{"Mail New Registration Subject", []string{templateTitleVar}},
-		"sharing_request":              subjectEntry{"Mail Sharing Request Subject", []string{"SharerPublicName"}},
-		"alert_account":                subjectEntry{"Mail Alert Account Subject", nil},
-		"notifications_diskquota":      subjectEntry{"Notifications Disk Quota Subject", nil},
+		"passphrase_hint":                subjectEntry{"Mail Hint Subject", nil},
+		"passphrase_reset":               subjectEntry{"Mail Reset Passphrase Subject", nil},
+		"archiver":                       subjectEntry{"Mail Archive Subject", nil},
+		"two_factor":                     subjectEntry{"Mail Two Factor Subject", nil},
+		"two_factor_mail_confirmation":   subjectEntry{"Mail Two Factor Mail Confirmation Subject", []string{templateTitleVar}},
+		"new_connection":                 subjectEntry{"Mail New Connection Subject", []string{templateTitleVar}},
+		"new_registration":               subjectEntry{"Mail New Registration Subject", []string{templateTitleVar}},
+		"sharing_request":                subjectEntry{"Mail Sharing Request Subject", []string{"SharerPublicName"}},
+		"alert_account":                  subjectEntry{"Mail Alert Account Subject", nil},
+		"notifications_diskquota":        subjectEntry{"Notifications Disk Quota Subject", nil},
+		"notifications_sharing_conflict": subjectEntry{"Notifications Sharing Conflict Subject", nil},
+		"notifications_sharing_expiry":   subjectEntry{"Notifications Sharing Expiry Subject", nil},
 	}
 }
 
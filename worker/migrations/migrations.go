@@ -13,6 +13,7 @@ import (
 	"github.com/cozy/cozy-stack/model/bitwarden"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/model/vfs/vfsswift"
@@ -39,6 +40,8 @@ const (
 	swiftV3ContainerPrefix     = "cozy-v3-"
 
 	accountsToOrganization = "accounts-to-organization"
+
+	searchIndex = "search-index"
 )
 
 // maxSimultaneousCalls is the maximal number of simultaneous calls to Swift
@@ -77,6 +80,8 @@ func worker(ctx *job.WorkerContext) error {
 		return fmt.Errorf("this migration type is no longer supported")
 	case accountsToOrganization:
 		return migrateAccountsToOrganization(ctx.Instance.Domain)
+	case searchIndex:
+		return migrateSearchIndex(ctx.Instance)
 	default:
 		return fmt.Errorf("unknown migration type %q", msg.Type)
 	}
@@ -92,6 +97,19 @@ func commit(ctx *job.WorkerContext, err error) error {
 	return err
 }
 
+// Add the trigger for the full-text search index of the files, and index the
+// existing files, for an instance created before the search was available.
+func migrateSearchIndex(inst *instance.Instance) error {
+	added, err := lifecycle.EnsureSearchIndexTrigger(inst)
+	if err != nil || !added {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "search-rebuild",
+	})
+	return err
+}
+
 // Migrate all the encrypted accounts to Bitwarden ciphers.
 // It decrypts each account, reencrypt the fields with the organization key,
 // and save it in the ciphers database.
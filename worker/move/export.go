@@ -705,9 +705,11 @@ func exportDocs(in *instance.Instance, withDoctypes []string, now time.Time, tw
 		}
 		switch doctype {
 		case consts.KonnectorLogs, consts.Archives,
-			consts.Sessions, consts.OAuthClients, consts.OAuthAccessCodes:
+			consts.Sessions, consts.OAuthClients, consts.OAuthAccessCodes, consts.OAuthDeviceCodes,
+			consts.PermissionsAudit:
 			// ignore these doctypes
-		case consts.Sharings, consts.SharingsAnswer, consts.Shared:
+		case consts.Sharings, consts.SharingsAnswer, consts.SharingsConflicts,
+			consts.SharingsActivities, consts.Shared:
 			// ignore sharings ? TBD
 		case consts.Files, consts.Settings:
 			// already written out in a special file
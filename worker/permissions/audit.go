@@ -0,0 +1,34 @@
+package permissions
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "permissions-audit-purge",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Minute,
+		WorkerFunc:   WorkerAuditPurge,
+	})
+}
+
+// WorkerAuditPurge is a worker that deletes the entries of the audit trail of
+// the permissions that are older than the retention of the instance.
+func WorkerAuditPurge(ctx *job.WorkerContext) error {
+	inst := ctx.Instance
+	before := time.Now().Add(-inst.PermissionsAudit.Retention())
+	deleted, err := permission.PurgeAuditEntries(inst, before)
+	if err != nil {
+		return err
+	}
+	ctx.Logger().WithField("nspace", "permissions").
+		Debugf("%d audit entries purged", deleted)
+	return nil
+}
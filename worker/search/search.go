@@ -0,0 +1,92 @@
+package search
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/search"
+	"github.com/cozy/cozy-stack/model/vfs"
+)
+
+type fileEvent struct {
+	Verb   string            `json:"verb"`
+	Doc    vfs.DirOrFileDoc  `json:"doc"`
+	OldDoc *vfs.DirOrFileDoc `json:"old,omitempty"`
+}
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "search-index",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      2 * time.Minute,
+		WorkerFunc:   Worker,
+	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "search-rebuild",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Reserved:     true,
+		Timeout:      1 * time.Hour,
+		WorkerFunc:   WorkerRebuild,
+	})
+}
+
+// Worker is a worker that keeps the search index up-to-date with the changes
+// on the files and directories.
+func Worker(ctx *job.WorkerContext) error {
+	var evt fileEvent
+	if err := ctx.UnmarshalEvent(&evt); err != nil {
+		return err
+	}
+	if evt.Doc.DirDoc == nil {
+		return nil
+	}
+	inst := ctx.Instance
+	dir, file := evt.Doc.Refine()
+	ctx.Logger().WithField("nspace", "search").Debugf("%s %s", evt.Verb, evt.Doc.ID())
+
+	if dir != nil {
+		// When a directory is moved or renamed, the paths of the files inside
+		// it have changed
+		if evt.Verb != "UPDATED" || evt.OldDoc == nil || evt.OldDoc.DirDoc == nil {
+			return nil
+		}
+		if evt.OldDoc.Fullpath == dir.Fullpath {
+			return nil
+		}
+		return search.IndexDir(ctx, inst, dir)
+	}
+
+	if evt.Verb == "DELETED" {
+		return search.RemoveFile(inst, file.ID())
+	}
+	// The event can be received after other changes on the file, so we load
+	// its last state
+	file, err := inst.VFS().FileByID(file.ID())
+	if err == os.ErrNotExist {
+		return search.RemoveFile(inst, evt.Doc.ID())
+	}
+	if err != nil {
+		return err
+	}
+	if !search.Indexable(file) {
+		return search.RemoveFile(inst, file.ID())
+	}
+	return search.IndexFile(ctx, inst, file)
+}
+
+// WorkerRebuild is a worker that rebuilds the whole search index of an
+// instance. It also adds the trigger for keeping the index up-to-date if it
+// is missing, for the instances created before the search feature.
+func WorkerRebuild(ctx *job.WorkerContext) error {
+	if _, err := lifecycle.EnsureSearchIndexTrigger(ctx.Instance); err != nil {
+		return err
+	}
+	return search.Rebuild(ctx, ctx.Instance)
+}
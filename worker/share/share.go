@@ -41,6 +41,15 @@ func init() {
 		Timeout:      1 * time.Hour,
 		WorkerFunc:   WorkerUpload,
 	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "share-expire",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      5 * time.Minute,
+		WorkerFunc:   WorkerExpire,
+	})
 }
 
 // WorkerTrack is used to update the io.cozy.shared database when a document
@@ -95,3 +104,23 @@ func WorkerUpload(ctx *job.WorkerContext) error {
 	}
 	return s.Upload(ctx.Instance, msg.Errors)
 }
+
+// WorkerExpire is used to revoke the access to a sharing, or to some of its
+// recipients, when the expiration date has been reached, and to remind the
+// owner a few days before.
+func WorkerExpire(ctx *job.WorkerContext) error {
+	var msg sharing.ExpireMsg
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	ctx.Instance.Logger().WithField("nspace", "share").
+		Debugf("Expire %#v", msg)
+	s, err := sharing.FindSharing(ctx.Instance, msg.SharingID)
+	if err != nil {
+		return err
+	}
+	if msg.Reminder {
+		return s.RemindExpiry(ctx.Instance)
+	}
+	return s.ExpireAccess(ctx.Instance)
+}
@@ -0,0 +1,32 @@
+package uploads
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "uploads-cleanup",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Minute,
+		WorkerFunc:   WorkerUploadsCleanup,
+	})
+}
+
+// WorkerUploadsCleanup is a worker that removes the chunks of the resumable
+// uploads whose session has expired.
+func WorkerUploadsCleanup(ctx *job.WorkerContext) error {
+	removed, err := lifecycle.CleanUploads(ctx.Instance)
+	if err != nil {
+		return err
+	}
+	ctx.Logger().WithField("nspace", "uploads").
+		Debugf("Chunks of %d abandoned upload sessions removed", removed)
+	return nil
+}